@@ -0,0 +1,106 @@
+package backoff
+
+import (
+	"status-updater/config"
+	"sync"
+	"time"
+)
+
+var (
+	mu          sync.Mutex
+	idleCycles  int
+	lastPublish time.Time
+
+	// Wakes the scheduler when the interval drops back to sleep_interval
+	resets = make(chan struct{}, 1)
+)
+
+// Reports whether backoff.idle_cycles enables adaptive scheduling
+func Enabled() bool {
+	return config.Current().Backoff.IdleCycles > 0
+}
+
+// Current time between status cycles: sleep_interval, doubled for every idle cycle past backoff.idle_cycles up to backoff.max_interval
+func Interval() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return intervalLocked()
+}
+
+func intervalLocked() time.Duration {
+	cfg := config.Current()
+	base := cfg.SleepInterval.Duration()
+	threshold := cfg.Backoff.IdleCycles
+	if threshold <= 0 || idleCycles < threshold {
+		return base
+	}
+
+	max := cfg.Backoff.MaxInterval.Duration()
+	interval := base
+	for i := threshold; i <= idleCycles && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		interval = max
+	}
+	return interval
+}
+
+// Records a successfully published status cycle; a cycle with changes drops back to sleep_interval
+func RecordCycle(changed bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	lastPublish = time.Now()
+	if changed {
+		resetLocked()
+		return
+	}
+	idleCycles++
+}
+
+// Records a cycle whose publish was skipped for lack of reportable changes; it counts as idle
+func RecordSkipped() {
+	mu.Lock()
+	defer mu.Unlock()
+	idleCycles++
+}
+
+// Records a keepalive attempt; a failed one is retried after another heartbeat_interval
+func RecordKeepalive() {
+	mu.Lock()
+	defer mu.Unlock()
+	lastPublish = time.Now()
+}
+
+// Drops back to sleep_interval, e.g. after a network change
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	resetLocked()
+}
+
+func resetLocked() {
+	backedOff := intervalLocked() > config.Current().SleepInterval.Duration()
+	idleCycles = 0
+	if backedOff {
+		select {
+		case resets <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Signalled when a stretched interval drops back to sleep_interval
+func Resets() <-chan struct{} {
+	return resets
+}
+
+// Time left until a keepalive is due, zero or less when one should be sent now
+func KeepaliveDue() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	if lastPublish.IsZero() {
+		lastPublish = time.Now()
+	}
+	return time.Until(lastPublish.Add(config.Current().Backoff.HeartbeatInterval.Duration()))
+}
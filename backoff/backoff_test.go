@@ -0,0 +1,66 @@
+package backoff
+
+import (
+	"status-updater/config"
+	"testing"
+	"time"
+)
+
+// Puts a config with the given heartbeat interval in effect and forgets the last publish and idle cycles, restoring
+// them afterwards
+func useHeartbeat(t *testing.T, interval time.Duration) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Backoff.HeartbeatInterval = config.Duration(interval)
+	previous := config.Current()
+	config.Set(cfg)
+
+	mu.Lock()
+	previousPublish, previousIdle := lastPublish, idleCycles
+	lastPublish, idleCycles = time.Time{}, 0
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		config.Set(previous)
+		mu.Lock()
+		lastPublish, idleCycles = previousPublish, previousIdle
+		mu.Unlock()
+	})
+}
+
+func TestKeepaliveNotDueRightAfterPublish(t *testing.T) {
+	useHeartbeat(t, 10*time.Minute)
+	RecordCycle(false)
+
+	if due := KeepaliveDue(); due <= 0 || due > 10*time.Minute {
+		t.Errorf("KeepaliveDue = %v right after a publish, want up to the heartbeat interval", due)
+	}
+}
+
+func TestKeepaliveDueAfterHeartbeatInterval(t *testing.T) {
+	useHeartbeat(t, 10*time.Minute)
+	mu.Lock()
+	lastPublish = time.Now().Add(-11 * time.Minute)
+	mu.Unlock()
+
+	if due := KeepaliveDue(); due > 0 {
+		t.Errorf("KeepaliveDue = %v after the heartbeat interval passed, want zero or less", due)
+	}
+
+	RecordKeepalive()
+	if due := KeepaliveDue(); due <= 0 {
+		t.Errorf("KeepaliveDue = %v after a keepalive, want the timer restarted", due)
+	}
+}
+
+func TestSkippedCycleDoesNotDelayKeepalive(t *testing.T) {
+	useHeartbeat(t, 10*time.Minute)
+	mu.Lock()
+	lastPublish = time.Now().Add(-11 * time.Minute)
+	mu.Unlock()
+
+	RecordSkipped()
+	if due := KeepaliveDue(); due > 0 {
+		t.Errorf("KeepaliveDue = %v after a skipped cycle, want it still due", due)
+	}
+}
@@ -0,0 +1,53 @@
+package backoff
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"status-updater/config"
+	"time"
+)
+
+// Offset into the interval this device's ticks land on, spread evenly over the fleet by a hash of the device ID
+func Phase(deviceID string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(deviceID))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// Time until the next status tick: the device's phase slot at least half an interval away, moved by up to
+// sleep_interval_jitter_pct either way. Slots are aligned to the wall clock, so devices that booted together still
+// spread out, and jitter doesn't accumulate from cycle to cycle.
+func NextTick(deviceID string) time.Duration {
+	interval := Interval()
+	now := time.Now()
+	return untilSlot(now.Add(interval/2), interval, Phase(deviceID, interval)) + interval/2 + jitter(interval)
+}
+
+// Time from t until the first instant at or after it that is phase past a multiple of interval
+func untilSlot(t time.Time, interval, phase time.Duration) time.Duration {
+	since := time.Duration((t.UnixNano() - int64(phase)) % int64(interval))
+	if since < 0 {
+		since += interval
+	}
+	if since == 0 {
+		return 0
+	}
+	return interval - since
+}
+
+// Uniform jitter of up to sleep_interval_jitter_pct percent of interval either way
+func jitter(interval time.Duration) time.Duration {
+	cfg := config.Current()
+	pct := 0
+	if cfg.SleepIntervalJitterPct != nil {
+		pct = *cfg.SleepIntervalJitterPct
+	}
+	spread := int64(interval) * int64(pct) / 100
+	if spread <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(2*spread+1) - spread)
+}
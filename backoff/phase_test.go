@@ -0,0 +1,86 @@
+package backoff
+
+import (
+	"fmt"
+	"status-updater/config"
+	"testing"
+	"time"
+)
+
+// Device IDs of a batch installed together: MAC addresses that only differ in the last bytes
+func batchDeviceIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("b8:27:eb:12:%02x:%02x", i/256, i%256)
+	}
+	return ids
+}
+
+func TestPhaseDistribution(t *testing.T) {
+	const (
+		interval = 300 * time.Second
+		devices  = 2000
+		buckets  = 10
+	)
+	counts := make([]int, buckets)
+	for _, id := range batchDeviceIDs(devices) {
+		phase := Phase(id, interval)
+		if phase < 0 || phase >= interval {
+			t.Fatalf("phase of %s = %v, outside [0, %v)", id, phase, interval)
+		}
+		counts[int(phase*buckets/interval)]++
+	}
+
+	// Each 30s slice of the interval should get about a tenth of the fleet
+	expected := devices / buckets
+	for i, count := range counts {
+		if count < expected*7/10 || count > expected*13/10 {
+			t.Errorf("%d of %d devices tick in slice %d of the interval, want about %d: %v", count, devices, i, expected, counts)
+			break
+		}
+	}
+}
+
+func TestPhaseIsStable(t *testing.T) {
+	if Phase("b8:27:eb:12:34:56", 5*time.Minute) != Phase("b8:27:eb:12:34:56", 5*time.Minute) {
+		t.Error("phase of the same device differs between calls")
+	}
+	if Phase("b8:27:eb:12:34:56", 0) != 0 {
+		t.Error("phase of a zero interval is not zero")
+	}
+}
+
+func TestUntilSlot(t *testing.T) {
+	interval, phase := 5*time.Minute, 42*time.Second
+	start := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	for offset := time.Duration(0); offset < 2*interval; offset += 7 * time.Second {
+		now := start.Add(offset)
+		wait := untilSlot(now, interval, phase)
+		if wait < 0 || wait >= interval {
+			t.Fatalf("untilSlot at %v = %v, outside [0, %v)", now, wait, interval)
+		}
+		if slot := now.Add(wait); slot.Sub(start)%interval != phase {
+			t.Fatalf("untilSlot at %v lands on %v, not %v past a slot", now, slot, phase)
+		}
+	}
+}
+
+func TestNextTickStaysWithinJitter(t *testing.T) {
+	pct := 5
+	cfg := &config.Config{SleepInterval: config.Duration(5 * time.Minute), SleepIntervalJitterPct: &pct}
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+
+	interval := 5 * time.Minute
+	spread := interval * 5 / 100
+	for _, id := range batchDeviceIDs(200) {
+		next := NextTick(id)
+		if next < interval/2-spread || next > interval*3/2+spread {
+			t.Errorf("NextTick(%s) = %v, want between half and one and a half intervals, give or take %v", id, next, spread)
+		}
+		if j := jitter(interval); j < -spread || j > spread {
+			t.Errorf("jitter = %v, beyond ±%v", j, spread)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package boot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ways the previous run can have ended
+const (
+	ReasonClean     = "clean"
+	ReasonCrash     = "crash"
+	ReasonPowerLoss = "power_loss"
+	ReasonWatchdog  = "watchdog"
+	ReasonUnknown   = "unknown"
+)
+
+// Written every cycle so the next start knows how long the previous run lasted
+type heartbeat struct {
+	Time          time.Time `json:"time"`
+	BootID        string    `json:"boot_id"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+}
+
+// How the previous run ended, reported in the first payload after startup
+type Report struct {
+	Reason         string
+	Rebooted       bool
+	PreviousUptime int64
+}
+
+var (
+	reportMutex sync.Mutex
+	report      *Report
+)
+
+func heartbeatPath() string {
+	return filepath.Join(config.Current().StateDir, "heartbeat")
+}
+
+func cleanShutdownPath() string {
+	return filepath.Join(config.Current().StateDir, "clean-shutdown")
+}
+
+// Classifies how the previous run ended from the heartbeat and clean-shutdown marker; call once at startup
+func Classify() Report {
+	r := Report{Reason: ReasonUnknown}
+
+	data, err := os.ReadFile(heartbeatPath())
+	var last heartbeat
+	if err == nil && json.Unmarshal(data, &last) == nil {
+		_, markerErr := os.Stat(cleanShutdownPath())
+		clean := markerErr == nil
+
+		r.Rebooted = last.BootID == "" || last.BootID != ID()
+		switch {
+		case clean:
+			r.Reason = ReasonClean
+		case !r.Rebooted:
+			r.Reason = ReasonCrash
+		case watchdogReset():
+			r.Reason = ReasonWatchdog
+		default:
+			r.Reason = ReasonPowerLoss
+		}
+		if r.Rebooted {
+			r.PreviousUptime = last.UptimeSeconds
+		}
+	}
+	os.Remove(cleanShutdownPath())
+
+	if r.Rebooted {
+		logShutdownHistory()
+	}
+
+	reportMutex.Lock()
+	report = &r
+	reportMutex.Unlock()
+	return r
+}
+
+// Returns the startup report until MarkReported is called
+func PendingReport() *Report {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+	return report
+}
+
+// Called after the first payload carrying the report was published
+func MarkReported() {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+	report = nil
+}
+
+// Records that the daemon is alive, with the current boot and system uptime
+func WriteHeartbeat() {
+	uptime, _ := gatherer.GetUptimeSeconds()
+	data, err := json.Marshal(heartbeat{Time: time.Now().UTC(), BootID: ID(), UptimeSeconds: uptime})
+	if err != nil {
+		return
+	}
+
+	tmp := heartbeatPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, heartbeatPath())
+	}
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to write heartbeat: %s", err))
+	}
+}
+
+// Marks the current stop as deliberate so the next start doesn't report a crash
+func MarkCleanShutdown() {
+	if err := os.WriteFile(cleanShutdownPath(), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to write clean shutdown marker: %s", err))
+	}
+}
+
+// Identifies the current boot; changes on every reboot
+func ID() string {
+	data, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Reports whether the hardware watchdog caused the last reset, where the driver exposes it
+func watchdogReset() bool {
+	data, err := os.ReadFile("/sys/class/watchdog/watchdog0/bootstatus")
+	if err != nil {
+		return false
+	}
+	status := strings.TrimSpace(string(data))
+	return status != "" && status != "0"
+}
+
+// Logs recent shutdown/reboot records from wtmp for extra context, where `last` is available
+func logShutdownHistory() {
+	if _, err := cmdrunner.LookPath("last"); err != nil {
+		return
+	}
+	output, err := cmdrunner.Output("last", "-x", "-n", "5", "shutdown", "reboot")
+	if err != nil {
+		return
+	}
+	logger.LogMessage("INFO", fmt.Sprintf("Recent shutdown history:\n%s", strings.TrimSpace(string(output))))
+}
@@ -11,9 +11,13 @@ OUTPUT_BINARY="status-updater-arm7"
 # Extract the version number from the control file
 VERSION=$(grep '^Version:' status-updater/DEBIAN/control | awk '{print $2}')
 
+# Build information embedded into the binary, shown by -version
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo "")
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+
 # Build the Go application
 echo "Building for $GOOS/$GOARCH (ARMv7)..."
-go build -o $OUTPUT_BINARY main.go
+go build -ldflags="-X status-updater/buildinfo.Version=$VERSION -X status-updater/buildinfo.Commit=$COMMIT -X status-updater/buildinfo.BuildDate=$BUILD_DATE" -o $OUTPUT_BINARY .
 
 cp $OUTPUT_BINARY /opt/status-updater/status-updater/opt/status-updater/status-updater
 cp $OUTPUT_BINARY /opt/status-updater/status-updater-buildroot/opt/status-updater/status-updater
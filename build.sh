@@ -13,9 +13,16 @@ export GOFLAGS="-tags=netgo,osusergo"  # Force use of Go's native networking and
 BUILD_DIR="./build"
 mkdir -p $BUILD_DIR
 
+# Build information embedded into the binary, shown by -version
+# The package version, which the updater compares with the update metadata
+VERSION=$(grep '^Version:' status-updater/DEBIAN/control 2>/dev/null | awk '{print $2}')
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo "")
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+BUILDINFO="-X status-updater/buildinfo.Version=$VERSION -X status-updater/buildinfo.Commit=$COMMIT -X status-updater/buildinfo.BuildDate=$BUILD_DATE"
+
 # Build the main application with fully static linking
 echo "Building status-updater..."
-go build -a -ldflags='-w -s -extldflags "-static"' -tags netgo,osusergo -o $BUILD_DIR/status-updater main.go
+go build -a -ldflags="-w -s $BUILDINFO -extldflags \"-static\"" -tags netgo,osusergo -o $BUILD_DIR/status-updater .
 
 # Build the installer with fully static linking
 echo "Building installer..."
@@ -0,0 +1,58 @@
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g. -ldflags "-X status-updater/buildinfo.Version=1.2.3"
+var (
+	Version   = ""
+	Commit    = ""
+	BuildDate = ""
+)
+
+// What binary is running; Flavor is filled in at runtime since one binary serves both Debian and Buildroot
+type Info struct {
+	Version   string `json:"version,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Flavor    string `json:"flavor,omitempty"`
+}
+
+// Returns the embedded build information, falling back to the VCS stamp Go records for the commit and date
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// One-line banner such as "version 1.2.3, commit abc1234, built 2024-05-01T10:00:00Z with go1.23.2"
+func (i Info) String() string {
+	version, commit, date := i.Version, i.Commit, i.BuildDate
+	if version == "" {
+		version = "unknown"
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	banner := fmt.Sprintf("version %s, commit %s, built %s with %s", version, commit, date, i.GoVersion)
+	if i.Flavor != "" {
+		banner += ", " + i.Flavor
+	}
+	return banner
+}
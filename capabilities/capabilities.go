@@ -0,0 +1,148 @@
+package capabilities
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/logger"
+	"sync"
+)
+
+// Privileges the daemon found at startup and the features that depend on them, reported as "capabilities"
+type Set struct {
+	Root             bool `json:"root"`
+	Sudo             bool `json:"sudo"`
+	StateDirWritable bool `json:"state_dir_writable"`
+	LogDirWritable   bool `json:"log_dir_writable"`
+	TimeCorrection   bool `json:"time_correction"`
+	DNSFix           bool `json:"dns_fix"`
+	UpdateInstall    bool `json:"update_install"`
+}
+
+// Checks the detection relies on; swapped out to test it without the privileges in question
+type probes struct {
+	euid         func() int
+	sudoAllowed  func(command string) bool
+	dirWritable  func(dir string) bool
+	fileWritable func(path string) bool
+}
+
+var systemProbes = probes{
+	euid:         os.Geteuid,
+	sudoAllowed:  sudoAllowed,
+	dirWritable:  dirWritable,
+	fileWritable: fileWritable,
+}
+
+const resolvConf = "/etc/resolv.conf"
+
+var (
+	mu      sync.Mutex
+	current *Set
+)
+
+// Detects the available privileges and logs one warning per feature that is disabled for lack of them
+func Detect(buildroot bool) *Set {
+	cfg := config.Current()
+	set := detect(systemProbes, buildroot)
+
+	if !set.StateDirWritable {
+		logger.LogMessage("WARN", fmt.Sprintf("state_dir %s is not writable, state won't survive a restart", cfg.StateDir))
+	}
+	if !set.LogDirWritable {
+		logger.LogMessage("WARN", fmt.Sprintf("Log directory of %s is not writable, logging may be degraded", cfg.Log.File))
+	}
+	if !set.TimeCorrection {
+		logger.LogMessage("WARN", "Not root and no passwordless sudo for date, system time correction disabled")
+	}
+	if !set.DNSFix {
+		logger.LogMessage("WARN", fmt.Sprintf("%s is not writable, DNS fix disabled", resolvConf))
+	}
+	if !set.UpdateInstall {
+		logger.LogMessage("WARN", "No privilege to install updates, the updater only reports new versions")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = set
+	return set
+}
+
+func detect(p probes, buildroot bool) *Set {
+	cfg := config.Current()
+	set := &Set{Root: p.euid() == 0}
+	set.StateDirWritable = p.dirWritable(cfg.StateDir)
+	set.LogDirWritable = cfg.Log.File == "" || p.dirWritable(filepath.Dir(cfg.Log.File))
+
+	dateAllowed := set.Root || p.sudoAllowed("date")
+	set.Sudo = !set.Root && dateAllowed
+	set.TimeCorrection = dateAllowed
+	// The fix keeps a backup next to resolv.conf
+	set.DNSFix = p.fileWritable(resolvConf) && (set.Root || p.dirWritable(filepath.Dir(resolvConf)))
+
+	if buildroot {
+		// deploy.sh replaces the binary in place
+		executable, err := os.Executable()
+		set.UpdateInstall = set.Root || (err == nil && p.dirWritable(filepath.Dir(executable)))
+	} else {
+		dpkgAllowed := set.Root || p.sudoAllowed("dpkg")
+		set.Sudo = set.Sudo || (!set.Root && dpkgAllowed)
+		set.UpdateInstall = dpkgAllowed
+	}
+	return set
+}
+
+// Returns the capabilities found at startup; everything is assumed available before Detect ran
+func Current() Set {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		return Set{Root: true, StateDirWritable: true, LogDirWritable: true, TimeCorrection: true, DNSFix: true, UpdateInstall: true}
+	}
+	return *current
+}
+
+// Returns the command line running name with root privileges: directly as root, otherwise through
+// non-interactive sudo so a missing sudoers entry fails instead of waiting for a password
+func Privileged(name string, args ...string) (string, []string) {
+	if os.Geteuid() == 0 {
+		return name, args
+	}
+	return "sudo", append([]string{"-n", name}, args...)
+}
+
+// Asks sudo whether command may run without a password, without running it
+func sudoAllowed(command string) bool {
+	path, err := cmdrunner.LookPath(command)
+	if err != nil {
+		return false
+	}
+	_, _, err = cmdrunner.Run("sudo", "-n", "-l", path)
+	return err == nil
+}
+
+// Creates and removes a file in dir, which also catches read-only mounts
+func dirWritable(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	file, err := os.CreateTemp(dir, ".writable-*")
+	if err != nil {
+		return false
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return true
+}
+
+// Opens path for writing without truncating or changing it
+func fileWritable(path string) bool {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
@@ -0,0 +1,156 @@
+package capabilities
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"testing"
+)
+
+const (
+	stateDir = "/var/lib/status-updater"
+	logDir   = "/var/log/status-updater"
+)
+
+// Probes of a device where the listed commands may run through sudo and the listed paths are writable
+func fakeProbes(euid int, sudo []string, writable ...string) probes {
+	allowed := make(map[string]bool)
+	for _, command := range sudo {
+		allowed[command] = true
+	}
+	isWritable := make(map[string]bool)
+	for _, path := range writable {
+		isWritable[path] = true
+	}
+	return probes{
+		euid:         func() int { return euid },
+		sudoAllowed:  func(command string) bool { return allowed[command] },
+		dirWritable:  func(dir string) bool { return isWritable[dir] },
+		fileWritable: func(path string) bool { return isWritable[path] },
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cfg := &config.Config{StateDir: stateDir}
+	cfg.Log.File = filepath.Join(logDir, "status-updater.log")
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryDir := filepath.Dir(executable)
+
+	tests := []struct {
+		name      string
+		probes    probes
+		buildroot bool
+		want      Set
+	}{
+		{
+			name:   "root",
+			probes: fakeProbes(0, nil, stateDir, logDir, "/etc/resolv.conf"),
+			want:   Set{Root: true, StateDirWritable: true, LogDirWritable: true, TimeCorrection: true, DNSFix: true, UpdateInstall: true},
+		},
+		{
+			name:   "unprivileged user",
+			probes: fakeProbes(998, nil, stateDir, logDir),
+			want:   Set{StateDirWritable: true, LogDirWritable: true},
+		},
+		{
+			name:   "sudo for date only",
+			probes: fakeProbes(998, []string{"date"}, stateDir, logDir),
+			want:   Set{Sudo: true, StateDirWritable: true, LogDirWritable: true, TimeCorrection: true},
+		},
+		{
+			name:   "sudo for dpkg only",
+			probes: fakeProbes(998, []string{"dpkg"}, stateDir, logDir),
+			want:   Set{Sudo: true, StateDirWritable: true, LogDirWritable: true, UpdateInstall: true},
+		},
+		{
+			name:   "writable resolv.conf without its directory",
+			probes: fakeProbes(998, nil, stateDir, logDir, "/etc/resolv.conf"),
+			want:   Set{StateDirWritable: true, LogDirWritable: true},
+		},
+		{
+			name:   "writable resolv.conf and /etc",
+			probes: fakeProbes(998, nil, stateDir, logDir, "/etc/resolv.conf", "/etc"),
+			want:   Set{StateDirWritable: true, LogDirWritable: true, DNSFix: true},
+		},
+		{
+			name:   "read-only state and log directories",
+			probes: fakeProbes(0, nil),
+			want:   Set{Root: true, TimeCorrection: true, UpdateInstall: true},
+		},
+		{
+			name:      "buildroot with a writable binary directory",
+			probes:    fakeProbes(998, []string{"dpkg"}, stateDir, logDir, binaryDir),
+			buildroot: true,
+			want:      Set{StateDirWritable: true, LogDirWritable: true, UpdateInstall: true},
+		},
+		{
+			name:      "buildroot without a writable binary directory",
+			probes:    fakeProbes(998, []string{"dpkg"}, stateDir, logDir),
+			buildroot: true,
+			want:      Set{StateDirWritable: true, LogDirWritable: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detect(tt.probes, tt.buildroot); *got != tt.want {
+				t.Errorf("detect = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectWithoutLogFile(t *testing.T) {
+	previous := config.Current()
+	config.Set(&config.Config{StateDir: stateDir})
+	t.Cleanup(func() { config.Set(previous) })
+
+	if set := detect(fakeProbes(998, nil), false); !set.LogDirWritable {
+		t.Error("log directory reported unwritable without a log file configured")
+	}
+}
+
+func TestSudoAllowed(t *testing.T) {
+	fake := cmdrunner.NewFakeRunner()
+	previous := cmdrunner.Current
+	cmdrunner.Current = fake
+	t.Cleanup(func() { cmdrunner.Current = previous })
+
+	fake.Set(cmdrunner.FakeResponse{Stdout: "/usr/bin/date\n"}, "sudo", "-n", "-l", "/usr/bin/date")
+	fake.Set(cmdrunner.FakeResponse{Stderr: "sudo: a password is required\n", Err: errors.New("exit status 1")}, "sudo", "-n", "-l", "/usr/bin/dpkg")
+	fake.Paths["date"] = "/usr/bin/date"
+	fake.Paths["dpkg"] = "/usr/bin/dpkg"
+
+	if !sudoAllowed("date") {
+		t.Error("sudoAllowed(date) = false with a sudoers entry")
+	}
+	if sudoAllowed("dpkg") {
+		t.Error("sudoAllowed(dpkg) = true although sudo wants a password")
+	}
+	if sudoAllowed("timedatectl") {
+		t.Error("sudoAllowed of a missing command = true")
+	}
+}
+
+func TestDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	if !dirWritable(dir) {
+		t.Error("temp directory reported unwritable")
+	}
+	if dirWritable(filepath.Join(dir, "missing")) || dirWritable("") {
+		t.Error("missing directory reported writable")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("probe left %d files behind", len(entries))
+	}
+}
@@ -0,0 +1,220 @@
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Default timeout for commands run without an explicit context
+	DefaultTimeout = 15 * time.Second
+
+	// Gatherer commands run through ExecRunner at once; further ones wait for a slot within their own timeout.
+	// Long commands started with RunLong don't take a slot.
+	MaxConcurrent = 4
+
+	// How long Wait waits for output pipes held open by grandchildren after the process group was killed
+	waitDelay = 2 * time.Second
+)
+
+// Executes external commands, returning captured stdout and stderr
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error)
+	LookPath(name string) (string, error)
+}
+
+// Runner used by gatherer, helpers and updater; swapped for a FakeRunner in tests
+var Current CommandRunner = ExecRunner{}
+
+// Runs a command with DefaultTimeout
+func Run(name string, args ...string) ([]byte, []byte, error) {
+	return RunWithTimeout(DefaultTimeout, name, args...)
+}
+
+// Runs a command with the given timeout
+func RunWithTimeout(timeout time.Duration, name string, args ...string) ([]byte, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return Current.Run(ctx, name, args...)
+}
+
+// Runs a command with DefaultTimeout and returns only stdout
+func Output(name string, args ...string) ([]byte, error) {
+	stdout, _, err := Run(name, args...)
+	return stdout, err
+}
+
+// Runs a long command such as a package install with the given timeout, outside the MaxConcurrent slots so it
+// can't starve the gatherers
+func RunLong(timeout time.Duration, name string, args ...string) ([]byte, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), longKey{}, true), timeout)
+	defer cancel()
+	return Current.Run(ctx, name, args...)
+}
+
+// Context key marking a command started with RunLong
+type longKey struct{}
+
+// Reports whether ctx belongs to a command started with RunLong
+func isLong(ctx context.Context) bool {
+	long, _ := ctx.Value(longKey{}).(bool)
+	return long
+}
+
+// Looks up an executable in PATH
+func LookPath(name string) (string, error) {
+	return Current.LookPath(name)
+}
+
+// Child processes started by ExecRunner, reported under "self"
+type Stats struct {
+	Spawned  uint64 `json:"spawned"`
+	TimedOut uint64 `json:"timed_out"`
+	Killed   uint64 `json:"killed"`
+	Running  int    `json:"running"`
+}
+
+var (
+	slots = make(chan struct{}, MaxConcurrent)
+
+	spawned  atomic.Uint64
+	timedOut atomic.Uint64
+	killed   atomic.Uint64
+	running  atomic.Int64
+)
+
+// Returns the child process counters since startup
+func ProcessStats() Stats {
+	return Stats{
+		Spawned:  spawned.Load(),
+		TimedOut: timedOut.Load(),
+		Killed:   killed.Load(),
+		Running:  int(running.Load()),
+	}
+}
+
+// Real implementation backed by os/exec
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	if !isLong(ctx) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			timedOut.Add(1)
+			return nil, nil, fmt.Errorf("%s not started, waited too long for a free slot: %w", name, ctx.Err())
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	KillGroupOnCancel(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	spawned.Add(1)
+	running.Add(1)
+	defer running.Add(-1)
+
+	err := cmd.Wait()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		timedOut.Add(1)
+		err = fmt.Errorf("%s timed out: %w", name, ctx.Err())
+	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// Starts cmd in its own process group and kills the whole group when its context ends, so grandchildren don't
+// outlive it; Wait gives up on their output after waitDelay
+func KillGroupOnCancel(cmd *exec.Cmd) {
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		killed.Add(1)
+		return killProcessGroup(cmd.Process)
+	}
+	cmd.WaitDelay = waitDelay
+}
+
+// Exit status of a command that ran and failed, -1 when it didn't run or was killed
+func ExitCode(err error) int {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func (ExecRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// Recorded result for a single command line
+type FakeResponse struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+// Replays recorded command output, keyed by the space-joined command line
+type FakeRunner struct {
+	mu        sync.Mutex
+	Responses map[string]FakeResponse
+	Paths     map[string]string
+	Calls     []string
+}
+
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		Responses: make(map[string]FakeResponse),
+		Paths:     make(map[string]string),
+	}
+}
+
+// Registers the response for a command line
+func (f *FakeRunner) Set(response FakeResponse, name string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Responses[commandLine(name, args)] = response
+	f.Paths[name] = name
+}
+
+func (f *FakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	line := commandLine(name, args)
+
+	f.mu.Lock()
+	f.Calls = append(f.Calls, line)
+	response, ok := f.Responses[line]
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("no recorded output for %q: %w", line, exec.ErrNotFound)
+	}
+	return []byte(response.Stdout), []byte(response.Stderr), response.Err
+}
+
+func (f *FakeRunner) LookPath(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if path, ok := f.Paths[name]; ok {
+		return path, nil
+	}
+	return "", exec.ErrNotFound
+}
+
+func commandLine(name string, args []string) string {
+	return strings.TrimSpace(name + " " + strings.Join(args, " "))
+}
@@ -0,0 +1,62 @@
+package cmdrunner
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestFakeRunner(t *testing.T) {
+	fake := NewFakeRunner()
+	fake.Set(FakeResponse{Stdout: "6.1.21-v8+\n"}, "uname", "-r")
+	fake.Set(FakeResponse{Stderr: "no modems\n", Err: errors.New("exit status 1")}, "mmcli", "-L")
+
+	stdout, _, err := fake.Run(context.Background(), "uname", "-r")
+	if err != nil || string(stdout) != "6.1.21-v8+\n" {
+		t.Errorf("uname -r = %q, %v", stdout, err)
+	}
+
+	_, stderr, err := fake.Run(context.Background(), "mmcli", "-L")
+	if err == nil || string(stderr) != "no modems\n" {
+		t.Errorf("mmcli -L = stderr %q, err %v, want the recorded failure", stderr, err)
+	}
+
+	if _, _, err := fake.Run(context.Background(), "lldpcli", "show"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("unrecorded command: err = %v, want exec.ErrNotFound", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := fake.Run(ctx, "uname", "-r"); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled context: err = %v, want context.Canceled", err)
+	}
+
+	want := []string{"uname -r", "mmcli -L", "lldpcli show", "uname -r"}
+	if !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("calls = %q, want %q", fake.Calls, want)
+	}
+}
+
+func TestFakeRunnerLookPath(t *testing.T) {
+	fake := NewFakeRunner()
+	fake.Set(FakeResponse{}, "mmcli", "-L")
+
+	if _, err := fake.LookPath("mmcli"); err != nil {
+		t.Errorf("LookPath of a recorded command: %v", err)
+	}
+	if _, err := fake.LookPath("lldpcli"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("LookPath of an unknown command: err = %v, want exec.ErrNotFound", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if code := ExitCode(err); code != 3 {
+		t.Errorf("ExitCode = %d, want 3", code)
+	}
+	if code := ExitCode(errors.New("not started")); code != -1 {
+		t.Errorf("ExitCode of a non-exit error = %d, want -1", code)
+	}
+}
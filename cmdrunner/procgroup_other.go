@@ -0,0 +1,15 @@
+//go:build !unix
+
+package cmdrunner
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups are Unix-only; only the direct child is killed elsewhere
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
@@ -0,0 +1,85 @@
+//go:build unix
+
+package cmdrunner
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Starts a shell that prints its PID (also its process group) and leaves a grandchild running, cancels it and
+// checks the whole group is gone
+func TestCancelKillsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+
+	killedBefore := killed.Load()
+	stdout, _, err := ExecRunner{}.Run(ctx, "sh", "-c", "echo $$; sleep 30 & sleep 30")
+	if err == nil {
+		t.Fatal("cancelled command returned no error")
+	}
+	if killed.Load() != killedBefore+1 {
+		t.Errorf("killed counter went from %d to %d, want one more", killedBefore, killed.Load())
+	}
+
+	pgid, err := strconv.Atoi(strings.TrimSpace(string(stdout)))
+	if err != nil {
+		t.Fatalf("no PID in output %q: %v", stdout, err)
+	}
+
+	// Killed processes may linger as zombies until they are reaped
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := syscall.Kill(-pgid, 0)
+		if errors.Is(err, syscall.ESRCH) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process group %d still exists after cancellation (kill: %v)", pgid, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestTimeoutIsCounted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	timedOutBefore := timedOut.Load()
+	_, _, err := ExecRunner{}.Run(ctx, "sleep", "30")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a deadline exceeded error", err)
+	}
+	if timedOut.Load() != timedOutBefore+1 {
+		t.Errorf("timed out counter went from %d to %d, want one more", timedOutBefore, timedOut.Load())
+	}
+}
+
+func TestLongCommandsDontTakeSlots(t *testing.T) {
+	// Occupy every gatherer slot
+	for i := 0; i < MaxConcurrent; i++ {
+		slots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < MaxConcurrent; i++ {
+			<-slots
+		}
+	}()
+
+	if _, _, err := RunLong(5*time.Second, "true"); err != nil {
+		t.Errorf("long command with all slots taken: %v", err)
+	}
+
+	_, _, err := RunWithTimeout(100*time.Millisecond, "true")
+	if err == nil || !strings.Contains(err.Error(), "free slot") {
+		t.Errorf("gatherer command with all slots taken: err = %v, want a free slot timeout", err)
+	}
+}
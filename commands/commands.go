@@ -0,0 +1,342 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/helpers"
+	"status-updater/history"
+	"status-updater/logger"
+	"status-updater/maintenance"
+	"status-updater/metrics"
+	"status-updater/system"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request received on the command topic
+type Command struct {
+	ID       string `json:"id,omitempty"`
+	Action   string `json:"action"`
+	Lines    int    `json:"lines,omitempty"`
+	Level    string `json:"level,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// Message published to the command response topic
+type Response struct {
+	ID      string                `json:"id,omitempty"`
+	Action  string                `json:"action"`
+	Seq     int                   `json:"seq"`
+	Status  string                `json:"status"`
+	Lines   []string              `json:"lines,omitempty"`
+	Chunks  int                   `json:"chunks,omitempty"`
+	Nonce   string                `json:"nonce,omitempty"`
+	State   *helpers.ServiceState `json:"state,omitempty"`
+	Cycle   *metrics.Cycle        `json:"cycle,omitempty"`
+	Window  *maintenance.Status   `json:"maintenance,omitempty"`
+	History []history.Entry       `json:"history,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// Response statuses
+const (
+	StatusChunk           = "chunk"
+	StatusComplete        = "complete"
+	StatusRejected        = "rejected"
+	StatusError           = "error"
+	StatusConfirmRequired = "confirm_required"
+	StatusAccepted        = "accepted"
+)
+
+// Time the backend has to echo the nonce of a disruptive command
+const confirmTimeout = 60 * time.Second
+
+// Disruptive commands waiting for their confirm message, by nonce
+var (
+	pendingMutex sync.Mutex
+	pending      = make(map[string]pendingCommand)
+)
+
+type pendingCommand struct {
+	cmd     Command
+	expires time.Time
+}
+
+// get_logs limits
+const (
+	DefaultLogLines = 200
+	MaxLogLines     = 2000
+	maxChunkBytes   = 32 * 1024
+)
+
+// Set while a get_logs request is being answered
+var logsInFlight atomic.Bool
+
+// Name the updater's own service is restarted by
+const selfService = "status-updater"
+
+// Level of a text ("... [WARN] ...") or JSON ("level":"WARN") log line
+var levelPattern = regexp.MustCompile(`\[(DEBUG|INFO|WARN|ERROR)\]|"level":"(DEBUG|INFO|WARN|ERROR)"`)
+
+// Parses a command and answers it through respond; unknown or malformed commands get an error response
+func Handle(payload []byte, respond func(Response) error) {
+	var cmd Command
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Ignoring malformed command: %v", err))
+		sendError(respond, cmd, fmt.Errorf("malformed command: %v", err))
+		return
+	}
+	logger.LogMessage("INFO", fmt.Sprintf("Received command %q (id %q)", cmd.Action, cmd.ID))
+
+	switch cmd.Action {
+	case "get_logs":
+		// Rejected right away instead of queued, a second dump would only repeat the first
+		if !logsInFlight.CompareAndSwap(false, true) {
+			sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusRejected, Error: "get_logs already in progress"})
+			return
+		}
+		go func() {
+			defer logsInFlight.Store(false)
+			if err := getLogs(cmd, respond); err != nil {
+				logger.LogMessage("ERROR", fmt.Sprintf("get_logs failed: %v", err))
+				sendError(respond, cmd, err)
+			}
+		}()
+	case "get_history":
+		if err := getHistory(cmd, respond); err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("get_history failed: %v", err))
+			sendError(respond, cmd, err)
+		}
+	case "status":
+		// Timing of the last status cycle, to profile a slow device remotely
+		cycle := metrics.LastCycle()
+		if cycle == nil {
+			sendError(respond, cmd, fmt.Errorf("no status cycle completed yet"))
+			return
+		}
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusComplete, Cycle: cycle})
+	case "maintenance":
+		// The duration is mandatory so a window always ends, even when the clearing command is lost
+		d, err := time.ParseDuration(cmd.Duration)
+		if err != nil {
+			sendError(respond, cmd, fmt.Errorf("invalid duration %q: %v", cmd.Duration, err))
+			return
+		}
+		window, err := maintenance.Set(d, maintenance.SetByCommand)
+		if err != nil {
+			sendError(respond, cmd, err)
+			return
+		}
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusComplete, Window: &window})
+	case "end_maintenance":
+		window := maintenance.Clear()
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusComplete, Window: &window})
+	case "reboot", "restart_service":
+		if cmd.Action == "restart_service" && !restartable(cmd.Service) {
+			sendError(respond, cmd, fmt.Errorf("service %q is not monitored", cmd.Service))
+			return
+		}
+		nonce, err := newNonce()
+		if err != nil {
+			sendError(respond, cmd, err)
+			return
+		}
+		pendingMutex.Lock()
+		pending[nonce] = pendingCommand{cmd: cmd, expires: time.Now().Add(confirmTimeout)}
+		pendingMutex.Unlock()
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusConfirmRequired, Nonce: nonce})
+	case "confirm":
+		confirmed, ok := takePending(cmd.Nonce, time.Now())
+		if !ok {
+			sendError(respond, cmd, fmt.Errorf("unknown or expired nonce"))
+			return
+		}
+		logger.LogMessage("INFO", fmt.Sprintf("Command %q (id %q) confirmed", confirmed.Action, confirmed.ID))
+		execute(confirmed, respond)
+	default:
+		sendError(respond, cmd, fmt.Errorf("unknown action %q", cmd.Action))
+	}
+}
+
+// Removes and returns the command waiting for nonce, dropping expired ones; a nonce confirms only once
+func takePending(nonce string, now time.Time) (Command, bool) {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+
+	for key, p := range pending {
+		if now.After(p.expires) {
+			delete(pending, key)
+		}
+	}
+	p, ok := pending[nonce]
+	if !ok || nonce == "" {
+		return Command{}, false
+	}
+	delete(pending, nonce)
+	return p.cmd, true
+}
+
+func newNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Only the monitored services and the updater itself may be restarted remotely
+func restartable(service string) bool {
+	if service == selfService {
+		return true
+	}
+	services, err := gatherer.ServiceNames()
+	if err != nil {
+		return false
+	}
+	for _, name := range services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs a confirmed disruptive command
+func execute(cmd Command, respond func(Response) error) {
+	switch {
+	case cmd.Action == "reboot":
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusAccepted})
+		// Runs on the MQTT client's goroutine, not in a task
+		system.RequestReboot(context.Background(), "reboot")
+	case cmd.Service == selfService:
+		// The service manager starts the updater again after a clean exit
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusAccepted})
+		system.RequestRestart(context.Background(), "restart")
+	default:
+		state, err := helpers.RestartService(cmd.Service)
+		if err != nil {
+			logger.LogMessage("ERROR", err.Error())
+			sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusError, State: &state, Error: err.Error()})
+			return
+		}
+		logger.LogMessage("INFO", fmt.Sprintf("Restarted %s, now %s (%s)", cmd.Service, state.ActiveState, state.SubState))
+		sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusComplete, State: &state})
+	}
+}
+
+func sendResponse(respond func(Response) error, response Response) {
+	if err := respond(response); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to publish command response: %v", err))
+	}
+}
+
+func sendError(respond func(Response) error, cmd Command, err error) {
+	sendResponse(respond, Response{ID: cmd.ID, Action: cmd.Action, Status: StatusError, Error: err.Error()})
+}
+
+// Publishes the recorded status changes, oldest first, in chunks of at most maxChunkBytes, followed by a complete marker
+func getHistory(cmd Command, respond func(Response) error) error {
+	seq := 0
+	var chunk []history.Entry
+	size := 0
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		seq++
+		err := respond(Response{ID: cmd.ID, Action: cmd.Action, Seq: seq, Status: StatusChunk, History: chunk})
+		chunk, size = nil, 0
+		return err
+	}
+
+	for _, entry := range history.Entries() {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		if size+len(encoded) > maxChunkBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		chunk = append(chunk, entry)
+		size += len(encoded)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return respond(Response{ID: cmd.ID, Action: cmd.Action, Seq: seq + 1, Status: StatusComplete, Chunks: seq})
+}
+
+// Publishes the sanitized tail of the log in chunks of at most maxChunkBytes, followed by a complete marker
+func getLogs(cmd Command, respond func(Response) error) error {
+	lines := cmd.Lines
+	if lines <= 0 {
+		lines = DefaultLogLines
+	}
+	if lines > MaxLogLines {
+		lines = MaxLogLines
+	}
+
+	minLevel := 0
+	if cmd.Level != "" {
+		level, ok := config.LogLevels[strings.ToUpper(cmd.Level)]
+		if !ok {
+			return fmt.Errorf("unknown level %q", cmd.Level)
+		}
+		minLevel = level
+	}
+
+	entries, err := logger.Tail(lines)
+	if err != nil {
+		return fmt.Errorf("failed to read log: %v", err)
+	}
+
+	seq := 0
+	var chunk []string
+	size := 0
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		seq++
+		err := respond(Response{ID: cmd.ID, Action: cmd.Action, Seq: seq, Status: StatusChunk, Lines: chunk})
+		chunk, size = nil, 0
+		return err
+	}
+
+	// Continuation lines such as stack traces keep the level of the entry they belong to
+	lineLevel := 0
+	for _, line := range entries {
+		if match := levelPattern.FindStringSubmatch(line); match != nil {
+			lineLevel = config.LogLevels[match[1]+match[2]]
+		}
+		if lineLevel < minLevel {
+			continue
+		}
+		line = logger.Sanitize(line)
+		if size+len(line) > maxChunkBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		chunk = append(chunk, line)
+		size += len(line)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return respond(Response{ID: cmd.ID, Action: cmd.Action, Seq: seq + 1, Status: StatusComplete, Chunks: seq})
+}
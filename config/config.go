@@ -1,27 +1,260 @@
 package config
 
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
 type Config struct {
 	MQTT struct {
-		Broker   string `json:"broker"`
-		BrokerIP string `json:"broker_ip"`
-		Port     int    `json:"port"`
-		ClientID string `json:"client_id"`
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Broker               string   `json:"broker"`
+		BrokerIP             string   `json:"broker_ip"`
+		Port                 int      `json:"port"`
+		ClientID             string   `json:"client_id"`
+		Username             string   `json:"username"`
+		Password             string   `json:"password"`
+		PasswordFile         string   `json:"password_file"`
+		ResolveCacheTTL      Duration `json:"resolve_cache_ttl"`
+		TopicTemplate        string   `json:"topic_template"`
+		Scheme               string   `json:"scheme"`
+		WebSocketPath        string   `json:"websocket_path"`
+		AllowInsecure        bool     `json:"allow_insecure"`
+		CAFile               string   `json:"ca_file"`
+		UseSystemCAs         bool     `json:"use_system_cas"`
+		SplitTopics          bool     `json:"split_topics"`
+		SplitStatic          bool     `json:"split_static"`
+		Brokers              []string `json:"brokers"`
+		FailbackAfter        Duration `json:"failback_after"`
+		Compress             bool     `json:"compress"`
+		CompressAbove        int      `json:"compress_above"`
+		RemoteConfig         bool     `json:"remote_config"`
+		SlowPublishThreshold Duration `json:"slow_publish_threshold"`
+		DuplicateCheck       *bool    `json:"duplicate_check"`
 	} `json:"mqtt"`
 	Log struct {
-		Level string `json:"level"`
-		File  string `json:"file"`
+		Level          string   `json:"level"`
+		File           string   `json:"file"`
+		MaxSizeMB      int      `json:"max_size_mb"`
+		MaxFiles       int      `json:"max_files"`
+		Compress       bool     `json:"compress"`
+		Format         string   `json:"format"`
+		Console        bool     `json:"console"`
+		Routes         Routes   `json:"routes"`
+		Syslog         bool     `json:"syslog"`
+		SuppressWindow Duration `json:"suppress_window"`
+		SuppressErrors bool     `json:"suppress_errors"`
 	} `json:"log"`
-	SleepInterval  int `json:"sleep_interval"`
-	UpdaterService struct {
-		MetadataURL string `json:"metadata_url"`
-		Username    string `json:"username"`
-		Password    string `json:"password"`
+	HTTP struct {
+		Listen string `json:"listen"`
+	} `json:"http"`
+	Network struct {
+		ExcludeInterfaces []string `json:"exclude_interfaces"`
+	} `json:"network"`
+	Gatherers map[string]bool `json:"gatherers"`
+	AppChecks []AppCheck      `json:"app_checks"`
+	Buildroot struct {
+		Services []string `json:"services"`
+	} `json:"buildroot"`
+	Containers struct {
+		Socket    string   `json:"socket"`
+		Allowlist []string `json:"allowlist"`
+	} `json:"containers"`
+	Temperature struct {
+		Primary    string             `json:"primary"`
+		Thresholds map[string]float64 `json:"thresholds"`
+	} `json:"temperature"`
+	USB struct {
+		Expected []struct {
+			VendorID  string `json:"vendor_id"`
+			ProductID string `json:"product_id"`
+			Label     string `json:"label"`
+		} `json:"expected"`
+	} `json:"usb"`
+	Location struct {
+		Enabled         bool     `json:"enabled"`
+		Precision       *int     `json:"precision"`
+		PublishInterval Duration `json:"publish_interval"`
+	} `json:"location"`
+	WANIP struct {
+		URL string `json:"url"`
+	} `json:"wan_ip"`
+	Commands struct {
+		Enabled bool `json:"enabled"`
+	} `json:"commands"`
+	Metrics struct {
+		PublishEvery int `json:"publish_every"`
+		// Gatherers taking longer than this in a status cycle are logged as a warning
+		SlowGatherThreshold Duration `json:"slow_gather_threshold"`
+	} `json:"metrics"`
+	History struct {
+		MaxEntries int `json:"max_entries"`
+		MaxBytes   int `json:"max_bytes"`
+	} `json:"history"`
+	Payload struct {
+		LegacyFields  *bool    `json:"legacy_fields"`
+		TempThreshold float64  `json:"temp_threshold"`
+		AlwaysSend    []string `json:"always_send"`
+		DiffIgnore    []string `json:"diff_ignore"`
+		Redact        []string `json:"redact"`
+		NullRemoved   *bool    `json:"null_removed"`
+		RedactOmit    bool     `json:"redact_omit"`
+		// Fields resent when unchanged for longer than their TTL, e.g. {"modem": "1h"}
+		FieldTTL map[string]Duration `json:"field_ttl"`
+	} `json:"payload"`
+	Fallback struct {
+		HTTPURL   string `json:"http_url"`
+		Token     string `json:"token"`
+		TokenFile string `json:"token_file"`
+	} `json:"fallback"`
+	ServiceWatch struct {
+		Disabled bool     `json:"disabled"`
+		Cooldown Duration `json:"cooldown"`
+	} `json:"service_watch"`
+	LogWatch struct {
+		Files []struct {
+			Path  string `json:"path"`
+			Regex string `json:"regex"`
+			Label string `json:"label"`
+		} `json:"files"`
+		EventThreshold int `json:"event_threshold"`
+	} `json:"log_watch"`
+	Self struct {
+		RSSCeilingMB int `json:"rss_ceiling_mb"`
+		RestartAfter int `json:"restart_after"`
+	} `json:"self"`
+	Backoff struct {
+		IdleCycles        int      `json:"idle_cycles"`
+		MaxInterval       Duration `json:"max_interval"`
+		HeartbeatInterval Duration `json:"heartbeat_interval"`
+	} `json:"backoff"`
+	Events struct {
+		CheckInterval   Duration `json:"check_interval"`
+		Cooldown        Duration `json:"cooldown"`
+		TempAbove       float64  `json:"temp_above"`
+		DiskAbovePct    float64  `json:"disk_above_pct"`
+		SignalBelowPct  int      `json:"signal_below_pct"`
+		ServiceInactive bool     `json:"service_inactive"`
+		VPNDown         bool     `json:"vpn_down"`
+		ContainerDown   *bool    `json:"container_down"`
+		StorageCritical *bool    `json:"storage_critical"`
+		MainsLost       *bool    `json:"mains_lost"`
+	} `json:"events"`
+	Site                   string   `json:"site"`
+	Label                  string   `json:"label"`
+	StateDir               string   `json:"state_dir"`
+	SleepInterval          Duration `json:"sleep_interval"`
+	SleepIntervalJitterPct *int     `json:"sleep_interval_jitter_pct"`
+	PublishRetryDelay      Duration `json:"publish_retry_delay"`
+	UpdateCheckInterval    Duration `json:"update_check_interval"`
+	UpdateCheckJitterPct   *int     `json:"update_check_jitter_pct"`
+	UpdateCheckIntervalMax Duration `json:"update_check_interval_max"` // Deprecated, only warned about
+	UpdateLockWait         Duration `json:"update_lock_wait"`
+	InitialDelayMax        Duration `json:"initial_delay_max"`
+	FullSyncInterval       Duration `json:"full_sync_interval"`
+	UpdaterService         struct {
+		MetadataURL          string `json:"metadata_url"`
+		Username             string `json:"username"`
+		Password             string `json:"password"`
+		PasswordFile         string `json:"password_file"`
+		MaxDownloadRate      int64  `json:"max_download_rate"`
+		CellularDownloadRate int64  `json:"cellular_download_rate"`
+		Disabled             bool   `json:"-"`
 	} `json:"updater_service"`
+
+	// SHA-256 of the config file this was loaded from, with secrets redacted
+	Hash string `json:"-"`
+}
+
+// Config in effect, replaced as a whole on reload
+var current atomic.Pointer[Config]
+
+// Returned by Current before a config was loaded
+var empty Config
+
+// Returns the config in effect. It is shared and must not be modified; take it once per operation so a reload
+// halfway through doesn't mix old and new values.
+func Current() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	return &empty
+}
+
+// Puts a loaded and validated config in effect
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// Gatherers that can be switched off in the gatherers section
+var GathererNames = []string{"modem", "lldp", "wifi", "temperature", "helpcom", "services", "usb", "power", "storage_health", "vpn", "containers"}
+
+// Reports whether a gatherer is enabled; every gatherer is unless switched off in the gatherers section
+func (c *Config) GathererEnabled(name string) bool {
+	enabled, ok := c.Gatherers[name]
+	return !ok || enabled
+}
+
+// Application probe run every status cycle, e.g. {"name": "sip", "type": "tcp_port", "target": "127.0.0.1:5060"}
+type AppCheck struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+
+	// Device types the check runs on: exact types such as "hc925", or "hc9xx" and "sos"; empty runs it everywhere
+	DeviceTypes []string `json:"device_types"`
+}
+
+// Probe types app_checks accepts
+var AppCheckTypes = []string{"tcp_port", "http_get", "unix_socket"}
+
+// Broker host and port to connect to
+type Broker struct {
+	Host string
+	Port int
+}
+
+// Returns the brokers in order of preference: mqtt.brokers entries (host or host:port, port defaulting to
+// mqtt.port) when set, otherwise mqtt.broker, or mqtt.broker_ip without a hostname
+func (c *Config) BrokerList() []Broker {
+	if len(c.MQTT.Brokers) == 0 {
+		host := c.MQTT.Broker
+		if host == "" {
+			host = c.MQTT.BrokerIP
+		}
+		return []Broker{{Host: host, Port: c.MQTT.Port}}
+	}
+
+	brokers := make([]Broker, 0, len(c.MQTT.Brokers))
+	for _, entry := range c.MQTT.Brokers {
+		broker, err := parseBroker(entry, c.MQTT.Port)
+		if err == nil {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+func parseBroker(entry string, defaultPort int) (Broker, error) {
+	host, portText, err := net.SplitHostPort(entry)
+	if err != nil {
+		// No port, or a bare IPv6 address
+		return Broker{Host: strings.Trim(entry, "[]"), Port: defaultPort}, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return Broker{}, fmt.Errorf("port %q is out of range 1-65535", portText)
+	}
+	if host == "" {
+		return Broker{}, fmt.Errorf("host is empty")
+	}
+	return Broker{Host: host, Port: port}, nil
 }
 
-var Current Config
+// Absolute path of the loaded config file
+var Path string
 
 var LogLevels = map[string]int{
 	"DEBUG": 1,
@@ -29,3 +262,9 @@ var LogLevels = map[string]int{
 	"WARN":  3,
 	"ERROR": 4,
 }
+
+// Destinations a log level can be routed to
+var LogDestinations = []string{"file", "console", "syslog", "event"}
+
+// Log destinations per level; levels left out go to the file, console and syslog as enabled
+type Routes map[string][]string
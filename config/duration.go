@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Accepts Go duration strings ("5m", "1h30m") or plain integer seconds for backward compatibility
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch value := raw.(type) {
+	case float64:
+		*d = Duration(time.Duration(value * float64(time.Second)))
+		return nil
+	case string:
+		parsed, err := ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	case nil:
+		*d = 0
+		return nil
+	default:
+		return fmt.Errorf("invalid duration %s: expected a duration string or number of seconds", string(data))
+	}
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Parses a duration string, treating a bare number as seconds
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: expected e.g. \"5m\", \"1h30m\" or a number of seconds", value)
+	}
+	return parsed, nil
+}
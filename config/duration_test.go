@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+	}{
+		{`60`, time.Minute},
+		{`1.5`, 1500 * time.Millisecond},
+		{`0`, 0},
+		{`"10m"`, 10 * time.Minute},
+		{`"1h30m"`, 90 * time.Minute},
+		{`"300"`, 5 * time.Minute},
+		{`" 2s "`, 2 * time.Second},
+		{`""`, 0},
+		{`null`, 0},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.input), &d); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.input, err)
+			continue
+		}
+		if d.Duration() != tt.want {
+			t.Errorf("unmarshal %s = %v, want %v", tt.input, d.Duration(), tt.want)
+		}
+	}
+}
+
+func TestDurationUnmarshalInvalid(t *testing.T) {
+	for _, input := range []string{`"ten minutes"`, `"5 parsecs"`, `true`, `[60]`, `{"seconds":60}`, `60s`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(input), &d); err == nil {
+			t.Errorf("unmarshal %s = %v, want an error", input, d.Duration())
+		}
+	}
+}
+
+func TestDurationRoundTrip(t *testing.T) {
+	type settings struct {
+		Interval Duration `json:"interval"`
+	}
+	for _, want := range []time.Duration{0, 1500 * time.Millisecond, 10 * time.Minute, 36*time.Hour + 5*time.Second} {
+		data, err := json.Marshal(settings{Interval: Duration(want)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded settings
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		if decoded.Interval.Duration() != want {
+			t.Errorf("round trip of %v through %s = %v", want, data, decoded.Interval.Duration())
+		}
+	}
+
+	data, err := json.Marshal(Duration(10 * time.Minute))
+	if err != nil || string(data) != `"10m0s"` {
+		t.Errorf("marshal 10m = %s, %v, want \"10m0s\"", data, err)
+	}
+}
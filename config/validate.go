@@ -0,0 +1,419 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Documented defaults applied by Validate
+const (
+	DefaultSleepInterval        = Duration(300 * time.Second)
+	DefaultSleepJitterPct       = 5
+	DefaultPublishRetryDelay    = Duration(180 * time.Second)
+	DefaultUpdateCheckInterval  = Duration(12 * time.Hour)
+	DefaultUpdateCheckJitterPct = 25
+	DefaultInitialDelayMax      = Duration(4 * time.Hour)
+	DefaultFullSyncInterval     = Duration(12 * time.Hour)
+	DefaultMQTTPort             = 8883
+	DefaultTopicTemplate        = "{deviceID}/status"
+	DefaultMQTTScheme           = "ssl"
+	DefaultWebSocketPath        = "/mqtt"
+	DefaultLogLevel             = "INFO"
+	DefaultLogFile              = "/var/log/status-updater.log"
+	DefaultStateDir             = "/var/lib/status-updater"
+	DefaultTempThreshold        = 0.5
+	DefaultEventCheckInterval   = Duration(30 * time.Second)
+	DefaultEventCooldown        = Duration(15 * time.Minute)
+	DefaultServiceWatchCooldown = Duration(5 * time.Minute)
+	DefaultBackoffMaxInterval   = Duration(30 * time.Minute)
+	DefaultHeartbeatInterval    = Duration(10 * time.Minute)
+	DefaultLocationPrecision    = 3
+	DefaultFailbackAfter        = Duration(30 * time.Minute)
+	DefaultCompressAbove        = 1024
+	DefaultSlowPublishThreshold = Duration(2 * time.Second)
+	DefaultUpdateLockWait       = Duration(30 * time.Minute)
+	DefaultSlowGatherThreshold  = Duration(10 * time.Second)
+	DefaultHistoryMaxEntries    = 200
+	DefaultHistoryMaxBytes      = 64 * 1024
+)
+
+// Interfaces left out of network reporting and change detection unless network.exclude_interfaces is set
+var DefaultExcludeInterfaces = []string{"lo", "docker*", "veth*", "br-*"}
+
+// Aggregated problems found by Validate; fatal ones prevent startup
+type ValidationError struct {
+	Fatal    []string
+	Warnings []string
+}
+
+func (e *ValidationError) Error() string {
+	problems := append(append([]string{}, e.Fatal...), e.Warnings...)
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(problems, "; "))
+}
+
+func (e *ValidationError) IsFatal() bool {
+	return len(e.Fatal) > 0
+}
+
+// Applies defaults and range-checks the config, returning a *ValidationError listing every problem
+func (c *Config) Validate() error {
+	result := &ValidationError{}
+	fatal := func(format string, args ...interface{}) {
+		result.Fatal = append(result.Fatal, fmt.Sprintf(format, args...))
+	}
+	warn := func(format string, args ...interface{}) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	// MQTT
+	if c.MQTT.Broker == "" && c.MQTT.BrokerIP == "" && len(c.MQTT.Brokers) == 0 {
+		fatal("mqtt.broker, mqtt.broker_ip or mqtt.brokers is required")
+	}
+	if c.MQTT.Username == "" {
+		fatal("mqtt.username is required")
+	}
+	if c.MQTT.Password == "" {
+		fatal("mqtt.password is required")
+	}
+	c.MQTT.Scheme = strings.ToLower(c.MQTT.Scheme)
+	switch c.MQTT.Scheme {
+	case "":
+		c.MQTT.Scheme = DefaultMQTTScheme
+	case "ssl", "wss":
+	case "tcp", "ws":
+		if !c.MQTT.AllowInsecure {
+			fatal("mqtt.scheme %q is unencrypted and requires mqtt.allow_insecure: true", c.MQTT.Scheme)
+		}
+	default:
+		fatal("mqtt.scheme %q is not one of ssl, tcp, ws, wss", c.MQTT.Scheme)
+	}
+	if (c.MQTT.Scheme == "ws" || c.MQTT.Scheme == "wss") && c.MQTT.WebSocketPath == "" {
+		c.MQTT.WebSocketPath = DefaultWebSocketPath
+	}
+	if c.MQTT.Port == 0 {
+		c.MQTT.Port = DefaultMQTTPort
+	} else if c.MQTT.Port < 1 || c.MQTT.Port > 65535 {
+		fatal("mqtt.port %d is out of range 1-65535", c.MQTT.Port)
+	}
+	for _, entry := range c.MQTT.Brokers {
+		if _, err := parseBroker(entry, c.MQTT.Port); err != nil {
+			fatal("mqtt.brokers entry %q is invalid: %v", entry, err)
+		}
+	}
+	if c.MQTT.CompressAbove == 0 {
+		c.MQTT.CompressAbove = DefaultCompressAbove
+	} else if c.MQTT.CompressAbove < 0 {
+		warn("mqtt.compress_above %d is negative, using %d", c.MQTT.CompressAbove, DefaultCompressAbove)
+		c.MQTT.CompressAbove = DefaultCompressAbove
+	}
+	if c.MQTT.TopicTemplate == "" {
+		c.MQTT.TopicTemplate = DefaultTopicTemplate
+	} else if !strings.Contains(c.MQTT.TopicTemplate, "{deviceID}") {
+		fatal("mqtt.topic_template %q must contain {deviceID}", c.MQTT.TopicTemplate)
+	}
+	if strings.Contains(c.MQTT.TopicTemplate, "{site}") && c.Site == "" {
+		fatal("mqtt.topic_template uses {site} but site is not set")
+	}
+	if c.MQTT.ResolveCacheTTL < 0 {
+		warn("mqtt.resolve_cache_ttl %s is negative, using default", c.MQTT.ResolveCacheTTL)
+		c.MQTT.ResolveCacheTTL = 0
+	}
+
+	// Logging
+	if c.Log.File == "" && !c.Log.Syslog {
+		c.Log.File = DefaultLogFile
+	}
+	c.Log.Level = strings.ToUpper(c.Log.Level)
+	if c.Log.Level == "" {
+		c.Log.Level = DefaultLogLevel
+	} else if _, ok := LogLevels[c.Log.Level]; !ok {
+		warn("log.level %q is not one of DEBUG, INFO, WARN, ERROR, using %s", c.Log.Level, DefaultLogLevel)
+		c.Log.Level = DefaultLogLevel
+	}
+	if c.Log.Format != "" && c.Log.Format != "text" && c.Log.Format != "json" {
+		warn("log.format %q is not one of text, json, using text", c.Log.Format)
+		c.Log.Format = "text"
+	}
+	if c.Log.MaxSizeMB < 0 {
+		warn("log.max_size_mb %d is negative, using default", c.Log.MaxSizeMB)
+		c.Log.MaxSizeMB = 0
+	}
+	if c.Log.MaxFiles < 0 {
+		warn("log.max_files %d is negative, using default", c.Log.MaxFiles)
+		c.Log.MaxFiles = 0
+	}
+	routes := make(Routes)
+	for level, destinations := range c.Log.Routes {
+		level = strings.ToUpper(level)
+		if _, ok := LogLevels[level]; !ok {
+			warn("log.routes level %q is not one of DEBUG, INFO, WARN, ERROR, ignoring it", level)
+			continue
+		}
+		// An empty list is kept, it silences the level
+		routes[level] = []string{}
+		for _, destination := range destinations {
+			known := false
+			for _, name := range LogDestinations {
+				known = known || destination == name
+			}
+			if !known {
+				warn("log.routes %s destination %q is not one of %s, ignoring it", level, destination, strings.Join(LogDestinations, ", "))
+				continue
+			}
+			routes[level] = append(routes[level], destination)
+		}
+	}
+	c.Log.Routes = routes
+
+	if c.StateDir == "" {
+		c.StateDir = DefaultStateDir
+	}
+
+	// Scheduling
+	checkDuration := func(name string, value *Duration, def, lower, upper Duration) {
+		if *value == 0 {
+			*value = def
+		} else if *value < lower || *value > upper {
+			warn("%s %s is out of range %s-%s, using %s", name, *value, lower, upper, def)
+			*value = def
+		}
+	}
+	checkDuration("sleep_interval", &c.SleepInterval, DefaultSleepInterval, Duration(10*time.Second), Duration(24*time.Hour))
+	if c.SleepIntervalJitterPct == nil {
+		jitter := DefaultSleepJitterPct
+		c.SleepIntervalJitterPct = &jitter
+	} else if *c.SleepIntervalJitterPct < 0 || *c.SleepIntervalJitterPct > 25 {
+		warn("sleep_interval_jitter_pct %d is out of range 0-25, using %d", *c.SleepIntervalJitterPct, DefaultSleepJitterPct)
+		jitter := DefaultSleepJitterPct
+		c.SleepIntervalJitterPct = &jitter
+	}
+	checkDuration("mqtt.failback_after", &c.MQTT.FailbackAfter, DefaultFailbackAfter, Duration(time.Minute), Duration(24*time.Hour))
+	if c.MQTT.DuplicateCheck == nil {
+		duplicateCheck := true
+		c.MQTT.DuplicateCheck = &duplicateCheck
+	}
+	checkDuration("mqtt.slow_publish_threshold", &c.MQTT.SlowPublishThreshold, DefaultSlowPublishThreshold, Duration(100*time.Millisecond), Duration(10*time.Second))
+	checkDuration("publish_retry_delay", &c.PublishRetryDelay, DefaultPublishRetryDelay, Duration(time.Second), Duration(time.Hour))
+	if c.UpdateCheckIntervalMax != 0 {
+		warn("update_check_interval_max is no longer used, set update_check_interval and update_check_jitter_pct instead")
+	}
+	checkDuration("update_check_interval", &c.UpdateCheckInterval, DefaultUpdateCheckInterval, Duration(time.Minute), Duration(7*24*time.Hour))
+	if c.UpdateCheckJitterPct == nil {
+		jitter := DefaultUpdateCheckJitterPct
+		c.UpdateCheckJitterPct = &jitter
+	} else if *c.UpdateCheckJitterPct < 0 || *c.UpdateCheckJitterPct > 90 {
+		warn("update_check_jitter_pct %d is out of range 0-90, using %d", *c.UpdateCheckJitterPct, DefaultUpdateCheckJitterPct)
+		jitter := DefaultUpdateCheckJitterPct
+		c.UpdateCheckJitterPct = &jitter
+	}
+	checkDuration("update_lock_wait", &c.UpdateLockWait, DefaultUpdateLockWait, Duration(time.Minute), Duration(6*time.Hour))
+	checkDuration("initial_delay_max", &c.InitialDelayMax, DefaultInitialDelayMax, Duration(time.Second), Duration(24*time.Hour))
+	checkDuration("full_sync_interval", &c.FullSyncInterval, DefaultFullSyncInterval, Duration(time.Minute), Duration(7*24*time.Hour))
+
+	if c.Backoff.IdleCycles < 0 {
+		warn("backoff.idle_cycles %d is negative, adaptive scheduling disabled", c.Backoff.IdleCycles)
+		c.Backoff.IdleCycles = 0
+	}
+	checkDuration("backoff.max_interval", &c.Backoff.MaxInterval, DefaultBackoffMaxInterval, Duration(time.Minute), Duration(24*time.Hour))
+	if c.Backoff.MaxInterval < c.SleepInterval {
+		warn("backoff.max_interval %s is shorter than sleep_interval, using %s", c.Backoff.MaxInterval, c.SleepInterval)
+		c.Backoff.MaxInterval = c.SleepInterval
+	}
+	checkDuration("backoff.heartbeat_interval", &c.Backoff.HeartbeatInterval, DefaultHeartbeatInterval, Duration(10*time.Second), Duration(24*time.Hour))
+
+	// Network
+	if c.Network.ExcludeInterfaces == nil {
+		c.Network.ExcludeInterfaces = DefaultExcludeInterfaces
+	}
+	for _, pattern := range c.Network.ExcludeInterfaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			warn("network.exclude_interfaces pattern %q is invalid: %v", pattern, err)
+		}
+	}
+
+	// Gatherers
+	for name := range c.Gatherers {
+		known := false
+		for _, gatherer := range GathererNames {
+			known = known || name == gatherer
+		}
+		if !known {
+			warn("gatherers.%s is not a known gatherer (%s)", name, strings.Join(GathererNames, ", "))
+		}
+	}
+
+	// USB
+	for i := range c.USB.Expected {
+		expected := &c.USB.Expected[i]
+		if expected.VendorID == "" || expected.ProductID == "" {
+			warn("usb.expected[%d] needs vendor_id and product_id, ignoring it", i)
+			continue
+		}
+		if expected.Label == "" {
+			expected.Label = expected.VendorID + ":" + expected.ProductID
+		}
+	}
+
+	// Application checks; invalid ones are dropped so they don't report as failing forever
+	checks := c.AppChecks[:0]
+	names := make(map[string]bool)
+	for i, check := range c.AppChecks {
+		known := false
+		for _, checkType := range AppCheckTypes {
+			known = known || check.Type == checkType
+		}
+		switch {
+		case check.Name == "" || check.Target == "":
+			warn("app_checks[%d] needs name and target, ignoring it", i)
+		case !known:
+			warn("app_checks %q has unknown type %q (%s), ignoring it", check.Name, check.Type, strings.Join(AppCheckTypes, ", "))
+		case names[check.Name]:
+			warn("app_checks %q is listed twice, ignoring the second one", check.Name)
+		default:
+			names[check.Name] = true
+			checks = append(checks, check)
+		}
+	}
+	c.AppChecks = checks
+
+	// Location
+	if c.Location.Precision == nil {
+		precision := DefaultLocationPrecision
+		c.Location.Precision = &precision
+	} else if *c.Location.Precision < 0 || *c.Location.Precision > 6 {
+		warn("location.precision %d is out of range 0-6, using %d", *c.Location.Precision, DefaultLocationPrecision)
+		precision := DefaultLocationPrecision
+		c.Location.Precision = &precision
+	}
+	if c.Location.PublishInterval != 0 && c.Location.PublishInterval < Duration(10*time.Second) {
+		warn("location.publish_interval %s is below 10s, location topic disabled", c.Location.PublishInterval)
+		c.Location.PublishInterval = 0
+	}
+
+	if c.Metrics.PublishEvery < 0 {
+		warn("metrics.publish_every %d is negative, metrics publishing disabled", c.Metrics.PublishEvery)
+		c.Metrics.PublishEvery = 0
+	}
+	if c.History.MaxEntries == 0 {
+		c.History.MaxEntries = DefaultHistoryMaxEntries
+	} else if c.History.MaxEntries < 0 || c.History.MaxEntries > 5000 {
+		warn("history.max_entries %d is out of range (1-5000), using %d", c.History.MaxEntries, DefaultHistoryMaxEntries)
+		c.History.MaxEntries = DefaultHistoryMaxEntries
+	}
+	if c.History.MaxBytes == 0 {
+		c.History.MaxBytes = DefaultHistoryMaxBytes
+	} else if c.History.MaxBytes < 4096 || c.History.MaxBytes > 1024*1024 {
+		warn("history.max_bytes %d is out of range (4096-1048576), using %d", c.History.MaxBytes, DefaultHistoryMaxBytes)
+		c.History.MaxBytes = DefaultHistoryMaxBytes
+	}
+	checkDuration("metrics.slow_gather_threshold", &c.Metrics.SlowGatherThreshold, DefaultSlowGatherThreshold, Duration(100*time.Millisecond), Duration(10*time.Minute))
+
+	if c.Events.StorageCritical == nil {
+		storageCritical := true
+		c.Events.StorageCritical = &storageCritical
+	}
+	if c.Events.MainsLost == nil {
+		mainsLost := true
+		c.Events.MainsLost = &mainsLost
+	}
+	if c.Events.ContainerDown == nil {
+		containerDown := true
+		c.Events.ContainerDown = &containerDown
+	}
+
+	// Payload
+	if c.Payload.LegacyFields == nil {
+		legacy := true
+		c.Payload.LegacyFields = &legacy
+	}
+	if c.Payload.TempThreshold == 0 {
+		c.Payload.TempThreshold = DefaultTempThreshold
+	} else if c.Payload.TempThreshold < 0 {
+		warn("payload.temp_threshold %g is negative, using %g", c.Payload.TempThreshold, DefaultTempThreshold)
+		c.Payload.TempThreshold = DefaultTempThreshold
+	}
+	for _, path := range c.Payload.Redact {
+		if path == "status" || path == "deviceID" {
+			warn("payload.redact entry %q is ignored, the backend routes on it", path)
+		}
+	}
+	for field, ttl := range c.Payload.FieldTTL {
+		if ttl < Duration(time.Minute) {
+			warn("payload.field_ttl of %s is %s, below the minimum of 1m, ignoring it", field, ttl)
+			delete(c.Payload.FieldTTL, field)
+		}
+	}
+
+	// HTTP fallback
+	if c.Fallback.HTTPURL != "" && !strings.HasPrefix(strings.ToLower(c.Fallback.HTTPURL), "https://") {
+		warn("fallback.http_url %q is not an https URL, HTTP fallback disabled", c.Fallback.HTTPURL)
+		c.Fallback.HTTPURL = ""
+	}
+
+	checkDuration("service_watch.cooldown", &c.ServiceWatch.Cooldown, DefaultServiceWatchCooldown, Duration(time.Second), Duration(24*time.Hour))
+
+	// Log watching
+	for _, entry := range c.LogWatch.Files {
+		if entry.Path == "" || entry.Regex == "" {
+			warn("log_watch.files entry %q needs both path and regex", entry.Label)
+		}
+	}
+	if c.LogWatch.EventThreshold < 0 {
+		warn("log_watch.event_threshold %d is negative, log events disabled", c.LogWatch.EventThreshold)
+		c.LogWatch.EventThreshold = 0
+	}
+
+	// Self-monitoring
+	if c.Self.RSSCeilingMB < 0 {
+		warn("self.rss_ceiling_mb %d is negative, RSS ceiling disabled", c.Self.RSSCeilingMB)
+		c.Self.RSSCeilingMB = 0
+	}
+	if c.Self.RestartAfter < 0 {
+		warn("self.restart_after %d is negative, self-restart disabled", c.Self.RestartAfter)
+		c.Self.RestartAfter = 0
+	}
+
+	// Events
+	checkDuration("events.check_interval", &c.Events.CheckInterval, DefaultEventCheckInterval, Duration(5*time.Second), Duration(time.Hour))
+	checkDuration("events.cooldown", &c.Events.Cooldown, DefaultEventCooldown, Duration(time.Minute), Duration(24*time.Hour))
+	if c.Events.DiskAbovePct < 0 || c.Events.DiskAbovePct > 100 {
+		warn("events.disk_above_pct %g is out of range 0-100, disk events disabled", c.Events.DiskAbovePct)
+		c.Events.DiskAbovePct = 0
+	}
+	if c.Events.SignalBelowPct < 0 || c.Events.SignalBelowPct > 100 {
+		warn("events.signal_below_pct %d is out of range 0-100, signal events disabled", c.Events.SignalBelowPct)
+		c.Events.SignalBelowPct = 0
+	}
+
+	if c.UpdaterService.MaxDownloadRate < 0 {
+		warn("updater_service.max_download_rate %d is negative, downloads are unlimited", c.UpdaterService.MaxDownloadRate)
+		c.UpdaterService.MaxDownloadRate = 0
+	}
+	if c.UpdaterService.CellularDownloadRate < 0 {
+		warn("updater_service.cellular_download_rate %d is negative, ignoring it", c.UpdaterService.CellularDownloadRate)
+		c.UpdaterService.CellularDownloadRate = 0
+	}
+
+	// Missing updater settings disable the updater rather than failing every check
+	var missing []string
+	if c.UpdaterService.MetadataURL == "" {
+		missing = append(missing, "metadata_url")
+	}
+	if c.UpdaterService.Username == "" {
+		missing = append(missing, "username")
+	}
+	if c.UpdaterService.Password == "" {
+		missing = append(missing, "password")
+	}
+	c.UpdaterService.Disabled = len(missing) > 0
+	if c.UpdaterService.Disabled {
+		warn("updater_service is missing %s, updater disabled", strings.Join(missing, ", "))
+	}
+
+	if len(result.Fatal) == 0 && len(result.Warnings) == 0 {
+		return nil
+	}
+	return result
+}
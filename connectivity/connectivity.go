@@ -0,0 +1,209 @@
+package connectivity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/boot"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/logger"
+	"status-updater/state"
+	"sync"
+	"time"
+)
+
+const (
+	window = 24 * time.Hour
+
+	// Flapping connections would otherwise rewrite the file on every transition
+	saveInterval = 10 * time.Minute
+)
+
+// Broker connectivity reported as "connectivity"
+type Stats struct {
+	LastConnect             string `json:"last_connect,omitempty"`
+	LastDisconnect          string `json:"last_disconnect,omitempty"`
+	OfflineSecondsSinceBoot int64  `json:"offline_seconds_since_boot"`
+	OfflineSeconds24h       int64  `json:"offline_seconds_24h"`
+	Reconnects              int    `json:"reconnects"`
+}
+
+// Period without a broker connection, in time since boot; End is zero while it lasts
+type outage struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end,omitempty"`
+}
+
+// Counters since boot, persisted so a daemon restart continues them
+type persisted struct {
+	BootID         string        `json:"boot_id"`
+	LastConnect    time.Time     `json:"last_connect"`
+	LastDisconnect time.Time     `json:"last_disconnect"`
+	OfflineClosed  time.Duration `json:"offline_closed"`
+	Reconnects     int           `json:"reconnects"`
+	Outages        []outage      `json:"outages"`
+}
+
+var (
+	mu       sync.Mutex
+	loaded   bool
+	dirty    bool
+	lastSave time.Time
+	current  persisted
+
+	// Time since boot at startup; adding the process' monotonic clock keeps it immune to wall clock corrections
+	startUptime time.Duration
+	startTime   = time.Now()
+)
+
+func filePath() string {
+	return filepath.Join(config.Current().StateDir, "connectivity.json")
+}
+
+// Monotonic time since boot
+func sinceBoot() time.Duration {
+	return startUptime + time.Since(startTime)
+}
+
+// Records a successful broker connection, ending the current outage; call from the client's OnConnect handler
+func RecordConnected() {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	last := lastOutage()
+	if last != nil && last.End == 0 {
+		last.End = sinceBoot()
+		current.OfflineClosed += last.End - last.Start
+		current.Reconnects++
+		logger.LogMessage("INFO", fmt.Sprintf("Broker connection restored after %v offline", (last.End-last.Start).Round(time.Second)))
+	} else if !current.LastConnect.IsZero() {
+		// Publishing connects every cycle; only the first connect and reconnects are recorded
+		return
+	}
+	current.LastConnect = time.Now().UTC()
+	markDirty()
+}
+
+// Records a lost connection or a failed attempt, starting an outage that lasts until the next connect
+func RecordDisconnected() {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	if last := lastOutage(); last != nil && last.End == 0 {
+		return
+	}
+	current.LastDisconnect = time.Now().UTC()
+	current.Outages = append(current.Outages, outage{Start: sinceBoot()})
+	markDirty()
+}
+
+// Returns the connect/disconnect times, time offline and reconnects since boot
+func Snapshot() *Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	now := sinceBoot()
+	prune(now)
+	stats := &Stats{
+		OfflineSecondsSinceBoot: int64(current.OfflineClosed.Seconds()),
+		Reconnects:              current.Reconnects,
+	}
+	if !current.LastConnect.IsZero() {
+		stats.LastConnect = current.LastConnect.Format(time.RFC3339)
+	}
+	if !current.LastDisconnect.IsZero() {
+		stats.LastDisconnect = current.LastDisconnect.Format(time.RFC3339)
+	}
+
+	var offline24h time.Duration
+	for _, o := range current.Outages {
+		start, end := o.Start, o.End
+		if end == 0 {
+			end = now
+			stats.OfflineSecondsSinceBoot += int64((end - start).Seconds())
+		}
+		if start < now-window {
+			start = now - window
+		}
+		if end > start {
+			offline24h += end - start
+		}
+	}
+	stats.OfflineSeconds24h = int64(offline24h.Seconds())
+	return stats
+}
+
+// Persists the counters
+func Save() {
+	mu.Lock()
+	defer mu.Unlock()
+	if dirty {
+		saveLocked()
+	}
+}
+
+func lastOutage() *outage {
+	if len(current.Outages) == 0 {
+		return nil
+	}
+	return &current.Outages[len(current.Outages)-1]
+}
+
+func markDirty() {
+	dirty = true
+	prune(sinceBoot())
+	if time.Since(lastSave) >= saveInterval {
+		saveLocked()
+	}
+}
+
+// Drops outages that ended before the 24h window
+func prune(now time.Duration) {
+	keep := 0
+	for keep < len(current.Outages) && current.Outages[keep].End != 0 && current.Outages[keep].End < now-window {
+		keep++
+	}
+	current.Outages = current.Outages[keep:]
+}
+
+func saveLocked() {
+	lastSave = time.Now()
+	if err := state.WriteJSON(filePath(), current); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to save connectivity statistics: %s", err))
+		return
+	}
+	dirty = false
+}
+
+// Restores the counters of this boot; after a reboot they start over, time since boot can't be compared across boots
+func load() {
+	if loaded {
+		return
+	}
+	loaded = true
+
+	if uptime, err := gatherer.GetUptimeSeconds(); err == nil {
+		startUptime = time.Duration(uptime)*time.Second - time.Since(startTime)
+	}
+	current = persisted{BootID: boot.ID()}
+
+	data, err := os.ReadFile(filePath())
+	if err != nil {
+		return
+	}
+	var saved persisted
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Ignoring corrupt connectivity statistics %s: %s", filePath(), err))
+		return
+	}
+	if saved.BootID == "" || saved.BootID != current.BootID {
+		return
+	}
+	// An outage open when the daemon stopped continues until the next connect
+	current = saved
+}
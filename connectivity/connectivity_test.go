@@ -0,0 +1,159 @@
+package connectivity
+
+import (
+	"reflect"
+	"status-updater/boot"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"testing"
+	"time"
+)
+
+// Starts from empty counters, with the state file in a temporary directory, restoring everything afterwards
+func useState(t *testing.T) {
+	t.Helper()
+	previousConfig := config.Current()
+	mu.Lock()
+	previousLoaded, previousDirty, previousSave, previousCurrent := loaded, dirty, lastSave, current
+	previousUptime, previousStart := startUptime, startTime
+	mu.Unlock()
+	t.Cleanup(func() {
+		config.Set(previousConfig)
+		mu.Lock()
+		loaded, dirty, lastSave, current = previousLoaded, previousDirty, previousSave, previousCurrent
+		startUptime, startTime = previousUptime, previousStart
+		mu.Unlock()
+	})
+
+	config.Set(&config.Config{StateDir: t.TempDir()})
+	loaded, dirty, lastSave, current = false, false, time.Time{}, persisted{}
+}
+
+func TestPrune(t *testing.T) {
+	now := 30 * time.Hour
+	tests := []struct {
+		name    string
+		outages []outage
+		want    []outage
+	}{
+		{
+			name:    "ended before the window",
+			outages: []outage{{Start: time.Hour, End: 2 * time.Hour}, {Start: 3 * time.Hour, End: 5 * time.Hour}},
+			want:    []outage{},
+		},
+		{
+			name:    "ended at the start of the window",
+			outages: []outage{{Start: 5 * time.Hour, End: 6 * time.Hour}},
+			want:    []outage{{Start: 5 * time.Hour, End: 6 * time.Hour}},
+		},
+		{
+			name:    "straddles the start of the window",
+			outages: []outage{{Start: time.Hour, End: 2 * time.Hour}, {Start: 5 * time.Hour, End: 7 * time.Hour}},
+			want:    []outage{{Start: 5 * time.Hour, End: 7 * time.Hour}},
+		},
+		{
+			name:    "still open since before the window",
+			outages: []outage{{Start: time.Hour, End: 2 * time.Hour}, {Start: 3 * time.Hour}},
+			want:    []outage{{Start: 3 * time.Hour}},
+		},
+		{
+			name:    "inside the window",
+			outages: []outage{{Start: 10 * time.Hour, End: 11 * time.Hour}, {Start: 29 * time.Hour}},
+			want:    []outage{{Start: 10 * time.Hour, End: 11 * time.Hour}, {Start: 29 * time.Hour}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useState(t)
+			current.Outages = tt.outages
+			prune(now)
+			if !reflect.DeepEqual(current.Outages, tt.want) {
+				t.Errorf("outages = %v, want %v", current.Outages, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfflineSeconds24h(t *testing.T) {
+	useState(t)
+	loaded = true
+	startUptime, startTime = 30*time.Hour, time.Now()
+	current.OfflineClosed = 3 * time.Hour
+	current.Outages = []outage{{Start: time.Hour, End: 2 * time.Hour}, {Start: 5 * time.Hour, End: 7 * time.Hour}}
+
+	stats := Snapshot()
+	// Only the hour of the second outage after the start of the window counts, less the time the test took
+	if stats.OfflineSeconds24h < 3599 || stats.OfflineSeconds24h > 3600 {
+		t.Errorf("offline_seconds_24h = %d, want 3600", stats.OfflineSeconds24h)
+	}
+	if stats.OfflineSecondsSinceBoot != 3*3600 {
+		t.Errorf("offline_seconds_since_boot = %d, want %d", stats.OfflineSecondsSinceBoot, 3*3600)
+	}
+	if len(current.Outages) != 1 {
+		t.Errorf("%d outages kept, want the one inside the window", len(current.Outages))
+	}
+}
+
+func TestOutageOpenAcrossRestart(t *testing.T) {
+	if boot.ID() == "" {
+		t.Skip("boot ID not available")
+	}
+	uptime, err := gatherer.GetUptimeSeconds()
+	if err != nil {
+		t.Skip(err)
+	}
+	useState(t)
+
+	// The previous run lost the broker ten minutes ago and stopped before reconnecting
+	start := time.Duration(uptime)*time.Second - 10*time.Minute
+	if start < 0 {
+		start = 0
+	}
+	offline := time.Duration(uptime)*time.Second - start
+	saved := persisted{
+		BootID:         boot.ID(),
+		LastConnect:    time.Now().Add(-time.Hour).UTC(),
+		LastDisconnect: time.Now().Add(-10 * time.Minute).UTC(),
+		OfflineClosed:  time.Minute,
+		Reconnects:     2,
+		Outages:        []outage{{Start: start}},
+	}
+	current = saved
+	saveLocked()
+
+	t.Run("continues after the restart", func(t *testing.T) {
+		loaded, current = false, persisted{}
+		stats := Snapshot()
+		if stats.Reconnects != 2 {
+			t.Errorf("reconnects = %d, want 2", stats.Reconnects)
+		}
+		if want := int64((time.Minute + offline).Seconds()); stats.OfflineSecondsSinceBoot < want {
+			t.Errorf("offline_seconds_since_boot = %d, want at least %d", stats.OfflineSecondsSinceBoot, want)
+		}
+
+		RecordConnected()
+		if current.Reconnects != 3 {
+			t.Errorf("reconnects after the connect = %d, want 3", current.Reconnects)
+		}
+		if last := lastOutage(); last == nil || last.End == 0 {
+			t.Errorf("outage still open after the connect: %v", current.Outages)
+		}
+		if current.OfflineClosed < time.Minute+offline {
+			t.Errorf("offline time = %v, want at least %v", current.OfflineClosed, time.Minute+offline)
+		}
+	})
+
+	t.Run("starts over after a reboot", func(t *testing.T) {
+		other := saved
+		other.BootID = "another-boot"
+		current = other
+		saveLocked()
+
+		loaded, current = false, persisted{}
+		stats := Snapshot()
+		if stats.Reconnects != 0 || stats.OfflineSecondsSinceBoot != 0 || len(current.Outages) != 0 {
+			t.Errorf("stats after a reboot = %+v with outages %v, want none", stats, current.Outages)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package duplicate
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Message IDs remembered; far more than are published while the check runs
+const maxSent = 1024
+
+// Status message of another instance publishing to this device's topic
+type Foreign struct {
+	MsgID string
+	Seq   uint64
+}
+
+var (
+	mu        sync.Mutex
+	sent      = make(map[string]bool)
+	sentOrder []string
+	startSeq  uint64
+	suspected bool
+)
+
+// Sets the sequence number restored at startup; messages up to it may be this device's own from before a restart
+func Start(seq uint64) {
+	mu.Lock()
+	defer mu.Unlock()
+	startSeq = seq
+}
+
+// Remembers the msg_id of a message this instance publishes, so its redelivery isn't taken for another device
+func RecordSent(msgID string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if sent[msgID] {
+		return
+	}
+	sent[msgID] = true
+	sentOrder = append(sentOrder, msgID)
+	if len(sentOrder) > maxSent {
+		delete(sent, sentOrder[0])
+		sentOrder = sentOrder[1:]
+	}
+}
+
+// Checks a message received on the status topic, returning the other instance that sent it, if any. Messages
+// without a msg_id, with one this instance sent, or with a seq from before the restart are not counted.
+func Check(payload []byte) (*Foreign, bool) {
+	var message struct {
+		MsgID string `json:"msg_id"`
+		Seq   uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil || message.MsgID == "" {
+		return nil, false
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sent[message.MsgID] || message.Seq <= startSeq {
+		return nil, false
+	}
+	suspected = true
+	return &Foreign{MsgID: message.MsgID, Seq: message.Seq}, true
+}
+
+// Reports whether a message from another instance was seen since startup
+func Suspected() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return suspected
+}
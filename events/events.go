@@ -0,0 +1,293 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/logger"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Compact alert published to <root>/events
+type Event struct {
+	Type      string      `json:"type"`
+	State     string      `json:"state"`
+	Value     interface{} `json:"value"`
+	Threshold interface{} `json:"threshold,omitempty"`
+	Detail    string      `json:"detail,omitempty"`
+	Date      string      `json:"date"`
+	DeviceID  string      `json:"deviceID"`
+}
+
+// Event states
+const (
+	StateActive  = "active"
+	StateCleared = "cleared"
+)
+
+// Alert state shared between the checker and the status payload
+var (
+	alertsMutex  sync.Mutex
+	activeAlerts = make(map[string]bool)
+	announced    = make(map[string]bool)
+	lastSent     = make(map[string]time.Time)
+	lastServices = make(map[string]string)
+	lastTunnels  = make(map[string]string)
+	lastAppOK    = make(map[string]bool)
+
+	lastContainers = make(map[string]string)
+)
+
+// Reports whether any threshold is configured
+func Enabled() bool {
+	current := config.Current()
+	cfg := current.Events
+	return cfg.TempAbove > 0 || cfg.DiskAbovePct > 0 || cfg.SignalBelowPct > 0 || cfg.ServiceInactive || cfg.VPNDown || storageCritical() || mainsLost() ||
+		len(current.USB.Expected) > 0 || len(current.Temperature.Thresholds) > 0 || len(current.AppChecks) > 0 ||
+		containerDown()
+}
+
+func storageCritical() bool {
+	critical := config.Current().Events.StorageCritical
+	return critical != nil && *critical
+}
+
+func containerDown() bool {
+	cfg := config.Current()
+	return cfg.Events.ContainerDown != nil && *cfg.Events.ContainerDown && cfg.GathererEnabled("containers")
+}
+
+func mainsLost() bool {
+	lost := config.Current().Events.MainsLost
+	return lost != nil && *lost
+}
+
+// Checks thresholds every events.check_interval and calls publish for each event, until ctx is cancelled
+func Run(ctx context.Context, publish func(Event)) {
+	ticker := time.NewTicker(config.Current().Events.CheckInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, event := range check(time.Now()) {
+				publish(event)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Returns the sorted types of alerts that are currently active
+func ActiveAlerts() []string {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	alerts := make([]string, 0, len(activeAlerts))
+	for alert := range activeAlerts {
+		alerts = append(alerts, alert)
+	}
+	sort.Strings(alerts)
+	return alerts
+}
+
+// Evaluates every configured threshold once
+func check(now time.Time) []Event {
+	current := config.Current()
+	cfg := current.Events
+	var events []Event
+
+	if cfg.TempAbove > 0 {
+		if temp, err := strconv.ParseFloat(gatherer.GetTemperature(), 64); err == nil {
+			events = appendEvent(events, now, "temperature_high", temp > cfg.TempAbove, temp, cfg.TempAbove)
+		}
+	}
+
+	if thresholds := current.Temperature.Thresholds; len(thresholds) > 0 {
+		temps := gatherer.GetTemperatures()
+		for sensor, threshold := range thresholds {
+			if temp, ok := temps[sensor]; ok {
+				events = appendEvent(events, now, "temperature_high:"+sensor, temp > threshold, temp, threshold)
+			}
+		}
+	}
+
+	if cfg.DiskAbovePct > 0 {
+		if used, err := diskUsagePct("/"); err == nil {
+			events = appendEvent(events, now, "disk_high", used > cfg.DiskAbovePct, used, cfg.DiskAbovePct)
+		} else {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to check disk usage: %s", err))
+		}
+	}
+
+	if cfg.SignalBelowPct > 0 {
+		if quality, ok := signalQuality(); ok {
+			events = appendEvent(events, now, "signal_low", quality < cfg.SignalBelowPct, quality, cfg.SignalBelowPct)
+		}
+	}
+
+	if cfg.ServiceInactive {
+		states, err := gatherer.GetServiceStates()
+		if err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to check service states: %s", err))
+		}
+		for _, state := range states {
+			eventType := "service_inactive:" + state.Name
+
+			// Only a transition away from active raises the alert, not a service that was never running
+			alertsMutex.Lock()
+			wasRunning := lastServices[state.Name] == "active"
+			lastServices[state.Name] = state.ActiveState
+			inactive := state.ActiveState != "active" && (wasRunning || activeAlerts[eventType])
+			alertsMutex.Unlock()
+
+			events = appendEvent(events, now, eventType, inactive, state.ActiveState, nil)
+		}
+	}
+
+	if cfg.VPNDown {
+		for _, tunnel := range gatherer.GetVPNTunnels() {
+			eventType := "vpn_down:" + tunnel.Interface
+
+			// Only a healthy tunnel going down raises the alert, not one that was never up or only degraded
+			alertsMutex.Lock()
+			wasUp := lastTunnels[tunnel.Interface] == gatherer.TunnelUp
+			lastTunnels[tunnel.Interface] = tunnel.State
+			down := tunnel.State == gatherer.TunnelDown && (wasUp || activeAlerts[eventType])
+			alertsMutex.Unlock()
+
+			events = appendEvent(events, now, eventType, down, tunnel.State, nil)
+		}
+	}
+
+	// Results of the latest status cycle; only a check that passed before failing raises the alert
+	for _, result := range gatherer.LastAppChecks() {
+		eventType := "app_check_failed:" + result.Name
+
+		alertsMutex.Lock()
+		wasOK := lastAppOK[result.Name]
+		lastAppOK[result.Name] = result.OK
+		failed := !result.OK && (wasOK || activeAlerts[eventType])
+		alertsMutex.Unlock()
+
+		events = appendEvent(events, now, eventType, failed, result.Error, nil)
+	}
+
+	// Only a running container exiting or crash-looping raises the alert; devices without a container runtime report nothing
+	if containerDown() {
+		containers, err := gatherer.GetContainers()
+		if err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to check container states: %s", err))
+		}
+		for _, container := range containers {
+			eventType := "container_down:" + container.Name
+
+			alertsMutex.Lock()
+			wasRunning := lastContainers[container.Name] == "running"
+			lastContainers[container.Name] = container.State
+			stopped := container.State == "exited" || container.State == "restarting" || container.State == "dead"
+			down := stopped && (wasRunning || activeAlerts[eventType])
+			alertsMutex.Unlock()
+
+			events = appendEvent(events, now, eventType, down, container.State, nil)
+		}
+	}
+
+	if expected := current.USB.Expected; len(expected) > 0 {
+		devices := gatherer.GetUSBDevices()
+		for _, device := range expected {
+			if device.VendorID == "" || device.ProductID == "" {
+				continue
+			}
+			missing := !gatherer.HasUSBDevice(devices, device.VendorID, device.ProductID)
+			events = appendEvent(events, now, "usb_missing:"+device.Label, missing, device.VendorID+":"+device.ProductID, nil)
+		}
+	}
+
+	// Active on mains loss, cleared when it is restored; devices without a power supply report nothing
+	if mainsLost() {
+		if power := gatherer.GetPower(); power != nil {
+			events = appendEvent(events, now, "mains_lost", power.Source == gatherer.PowerBattery, power.ChargePct, nil)
+		}
+	}
+
+	// Devices without eMMC or SD card report nothing
+	if storageCritical() {
+		if health := gatherer.GetStorageHealth(); health != nil {
+			events = appendEvent(events, now, "storage_critical", health.Status == gatherer.StorageCritical, health.Status, nil)
+		}
+	}
+
+	return events
+}
+
+// Records alert transitions, emitting an active event at most once per events.cooldown per type
+// and a cleared event when an announced alert recovers
+func appendEvent(events []Event, now time.Time, eventType string, active bool, value, threshold interface{}) []Event {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	state := StateActive
+	if active {
+		if activeAlerts[eventType] {
+			return events
+		}
+		activeAlerts[eventType] = true
+		if sent := lastSent[eventType]; !sent.IsZero() && now.Sub(sent) < config.Current().Events.Cooldown.Duration() {
+			return events
+		}
+		lastSent[eventType] = now
+		announced[eventType] = true
+	} else {
+		if !activeAlerts[eventType] {
+			return events
+		}
+		delete(activeAlerts, eventType)
+		if !announced[eventType] {
+			return events
+		}
+		delete(announced, eventType)
+		state = StateCleared
+	}
+
+	return append(events, Event{
+		Type:      eventType,
+		State:     state,
+		Value:     value,
+		Threshold: threshold,
+		Date:      now.UTC().Format(time.RFC3339),
+		DeviceID:  gatherer.GetDeviceID(),
+	})
+}
+
+// Percentage of blocks in use on the filesystem at path
+func diskUsagePct(path string) (float64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	total := uint64(stat.Blocks)
+	if total == 0 {
+		return 0, fmt.Errorf("filesystem %s reports no blocks", path)
+	}
+	used := total - uint64(stat.Bfree)
+	return float64(used) / float64(total) * 100, nil
+}
+
+func signalQuality() (int, bool) {
+	var details struct {
+		SignalQuality string `json:"signal_quality"`
+	}
+	if err := json.Unmarshal([]byte(gatherer.GetModemDetails()), &details); err != nil {
+		return 0, false
+	}
+	quality, err := strconv.Atoi(details.SignalQuality)
+	return quality, err == nil
+}
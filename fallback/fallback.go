@@ -0,0 +1,46 @@
+package fallback
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"status-updater/config"
+	"time"
+)
+
+const postTimeout = 30 * time.Second
+
+// Reports whether fallback.http_url is configured
+func Enabled() bool {
+	return config.Current().Fallback.HTTPURL != ""
+}
+
+// POSTs a JSON message to fallback.http_url, authenticating with fallback.token or the updater_service credentials
+func Post(message []byte) error {
+	cfg := config.Current()
+	req, err := http.NewRequest("POST", cfg.Fallback.HTTPURL, bytes.NewReader(message))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token := cfg.Fallback.Token; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(cfg.UpdaterService.Username, cfg.UpdaterService.Password)
+	}
+
+	client := &http.Client{Timeout: postTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to POST to fallback endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("fallback endpoint returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package gatherer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"status-updater/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each probe gets this long, so a hung service can't stall the status cycle
+const appCheckTimeout = 3 * time.Second
+
+// Outcome of one configured app check
+type AppCheck struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Results of the latest run, read by the event checker
+var (
+	appChecksMutex sync.Mutex
+	lastAppChecks  []AppCheck
+)
+
+// Runs every app check that applies to deviceType, in config order
+func RunAppChecks(deviceType string) []AppCheck {
+	var results []AppCheck
+	for _, check := range config.Current().AppChecks {
+		if !appliesTo(check.DeviceTypes, deviceType) {
+			continue
+		}
+		start := time.Now()
+		err := probe(check)
+		result := AppCheck{
+			Name:      check.Name,
+			Type:      check.Type,
+			OK:        err == nil,
+			LatencyMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	appChecksMutex.Lock()
+	lastAppChecks = results
+	appChecksMutex.Unlock()
+	return results
+}
+
+// Returns the results of the latest run, nil before the first one
+func LastAppChecks() []AppCheck {
+	appChecksMutex.Lock()
+	defer appChecksMutex.Unlock()
+	return lastAppChecks
+}
+
+func probe(check config.AppCheck) error {
+	switch check.Type {
+	case "tcp_port":
+		return dial("tcp", check.Target)
+	case "unix_socket":
+		return dial("unix", check.Target)
+	case "http_get":
+		client := &http.Client{Timeout: appCheckTimeout}
+		resp, err := client.Get(check.Target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown check type %q", check.Type)
+}
+
+func dial(network, address string) error {
+	conn, err := net.DialTimeout(network, address, appCheckTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Matches exact device types, "hc9xx" for any HC9xx controller and "sos" for any SOS version; no types match all
+func appliesTo(types []string, deviceType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	deviceType = strings.ToLower(deviceType)
+	for _, t := range types {
+		t = strings.ToLower(t)
+		switch {
+		case t == deviceType:
+			return true
+		case t == "sos" && strings.HasPrefix(deviceType, "sos"):
+			return true
+		case t == "hc9xx" && strings.HasPrefix(deviceType, "hc9"):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package gatherer
+
+import (
+	"status-updater/helpers"
+	"time"
+)
+
+// Clocks before this were never set, e.g. a device without RTC booting at the epoch or its image build date
+var earliestPlausibleTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Reports whether the wall clock can't be trusted: implausibly early, or not synchronized and never verified
+func DateUnreliable() bool {
+	if time.Now().Before(earliestPlausibleTime) {
+		return true
+	}
+
+	if helpers.ClockVerified() {
+		return false
+	}
+
+	// Without a kernel answer only an implausible date counts
+	synced, known := kernelClockSynced()
+	return known && !synced
+}
@@ -0,0 +1,15 @@
+package gatherer
+
+import "syscall"
+
+// STA_UNSYNC from linux/timex.h, set until NTP (or chrony/timesyncd) disciplines the clock
+const staUnsync = 0x0040
+
+// Kernel's view of clock synchronization; known is false when it can't be queried
+func kernelClockSynced() (synced, known bool) {
+	var timex syscall.Timex
+	if _, err := syscall.Adjtimex(&timex); err != nil {
+		return false, false
+	}
+	return timex.Status&staUnsync == 0, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package gatherer
+
+// Clock synchronization is only queried on Linux
+func kernelClockSynced() (synced, known bool) {
+	return false, false
+}
@@ -0,0 +1,144 @@
+package gatherer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Docker and rootful Podman API sockets, tried in order unless containers.socket is set
+var containerSockets = []string{"/var/run/docker.sock", "/run/podman/podman.sock"}
+
+// Covers listing and inspecting a handful of containers; the API answers locally in milliseconds
+const containerQueryTimeout = 5 * time.Second
+
+// State of one container as reported by the container runtime
+type Container struct {
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	RestartCount int    `json:"restart_count"`
+	Image        string `json:"image"`
+}
+
+// Socket found by the previous query, so only a change of runtime is logged
+var (
+	containerSocketMutex sync.Mutex
+	containerSocket      *string
+)
+
+// Returns the containers in containers.allowlist, or all of them when it is empty, sorted by name; nil without a
+// container runtime
+func GetContainers() ([]Container, error) {
+	socket := findContainerSocket()
+	if socket == "" {
+		return nil, nil
+	}
+
+	client := &http.Client{
+		Timeout: containerQueryTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	var listed []struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+		Image string   `json:"Image"`
+		State string   `json:"State"`
+	}
+	if err := queryContainerAPI(client, "/containers/json?all=true", &listed); err != nil {
+		return nil, fmt.Errorf("failed to list containers on %s: %v", socket, err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range config.Current().Containers.Allowlist {
+		allowed[name] = true
+	}
+
+	var containers []Container
+	for _, entry := range listed {
+		if len(entry.Names) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(entry.Names[0], "/")
+		if len(allowed) > 0 && !allowed[name] {
+			continue
+		}
+
+		// The restart count is only part of the inspect output
+		var inspected struct {
+			RestartCount int `json:"RestartCount"`
+		}
+		if err := queryContainerAPI(client, "/containers/"+url.PathEscape(entry.ID)+"/json", &inspected); err != nil {
+			return nil, fmt.Errorf("failed to inspect container %s: %v", name, err)
+		}
+		containers = append(containers, Container{
+			Name:         name,
+			State:        entry.State,
+			RestartCount: inspected.RestartCount,
+			Image:        entry.Image,
+		})
+	}
+
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, nil
+}
+
+func queryContainerAPI(client *http.Client, path string, result interface{}) error {
+	// The host is ignored, the transport always dials the socket
+	resp, err := client.Get("http://localhost" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Returns the first container runtime socket present, empty when there is none; logged only when it changes
+func findContainerSocket() string {
+	cfg := config.Current()
+	candidates := containerSockets
+	if cfg.Containers.Socket != "" {
+		candidates = []string{cfg.Containers.Socket}
+	}
+
+	socket := ""
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode()&os.ModeSocket != 0 {
+			socket = candidate
+			break
+		}
+	}
+
+	containerSocketMutex.Lock()
+	defer containerSocketMutex.Unlock()
+	if containerSocket != nil && *containerSocket == socket {
+		return socket
+	}
+	if socket == "" {
+		logger.LogMessage("INFO", "No container runtime socket found, not reporting containers")
+	} else {
+		logger.LogMessage("INFO", fmt.Sprintf("Reporting containers from %s", socket))
+	}
+	containerSocket = &socket
+	return socket
+}
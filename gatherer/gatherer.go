@@ -2,13 +2,16 @@ package gatherer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"status-updater/cmdrunner"
+	"status-updater/config"
 	"status-updater/helpers"
 	"status-updater/logger"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -39,60 +42,89 @@ func ReadHelpcomConfig() (map[string]string, error) {
 	return helpcomConfig, nil
 }
 
+var sosServices = []string{
+	"sos-audio",
+	"sos-businesslogicserver",
+	"sos-hc100-emu",
+	"sos-helpcom",
+	"sos-nas",
+	"sos-vca",
+	"sos-web",
+}
+
 // Returns status of running services based on device type
 func GetServiceStatus() string {
-	deviceType, err := GetDeviceType()
+	states, err := GetServiceStates()
 	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to determine device type: %v", err))
 		return "Unknown"
 	}
-	var services []string
+	return FormatServiceStatus(states)
+}
 
-	sosServices := []string{
-		"sos-audio",
-		"sos-businesslogicserver",
-		"sos-hc100-emu",
-		"sos-helpcom",
-		"sos-nas",
-		"sos-vca",
-		"sos-web",
+// Returns structured state of the services relevant to the device type
+func GetServiceStates() ([]helpers.ServiceState, error) {
+	services, err := ServiceNames()
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to determine device type: %v", err))
+		return nil, err
 	}
 
-	if deviceType == "hc900" || deviceType == "hc925" || deviceType == "hc950" {
-		if helpers.IsBuildroot() {
-			status := helpers.CheckInitDServiceStatus("helpcom")
-			if status != "" {
-				services = append(services, status)
-			} else {
-				services = append(services, "helpcom: stopped")
-			}
-		} else {
-			status := helpers.CheckServiceStatus("helpcom")
-			if status != "" {
-				services = append(services, status)
-			} else {
-				services = append(services, "helpcom: inactive")
-			}
-		}
-	} else {
-		if helpers.IsBuildroot() {
+	if helpers.IsBuildroot() {
+		if len(services) == 0 {
 			logger.LogMessage("INFO", "Running on Buildroot, skipping SOS service check")
-		} else {
-			for _, service := range sosServices {
-				status := helpers.CheckServiceStatus(service)
-				if status != "" {
-					services = append(services, status)
-				} else {
-					services = append(services, fmt.Sprintf("%s: inactive", service))
-				}
-			}
 		}
+		return helpers.GetInitDServiceStates(services), nil
+	}
+	return helpers.GetServiceStates(services), nil
+}
+
+// Returns the services checked for the device type: on Buildroot the init.d scripts (helpcom on HC devices
+// plus buildroot.services), elsewhere the systemd units
+func ServiceNames() ([]string, error) {
+	deviceType, err := GetDeviceType()
+	if err != nil {
+		return nil, err
+	}
+	hc := deviceType == "hc900" || deviceType == "hc925" || deviceType == "hc950"
+
+	if helpers.IsBuildroot() {
+		services := config.Current().Buildroot.Services
+		if hc {
+			services = append([]string{"helpcom"}, services...)
+		}
+		return services, nil
+	}
+	if hc {
+		return []string{"helpcom"}, nil
 	}
+	return sosServices, nil
+}
+
+// Returns the systemd services relevant to the device type, nil on Buildroot where init.d is used instead
+func MonitoredServices() []string {
+	if helpers.IsBuildroot() {
+		return nil
+	}
+	deviceType, err := GetDeviceType()
+	if err != nil {
+		return nil
+	}
+	if deviceType == "hc900" || deviceType == "hc925" || deviceType == "hc950" {
+		return []string{"helpcom"}
+	}
+	return sosServices
+}
 
-	if len(services) == 0 {
+// Formats service states as the legacy comma-separated "name: state" string
+func FormatServiceStatus(states []helpers.ServiceState) string {
+	if len(states) == 0 {
 		return "No services found"
 	}
 
+	services := make([]string, 0, len(states))
+	for _, state := range states {
+		services = append(services, fmt.Sprintf("%s: %s", state.Name, state.ActiveState))
+	}
 	return strings.Join(services, ", ")
 }
 
@@ -102,8 +134,7 @@ func GetDeviceType() (string, error) {
 	data, err := os.ReadFile(deviceTypeFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			cmd := exec.Command("dpkg-query", "--showformat='${Version}'", "--show", "sospi2")
-			output, err := cmd.Output()
+			output, err := cmdrunner.Output("dpkg-query", "--showformat='${Version}'", "--show", "sospi2")
 			if err != nil {
 				logger.LogMessage("WARN", fmt.Sprintf("Failed to get SOS version: %s", err))
 				return "SOS: Unknown", nil
@@ -120,10 +151,19 @@ func GetDeviceType() (string, error) {
 	return deviceType, nil
 }
 
-// Returns MAC addresses for all network interfaces
+// Returns the device ID used in topics and payloads (eth0 MAC)
+func GetDeviceID() string {
+	eth0MAC, err := helpers.GetMACAddress("eth0")
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to get MAC address for eth0: %s", err))
+		return "unknown"
+	}
+	return eth0MAC
+}
+
+// Returns MAC addresses and types of all network interfaces except network.exclude_interfaces
 func GetMACAddresses() string {
-	cmd := exec.Command("ip", "link", "show")
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("ip", "link", "show")
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to get MAC addresses: %s", err))
 		return "[]"
@@ -143,9 +183,13 @@ func GetMACAddresses() string {
 			parts := strings.Fields(line)
 			if len(parts) >= 2 {
 				macAddress := parts[1]
+				if helpers.ExcludedInterface(interfaceName) {
+					continue
+				}
 				macAddresses = append(macAddresses, map[string]string{
 					"interface":   interfaceName,
 					"mac_address": macAddress,
+					"type":        helpers.InterfaceType(interfaceName),
 				})
 				logger.LogMessage("INFO", fmt.Sprintf("Retrieved MAC address for %s: %s", interfaceName, macAddress))
 			}
@@ -161,10 +205,9 @@ func GetMACAddresses() string {
 	return string(macAddressesJSON)
 }
 
-// Returns IP addresses for all network interfaces
+// Returns IP addresses and types of all network interfaces except network.exclude_interfaces
 func GetIPAddresses() string {
-	cmd := exec.Command("ip", "-o", "-4", "addr", "list")
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("ip", "-o", "-4", "addr", "list")
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to get IP addresses: %s", err))
 		return "[]"
@@ -177,10 +220,14 @@ func GetIPAddresses() string {
 			parts := strings.Fields(line)
 			if len(parts) >= 4 {
 				interfaceName := parts[1]
+				if helpers.ExcludedInterface(interfaceName) {
+					continue
+				}
 				ipAddress := strings.Split(parts[3], "/")[0]
 				ipAddresses = append(ipAddresses, map[string]string{
 					"interface":  interfaceName,
 					"ip_address": ipAddress,
+					"type":       helpers.InterfaceType(interfaceName),
 				})
 				logger.LogMessage("INFO", fmt.Sprintf("Retrieved IP address for %s: %s", interfaceName, ipAddress))
 			}
@@ -196,97 +243,199 @@ func GetIPAddresses() string {
 	return string(ipAddressesJSON)
 }
 
-// Returns modem details via mmcli
-func GetModemDetails() string {
-	if _, err := exec.LookPath("mmcli"); err != nil {
-		logger.LogMessage("WARN", "mmcli command not found. No modem information will be retrieved.")
-		return `{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A"}`
-	}
+// Index of the first modem ModemManager lists; it can change when the modem resets
+func ModemIndex() (int, error) {
+	return findModemIndex()
+}
+
+// Returned when ModemManager runs but lists no modem
+var errNoModems = errors.New("no modems found")
 
-	cmd := exec.Command("mmcli", "-L")
-	output, err := cmd.Output()
+// Returns the index of the first modem listed by mmcli -L
+func findModemIndex() (int, error) {
+	output, err := mmcli("-L")
 	if err != nil {
-		logger.LogMessage("WARN", fmt.Sprintf("Failed to get modem list: %s", err))
-		return `{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A"}`
+		return -1, fmt.Errorf("failed to get modem list: %v", err)
 	}
 
-	modemIndex := -1
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
 	for _, line := range lines {
 		if strings.HasPrefix(line, "/org/freedesktop/ModemManager1/Modem/") {
 			parts := strings.Split(line, " ")
 			if len(parts) > 0 {
 				indexStr := strings.TrimPrefix(parts[0], "/org/freedesktop/ModemManager1/Modem/")
 				if index, err := strconv.Atoi(indexStr); err == nil {
-					modemIndex = index
-					break
+					return index, nil
 				}
 			}
 		}
 	}
+	return -1, errNoModems
+}
+
+// Tools found missing, remembered for the life of the process instead of probing every cycle
+var (
+	missingToolsMutex sync.Mutex
+	missingTools      = make(map[string]bool)
+)
 
-	if modemIndex == -1 {
-		logger.LogMessage("WARN", "No modems found")
-		return `{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A"}`
+// Reports whether a tool is in PATH, logging a missing one only the first time
+func toolAvailable(name, missingMessage string) bool {
+	missingToolsMutex.Lock()
+	defer missingToolsMutex.Unlock()
+	if missingTools[name] {
+		return false
+	}
+	if _, err := cmdrunner.LookPath(name); err != nil {
+		missingTools[name] = true
+		logger.LogMessage("WARN", missingMessage)
+		return false
 	}
+	return true
+}
+
+// Modem status reported as modem_status, telling a missing ModemManager apart from a missing or failing modem
+const (
+	ModemUnsupported  = "unsupported"
+	ModemNoneDetected = "none_detected"
+	ModemError        = "error"
+	ModemOK           = "ok"
+)
 
-	cmd = exec.Command("mmcli", "-m", strconv.Itoa(modemIndex))
-	output, err = cmd.Output()
+// Wait after mmcli --scan-modems before looking for the modem again
+const modemRescanSettle = 5 * time.Second
+
+// A previously working modem that disappeared or started failing
+type ModemTransition struct {
+	From      string
+	To        string
+	Error     string
+	Recovered bool
+	Time      time.Time
+}
+
+var (
+	// Serializes modem reads so the status cycle and event checks agree on transitions
+	modemMutex      sync.Mutex
+	lastModemStatus string
+	modemLost       = make(chan ModemTransition, 4)
+)
+
+// Delivers the transitions of a working modem to none_detected or error, after the rescan attempt
+func ModemTransitions() <-chan ModemTransition {
+	return modemLost
+}
+
+// Returns modem details via mmcli, with modem_status and, when it failed, modem_error
+func GetModemDetails() string {
+	modemMutex.Lock()
+	defer modemMutex.Unlock()
+
+	details, status, errMsg := readModem()
+	if lastModemStatus == ModemOK && (status == ModemNoneDetected || status == ModemError) {
+		logger.LogMessage("WARN", fmt.Sprintf("Modem went from ok to %s (%s), rescanning", status, errMsg))
+		transition := ModemTransition{From: ModemOK, To: status, Error: errMsg, Time: time.Now()}
+
+		// Some modems come back after ModemManager probes them again
+		if _, _, err := cmdrunner.Run("mmcli", "--scan-modems"); err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("mmcli --scan-modems failed: %v", err))
+		} else {
+			time.Sleep(modemRescanSettle)
+			details, status, errMsg = readModem()
+		}
+		transition.Recovered = status == ModemOK
+		if transition.Recovered {
+			logger.LogMessage("INFO", "Modem recovered after rescanning")
+		}
+		select {
+		case modemLost <- transition:
+		default:
+		}
+	}
+	lastModemStatus = status
+
+	details["modem_status"] = status
+	if errMsg != "" {
+		details["modem_error"] = errMsg
+	}
+	modemDetailsJSON, err := json.Marshal(details)
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal modem details: %s", err))
+		return fmt.Sprintf(`{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A","modem_status":%q}`, status)
+	}
+	return string(modemDetailsJSON)
+}
+
+// Reads the first modem and its SIM, returning the detail fields, the modem status and the mmcli error if any
+func readModem() (map[string]string, string, string) {
+	details := map[string]string{
+		"manufacturer":   "N/A",
+		"model":          "N/A",
+		"signal_quality": "N/A",
+		"state":          "N/A",
+		"imei":           "N/A",
+		"operator_id":    "N/A",
+		"imsi":           "N/A",
+	}
+	if !toolAvailable("mmcli", "mmcli command not found. No modem information will be retrieved.") {
+		return details, ModemUnsupported, ""
+	}
+
+	modemIndex, err := findModemIndex()
+	if errors.Is(err, errNoModems) {
+		logger.LogMessage("WARN", "ModemManager lists no modems")
+		return details, ModemNoneDetected, ""
+	}
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Modem lookup failed: %s", err))
+		return details, ModemError, err.Error()
+	}
+
+	modemInfo, err := mmcli("-m", strconv.Itoa(modemIndex))
 	if err != nil {
 		logger.LogMessage("WARN", fmt.Sprintf("Failed to get modem details: %s", err))
-		return `{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A"}`
+		return details, ModemError, err.Error()
 	}
 
-	modemInfo := string(output)
 	modemManufacturer := helpers.ExtractField(modemInfo, "manufacturer")
 	modemModel := helpers.ExtractField(modemInfo, "model")
 	modemHWRevision := helpers.ExtractField(modemInfo, "h/w revision")
-	modemSignalQuality := helpers.ExtractField(modemInfo, "signal quality")
-	modemSignalQuality = helpers.ExtractPercentage(modemSignalQuality)
-	modemIMEI := helpers.ExtractField(modemInfo, "imei")
-	modemState := helpers.ExtractField(modemInfo, "state")
-	modemState = helpers.StripANSI(modemState)
-
 	if strings.Contains(modemManufacturer, "SIMCOM") {
 		modemModel = modemHWRevision
 	}
+	details["manufacturer"] = modemManufacturer
+	details["model"] = modemModel
+	details["signal_quality"] = helpers.ExtractPercentage(helpers.ExtractField(modemInfo, "signal quality"))
+	details["imei"] = helpers.ExtractField(modemInfo, "imei")
+	details["state"] = helpers.StripANSI(helpers.ExtractField(modemInfo, "state"))
 
-	cmd = exec.Command("mmcli", "-i", strconv.Itoa(modemIndex))
-	output, err = cmd.Output()
+	// The modem itself answered; a missing or unseated SIM is reported as an error with the modem details kept
+	simInfo, err := mmcli("-i", strconv.Itoa(modemIndex))
 	if err != nil {
 		logger.LogMessage("WARN", fmt.Sprintf("Failed to get SIM details: %s", err))
-		return `{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A"}`
-	}
-
-	simInfo := string(output)
-	modemIMSI := helpers.ExtractField(simInfo, "imsi")
-	modemOperatorID := helpers.ExtractField(simInfo, "operator id")
-	modemOperator := helpers.ExtractField(simInfo, "operator name")
-
-	modemDetails := map[string]string{
-		"manufacturer":   modemManufacturer,
-		"model":          modemModel,
-		"signal_quality": modemSignalQuality,
-		"state":          modemState,
-		"imei":           modemIMEI,
-		"operator":       modemOperator,
-		"operator_id":    modemOperatorID,
-		"imsi":           modemIMSI,
+		return details, ModemError, fmt.Sprintf("SIM: %s", err)
 	}
+	details["imsi"] = helpers.ExtractField(simInfo, "imsi")
+	details["operator_id"] = helpers.ExtractField(simInfo, "operator id")
+	details["operator"] = helpers.ExtractField(simInfo, "operator name")
+	return details, ModemOK, ""
+}
 
-	modemDetailsJSON, err := json.Marshal(modemDetails)
+// Runs mmcli, returning its output or an error with mmcli's own message
+func mmcli(args ...string) (string, error) {
+	stdout, stderr, err := cmdrunner.Run("mmcli", args...)
 	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal modem details: %s", err))
-		return `{"manufacturer":"N/A","model":"N/A","signal_quality":"N/A","state":"N/A","imei":"N/A","operator_id":"N/A","imsi":"N/A"}`
+		if msg := strings.TrimSpace(string(stderr)); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
 	}
-
-	return string(modemDetailsJSON)
+	return string(stdout), nil
 }
 
 // Returns kernel version
 func GetLinuxVersion() string {
-	cmd := exec.Command("uname", "-r")
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("uname", "-r")
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to get Linux version: %s", err))
 		return "Unknown"
@@ -296,16 +445,9 @@ func GetLinuxVersion() string {
 
 // Returns system uptime from /proc/uptime
 func GetUptime() string {
-	uptimeBytes, err := os.ReadFile("/proc/uptime")
+	uptimeSeconds, err := GetUptimeSeconds()
 	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to read uptime: %s", err))
-		return "N/A"
-	}
-
-	uptimeStr := strings.Fields(string(uptimeBytes))[0]
-	uptimeSeconds, err := strconv.ParseFloat(uptimeStr, 64)
-	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to parse uptime: %s", err))
+		logger.LogMessage("ERROR", err.Error())
 		return "N/A"
 	}
 
@@ -313,36 +455,31 @@ func GetUptime() string {
 	return uptimeDuration.String()
 }
 
-// Returns connected AP MAC via iwgetid
-func GetAccessPointMAC() string {
-	cmd := exec.Command("iwgetid", "-a")
-	output, err := cmd.Output()
-	if err != nil || strings.TrimSpace(string(output)) == "" {
-		logger.LogMessage("INFO", "No Access Point MAC found or failed to get Access Point MAC")
-		return "N/A"
+// Returns whole seconds since boot from /proc/uptime
+func GetUptimeSeconds() (int64, error) {
+	uptimeBytes, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read uptime: %v", err)
 	}
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Access Point/Cell:") {
-			parts := strings.Split(line, ": ")
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1])
-			}
-		}
+	fields := strings.Fields(string(uptimeBytes))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("failed to parse uptime: empty /proc/uptime")
+	}
+	uptimeSeconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse uptime: %v", err)
 	}
-	return "N/A"
+	return int64(uptimeSeconds), nil
 }
 
 // Returns LLDP neighbor details
 func GetLLDPDetails() (string, string, string, string, string, string, string) {
-	if _, err := exec.LookPath("lldpcli"); err != nil {
-		logger.LogMessage("WARN", "Skipping LLDP information retrieval.")
+	if !toolAvailable("lldpcli", "lldpcli command not found. Skipping LLDP information retrieval.") {
 		return "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A"
 	}
 
-	cmd := exec.Command("lldpcli", "show", "neighbors", "details")
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("lldpcli", "show", "neighbors", "details")
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to get LLDP details: %s", err))
 		return "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A"
@@ -360,15 +497,19 @@ func GetLLDPDetails() (string, string, string, string, string, string, string) {
 	return switchName, switchIP, switchPort, switchMacAddress, switchPortVlan, switchSysDescription, switchPortDescription
 }
 
-// Returns CPU/GPU temp from vcgencmd or thermal zone
+// Returns the hottest (or temperature.primary) sensor, falling back to vcgencmd and thermal zone 0
 func GetTemperature() string {
 	if helpers.IsBuildroot() {
 		logger.LogMessage("INFO", "Running on Buildroot, skipping temperature measurement")
 		return "N/A"
 	}
 
-	cmd := exec.Command("/opt/vc/bin/vcgencmd", "measure_temp")
-	output, err := cmd.Output()
+	// Hottest sensor unless temperature.primary names one
+	if temp, ok := primaryTemperature(GetTemperatures(), config.Current().Temperature.Primary); ok {
+		return formatTemperature(temp)
+	}
+
+	output, err := cmdrunner.Output("/opt/vc/bin/vcgencmd", "measure_temp")
 	if err == nil {
 		tempOutput := strings.TrimSpace(string(output))
 		tempParts := strings.Split(tempOutput, "=")
@@ -0,0 +1,327 @@
+package gatherer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"strings"
+	"testing"
+)
+
+// Replaces the command runner with a FakeRunner and forgets tools found missing, restoring both afterwards
+func useFakeRunner(t *testing.T) *cmdrunner.FakeRunner {
+	t.Helper()
+	fake := cmdrunner.NewFakeRunner()
+	previous := cmdrunner.Current
+	cmdrunner.Current = fake
+
+	resetMissing := func() {
+		missingToolsMutex.Lock()
+		missingTools = make(map[string]bool)
+		missingToolsMutex.Unlock()
+	}
+	resetMissing()
+	t.Cleanup(func() {
+		cmdrunner.Current = previous
+		resetMissing()
+	})
+	return fake
+}
+
+// Puts cfg in effect for the test, restoring the previous config afterwards
+func useConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+// Error ExecRunner returns for a command that ran into its timeout
+func timeoutError(name string) error {
+	return fmt.Errorf("%s timed out: %w", name, context.DeadlineExceeded)
+}
+
+const ipAddrOutput = `1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 192.168.1.20/24 brd 192.168.1.255 scope global eth0\       valid_lft forever preferred_lft forever
+3: wwan0    inet 10.64.12.7/30 brd 10.64.12.7 scope global wwan0\       valid_lft forever preferred_lft forever
+4: docker0    inet 172.17.0.1/16 brd 172.17.255.255 scope global docker0\       valid_lft forever preferred_lft forever
+`
+
+const ipLinkOutput = `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000
+    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc pfifo_fast state UP mode DEFAULT group default qlen 1000
+    link/ether b8:27:eb:12:34:56 brd ff:ff:ff:ff:ff:ff
+3: wlan0: <BROADCAST,MULTICAST> mtu 1500 qdisc noop state DOWN mode DEFAULT group default qlen 1000
+    link/ether b8:27:eb:65:43:21 brd ff:ff:ff:ff:ff:ff
+4: docker0: <NO-CARRIER,BROADCAST,MULTICAST,UP> mtu 1500 qdisc noqueue state DOWN mode DEFAULT group default
+    link/ether 02:42:ac:11:00:01 brd ff:ff:ff:ff:ff:ff
+`
+
+const mmcliListOutput = `    /org/freedesktop/ModemManager1/Modem/0 [QUALCOMM INCORPORATED] QUECTEL Mobile Broadband Module
+`
+
+const mmcliModemOutput = `  --------------------------------
+  General  |                 path: /org/freedesktop/ModemManager1/Modem/0
+           |            device id: 3f8a1d2c9b7e6f5a4d3c2b1a0f9e8d7c6b5a4f3e
+  --------------------------------
+  Hardware |         manufacturer: QUALCOMM INCORPORATED
+           |                model: QUECTEL Mobile Broadband Module
+           |    firmware revision: EG25GGBR07A08M2G
+           |         h/w revision: 10000
+           |            supported: gsm-umts, lte
+  --------------------------------
+  Status   |                state: ` + "\x1b[32mconnected\x1b[0m" + `
+           |       signal quality: 67% (recent)
+  --------------------------------
+  3GPP     |                 imei: 861107030000000
+           |        operator name: KPN
+`
+
+const mmcliSIMOutput = `  --------------------------------
+  General    |           path: /org/freedesktop/ModemManager1/SIM/0
+  --------------------------------
+  Properties |         active: yes
+             |           imsi: 204080000000000
+             |          iccid: 8931080000000000000
+             |    operator id: 20408
+             |  operator name: KPN
+`
+
+const lldpOutput = `-------------------------------------------------------------------------------
+LLDP neighbors:
+-------------------------------------------------------------------------------
+Interface:    eth0, via: LLDP, RID: 1, Time: 0 day, 02:13:44
+  Chassis:
+    ChassisID:    mac 00:1b:54:aa:bb:cc
+    SysName:      core-sw-01
+    SysDescr:     Cisco IOS Software, C2960X Software
+    MgmtIP:       10.0.0.2
+  Port:
+    PortID:       ifname Gi1/0/12
+    PortDescr:    GigabitEthernet1/0/12
+  VLAN:         120, pvid: yes
+-------------------------------------------------------------------------------
+`
+
+func TestGetIPAddresses(t *testing.T) {
+	fake := useFakeRunner(t)
+	useConfig(t, &config.Config{Network: struct {
+		ExcludeInterfaces []string `json:"exclude_interfaces"`
+	}{ExcludeInterfaces: []string{"lo", "docker*"}}})
+	fake.Set(cmdrunner.FakeResponse{Stdout: ipAddrOutput}, "ip", "-o", "-4", "addr", "list")
+
+	var addresses []map[string]string
+	if err := json.Unmarshal([]byte(GetIPAddresses()), &addresses); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, address := range addresses {
+		got[address["interface"]] = address["ip_address"]
+	}
+	want := map[string]string{"eth0": "192.168.1.20", "wwan0": "10.64.12.7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IP addresses = %v, want %v", got, want)
+	}
+}
+
+func TestGetIPAddressesFailure(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Err: timeoutError("ip")}, "ip", "-o", "-4", "addr", "list")
+
+	if got := GetIPAddresses(); got != "[]" {
+		t.Errorf("GetIPAddresses after a timeout = %s, want []", got)
+	}
+}
+
+func TestGetMACAddresses(t *testing.T) {
+	fake := useFakeRunner(t)
+	useConfig(t, &config.Config{Network: struct {
+		ExcludeInterfaces []string `json:"exclude_interfaces"`
+	}{ExcludeInterfaces: []string{"docker*"}}})
+	fake.Set(cmdrunner.FakeResponse{Stdout: ipLinkOutput}, "ip", "link", "show")
+
+	var addresses []map[string]string
+	if err := json.Unmarshal([]byte(GetMACAddresses()), &addresses); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, address := range addresses {
+		got[address["interface"]] = address["mac_address"]
+	}
+	want := map[string]string{"eth0": "b8:27:eb:12:34:56", "wlan0": "b8:27:eb:65:43:21"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MAC addresses = %v, want %v", got, want)
+	}
+}
+
+func TestReadModem(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: mmcliListOutput}, "mmcli", "-L")
+	fake.Set(cmdrunner.FakeResponse{Stdout: mmcliModemOutput}, "mmcli", "-m", "0")
+	fake.Set(cmdrunner.FakeResponse{Stdout: mmcliSIMOutput}, "mmcli", "-i", "0")
+
+	details, status, errMsg := readModem()
+	if status != ModemOK || errMsg != "" {
+		t.Fatalf("status = %q (%q), want ok", status, errMsg)
+	}
+	want := map[string]string{
+		"manufacturer":   "QUALCOMM INCORPORATED",
+		"model":          "QUECTEL Mobile Broadband Module",
+		"signal_quality": "67",
+		"state":          "connected",
+		"imei":           "861107030000000",
+		"operator_id":    "20408",
+		"operator":       "KPN",
+		"imsi":           "204080000000000",
+	}
+	if !reflect.DeepEqual(details, want) {
+		t.Errorf("modem details = %v, want %v", details, want)
+	}
+}
+
+func TestReadModemStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		responses  map[string]cmdrunner.FakeResponse
+		wantStatus string
+		wantError  string
+	}{
+		{
+			name:       "mmcli missing",
+			wantStatus: ModemUnsupported,
+		},
+		{
+			name: "no modems",
+			responses: map[string]cmdrunner.FakeResponse{
+				"-L": {Stdout: "No modems were found\n"},
+			},
+			wantStatus: ModemNoneDetected,
+		},
+		{
+			name: "modem manager not running",
+			responses: map[string]cmdrunner.FakeResponse{
+				"-L": {Stderr: "error: couldn't find the ModemManager process in the bus\n", Err: errors.New("exit status 1")},
+			},
+			wantStatus: ModemError,
+			wantError:  "failed to get modem list: error: couldn't find the ModemManager process in the bus",
+		},
+		{
+			name: "modem query timed out",
+			responses: map[string]cmdrunner.FakeResponse{
+				"-L":   {Stdout: mmcliListOutput},
+				"-m 0": {Err: timeoutError("mmcli")},
+			},
+			wantStatus: ModemError,
+			wantError:  "mmcli timed out: context deadline exceeded",
+		},
+		{
+			name: "SIM missing",
+			responses: map[string]cmdrunner.FakeResponse{
+				"-L":   {Stdout: mmcliListOutput},
+				"-m 0": {Stdout: mmcliModemOutput},
+				"-i 0": {Stderr: "error: couldn't find SIM\n", Err: errors.New("exit status 1")},
+			},
+			wantStatus: ModemError,
+			wantError:  "SIM: error: couldn't find SIM",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeRunner(t)
+			for args, response := range tt.responses {
+				fake.Set(response, "mmcli", strings.Fields(args)...)
+			}
+
+			_, status, errMsg := readModem()
+			if status != tt.wantStatus || errMsg != tt.wantError {
+				t.Errorf("status = %q (%q), want %q (%q)", status, errMsg, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestToolAvailableRemembersMissingTool(t *testing.T) {
+	useFakeRunner(t)
+
+	if toolAvailable("lldpcli", "lldpcli missing") {
+		t.Fatal("lldpcli reported available without being in PATH")
+	}
+
+	// Appearing later doesn't matter, the absence is remembered for the life of the process
+	cmdrunner.Current.(*cmdrunner.FakeRunner).Paths["lldpcli"] = "/usr/sbin/lldpcli"
+	if toolAvailable("lldpcli", "lldpcli missing") {
+		t.Error("lldpcli probed again after it was found missing")
+	}
+}
+
+func TestGetLLDPDetails(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: lldpOutput}, "lldpcli", "show", "neighbors", "details")
+
+	name, ip, port, mac, vlan, sysDescr, portDescr := GetLLDPDetails()
+	got := []string{name, ip, port, mac, vlan, sysDescr, portDescr}
+	want := []string{"core-sw-01", "10.0.0.2", "ifname Gi1/0/12", "mac 00:1b:54:aa:bb:cc", "120, pvid: yes",
+		"Cisco IOS Software, C2960X Software", "GigabitEthernet1/0/12"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LLDP details = %q, want %q", got, want)
+	}
+}
+
+func TestGetLLDPDetailsTimeout(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Err: timeoutError("lldpcli")}, "lldpcli", "show", "neighbors", "details")
+
+	name, ip, _, _, _, _, _ := GetLLDPDetails()
+	if name != "N/A" || ip != "N/A" {
+		t.Errorf("LLDP details after a timeout = %q, %q, want N/A", name, ip)
+	}
+}
+
+func TestGetLinuxVersion(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: "6.1.21-v8+\n"}, "uname", "-r")
+	if got := GetLinuxVersion(); got != "6.1.21-v8+" {
+		t.Errorf("GetLinuxVersion = %q, want 6.1.21-v8+", got)
+	}
+
+	fake.Set(cmdrunner.FakeResponse{Err: errors.New("exit status 1")}, "uname", "-r")
+	if got := GetLinuxVersion(); got != "Unknown" {
+		t.Errorf("GetLinuxVersion after a failure = %q, want Unknown", got)
+	}
+}
+
+func TestRouteDevice(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3.4 via 10.0.0.1 dev wwan0 src 10.0.0.2 uid 0":      "wwan0",
+		"default via 192.168.1.1 dev eth0 proto dhcp metric 100": "eth0",
+		"local 127.0.0.1 dev lo table local src 127.0.0.1":       "lo",
+		"unreachable 10.9.9.9":                                   "",
+		"":                                                       "",
+	}
+	for output, want := range tests {
+		if got := routeDevice(output); got != want {
+			t.Errorf("routeDevice(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
+
+func TestDefaultRouteInterface(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: "default via 10.64.12.6 dev wwan0 proto static metric 700\ndefault via 192.168.1.1 dev eth0 metric 800\n"},
+		"ip", "route", "show", "default")
+	if got := DefaultRouteInterface(); got != "wwan0" {
+		t.Errorf("DefaultRouteInterface = %q, want wwan0", got)
+	}
+
+	fake.Set(cmdrunner.FakeResponse{Err: timeoutError("ip")}, "ip", "route", "show", "default")
+	if got := DefaultRouteInterface(); got != "" {
+		t.Errorf("DefaultRouteInterface after a timeout = %q, want empty", got)
+	}
+}
@@ -0,0 +1,88 @@
+package gatherer
+
+import (
+	"fmt"
+	"math"
+	"status-updater/cmdrunner"
+	"status-updater/helpers"
+	"status-updater/logger"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Location states
+const (
+	LocationFix   = "fix"
+	LocationNoFix = "no_fix"
+)
+
+// Coarse GNSS position from the modem
+type Location struct {
+	Status    string   `json:"status"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	FixTime   string   `json:"fix_time,omitempty"`
+}
+
+// Modem on which enabling NMEA location gathering was attempted, so it is only tried once
+var (
+	gpsModemMutex sync.Mutex
+	gpsModem      = -1
+	gpsEnabled    bool
+)
+
+// Reads the modem's GNSS position rounded to precision decimals; no_fix without a modem, GNSS or lock
+func GetLocation(precision int) Location {
+	noFix := Location{Status: LocationNoFix}
+	if !toolAvailable("mmcli", "mmcli command not found. No location will be retrieved.") {
+		return noFix
+	}
+	modemIndex, err := findModemIndex()
+	if err != nil {
+		logger.LogMessage("DEBUG", fmt.Sprintf("No location, modem lookup failed: %s", err))
+		return noFix
+	}
+	index := strconv.Itoa(modemIndex)
+
+	// A modem without GNSS capability fails here and keeps reporting no_fix
+	gpsModemMutex.Lock()
+	if gpsModem != modemIndex {
+		gpsModem = modemIndex
+		_, err := cmdrunner.Output("mmcli", "-m", index, "--location-enable-gps-nmea")
+		gpsEnabled = err == nil
+		if err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to enable GPS on modem %s: %s", index, err))
+		}
+	}
+	enabled := gpsEnabled
+	gpsModemMutex.Unlock()
+	if !enabled {
+		return noFix
+	}
+
+	output, err := cmdrunner.Output("mmcli", "-m", index, "--location-get")
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to get modem location: %s", err))
+		return noFix
+	}
+
+	info := string(output)
+	latitude, errLat := strconv.ParseFloat(helpers.ExtractField(info, "latitude"), 64)
+	longitude, errLon := strconv.ParseFloat(helpers.ExtractField(info, "longitude"), 64)
+	if errLat != nil || errLon != nil {
+		return noFix
+	}
+
+	latitude, longitude = roundTo(latitude, precision), roundTo(longitude, precision)
+	location := Location{Status: LocationFix, Latitude: &latitude, Longitude: &longitude}
+	if utc := helpers.ExtractField(info, "utc"); utc != "unknown" && utc != "--" {
+		location.FixTime = strings.TrimSpace(utc)
+	}
+	return location
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,80 @@
+package gatherer
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+// Power sources
+const (
+	PowerMains   = "mains"
+	PowerBattery = "battery"
+)
+
+// Where the device draws power from, plus the battery state when one is present
+type Power struct {
+	Source               string `json:"source"`
+	ChargePct            *int   `json:"charge_pct,omitempty"`
+	BatteryStatus        string `json:"battery_status,omitempty"`
+	TimeRemainingSeconds *int64 `json:"time_remaining_seconds,omitempty"`
+}
+
+// Readers tried in order until one reports, so UPS HATs without a kernel driver can be added
+var powerReaders = []func() *Power{
+	sysfsPower,
+}
+
+// Returns the power state, nil on devices without a known power source
+func GetPower() *Power {
+	for _, read := range powerReaders {
+		if power := read(); power != nil {
+			return power
+		}
+	}
+	return nil
+}
+
+// Reads /sys/class/power_supply; mains is online when any mains or USB supply is, or when the battery isn't discharging
+func sysfsPower() *Power {
+	entries, err := os.ReadDir(powerSupplyDir)
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+
+	var power Power
+	mainsSeen, mainsOnline := false, false
+	for _, entry := range entries {
+		dir := filepath.Join(powerSupplyDir, entry.Name())
+		switch readSysfs(filepath.Join(dir, "type")) {
+		case "Mains", "USB":
+			mainsSeen = true
+			if readSysfs(filepath.Join(dir, "online")) == "1" {
+				mainsOnline = true
+			}
+		case "Battery", "UPS":
+			if power.BatteryStatus != "" {
+				continue
+			}
+			power.BatteryStatus = strings.ToLower(readSysfs(filepath.Join(dir, "status")))
+			if capacity, err := strconv.Atoi(readSysfs(filepath.Join(dir, "capacity"))); err == nil {
+				power.ChargePct = &capacity
+			}
+			if remaining, err := strconv.ParseInt(readSysfs(filepath.Join(dir, "time_to_empty_now")), 10, 64); err == nil {
+				power.TimeRemainingSeconds = &remaining
+			}
+		}
+	}
+	if !mainsSeen && power.BatteryStatus == "" {
+		return nil
+	}
+
+	power.Source = PowerMains
+	if mainsSeen && !mainsOnline || !mainsSeen && power.BatteryStatus == "discharging" {
+		power.Source = PowerBattery
+	}
+	return &power
+}
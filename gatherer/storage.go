@@ -0,0 +1,139 @@
+package gatherer
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"status-updater/cmdrunner"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Storage health classifications
+const (
+	StorageOK       = "ok"
+	StorageWarning  = "warning"
+	StorageCritical = "critical"
+)
+
+// Write errors on an SD card before it is classified as warning or critical
+const (
+	sdWarningErrors  = 1
+	sdCriticalErrors = 10
+)
+
+var (
+	sdDevicePattern   = regexp.MustCompile(`^mmcblk\d+$`)
+	sdWriteErrPattern = regexp.MustCompile(`I/O error.*mmcblk\d+|mmcblk\d+: error .*(writing|sending)`)
+)
+
+// Highest write error count seen this boot, so a rotated kernel log doesn't lower it
+var (
+	sdWriteErrorsMutex sync.Mutex
+	sdWriteErrors      int
+)
+
+// Wear indicators of the eMMC or SD card the system runs from
+type StorageHealth struct {
+	Device      string `json:"device"`
+	Type        string `json:"type"`
+	LifeTime    string `json:"life_time,omitempty"`
+	PreEOLInfo  string `json:"pre_eol_info,omitempty"`
+	WriteErrors *int   `json:"write_errors,omitempty"`
+	Status      string `json:"status"`
+}
+
+// Reads eMMC life time estimates, falling back to counting SD card write errors in the kernel log; nil without either
+func GetStorageHealth() *StorageHealth {
+	if health := emmcHealth(); health != nil {
+		return health
+	}
+	return sdHealth()
+}
+
+func emmcHealth() *StorageHealth {
+	paths, _ := filepath.Glob("/sys/class/mmc_host/*/mmc*/life_time")
+	if len(paths) == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(paths[0])
+	health := &StorageHealth{
+		Device:     filepath.Base(dir),
+		Type:       "emmc",
+		LifeTime:   readSysfs(filepath.Join(dir, "life_time")),
+		PreEOLInfo: readSysfs(filepath.Join(dir, "pre_eol_info")),
+		Status:     StorageOK,
+	}
+
+	// JEDEC life time estimates step in 10% of the rated lifetime, 0x0B means exceeded;
+	// pre-EOL info is 0x02 at 80% of the reserved blocks consumed and 0x03 when urgent
+	wear := int64(0)
+	for _, field := range strings.Fields(health.LifeTime) {
+		if value, err := strconv.ParseInt(field, 0, 64); err == nil && value > wear {
+			wear = value
+		}
+	}
+	preEOL, _ := strconv.ParseInt(health.PreEOLInfo, 0, 64)
+	switch {
+	case preEOL >= 3 || wear >= 0x0A:
+		health.Status = StorageCritical
+	case preEOL == 2 || wear >= 0x08:
+		health.Status = StorageWarning
+	}
+	return health
+}
+
+func sdHealth() *StorageHealth {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil
+	}
+	device := ""
+	for _, entry := range entries {
+		if sdDevicePattern.MatchString(entry.Name()) {
+			device = entry.Name()
+			break
+		}
+	}
+	if device == "" {
+		return nil
+	}
+
+	health := &StorageHealth{Device: device, Type: "sd", Status: StorageOK}
+	output, err := cmdrunner.Output("dmesg")
+	if err != nil {
+		return health
+	}
+
+	count := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		if sdWriteErrPattern.MatchString(line) {
+			count++
+		}
+	}
+	sdWriteErrorsMutex.Lock()
+	if count > sdWriteErrors {
+		sdWriteErrors = count
+	}
+	count = sdWriteErrors
+	sdWriteErrorsMutex.Unlock()
+
+	health.WriteErrors = &count
+	switch {
+	case count >= sdCriticalErrors:
+		health.Status = StorageCritical
+	case count >= sdWarningErrors:
+		health.Status = StorageWarning
+	}
+	return health
+}
+
+func readSysfs(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,85 @@
+package gatherer
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns every thermal zone and hwmon temperature sensor in °C, keyed by sensor name
+func GetTemperatures() map[string]float64 {
+	temps := make(map[string]float64)
+
+	// Thermal zones are named by type, e.g. cpu-thermal; duplicates get the zone number appended
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	for _, zone := range zones {
+		value, ok := readMilliCelsius(filepath.Join(zone, "temp"))
+		if !ok {
+			continue
+		}
+		name := sensorName(readSysfs(filepath.Join(zone, "type")))
+		if name == "" {
+			name = filepath.Base(zone)
+		}
+		if _, taken := temps[name]; taken {
+			name += "_" + strings.TrimPrefix(filepath.Base(zone), "thermal_zone")
+		}
+		temps[name] = value
+	}
+
+	// hwmon sensors are named <chip>_<label>, e.g. nvme_composite, falling back to the input name
+	inputs, _ := filepath.Glob("/sys/class/hwmon/hwmon*/temp*_input")
+	for _, input := range inputs {
+		value, ok := readMilliCelsius(input)
+		if !ok {
+			continue
+		}
+		dir := filepath.Dir(input)
+		sensor := strings.TrimSuffix(filepath.Base(input), "_input")
+		label := readSysfs(filepath.Join(dir, sensor+"_label"))
+		if label == "" {
+			label = sensor
+		}
+		name := sensorName(readSysfs(filepath.Join(dir, "name")) + "_" + label)
+		if _, taken := temps[name]; taken {
+			name += "_" + filepath.Base(dir)
+		}
+		temps[name] = value
+	}
+	return temps
+}
+
+// Hottest sensor, or primary when it is set and present
+func primaryTemperature(temps map[string]float64, primary string) (float64, bool) {
+	if value, ok := temps[primary]; ok && primary != "" {
+		return value, true
+	}
+	hottest, found := math.Inf(-1), false
+	for _, value := range temps {
+		if value > hottest {
+			hottest, found = value, true
+		}
+	}
+	return hottest, found
+}
+
+// Lowercases and replaces spaces so names are usable as JSON keys and in event types
+func sensorName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+}
+
+// Reads a sysfs temperature in millidegrees as °C rounded to 0.1
+func readMilliCelsius(path string) (float64, bool) {
+	millis, err := strconv.ParseInt(readSysfs(path), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return math.Round(float64(millis)/100) / 10, true
+}
+
+// Formats a temperature the way the legacy temp field always has
+func formatTemperature(value float64) string {
+	return fmt.Sprintf("%.2f", value)
+}
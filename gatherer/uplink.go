@@ -0,0 +1,61 @@
+package gatherer
+
+import (
+	"fmt"
+	"net"
+	"status-updater/cmdrunner"
+	"status-updater/helpers"
+	"status-updater/logger"
+	"status-updater/system"
+	"strings"
+)
+
+// Looks up the interface the kernel routes a destination through
+var routeInterface = system.RouteInterface
+
+// Interface currently carrying traffic to the broker
+type Uplink struct {
+	Interface string `json:"interface"`
+	Type      string `json:"type"`
+}
+
+// Returns the interface the route to the broker (host:port) goes through, or nil when it can't be determined
+func GetActiveUplink(broker string) *Uplink {
+	host, _, err := net.SplitHostPort(broker)
+	if err != nil {
+		host = broker
+	}
+	if host == "" {
+		return nil
+	}
+	ip := net.ParseIP(helpers.ResolveBroker(host))
+	if ip == nil {
+		return nil
+	}
+
+	iface, err := routeInterface(ip)
+	if err != nil {
+		logger.LogMessage("DEBUG", fmt.Sprintf("Netlink route lookup for %s failed, using ip route: %v", ip, err))
+		output, err := cmdrunner.Output("ip", "route", "get", ip.String())
+		if err != nil {
+			return nil
+		}
+		iface = routeDevice(string(output))
+	}
+	if iface == "" {
+		return nil
+	}
+	return &Uplink{Interface: iface, Type: uplinkType(iface)}
+}
+
+// Classifies an interface: wwan/ppp are cellular, wireless ones wifi, anything else ethernet
+func uplinkType(iface string) string {
+	switch {
+	case strings.HasPrefix(iface, "wwan"), strings.HasPrefix(iface, "ppp"):
+		return "cellular"
+	case helpers.IsWireless(iface):
+		return "wifi"
+	default:
+		return "ethernet"
+	}
+}
@@ -0,0 +1,114 @@
+package gatherer
+
+import (
+	"errors"
+	"net"
+	"status-updater/cmdrunner"
+	"testing"
+)
+
+// Route of a synthetic routing table
+type route struct {
+	prefix string
+	dev    string
+	metric int
+}
+
+// Picks the most specific route to ip, then the lowest metric, like the kernel's lookup
+func lookup(table []route, ip net.IP) (string, error) {
+	best, bestOnes, bestMetric := "", -1, 0
+	for _, r := range table {
+		_, network, err := net.ParseCIDR(r.prefix)
+		if err != nil || !network.Contains(ip) {
+			continue
+		}
+		ones, _ := network.Mask.Size()
+		if ones > bestOnes || (ones == bestOnes && r.metric < bestMetric) {
+			best, bestOnes, bestMetric = r.dev, ones, r.metric
+		}
+	}
+	if best == "" {
+		return "", errors.New("route lookup failed: network is unreachable")
+	}
+	return best, nil
+}
+
+// Answers route lookups from table, restoring the netlink lookup afterwards
+func useRoutingTable(t *testing.T, table *[]route) {
+	t.Helper()
+	previous := routeInterface
+	routeInterface = func(ip net.IP) (string, error) { return lookup(*table, ip) }
+	t.Cleanup(func() { routeInterface = previous })
+}
+
+func TestGetActiveUplink(t *testing.T) {
+	useFakeRunner(t)
+	const broker = "203.0.113.10:8883"
+
+	ethernetAndCellular := []route{
+		{"0.0.0.0/0", "eth0", 100},
+		{"0.0.0.0/0", "wwan0", 700},
+		{"192.168.1.0/24", "eth0", 100},
+		{"10.64.3.0/24", "wwan0", 700},
+	}
+	tests := []struct {
+		name  string
+		table []route
+		want  *Uplink
+	}{
+		{"ethernet preferred", ethernetAndCellular, &Uplink{Interface: "eth0", Type: "ethernet"}},
+		{"ethernet unplugged fails over to cellular", []route{
+			{"0.0.0.0/0", "wwan0", 700},
+			{"10.64.3.0/24", "wwan0", 700},
+		}, &Uplink{Interface: "wwan0", Type: "cellular"}},
+		{"host route to the broker over cellular", append([]route{{"203.0.113.10/32", "wwan0", 0}}, ethernetAndCellular...),
+			&Uplink{Interface: "wwan0", Type: "cellular"}},
+		{"ppp dial-up", []route{{"0.0.0.0/0", "ppp0", 0}}, &Uplink{Interface: "ppp0", Type: "cellular"}},
+		{"no route", []route{{"192.168.1.0/24", "eth0", 100}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := tt.table
+			useRoutingTable(t, &table)
+			got := GetActiveUplink(broker)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("GetActiveUplink = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetActiveUplinkFollowsFailover(t *testing.T) {
+	useFakeRunner(t)
+	table := []route{{"0.0.0.0/0", "eth0", 100}, {"0.0.0.0/0", "wwan0", 700}}
+	useRoutingTable(t, &table)
+
+	before := GetActiveUplink("203.0.113.10")
+	table = table[1:]
+	after := GetActiveUplink("203.0.113.10")
+	if before == nil || after == nil || before.Interface != "eth0" || after.Interface != "wwan0" || *before == *after {
+		t.Errorf("uplink before and after unplugging eth0 = %+v, %+v, want eth0 then wwan0", before, after)
+	}
+}
+
+func TestGetActiveUplinkFallsBackToIPRoute(t *testing.T) {
+	fake := useFakeRunner(t)
+	previous := routeInterface
+	routeInterface = func(net.IP) (string, error) {
+		return "", errors.New("failed to open netlink socket: permission denied")
+	}
+	t.Cleanup(func() { routeInterface = previous })
+
+	fake.Set(cmdrunner.FakeResponse{Stdout: "203.0.113.10 via 10.64.3.1 dev wwan0 src 10.64.3.2 uid 0 \n    cache \n"},
+		"ip", "route", "get", "203.0.113.10")
+	if got := GetActiveUplink("203.0.113.10:8883"); got == nil || *got != (Uplink{Interface: "wwan0", Type: "cellular"}) {
+		t.Errorf("GetActiveUplink via ip route = %+v, want wwan0 cellular", got)
+	}
+
+	fake.Set(cmdrunner.FakeResponse{Stderr: "RTNETLINK answers: Network is unreachable\n", Err: errors.New("exit status 2")},
+		"ip", "route", "get", "203.0.113.10")
+	if got := GetActiveUplink("203.0.113.10:8883"); got != nil {
+		t.Errorf("GetActiveUplink without a route = %+v, want nil", got)
+	}
+}
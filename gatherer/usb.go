@@ -0,0 +1,79 @@
+package gatherer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const usbDevicesDir = "/sys/bus/usb/devices"
+
+// USB device as enumerated from sysfs
+type USBDevice struct {
+	Path         string `json:"path"`
+	Bus          string `json:"bus"`
+	VendorID     string `json:"vendor_id"`
+	ProductID    string `json:"product_id"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+}
+
+// Devices from the last enumeration, reused while the sysfs entries stay the same
+var (
+	usbMutex     sync.Mutex
+	usbEntries   string
+	usbInventory []USBDevice
+)
+
+// Returns the attached USB devices sorted by port path; attributes are only re-read when devices come or go
+func GetUSBDevices() []USBDevice {
+	entries, err := os.ReadDir(usbDevicesDir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	signature := strings.Join(names, ",")
+
+	usbMutex.Lock()
+	defer usbMutex.Unlock()
+	if signature == usbEntries {
+		return usbInventory
+	}
+
+	// Interfaces (1-1:1.0) have no idVendor and are skipped
+	devices := []USBDevice{}
+	for _, name := range names {
+		dir := filepath.Join(usbDevicesDir, name)
+		vendorID := readSysfs(filepath.Join(dir, "idVendor"))
+		if vendorID == "" {
+			continue
+		}
+		devices = append(devices, USBDevice{
+			Path:         name,
+			Bus:          readSysfs(filepath.Join(dir, "busnum")),
+			VendorID:     vendorID,
+			ProductID:    readSysfs(filepath.Join(dir, "idProduct")),
+			Manufacturer: readSysfs(filepath.Join(dir, "manufacturer")),
+			Product:      readSysfs(filepath.Join(dir, "product")),
+		})
+	}
+	usbEntries = signature
+	usbInventory = devices
+	return devices
+}
+
+// Reports whether a device with the given vendor and product ID is attached
+func HasUSBDevice(devices []USBDevice, vendorID, productID string) bool {
+	for _, device := range devices {
+		if strings.EqualFold(device.VendorID, vendorID) && strings.EqualFold(device.ProductID, productID) {
+			return true
+		}
+	}
+	return false
+}
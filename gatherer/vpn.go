@@ -0,0 +1,126 @@
+package gatherer
+
+import (
+	"net"
+	"sort"
+	"status-updater/cmdrunner"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WireGuard handshakes are renewed every 2 minutes while traffic flows
+const staleHandshake = 3 * time.Minute
+
+// Tunnel states
+const (
+	TunnelUp       = "up"
+	TunnelDegraded = "degraded"
+	TunnelDown     = "down"
+)
+
+// State of an OpenVPN (tun*) or WireGuard (wg*) tunnel interface
+type Tunnel struct {
+	Interface        string `json:"interface"`
+	Kind             string `json:"kind"`
+	State            string `json:"state"`
+	Address          string `json:"address,omitempty"`
+	HandshakeAgeSecs *int64 `json:"handshake_age_seconds,omitempty"`
+}
+
+// Tunnels seen since startup, reported as down once their interface disappears
+var (
+	seenTunnelsMutex sync.Mutex
+	seenTunnels      = make(map[string]string)
+)
+
+// Returns the tunnel interfaces sorted by name, including ones that existed earlier and are gone now
+func GetVPNTunnels() []Tunnel {
+	interfaces, _ := net.Interfaces()
+	present := make(map[string]bool)
+	var tunnels []Tunnel
+	for _, iface := range interfaces {
+		kind := tunnelKind(iface.Name)
+		if kind == "" {
+			continue
+		}
+		present[iface.Name] = true
+		tunnels = append(tunnels, inspectTunnel(iface, kind))
+	}
+
+	seenTunnelsMutex.Lock()
+	for _, tunnel := range tunnels {
+		seenTunnels[tunnel.Interface] = tunnel.Kind
+	}
+	for name, kind := range seenTunnels {
+		if !present[name] {
+			tunnels = append(tunnels, Tunnel{Interface: name, Kind: kind, State: TunnelDown})
+		}
+	}
+	seenTunnelsMutex.Unlock()
+
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Interface < tunnels[j].Interface })
+	return tunnels
+}
+
+func tunnelKind(name string) string {
+	switch {
+	case strings.HasPrefix(name, "wg"):
+		return "wireguard"
+	case strings.HasPrefix(name, "tun"):
+		return "openvpn"
+	}
+	return ""
+}
+
+// Up with an address counts as up; a WireGuard tunnel without a recent handshake is degraded
+func inspectTunnel(iface net.Interface, kind string) Tunnel {
+	tunnel := Tunnel{Interface: iface.Name, Kind: kind, State: TunnelDown}
+	if addrs, err := iface.Addrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				tunnel.Address = ipNet.IP.String()
+				break
+			}
+		}
+	}
+	if iface.Flags&net.FlagUp == 0 || tunnel.Address == "" {
+		return tunnel
+	}
+	tunnel.State = TunnelUp
+
+	if kind == "wireguard" {
+		age, ok := wireGuardHandshakeAge(iface.Name)
+		if ok {
+			tunnel.HandshakeAgeSecs = &age
+		}
+		if !ok || time.Duration(age)*time.Second > staleHandshake {
+			tunnel.State = TunnelDegraded
+		}
+	}
+	return tunnel
+}
+
+// Seconds since the most recent handshake of any peer; false when no peer ever completed one
+func wireGuardHandshakeAge(iface string) (int64, bool) {
+	output, err := cmdrunner.Output("wg", "show", iface, "latest-handshakes")
+	if err != nil {
+		return 0, false
+	}
+
+	var latest int64
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if timestamp, err := strconv.ParseInt(fields[1], 10, 64); err == nil && timestamp > latest {
+			latest = timestamp
+		}
+	}
+	if latest == 0 {
+		return 0, false
+	}
+	return time.Now().Unix() - latest, true
+}
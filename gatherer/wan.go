@@ -0,0 +1,125 @@
+package gatherer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const wanIPTimeout = 10 * time.Second
+
+// Public address and egress interface, looked up once and again after a network change
+type wanInfo struct {
+	ip        string
+	iface     string
+	lookedUp  bool
+	lookupURL string
+}
+
+var (
+	wanMutex sync.Mutex
+	wan      wanInfo
+)
+
+// Returns the public IP reported by wan_ip.url and the interface traffic to it leaves through; both are empty
+// when wan_ip.url isn't set or the lookup failed
+func GetWANIP() (string, string) {
+	endpoint := config.Current().WANIP.URL
+	if endpoint == "" {
+		return "", ""
+	}
+
+	wanMutex.Lock()
+	defer wanMutex.Unlock()
+	if wan.lookedUp && wan.lookupURL == endpoint {
+		return wan.ip, wan.iface
+	}
+
+	// A failed lookup is remembered too, so an airgapped device doesn't retry every cycle
+	wan = wanInfo{lookedUp: true, lookupURL: endpoint}
+	ip, err := fetchWANIP(endpoint)
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to look up the public IP: %v", err))
+		return "", ""
+	}
+	wan.ip = ip
+	wan.iface = egressInterface(endpoint)
+	logger.LogMessage("INFO", fmt.Sprintf("Public IP %s via %s", wan.ip, wan.iface))
+	return wan.ip, wan.iface
+}
+
+// Forgets the looked up public IP so the next cycle asks again, e.g. after a network change
+func InvalidateWANIP() {
+	wanMutex.Lock()
+	defer wanMutex.Unlock()
+	wan = wanInfo{}
+}
+
+// Asks an echo endpoint such as https://api.ipify.org, which answers with the caller's address as plain text
+func fetchWANIP(endpoint string) (string, error) {
+	client := &http.Client{Timeout: wanIPTimeout}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("response %q is not an IP address", ip)
+	}
+	return ip, nil
+}
+
+// Interface the route to the endpoint's host goes through, from "ip route get"
+func egressInterface(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	addrs, err := net.LookupHost(u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	output, err := cmdrunner.Output("ip", "route", "get", addrs[0])
+	if err != nil {
+		return ""
+	}
+	return routeDevice(string(output))
+}
+
+// Extracts the device from "ip route get" output such as "1.2.3.4 via 10.0.0.1 dev wwan0 src 10.0.0.2 uid 0"
+func routeDevice(output string) string {
+	fields := strings.Fields(output)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "dev" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// Interface of the default route, e.g. wwan0 on a cellular uplink; empty without one
+func DefaultRouteInterface() string {
+	output, err := cmdrunner.Output("ip", "route", "show", "default")
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(output), "\n")
+	return routeDevice(line)
+}
@@ -0,0 +1,88 @@
+package gatherer
+
+import (
+	"bufio"
+	"os"
+	"status-updater/cmdrunner"
+	"status-updater/helpers"
+	"strconv"
+	"strings"
+)
+
+// Association of one wireless interface
+type WifiLink struct {
+	Interface string `json:"interface"`
+	Connected bool   `json:"connected"`
+	SSID      string `json:"ssid,omitempty"`
+	APMAC     string `json:"ap_mac,omitempty"`
+	SignalDBm *int   `json:"signal_dbm,omitempty"`
+}
+
+var (
+	procNetWireless = "/proc/net/wireless"
+
+	// Lists the wireless interfaces from sysfs
+	wirelessInterfaces = helpers.WirelessInterfaces
+)
+
+// Returns the SSID, access point and signal of every wireless interface, sorted by name
+func GetWifiLinks() []WifiLink {
+	signals := wirelessSignals(procNetWireless)
+	var links []WifiLink
+	for _, iface := range wirelessInterfaces() {
+		link := WifiLink{Interface: iface}
+		link.APMAC = AssociatedAP(iface)
+		if link.APMAC != "" {
+			link.Connected = true
+			if output, err := cmdrunner.Output("iwgetid", iface, "-r"); err == nil {
+				link.SSID = strings.TrimSpace(string(output))
+			}
+			if signal, ok := signals[iface]; ok {
+				link.SignalDBm = &signal
+			}
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// MAC of the access point iface is associated with as iwgetid prints it, empty when it isn't
+func AssociatedAP(iface string) string {
+	output, err := cmdrunner.Output("iwgetid", iface, "-a", "-r")
+	if err != nil {
+		return ""
+	}
+	mac := strings.TrimSpace(string(output))
+	if mac == "00:00:00:00:00:00" {
+		return ""
+	}
+	return mac
+}
+
+// Parses the signal level in dBm per interface, e.g. " wlan0: 0000   54.  -56.  -256 ..."
+func wirelessSignals(path string) map[string]int {
+	signals := make(map[string]int)
+	file, err := os.Open(path)
+	if err != nil {
+		return signals
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 3 {
+			continue
+		}
+		level, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err != nil {
+			continue
+		}
+		signals[strings.TrimSpace(name)] = int(level)
+	}
+	return signals
+}
@@ -0,0 +1,72 @@
+package gatherer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"status-updater/cmdrunner"
+	"testing"
+)
+
+const procNetWirelessOutput = `Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+ face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+wlx00c0ca123456: 0000   54.  -56.  -256        0      0      0      0      0        0
+`
+
+// Lists interfaces as the wireless ones and serves /proc/net/wireless from output, restoring both afterwards
+func useWireless(t *testing.T, interfaces []string, output string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wireless")
+	if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previousPath, previousList := procNetWireless, wirelessInterfaces
+	procNetWireless = path
+	wirelessInterfaces = func() []string { return interfaces }
+	t.Cleanup(func() { procNetWireless, wirelessInterfaces = previousPath, previousList })
+}
+
+func TestGetWifiLinksUSBAdapter(t *testing.T) {
+	fake := useFakeRunner(t)
+	// wlan0 is unconfigured, the wlx USB adapter carries the connection
+	useWireless(t, []string{"wlan0", "wlx00c0ca123456"}, procNetWirelessOutput)
+	fake.Set(cmdrunner.FakeResponse{Stdout: "00:00:00:00:00:00\n"}, "iwgetid", "wlan0", "-a", "-r")
+	fake.Set(cmdrunner.FakeResponse{Stdout: "f0:9f:c2:aa:bb:cc\n"}, "iwgetid", "wlx00c0ca123456", "-a", "-r")
+	fake.Set(cmdrunner.FakeResponse{Stdout: "office\n"}, "iwgetid", "wlx00c0ca123456", "-r")
+
+	signal := -56
+	want := []WifiLink{
+		{Interface: "wlan0"},
+		{Interface: "wlx00c0ca123456", Connected: true, SSID: "office", APMAC: "f0:9f:c2:aa:bb:cc", SignalDBm: &signal},
+	}
+	if got := GetWifiLinks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetWifiLinks = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetWifiLinksNotAssociated(t *testing.T) {
+	fake := useFakeRunner(t)
+	useWireless(t, []string{"wlx00c0ca123456"}, "")
+	fake.Set(cmdrunner.FakeResponse{Err: errors.New("exit status 255")}, "iwgetid", "wlx00c0ca123456", "-a", "-r")
+
+	want := []WifiLink{{Interface: "wlx00c0ca123456"}}
+	if got := GetWifiLinks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetWifiLinks = %+v, want %+v", got, want)
+	}
+}
+
+func TestWirelessSignals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wireless")
+	output := procNetWirelessOutput + "  wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0\n"
+	if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"wlx00c0ca123456": -56, "wlan0": -40}
+	if got := wirelessSignals(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("wirelessSignals = %v, want %v", got, want)
+	}
+	if got := wirelessSignals(filepath.Join(t.TempDir(), "missing")); len(got) != 0 {
+		t.Errorf("wirelessSignals of a missing file = %v, want none", got)
+	}
+}
@@ -2,9 +2,13 @@ module status-updater
 
 go 1.23.2
 
-require github.com/eclipse/paho.mqtt.golang v1.5.0
+require (
+	github.com/coreos/go-systemd/v22 v22.7.0
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+)
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
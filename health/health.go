@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"status-updater/config"
+	"status-updater/logger"
+	"status-updater/metrics"
+	"sync"
+	"time"
+)
+
+// Daemon state reported by the local HTTP endpoint
+var (
+	stateMutex     sync.RWMutex
+	lastCycle      time.Time
+	lastPublish    time.Time
+	lastPublishErr error
+	lastPayload    interface{}
+)
+
+// Records that the main loop started a status cycle
+func RecordCycle() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	lastCycle = time.Now()
+}
+
+// Records the outcome of the most recent status publish
+func RecordPublish(err error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	lastPublish = time.Now()
+	lastPublishErr = err
+	if err != nil {
+		publishFailures++
+	}
+}
+
+// Stores the most recently gathered status payload
+func SetLastPayload(payload interface{}) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	lastPayload = payload
+}
+
+// Returns nil when the main loop ran within 2×sleep_interval and the last publish succeeded
+func Check() error {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+
+	maxAge := 2 * config.Current().SleepInterval.Duration()
+	switch {
+	case lastCycle.IsZero():
+		return errors.New("no status cycle has run yet")
+	case time.Since(lastCycle) > maxAge:
+		return fmt.Errorf("last status cycle ran %s ago, expected within %s", time.Since(lastCycle).Round(time.Second), maxAge)
+	case lastPublishErr != nil:
+		return fmt.Errorf("last publish at %s failed: %v", lastPublish.UTC().Format(time.RFC3339), lastPublishErr)
+	}
+	return nil
+}
+
+// Serves /healthz, /status and /metrics on the configured address until the context is cancelled
+func Serve(ctx context.Context, listen string) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to start HTTP listener on %s: %s", listen, err))
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.LogMessage("INFO", fmt.Sprintf("HTTP status endpoint listening on %s", listener.Addr()))
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.LogMessage("ERROR", fmt.Sprintf("HTTP status endpoint failed: %s", err))
+	}
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := Check(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "unhealthy: %s\n", err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	stateMutex.RLock()
+	payload := lastPayload
+	stateMutex.RUnlock()
+
+	if payload == nil {
+		http.Error(w, "no status gathered yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to encode status response: %s", err))
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WritePrometheus(w)
+}
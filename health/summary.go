@@ -0,0 +1,71 @@
+package health
+
+import (
+	"status-updater/logger"
+	"time"
+)
+
+// Health states reported in the payload
+const (
+	StateOK       = "ok"
+	StateDegraded = "degraded"
+	StateError    = "error"
+)
+
+// Errors and warnings older than this no longer affect the state
+const recentWindow = 15 * time.Minute
+
+// Health section of the status payload
+type Summary struct {
+	State      string                        `json:"state"`
+	LastErrors map[string]logger.ErrorRecord `json:"last_errors,omitempty"`
+	Counters   Counters                      `json:"counters"`
+}
+
+// Log entries and publish outcomes since startup
+type Counters struct {
+	Errors          int `json:"errors"`
+	Warnings        int `json:"warnings"`
+	PublishFailures int `json:"publish_failures"`
+}
+
+// Failed publishes since startup
+var publishFailures int
+
+// Builds the health summary; alerting is true while any event alert is active
+func Summarize(alerting bool) Summary {
+	counts := logger.LevelCounts()
+	lastErrors := logger.LastErrors()
+
+	stateMutex.RLock()
+	publishErr := lastPublishErr
+	failures := publishFailures
+	stateMutex.RUnlock()
+
+	return Summary{
+		State:      classify(time.Now().UTC(), lastErrors, publishErr != nil, logger.IsDegraded() || alerting),
+		LastErrors: lastErrors,
+		Counters: Counters{
+			Errors:          counts["ERROR"],
+			Warnings:        counts["WARN"],
+			PublishFailures: failures,
+		},
+	}
+}
+
+// Error when the last publish failed or an error was logged within recentWindow,
+// degraded while logging is degraded or an alert is active, ok otherwise
+func classify(now time.Time, lastErrors map[string]logger.ErrorRecord, publishFailed, degraded bool) string {
+	if publishFailed {
+		return StateError
+	}
+	for _, record := range lastErrors {
+		if now.Sub(record.Time) < recentWindow {
+			return StateError
+		}
+	}
+	if degraded {
+		return StateDegraded
+	}
+	return StateOK
+}
@@ -0,0 +1,67 @@
+package health
+
+import (
+	"errors"
+	"status-updater/logger"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	recent := map[string]logger.ErrorRecord{
+		"mqtt": {Time: now.Add(-time.Minute), Message: "Connection lost"},
+	}
+	stale := map[string]logger.ErrorRecord{
+		"mqtt": {Time: now.Add(-recentWindow), Message: "Connection lost"},
+	}
+	mixed := map[string]logger.ErrorRecord{
+		"mqtt":    {Time: now.Add(-time.Hour), Message: "Connection lost"},
+		"updater": {Time: now.Add(-time.Minute), Message: "Metadata request failed"},
+	}
+
+	tests := []struct {
+		name          string
+		lastErrors    map[string]logger.ErrorRecord
+		publishFailed bool
+		degraded      bool
+		want          string
+	}{
+		{name: "nothing wrong", want: StateOK},
+		{name: "publish failed", publishFailed: true, want: StateError},
+		{name: "publish failed while degraded", publishFailed: true, degraded: true, want: StateError},
+		{name: "recent error", lastErrors: recent, want: StateError},
+		{name: "recent error in one subsystem", lastErrors: mixed, want: StateError},
+		{name: "stale error", lastErrors: stale, want: StateOK},
+		{name: "stale error while degraded", lastErrors: stale, degraded: true, want: StateDegraded},
+		{name: "degraded or alerting", degraded: true, want: StateDegraded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(now, tt.lastErrors, tt.publishFailed, tt.degraded); got != tt.want {
+				t.Errorf("classify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizePublishFailure(t *testing.T) {
+	stateMutex.Lock()
+	previousAt, previousErr, previousFailures := lastPublish, lastPublishErr, publishFailures
+	stateMutex.Unlock()
+	t.Cleanup(func() {
+		stateMutex.Lock()
+		lastPublish, lastPublishErr, publishFailures = previousAt, previousErr, previousFailures
+		stateMutex.Unlock()
+	})
+
+	RecordPublish(errors.New("publish timed out"))
+	summary := Summarize(false)
+	if summary.State != StateError {
+		t.Errorf("state after a failed publish = %q, want %q", summary.State, StateError)
+	}
+	if summary.Counters.PublishFailures != previousFailures+1 {
+		t.Errorf("publish failures = %d, want %d", summary.Counters.PublishFailures, previousFailures+1)
+	}
+}
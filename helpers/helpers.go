@@ -1,21 +1,45 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"status-updater/buildinfo"
+	"status-updater/capabilities"
+	"status-updater/cmdrunner"
 	"status-updater/config"
 	"status-updater/logger"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Set once CheckSystemTime confirmed or corrected the clock
+var (
+	clockVerifiedMutex sync.Mutex
+	clockVerified      bool
+)
+
+// Reports whether CheckSystemTime confirmed or corrected the clock since startup
+func ClockVerified() bool {
+	clockVerifiedMutex.Lock()
+	defer clockVerifiedMutex.Unlock()
+	return clockVerified
+}
+
+func markClockVerified() {
+	clockVerifiedMutex.Lock()
+	defer clockVerifiedMutex.Unlock()
+	clockVerified = true
+}
+
 // CheckSystemTime verifies system time against network time and corrects it if needed
 func CheckSystemTime() bool {
 	// Try to get time from HTTP time server
-	cmd := exec.Command("curl", "-s", "-I", "https://www.google.com")
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("curl", "-s", "-I", "https://www.google.com")
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to query HTTP server: %s", err))
 		return false
@@ -42,6 +66,10 @@ func CheckSystemTime() bool {
 	systemTime := time.Now()
 	offset := time.Since(serverTime).Seconds()
 
+	if (offset < -30.0 || offset > 30.0) && !capabilities.Current().TimeCorrection {
+		logger.LogMessage("DEBUG", fmt.Sprintf("System time is off by %.2f seconds, not correcting without the privileges to", offset))
+		return false
+	}
 	if offset < -30.0 || offset > 30.0 {
 		logger.LogMessage("WARN", fmt.Sprintf("System time is off by %.2f seconds, correcting...", offset))
 		logger.LogMessage("INFO", fmt.Sprintf("System time: %s", systemTime.Format(time.RFC3339)))
@@ -51,21 +79,27 @@ func CheckSystemTime() bool {
 		timeStr := serverTime.Format("2006-01-02 15:04:05")
 
 		// Set system time using date command
-		cmd = exec.Command("sudo", "date", "-s", timeStr)
-		if err := cmd.Run(); err != nil {
+		name, args := capabilities.Privileged("date", "-s", timeStr)
+		if _, _, err := cmdrunner.Run(name, args...); err != nil {
 			logger.LogMessage("ERROR", fmt.Sprintf("Failed to set system time: %s", err))
 			return false
 		}
 
 		logger.LogMessage("INFO", "System time corrected successfully")
+		markClockVerified()
 		return true
 	}
 
+	markClockVerified()
 	return true
 }
 
-// Gets status-updater version from version file or dpkg
+// Gets status-updater version embedded at build time, else from the version file or dpkg
 func GetUpdaterVersion() string {
+	if buildinfo.Version != "" {
+		return buildinfo.Version
+	}
+
 	// Try to get version from file first
 	if versionBytes, err := os.ReadFile("/opt/status-updater/version"); err == nil {
 		version := strings.TrimSpace(string(versionBytes))
@@ -76,8 +110,7 @@ func GetUpdaterVersion() string {
 
 	// If file doesn't exist or is empty, try dpkg on Debian systems
 	if !IsBuildroot() {
-		cmd := exec.Command("dpkg-query", "--showformat='${Version}'", "--show", "status-updater")
-		if output, err := cmd.Output(); err == nil {
+		if output, err := cmdrunner.Output("dpkg-query", "--showformat='${Version}'", "--show", "status-updater"); err == nil {
 			return strings.Trim(string(output), "'")
 		}
 	}
@@ -85,29 +118,9 @@ func GetUpdaterVersion() string {
 	return "Unknown"
 }
 
-// Checks if any WLAN interface has IP
-func HasActiveWLANInterface() bool {
-	cmd := exec.Command("ip", "-o", "-4", "addr", "list")
-	output, err := cmd.Output()
-	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to list interfaces: %s", err))
-		return false
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "wlan") {
-			logger.LogMessage("DEBUG", fmt.Sprintf("Found active WLAN interface: %s", line))
-			return true
-		}
-	}
-	return false
-}
-
 // Checks systemctl service status
 func CheckServiceStatus(serviceName string) string {
-	cmd := exec.Command("systemctl", "is-active", serviceName)
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("systemctl", "is-active", serviceName)
 	if err == nil {
 		status := strings.TrimSpace(string(output))
 		return fmt.Sprintf("%s: %s", serviceName, status)
@@ -117,26 +130,28 @@ func CheckServiceStatus(serviceName string) string {
 	return ""
 }
 
-// Checks init.d service status on Buildroot
-func CheckInitDServiceStatus(serviceName string) string {
-	servicePath := fmt.Sprintf("/etc/init.d/%s", serviceName)
-	if _, err := os.Stat(servicePath); err == nil {
-		cmd := exec.Command(servicePath, "status")
-		output, err := cmd.Output()
-		if err == nil {
-			status := strings.TrimSpace(string(output))
-			if strings.Contains(status, "running") {
-				return fmt.Sprintf("%s: running", serviceName)
-			} else {
-				return fmt.Sprintf("%s: stopped", serviceName)
-			}
-		} else {
-			logger.LogMessage("WARN", fmt.Sprintf("Failed to get status for service %s: %s", serviceName, err))
-		}
+// Restarts a service through its init.d script on Buildroot or systemctl elsewhere, returning its state afterwards
+func RestartService(serviceName string) (ServiceState, error) {
+	var err error
+	if IsBuildroot() {
+		_, err = cmdrunner.Output(filepath.Join(initDDir, serviceName), "restart")
 	} else {
-		logger.LogMessage("WARN", fmt.Sprintf("Service %s not found in /etc/init.d/", serviceName))
+		_, err = cmdrunner.Output("systemctl", "restart", serviceName+".service")
 	}
-	return ""
+	if err != nil {
+		return ServiceState{Name: serviceName, ActiveState: "unknown"}, fmt.Errorf("failed to restart %s: %v", serviceName, err)
+	}
+
+	var states []ServiceState
+	if IsBuildroot() {
+		states = GetInitDServiceStates([]string{serviceName})
+	} else {
+		states = GetServiceStates([]string{serviceName})
+	}
+	if len(states) == 0 {
+		return ServiceState{Name: serviceName, ActiveState: "unknown"}, nil
+	}
+	return states[0], nil
 }
 
 // Extracts percentage value from string
@@ -163,20 +178,9 @@ func ExtractField(output, field string) string {
 	return "unknown"
 }
 
-// Gets current WiFi SSID
-func GetSSID() string {
-	cmd := exec.Command("iwgetid", "-r")
-	output, err := cmd.Output()
-	if err != nil || strings.TrimSpace(string(output)) == "" {
-		logger.LogMessage("INFO", "No SSID found or failed to get SSID")
-		return "N/A"
-	}
-	return strings.TrimSpace(string(output))
-}
-
 // Pings test IP to check internet connectivity
 func IsInternetAvailable() bool {
-	_, err := exec.Command("ping", "-c", "1", "172.233.38.166").Output()
+	_, err := cmdrunner.Output("ping", "-c", "1", "172.233.38.166")
 	if err != nil {
 		logger.LogMessage("WARN", "Internet connection is not available")
 		return false
@@ -192,21 +196,94 @@ func StripANSI(input string) string {
 
 // Gets MAC address for specified interface
 func GetMACAddress(interfaceName string) (string, error) {
-	cmd := exec.Command("cat", fmt.Sprintf("/sys/class/net/%s/address", interfaceName))
-	output, err := cmd.Output()
+	output, err := cmdrunner.Output("cat", fmt.Sprintf("/sys/class/net/%s/address", interfaceName))
 	if err != nil {
 		return "", fmt.Errorf("failed to get MAC address for %s: %v", interfaceName, err)
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
-// Resolves broker address to IP if needed
-func ResolveBroker() string {
-	cmd := exec.Command("getent", "hosts", config.Current.MQTT.Broker)
-	if err := cmd.Run(); err != nil {
-		return config.Current.MQTT.BrokerIP
+const (
+	brokerResolveTimeout    = 5 * time.Second
+	defaultBrokerResolveTTL = 300 * time.Second
+)
+
+// Last successful resolution per broker host, reused until the TTL expires
+type resolvedBroker struct {
+	addr          string
+	at            time.Time
+	usingFallback bool
+}
+
+var (
+	brokerMutex    sync.Mutex
+	resolveResults = make(map[string]*resolvedBroker)
+)
+
+// Resolves a broker hostname to an IP, caching results and falling back to the last-known-good IP, or
+// mqtt.broker_ip for the mqtt.broker host
+func ResolveBroker(host string) string {
+	cfg := config.Current()
+	brokerMutex.Lock()
+	defer brokerMutex.Unlock()
+
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	cached := resolveResults[host]
+	if cached == nil {
+		cached = &resolvedBroker{}
+		resolveResults[host] = cached
+	}
+
+	ttl := defaultBrokerResolveTTL
+	if cfg.MQTT.ResolveCacheTTL > 0 {
+		ttl = cfg.MQTT.ResolveCacheTTL.Duration()
+	}
+	if cached.addr != "" && time.Since(cached.at) < ttl {
+		return cached.addr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerResolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err == nil && len(addrs) > 0 {
+		if cached.usingFallback {
+			logger.LogMessage("INFO", fmt.Sprintf("Broker %s resolves again via DNS: %s", host, addrs[0]))
+		}
+		cached.usingFallback = false
+		cached.addr = addrs[0]
+		cached.at = time.Now()
+		return cached.addr
+	}
+
+	var fallback string
+	if host == cfg.MQTT.Broker {
+		fallback = cfg.MQTT.BrokerIP
+	}
+	if cached.addr != "" {
+		fallback = cached.addr
+	}
+	if fallback == "" {
+		// Nothing better to offer, the connection attempt reports the failure
+		fallback = host
+	}
+	if !cached.usingFallback {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to resolve broker %s (%v), falling back to %s", host, err, fallback))
+	}
+	cached.usingFallback = true
+	return fallback
+}
+
+// Embedded build information with the flavor of the running system
+func BuildInfo() buildinfo.Info {
+	info := buildinfo.Get()
+	info.Flavor = "debian"
+	if IsBuildroot() {
+		info.Flavor = "buildroot"
 	}
-	return config.Current.MQTT.Broker
+	return info
 }
 
 // Detects if system is running Buildroot
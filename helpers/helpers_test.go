@@ -0,0 +1,109 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"status-updater/cmdrunner"
+	"testing"
+)
+
+// Replaces the command runner with a FakeRunner, restoring it afterwards
+func useFakeRunner(t *testing.T) *cmdrunner.FakeRunner {
+	t.Helper()
+	fake := cmdrunner.NewFakeRunner()
+	previous := cmdrunner.Current
+	cmdrunner.Current = fake
+	t.Cleanup(func() { cmdrunner.Current = previous })
+	return fake
+}
+
+const systemctlShowOutput = `Id=sos-web.service
+ActiveState=active
+SubState=running
+NRestarts=2
+
+Id=sos-audio.service
+ActiveState=failed
+SubState=failed
+NRestarts=5
+
+Id=sos-nas.service
+ActiveState=inactive
+SubState=dead
+NRestarts=0
+`
+
+func TestGetServiceStatesSystemctl(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: systemctlShowOutput}, "systemctl", "show", "--property=Id,ActiveState,SubState,NRestarts",
+		"sos-web.service", "sos-audio.service", "sos-nas.service", "sos-vca.service")
+
+	got := getServiceStatesSystemctl([]string{"sos-web", "sos-audio", "sos-nas", "sos-vca"})
+	want := []ServiceState{
+		{Name: "sos-web", ActiveState: "active", SubState: "running", Restarts: 2},
+		{Name: "sos-audio", ActiveState: "failed", SubState: "failed", Restarts: 5},
+		{Name: "sos-nas", ActiveState: "inactive", SubState: "dead"},
+		{Name: "sos-vca", ActiveState: "inactive"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("service states = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetServiceStatesSystemctlTimeout(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Err: fmt.Errorf("systemctl timed out: %w", context.DeadlineExceeded)},
+		"systemctl", "show", "--property=Id,ActiveState,SubState,NRestarts", "helpcom.service")
+
+	got := getServiceStatesSystemctl([]string{"helpcom"})
+	want := []ServiceState{{Name: "helpcom", ActiveState: "inactive"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("service states after a timeout = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetMACAddress(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: "b8:27:eb:12:34:56\n"}, "cat", "/sys/class/net/eth0/address")
+
+	mac, err := GetMACAddress("eth0")
+	if err != nil || mac != "b8:27:eb:12:34:56" {
+		t.Errorf("GetMACAddress(eth0) = %q, %v, want b8:27:eb:12:34:56", mac, err)
+	}
+
+	if _, err := GetMACAddress("eth9"); err == nil {
+		t.Error("GetMACAddress of a missing interface returned no error")
+	}
+}
+
+func TestIsInternetAvailable(t *testing.T) {
+	fake := useFakeRunner(t)
+	fake.Set(cmdrunner.FakeResponse{Stdout: "1 packets transmitted, 1 received\n"}, "ping", "-c", "1", "172.233.38.166")
+	if !IsInternetAvailable() {
+		t.Error("IsInternetAvailable = false with a successful ping")
+	}
+
+	fake.Set(cmdrunner.FakeResponse{Err: errors.New("exit status 1")}, "ping", "-c", "1", "172.233.38.166")
+	if IsInternetAvailable() {
+		t.Error("IsInternetAvailable = true with a failing ping")
+	}
+}
+
+func TestExtractField(t *testing.T) {
+	output := "  Hardware |         manufacturer: QUALCOMM INCORPORATED\n  Status   |       signal quality: 67% (recent)\n"
+
+	if got := ExtractField(output, "manufacturer"); got != "QUALCOMM INCORPORATED" {
+		t.Errorf("ExtractField(manufacturer) = %q", got)
+	}
+	if got := ExtractField(output, "imei"); got != "unknown" {
+		t.Errorf("ExtractField of a missing field = %q, want unknown", got)
+	}
+	if got := ExtractPercentage(ExtractField(output, "signal quality")); got != "67" {
+		t.Errorf("ExtractPercentage = %q, want 67", got)
+	}
+	if got := ExtractPercentage("unknown"); got != "N/A" {
+		t.Errorf("ExtractPercentage without a percentage = %q, want N/A", got)
+	}
+}
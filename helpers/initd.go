@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"status-updater/cmdrunner"
+	"status-updater/logger"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Directory holding the Buildroot init scripts
+var initDDir = "/etc/init.d"
+
+// LSB exit codes of an init script's status action
+const (
+	lsbRunning       = 0
+	lsbDeadPidFile   = 1
+	lsbDeadLockFile  = 2
+	lsbNotRunning    = 3
+	lsbUnknownStatus = 4
+)
+
+// Pidfile paths under a run directory referenced in an init script, e.g. PIDFILE=/var/run/helpcom.pid
+var pidFilePattern = regexp.MustCompile(`(?:/[\w.-]+)*/run/[\w./-]+\.pid`)
+
+// Queries init.d services on Buildroot, reported with the same states as systemd units
+func GetInitDServiceStates(serviceNames []string) []ServiceState {
+	states := make([]ServiceState, 0, len(serviceNames))
+	for _, name := range serviceNames {
+		states = append(states, initDServiceState(name))
+	}
+	return states
+}
+
+// Trusts the LSB exit code of "status" (0 running, 3 stopped), falling back to the script's pidfile
+func initDServiceState(name string) ServiceState {
+	state := ServiceState{Name: name, ActiveState: "unknown"}
+	script := filepath.Join(initDDir, name)
+	if _, err := os.Stat(script); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Service %s not found in %s", name, initDDir))
+		state.ActiveState, state.SubState = "inactive", "dead"
+		return state
+	}
+
+	output, err := cmdrunner.Output(script, "status")
+	code := 0
+	if err != nil {
+		code = cmdrunner.ExitCode(err)
+	}
+	// Scripts without a status action print their usage, whatever they exit with
+	implemented := !strings.Contains(strings.ToLower(string(output)), "usage")
+
+	switch {
+	case implemented && code == lsbRunning:
+		state.ActiveState, state.SubState = "active", "running"
+		return state
+	case implemented && code == lsbNotRunning:
+		state.ActiveState, state.SubState = "inactive", "dead"
+		return state
+	}
+
+	switch pidFileState(script) {
+	case "running":
+		state.ActiveState, state.SubState = "active", "running"
+		return state
+	case "removed":
+		state.ActiveState, state.SubState = "inactive", "dead"
+		return state
+	case "stale":
+		state.ActiveState, state.SubState = "failed", "dead"
+		return state
+	}
+
+	switch {
+	case implemented && (code == lsbDeadPidFile || code == lsbDeadLockFile):
+		state.ActiveState, state.SubState = "failed", "dead"
+	case code == lsbUnknownStatus || code == -1:
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to get status for service %s: %v", name, err))
+	}
+	return state
+}
+
+// Checks the first pidfile referenced by the script: "running", "removed" (clean stop), "stale" (process gone) or "" without one
+func pidFileState(script string) string {
+	content, err := os.ReadFile(script)
+	if err != nil {
+		return ""
+	}
+	pidFile := pidFilePattern.FindString(string(content))
+	if pidFile == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if os.IsNotExist(err) {
+		return "removed"
+	}
+	if err != nil {
+		return ""
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return "stale"
+	}
+	if err := syscall.Kill(pid, 0); err == nil || err == syscall.EPERM {
+		return "running"
+	}
+	return "stale"
+}
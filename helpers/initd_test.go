@@ -0,0 +1,143 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Points initDDir at a temporary directory for fake init scripts, returning it
+func useInitDDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous := initDDir
+	initDDir = dir
+	t.Cleanup(func() { initDDir = previous })
+	return dir
+}
+
+func writeInitScript(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Script without a status action that only references its pidfile
+func pidFileScript(pidFile string) string {
+	return fmt.Sprintf(`PIDFILE=%s
+case "$1" in
+  start|stop) ;;
+  *) echo "Usage: $0 {start|stop}"; exit 1 ;;
+esac
+`, pidFile)
+}
+
+// PID of a process that has exited and been reaped
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestInitDServiceState(t *testing.T) {
+	dir := useInitDDir(t)
+	runDir := filepath.Join(t.TempDir(), "run")
+	if err := os.Mkdir(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writePID := func(name string, pid int) string {
+		path := filepath.Join(runDir, name+".pid")
+		if err := os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name       string
+		script     string
+		wantActive string
+		wantSub    string
+	}{
+		{
+			name:       "lsb-running",
+			script:     "echo \"lsb-running is running\"\nexit 0\n",
+			wantActive: "active", wantSub: "running",
+		},
+		{
+			name:       "lsb-stopped",
+			script:     "exit 3\n",
+			wantActive: "inactive", wantSub: "dead",
+		},
+		{
+			name:       "not-running-output",
+			script:     "echo \"not-running-output is not running\"\nexit 3\n",
+			wantActive: "inactive", wantSub: "dead",
+		},
+		{
+			name:       "live-pidfile",
+			script:     pidFileScript(writePID("live-pidfile", os.Getpid())),
+			wantActive: "active", wantSub: "running",
+		},
+		{
+			name:       "stale-pidfile",
+			script:     pidFileScript(writePID("stale-pidfile", deadPID(t))),
+			wantActive: "failed", wantSub: "dead",
+		},
+		{
+			name:       "removed-pidfile",
+			script:     pidFileScript(filepath.Join(runDir, "removed-pidfile.pid")),
+			wantActive: "inactive", wantSub: "dead",
+		},
+		{
+			name:       "stale-pidfile-lsb-dead",
+			script:     "PIDFILE=" + writePID("stale-pidfile-lsb-dead", deadPID(t)) + "\necho \"pidfile exists but process is dead\"\nexit 1\n",
+			wantActive: "failed", wantSub: "dead",
+		},
+		{
+			name:       "unknown-status",
+			script:     "echo \"cannot determine status\"\nexit 4\n",
+			wantActive: "unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeInitScript(t, dir, tt.name, tt.script)
+			got := initDServiceState(tt.name)
+			want := ServiceState{Name: tt.name, ActiveState: tt.wantActive, SubState: tt.wantSub}
+			if got != want {
+				t.Errorf("state = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestInitDServiceStateMissingScript(t *testing.T) {
+	useInitDDir(t)
+	got := initDServiceState("helpcom")
+	want := ServiceState{Name: "helpcom", ActiveState: "inactive", SubState: "dead"}
+	if got != want {
+		t.Errorf("state of a missing script = %+v, want %+v", got, want)
+	}
+}
+
+func TestPidFilePattern(t *testing.T) {
+	tests := map[string]string{
+		"PIDFILE=/var/run/helpcom.pid\n":                       "/var/run/helpcom.pid",
+		"start-stop-daemon --pidfile /run/sos/web.pid --start": "/run/sos/web.pid",
+		"DAEMON=/opt/helpcom/helpcom\n":                        "",
+	}
+	for script, want := range tests {
+		if got := pidFilePattern.FindString(script); got != want {
+			t.Errorf("pidfile in %q = %q, want %q", script, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package helpers
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"status-updater/config"
+	"strings"
+)
+
+// Where the kernel lists network interfaces
+var sysClassNet = "/sys/class/net"
+
+// Drivers of USB and PCIe modems that expose a network interface
+var cellularDrivers = map[string]bool{
+	"qmi_wwan":       true,
+	"cdc_mbim":       true,
+	"huawei_cdc_ncm": true,
+	"sierra_net":     true,
+	"mhi_net":        true,
+}
+
+// Reports whether an interface matches one of the network.exclude_interfaces glob patterns
+func ExcludedInterface(name string) bool {
+	for _, pattern := range config.Current().Network.ExcludeInterfaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Classifies an interface as ethernet, wifi, cellular, virtual or other from sysfs
+func InterfaceType(name string) string {
+	base := filepath.Join(sysClassNet, name)
+	if IsWireless(name) {
+		return "wifi"
+	}
+	if strings.HasPrefix(name, "wwan") {
+		return "cellular"
+	}
+
+	// Interfaces without a backing device are created in software (bridges, veth, tunnels, lo)
+	if !exists(filepath.Join(base, "device")) {
+		return "virtual"
+	}
+	if driver, err := os.Readlink(filepath.Join(base, "device", "driver")); err == nil && cellularDrivers[filepath.Base(driver)] {
+		return "cellular"
+	}
+
+	// ARPHRD_ETHER
+	if data, err := os.ReadFile(filepath.Join(base, "type")); err == nil && strings.TrimSpace(string(data)) == "1" {
+		return "ethernet"
+	}
+	return "other"
+}
+
+// Reports whether an interface is wireless from sysfs rather than its name, so USB adapters named wlx<mac> count too
+func IsWireless(name string) bool {
+	base := filepath.Join(sysClassNet, name)
+	return exists(filepath.Join(base, "wireless")) || exists(filepath.Join(base, "phy80211"))
+}
+
+// Returns the wireless interfaces sorted by name
+func WirelessInterfaces() []string {
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if IsWireless(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Builds a fake /sys/class/net and points sysClassNet at it, restoring it afterwards:
+//   - eth0: onboard ethernet
+//   - wlan0: onboard wifi with a wireless directory
+//   - wlx00c0ca123456: USB wifi adapter with only the phy80211 link
+//   - usb0: modem on the qmi_wwan driver
+//   - wwan0: modem named after its type
+//   - br0: bridge without a device
+func useFakeSysClassNet(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	devices := t.TempDir()
+	mkdir := func(parts ...string) string {
+		dir := filepath.Join(append([]string{root}, parts...)...)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	write := func(content string, parts ...string) {
+		if err := os.WriteFile(filepath.Join(append([]string{root}, parts...)...), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target string, parts ...string) {
+		if err := os.Symlink(target, filepath.Join(append([]string{root}, parts...)...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	driver := func(name string) string {
+		dir := filepath.Join(devices, "drivers", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	mkdir("eth0", "device")
+	write("1\n", "eth0", "type")
+
+	mkdir("wlan0", "device")
+	mkdir("wlan0", "wireless")
+	write("1\n", "wlan0", "type")
+
+	mkdir("wlx00c0ca123456", "device")
+	symlink(t.TempDir(), "wlx00c0ca123456", "phy80211")
+	write("1\n", "wlx00c0ca123456", "type")
+
+	mkdir("usb0", "device")
+	symlink(driver("qmi_wwan"), "usb0", "device", "driver")
+	write("1\n", "usb0", "type")
+
+	mkdir("wwan0")
+	mkdir("br0")
+	write("1\n", "br0", "type")
+
+	previous := sysClassNet
+	sysClassNet = root
+	t.Cleanup(func() { sysClassNet = previous })
+}
+
+func TestWirelessInterfaces(t *testing.T) {
+	useFakeSysClassNet(t)
+	want := []string{"wlan0", "wlx00c0ca123456"}
+	if got := WirelessInterfaces(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WirelessInterfaces = %v, want %v", got, want)
+	}
+}
+
+func TestInterfaceType(t *testing.T) {
+	useFakeSysClassNet(t)
+	tests := map[string]string{
+		"eth0":            "ethernet",
+		"wlan0":           "wifi",
+		"wlx00c0ca123456": "wifi",
+		"usb0":            "cellular",
+		"wwan0":           "cellular",
+		"br0":             "virtual",
+	}
+	for name, want := range tests {
+		if got := InterfaceType(name); got != want {
+			t.Errorf("InterfaceType(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
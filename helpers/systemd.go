@@ -0,0 +1,186 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"status-updater/cmdrunner"
+	"status-updater/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// Unit state as reported by systemd or init.d
+type ServiceState struct {
+	Name        string `json:"name"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state,omitempty"`
+	Restarts    int    `json:"restarts"`
+}
+
+const systemdQueryTimeout = 5 * time.Second
+
+// D-Bus connection cached across status cycles
+var (
+	systemdConn  *dbus.Conn
+	systemdMutex sync.Mutex
+)
+
+// Returns the cached systemd D-Bus connection, redialing if it dropped
+func systemdConnection(ctx context.Context) (*dbus.Conn, error) {
+	if systemdConn != nil && systemdConn.Connected() {
+		return systemdConn, nil
+	}
+	if systemdConn != nil {
+		systemdConn.Close()
+		systemdConn = nil
+	}
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	systemdConn = conn
+	return conn, nil
+}
+
+// Queries systemd unit states over D-Bus, falling back to systemctl when D-Bus is unavailable
+func GetServiceStates(serviceNames []string) []ServiceState {
+	states, err := getServiceStatesDBus(serviceNames)
+	if err == nil {
+		return states
+	}
+	logger.LogMessage("DEBUG", fmt.Sprintf("D-Bus service query unavailable, falling back to systemctl: %s", err))
+	return getServiceStatesSystemctl(serviceNames)
+}
+
+func getServiceStatesDBus(serviceNames []string) ([]ServiceState, error) {
+	systemdMutex.Lock()
+	defer systemdMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), systemdQueryTimeout)
+	defer cancel()
+
+	conn, err := systemdConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to systemd: %v", err)
+	}
+
+	unitNames := unitNamesOf(serviceNames)
+	units, err := conn.ListUnitsByNamesContext(ctx, unitNames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list units: %v", err)
+	}
+
+	unitsByName := make(map[string]dbus.UnitStatus, len(units))
+	for _, unit := range units {
+		unitsByName[unit.Name] = unit
+	}
+
+	states := make([]ServiceState, 0, len(serviceNames))
+	for i, name := range serviceNames {
+		state := ServiceState{Name: name, ActiveState: "inactive"}
+		if unit, ok := unitsByName[unitNames[i]]; ok {
+			state.ActiveState = unit.ActiveState
+			state.SubState = unit.SubState
+		}
+
+		if prop, err := conn.GetServicePropertyContext(ctx, unitNames[i], "NRestarts"); err == nil {
+			if restarts, ok := prop.Value.Value().(uint32); ok {
+				state.Restarts = int(restarts)
+			}
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// Reads all unit states with a single systemctl show invocation
+func getServiceStatesSystemctl(serviceNames []string) []ServiceState {
+	args := append([]string{"show", "--property=Id,ActiveState,SubState,NRestarts"}, unitNamesOf(serviceNames)...)
+	output, err := cmdrunner.Output("systemctl", args...)
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to get service states from systemctl: %s", err))
+	}
+
+	parsed := make(map[string]ServiceState)
+	for _, block := range strings.Split(strings.TrimSpace(string(output)), "\n\n") {
+		var state ServiceState
+		for _, line := range strings.Split(block, "\n") {
+			key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+			if !found {
+				continue
+			}
+			switch key {
+			case "Id":
+				state.Name = strings.TrimSuffix(value, ".service")
+			case "ActiveState":
+				state.ActiveState = value
+			case "SubState":
+				state.SubState = value
+			case "NRestarts":
+				state.Restarts, _ = strconv.Atoi(value)
+			}
+		}
+		if state.Name != "" {
+			parsed[state.Name] = state
+		}
+	}
+
+	states := make([]ServiceState, 0, len(serviceNames))
+	for _, name := range serviceNames {
+		state, ok := parsed[name]
+		if !ok || state.ActiveState == "" {
+			state = ServiceState{Name: name, ActiveState: "inactive"}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func unitNamesOf(serviceNames []string) []string {
+	unitNames := make([]string, len(serviceNames))
+	for i, name := range serviceNames {
+		unitNames[i] = name + ".service"
+	}
+	return unitNames
+}
+
+// Calls onChange with the unit's new sub state whenever systemd reports a change for one of the services; returns nil on cancellation
+func WatchServiceStates(ctx context.Context, serviceNames []string, onChange func(name, subState string)) error {
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Subscribe(); err != nil {
+		return fmt.Errorf("failed to subscribe to systemd signals: %v", err)
+	}
+
+	watched := make(map[string]string, len(serviceNames))
+	for i, unitName := range unitNamesOf(serviceNames) {
+		watched[unitName] = serviceNames[i]
+	}
+
+	updates := make(chan *dbus.SubStateUpdate, 64)
+	errs := make(chan error, 8)
+	conn.SetSubStateSubscriber(updates, errs)
+
+	for {
+		select {
+		case update := <-updates:
+			if name, ok := watched[update.UnitName]; ok {
+				onChange(name, update.SubState)
+			}
+		case err := <-errs:
+			return fmt.Errorf("systemd subscription failed: %v", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"status-updater/logger"
+	"status-updater/state"
+	"status-updater/status"
+	"sync"
+	"time"
+)
+
+// History only helps after an incident, so saving less often only risks losing the last changes across a power cut
+const saveInterval = 15 * time.Minute
+
+// Fields that changed in one status cycle
+type Entry struct {
+	Date    string        `json:"date"`
+	Changes status.Fields `json:"changes"`
+}
+
+var (
+	mu       sync.Mutex
+	loaded   bool
+	entries  []Entry
+	sizes    []int
+	total    int
+	previous status.Fields
+	dirty    bool
+	lastSave time.Time
+)
+
+func filePath() string {
+	return filepath.Join(config.Current().StateDir, "history.json")
+}
+
+// Adds the fields that changed since the previous cycle, whether or not the status could be published;
+// the first cycle after startup only sets the baseline
+func Record(fields status.Fields, tolerances map[string]float64, now time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	if previous == nil {
+		previous = make(status.Fields)
+		previous.Apply(fields)
+		return
+	}
+	changes := status.Changes(previous, fields, tolerances)
+	// Values within tolerance stay at the last recorded one, so they can't drift unrecorded
+	previous.Apply(changes)
+	for key, value := range fields {
+		if _, ok := previous[key]; !ok {
+			previous[key] = value
+		}
+	}
+	previous.Prune(fields)
+	if len(changes) == 0 {
+		return
+	}
+
+	entry := Entry{Date: now.UTC().Format(time.RFC3339), Changes: changes}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	entries = append(entries, entry)
+	sizes = append(sizes, len(data))
+	total += len(data)
+	trim()
+	dirty = true
+
+	if now.Sub(lastSave) >= saveInterval {
+		saveLocked()
+	}
+}
+
+// Drops the oldest entries until both history.max_entries and history.max_bytes are met
+func trim() {
+	limits := config.Current().History
+	for len(entries) > 0 && (len(entries) > limits.MaxEntries || total > limits.MaxBytes) {
+		total -= sizes[0]
+		entries, sizes = entries[1:], sizes[1:]
+	}
+}
+
+// Returns the number of recorded changes
+func Count() int {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	return len(entries)
+}
+
+// Returns the recorded changes, oldest first
+func Entries() []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	return append([]Entry(nil), entries...)
+}
+
+// Persists the recorded changes
+func Save() {
+	mu.Lock()
+	defer mu.Unlock()
+	if dirty {
+		saveLocked()
+	}
+}
+
+func saveLocked() {
+	lastSave = time.Now()
+	if err := state.WriteJSON(filePath(), entries); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to save status history: %s", err))
+		return
+	}
+	dirty = false
+}
+
+// Reads the persisted history once; a missing or corrupt file starts empty
+func load() {
+	if loaded {
+		return
+	}
+	loaded = true
+
+	data, err := os.ReadFile(filePath())
+	if err != nil {
+		return
+	}
+	var saved []Entry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Ignoring corrupt status history %s: %s", filePath(), err))
+		return
+	}
+	for _, entry := range saved {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		sizes = append(sizes, len(encoded))
+		total += len(encoded)
+	}
+	// The limits may have been lowered since
+	trim()
+}
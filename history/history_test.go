@@ -0,0 +1,93 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"status-updater/config"
+	"status-updater/status"
+	"testing"
+)
+
+// Replaces the recorded history with n entries of the given encoded size, restoring everything afterwards
+func useEntries(t *testing.T, n, size int) {
+	t.Helper()
+	previous := config.Current()
+	previousEntries, previousSizes, previousTotal := entries, sizes, total
+	t.Cleanup(func() {
+		config.Set(previous)
+		entries, sizes, total = previousEntries, previousSizes, previousTotal
+	})
+
+	entries, sizes, total = nil, nil, 0
+	for i := 0; i < n; i++ {
+		value, _ := json.Marshal(fmt.Sprintf("%d", i))
+		entries = append(entries, Entry{Date: fmt.Sprintf("entry %d", i), Changes: status.Fields{"temp": value}})
+		sizes = append(sizes, size)
+		total += size
+	}
+}
+
+func TestTrim(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int
+		want       int
+	}{
+		{name: "within both limits", maxEntries: 10, maxBytes: 1000, want: 10},
+		{name: "max_entries reached first", maxEntries: 4, maxBytes: 1000, want: 4},
+		{name: "max_bytes reached first", maxEntries: 10, maxBytes: 350, want: 3},
+		{name: "both exceeded", maxEntries: 6, maxBytes: 500, want: 5},
+		{name: "nothing fits", maxEntries: 10, maxBytes: 50, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useEntries(t, 10, 100)
+			cfg := &config.Config{}
+			cfg.History.MaxEntries = tt.maxEntries
+			cfg.History.MaxBytes = tt.maxBytes
+			config.Set(cfg)
+
+			trim()
+			if len(entries) != tt.want || len(sizes) != tt.want {
+				t.Fatalf("kept %d entries and %d sizes, want %d", len(entries), len(sizes), tt.want)
+			}
+			if total != tt.want*100 {
+				t.Errorf("total = %d, want %d", total, tt.want*100)
+			}
+			if tt.want > 0 {
+				if want := fmt.Sprintf("entry %d", 10-tt.want); entries[0].Date != want {
+					t.Errorf("oldest kept entry = %q, want %q", entries[0].Date, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveAndReloadWithLowerLimits(t *testing.T) {
+	useEntries(t, 10, 100)
+	previousLoaded, previousDirty, previousSave := loaded, dirty, lastSave
+	t.Cleanup(func() { loaded, dirty, lastSave = previousLoaded, previousDirty, previousSave })
+
+	cfg := &config.Config{StateDir: t.TempDir()}
+	cfg.History.MaxEntries = 10
+	cfg.History.MaxBytes = 100000
+	config.Set(cfg)
+	dirty = true
+	saveLocked()
+	if dirty {
+		t.Fatal("history still dirty after saving")
+	}
+
+	lowered := *cfg
+	lowered.History.MaxEntries = 4
+	config.Set(&lowered)
+	loaded, entries, sizes, total = false, nil, nil, 0
+	if got := Count(); got != 4 {
+		t.Fatalf("reloaded %d entries, want 4", got)
+	}
+	if kept := Entries(); kept[0].Date != "entry 6" || kept[3].Date != "entry 9" {
+		t.Errorf("reloaded entries %q to %q, want the newest four", kept[0].Date, kept[3].Date)
+	}
+}
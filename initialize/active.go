@@ -0,0 +1,67 @@
+package initialize
+
+import (
+	"net/url"
+	"status-updater/config"
+	"status-updater/logger"
+	"sync"
+)
+
+// Non-secret effective configuration, reported in the meta section so a config push can be confirmed per device
+type ActiveConfig struct {
+	BrokerHost    string   `json:"broker_host"`
+	BrokerPort    int      `json:"broker_port"`
+	SleepInterval string   `json:"sleep_interval"`
+	LogLevel      string   `json:"log_level"`
+	UpdaterHost   string   `json:"updater_host,omitempty"`
+	Gatherers     []string `json:"gatherers"`
+}
+
+var (
+	activeMutex  sync.Mutex
+	activeConfig *ActiveConfig
+)
+
+// Returns the effective configuration as of the last successful load or reload
+func Active() *ActiveConfig {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	return activeConfig
+}
+
+// Rebuilds the reported configuration from the config in effect; every string goes through the log sanitizer, so a
+// configured secret or credentials embedded in a URL never end up in the payload
+func refreshActive() {
+	c := config.Current()
+	active := &ActiveConfig{
+		SleepInterval: c.SleepInterval.String(),
+		LogLevel:      logger.Sanitize(c.Log.Level),
+		UpdaterHost:   logger.Sanitize(urlHost(c.UpdaterService.MetadataURL)),
+		Gatherers:     []string{},
+	}
+	if brokers := c.BrokerList(); len(brokers) > 0 {
+		active.BrokerHost = logger.Sanitize(brokers[0].Host)
+		active.BrokerPort = brokers[0].Port
+	}
+	for _, name := range config.GathererNames {
+		if c.GathererEnabled(name) {
+			active.Gatherers = append(active.Gatherers, name)
+		}
+	}
+
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	activeConfig = active
+}
+
+// Host part of a URL, without scheme, credentials, path or query
+func urlHost(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,97 @@
+package initialize
+
+import (
+	"encoding/json"
+	"reflect"
+	"status-updater/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Puts cfg in effect and rebuilds the active config from it, restoring both afterwards
+func useActive(t *testing.T, cfg *config.Config) *ActiveConfig {
+	t.Helper()
+	previous := config.Current()
+	previousActive := Active()
+	config.Set(cfg)
+	t.Cleanup(func() {
+		config.Set(previous)
+		activeMutex.Lock()
+		activeConfig = previousActive
+		activeMutex.Unlock()
+	})
+	refreshActive()
+	return Active()
+}
+
+func TestActiveConfig(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.MQTT.Brokers = []string{"broker.example.com:8883", "backup.example.com"}
+	cfg.MQTT.Port = 1883
+	cfg.SleepInterval = config.Duration(5 * time.Minute)
+	cfg.Log.Level = "INFO"
+	cfg.UpdaterService.MetadataURL = "https://updates.example.com:8443/status-updater/meta.json?channel=beta"
+	cfg.Gatherers = map[string]bool{}
+	for _, name := range config.GathererNames {
+		cfg.Gatherers[name] = name == "modem" || name == "lldp"
+	}
+
+	active := useActive(t, cfg)
+	want := &ActiveConfig{
+		BrokerHost:    "broker.example.com",
+		BrokerPort:    8883,
+		SleepInterval: "5m0s",
+		LogLevel:      "INFO",
+		UpdaterHost:   "updates.example.com",
+		Gatherers:     []string{},
+	}
+	for _, name := range config.GathererNames {
+		if name == "modem" || name == "lldp" {
+			want.Gatherers = append(want.Gatherers, name)
+		}
+	}
+	if !reflect.DeepEqual(active, want) {
+		t.Errorf("active config = %+v, want %+v", active, want)
+	}
+}
+
+func TestActiveConfigHasNoSecrets(t *testing.T) {
+	tests := map[string]func(*config.Config){
+		"credentials in the updater URL": func(cfg *config.Config) {
+			cfg.UpdaterService.MetadataURL = "https://device:" + cfg.UpdaterService.Password + "@updates.example.com/meta.json"
+		},
+		"password in the broker host": func(cfg *config.Config) {
+			cfg.MQTT.Broker = cfg.MQTT.Password + ".broker.example.com"
+		},
+		"token as the log level": func(cfg *config.Config) {
+			cfg.Log.Level = cfg.Fallback.Token
+		},
+		"password in the updater host": func(cfg *config.Config) {
+			cfg.UpdaterService.MetadataURL = "https://" + cfg.UpdaterService.Password + ".example.com/meta.json"
+		},
+	}
+
+	for name, apply := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.MQTT.Broker = "broker.example.com"
+			cfg.MQTT.Port = 8883
+			cfg.MQTT.Password = "mqttpw42"
+			cfg.Fallback.Token = "fbtoken77"
+			cfg.UpdaterService.Password = "updpw99"
+			cfg.Log.Level = "DEBUG"
+			apply(cfg)
+
+			data, err := json.Marshal(useActive(t, cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{cfg.MQTT.Password, cfg.Fallback.Token, cfg.UpdaterService.Password} {
+				if strings.Contains(string(data), secret) {
+					t.Errorf("active config %s contains the secret %q", data, secret)
+				}
+			}
+		})
+	}
+}
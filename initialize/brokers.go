@@ -0,0 +1,133 @@
+package initialize
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"status-updater/config"
+	"status-updater/helpers"
+	"status-updater/logger"
+	"strconv"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Failover state: the broker being tried, the one connected to and the secondary preferred until failback
+var (
+	failoverMutex   sync.Mutex
+	attemptedBroker = -1
+	connectedBroker = -1
+	preferredBroker int
+	preferredSince  time.Time
+	probingPrimary  bool
+)
+
+// Adds every configured broker to opts in connection order and, with failover set, tracks which one is being tried.
+// The TLS server name follows the broker's hostname, since the URL carries the resolved IP.
+func addBrokers(opts *MQTT.ClientOptions, failover bool) {
+	brokers := config.Current().BrokerList()
+	hosts := make(map[string]int, len(brokers))
+	for _, index := range brokerOrder(len(brokers), failover) {
+		broker := brokers[index]
+		brokerURL := BrokerURL(helpers.ResolveBroker(broker.Host), broker.Port)
+		logger.LogMessage("DEBUG", fmt.Sprintf("Using broker URL: %s", brokerURL))
+		opts.AddBroker(brokerURL)
+		if parsed, err := url.Parse(brokerURL); err == nil {
+			hosts[parsed.Host] = index
+		}
+	}
+
+	opts.SetConnectionAttemptHandler(func(brokerURL *url.URL, tlsCfg *tls.Config) *tls.Config {
+		index, ok := hosts[brokerURL.Host]
+		if failover {
+			failoverMutex.Lock()
+			attemptedBroker = -1
+			if ok {
+				attemptedBroker = index
+			}
+			failoverMutex.Unlock()
+		}
+
+		if !ok || tlsCfg == nil {
+			return tlsCfg
+		}
+		cfg := tlsCfg.Clone()
+		cfg.ServerName = brokers[index].Host
+		return cfg
+	})
+}
+
+// Broker indexes in the order to try them: the primary first, unless a secondary took over less than
+// mqtt.failback_after ago. Once that passed the primary is probed again, which only the failover connection records.
+func brokerOrder(count int, failover bool) []int {
+	failoverMutex.Lock()
+	defer failoverMutex.Unlock()
+
+	first := 0
+	probing := false
+	if preferredBroker > 0 && preferredBroker < count {
+		if time.Since(preferredSince) < config.Current().MQTT.FailbackAfter.Duration() {
+			first = preferredBroker
+		} else {
+			probing = true
+		}
+	}
+	if failover {
+		probingPrimary = probing
+	}
+
+	order := []int{first}
+	for index := 0; index < count; index++ {
+		if index != first {
+			order = append(order, index)
+		}
+	}
+	return order
+}
+
+// Records that the broker being tried accepted the connection; call from the client's OnConnect handler
+func RecordConnected() {
+	brokers := config.Current().BrokerList()
+
+	failoverMutex.Lock()
+	defer failoverMutex.Unlock()
+
+	index := attemptedBroker
+	connectedBroker = index
+	if index < 0 || index >= len(brokers) {
+		return
+	}
+
+	switch {
+	case index == 0 && preferredBroker > 0:
+		logger.LogMessage("INFO", fmt.Sprintf("Returned to primary broker %s", brokerAddress(brokers[0])))
+		preferredBroker = 0
+	case index > 0 && (index != preferredBroker || probingPrimary):
+		// A failed probe of the primary starts a new failback period
+		if index != preferredBroker {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed over to broker %s", brokerAddress(brokers[index])))
+		}
+		preferredBroker = index
+		preferredSince = time.Now()
+	}
+	probingPrimary = false
+}
+
+// Returns host:port of the broker the last connection went to, empty before the first connection
+func ConnectedBroker() string {
+	brokers := config.Current().BrokerList()
+
+	failoverMutex.Lock()
+	defer failoverMutex.Unlock()
+	if connectedBroker < 0 || connectedBroker >= len(brokers) {
+		return ""
+	}
+	return brokerAddress(brokers[connectedBroker])
+}
+
+func brokerAddress(broker config.Broker) string {
+	return net.JoinHostPort(broker.Host, strconv.Itoa(broker.Port))
+}
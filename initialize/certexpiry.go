@@ -0,0 +1,124 @@
+package initialize
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certExpiryWarn  = 30 * 24 * time.Hour
+	certExpiryError = 7 * 24 * time.Hour
+)
+
+// Expiry of the CA and the broker leaf certificate from the last successful handshake
+var (
+	certExpiryMutex  sync.Mutex
+	caCertExpiry     time.Time
+	brokerCertExpiry time.Time
+)
+
+// Returns the earliest CA expiry and the broker certificate expiry; zero when unknown
+func CertificateExpiry() (ca, broker time.Time) {
+	certExpiryMutex.Lock()
+	defer certExpiryMutex.Unlock()
+	return caCertExpiry, brokerCertExpiry
+}
+
+// Re-reads the CA certificates and logs WARN/ERROR for the CA or broker certificate nearing expiry; run at startup and daily
+func CheckCertificateExpiry() {
+	if !IsTLSScheme(config.Current().MQTT.Scheme) {
+		return
+	}
+
+	LogCACertificates()
+
+	_, broker := CertificateExpiry()
+	if !broker.IsZero() {
+		logCertExpiry("Broker certificate", broker)
+	}
+}
+
+// Records the broker leaf certificate after the handshake verified it
+func recordBrokerCertificate(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+
+	certExpiryMutex.Lock()
+	changed := !brokerCertExpiry.Equal(leaf.NotAfter)
+	brokerCertExpiry = leaf.NotAfter
+	certExpiryMutex.Unlock()
+
+	if changed {
+		logger.LogMessage("INFO", fmt.Sprintf("Broker certificate: subject=%q expires=%s",
+			leaf.Subject.String(), leaf.NotAfter.UTC().Format(time.RFC3339)))
+		logCertExpiry("Broker certificate", leaf.NotAfter)
+	}
+	return nil
+}
+
+func recordCAExpiry(certs []*x509.Certificate) {
+	var earliest time.Time
+	for _, cert := range certs {
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+
+	certExpiryMutex.Lock()
+	caCertExpiry = earliest
+	certExpiryMutex.Unlock()
+}
+
+// Logs ERROR within 7 days of expiry (or after it) and WARN within 30
+func logCertExpiry(name string, notAfter time.Time) {
+	remaining := time.Until(notAfter)
+	expires := notAfter.UTC().Format(time.RFC3339)
+	switch {
+	case remaining <= 0:
+		logger.LogMessage("ERROR", fmt.Sprintf("%s expired at %s", name, expires))
+	case remaining < certExpiryError:
+		logger.LogMessage("ERROR", fmt.Sprintf("%s expires in %s at %s", name, remaining.Round(time.Hour), expires))
+	case remaining < certExpiryWarn:
+		logger.LogMessage("WARN", fmt.Sprintf("%s expires in %s at %s", name, remaining.Round(time.Hour), expires))
+	}
+}
+
+// Describes TLS handshake failures (expired certificate, unknown authority, hostname mismatch); empty for other errors
+func ClassifyTLSError(err error) string {
+	var invalid x509.CertificateInvalidError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "TLS certificate expired or not yet valid"
+	case errors.As(err, &unknownAuthority):
+		return "TLS certificate signed by unknown authority, check mqtt.ca_file"
+	case errors.As(err, &hostname):
+		return "TLS certificate hostname mismatch, check mqtt.broker"
+	}
+
+	// paho may flatten the error into a string
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "certificate has expired"):
+		return "TLS certificate expired or not yet valid"
+	case strings.Contains(message, "certificate signed by unknown authority"):
+		return "TLS certificate signed by unknown authority, check mqtt.ca_file"
+	case strings.Contains(message, "certificate is valid for"), strings.Contains(message, "certificate is not valid for"):
+		return "TLS certificate hostname mismatch, check mqtt.broker"
+	case strings.Contains(message, "tls:"):
+		return "TLS handshake failed"
+	}
+	return ""
+}
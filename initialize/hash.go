@@ -0,0 +1,76 @@
+package initialize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Config keys whose values are replaced before hashing, so rotating a secret doesn't change the hash
+var secretKeys = map[string]bool{
+	"password": true,
+	"token":    true,
+}
+
+// Returns the SHA-256 of the config file with secrets redacted, or "" when it can't be read
+func hashConfigFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return ""
+	}
+	// encoding/json sorts map keys, so formatting changes don't affect the hash either
+	canonical, err := json.Marshal(redactSecrets(parsed))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+func redactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if secretKeys[strings.ToLower(key)] {
+				v[key] = "redacted"
+			} else {
+				v[key] = redactSecrets(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactSecrets(child)
+		}
+	}
+	return value
+}
+
+// Returns the SHA-256 over the contents of the configured CA files, or "" when none can be read
+func CAHash() string {
+	caPath := caFilePath()
+	info, err := os.Stat(caPath)
+	if err != nil {
+		return ""
+	}
+	files, err := caFiles(caPath, info)
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return ""
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
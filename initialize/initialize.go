@@ -4,66 +4,175 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"status-updater/config"
 	"status-updater/helpers"
 	"status-updater/logger"
+	"strconv"
+	"strings"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
-func LoadConfig() error {
+// Standard config locations searched when no -config flag is given
+var configSearchPaths = []string{
+	"/etc/status-updater/config.json",
+	"/opt/status-updater/config",
+}
+
+// Returns the config path from the flag, $STATUS_UPDATER_CONFIG, the standard locations, then the cwd
+func findConfigFile(flagPath string) (string, error) {
+	if flagPath != "" {
+		return flagPath, nil
+	}
+	if envPath := os.Getenv("STATUS_UPDATER_CONFIG"); envPath != "" {
+		return envPath, nil
+	}
+
+	candidates := append([]string{}, configSearchPaths...)
 	cwd, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("failed to get current working directory: %v", err)
+		return "", fmt.Errorf("failed to get current working directory: %v", err)
+	}
+	candidates = append(candidates, filepath.Join(cwd, "config.json"))
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
 	}
-	configFilePath := filepath.Join(cwd, "config.json")
+	return "", fmt.Errorf("configuration file not found in any of: %s", strings.Join(candidates, ", "))
+}
 
-	file, err := os.Open(configFilePath)
+func LoadConfig(flagPath string) error {
+	configFilePath, err := findConfigFile(flagPath)
 	if err != nil {
-		return fmt.Errorf("configuration file not found at %s", configFilePath)
+		return err
+	}
+	if absPath, err := filepath.Abs(configFilePath); err == nil {
+		configFilePath = absPath
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config.Current); err != nil {
-		return fmt.Errorf("failed to decode configuration: %v", err)
+	cfg, err := readConfigFile(configFilePath)
+	if err != nil {
+		return err
 	}
 
-	// Required config validation
-	if config.Current.UpdaterService.MetadataURL == "" {
-		return fmt.Errorf("missing required configuration key: METADATA_URL")
+	config.Path = configFilePath
+	cfg.Hash = hashConfigFile(configFilePath)
+	err = cfg.Validate()
+	config.Set(&cfg)
+	refreshActive()
+	return err
+}
+
+// Re-reads the loaded config file and its secret files, keeping the current config if the new one is fatally invalid
+func ReloadConfig() error {
+	cfg, err := readConfigFile(config.Path)
+	if err != nil {
+		return err
 	}
-	if config.Current.UpdaterService.Username == "" {
-		return fmt.Errorf("missing required configuration key: USERNAME")
+
+	validateErr := cfg.Validate()
+	var validationErr *config.ValidationError
+	if errors.As(validateErr, &validationErr) && validationErr.IsFatal() {
+		return validateErr
 	}
-	if config.Current.UpdaterService.Password == "" {
-		return fmt.Errorf("missing required configuration key: PASSWORD")
+
+	cfg.Hash = hashConfigFile(config.Path)
+	config.Set(&cfg)
+	refreshActive()
+	return validateErr
+}
+
+// Decodes a config file and resolves secrets referenced by *_file keys
+func readConfigFile(configFilePath string) (config.Config, error) {
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("configuration file not found at %s", configFilePath)
 	}
+	return decodeConfig(data)
+}
 
+// Checks a complete config file's contents the way a reload would, without applying them; only fatal problems
+// are returned
+func CheckConfig(data []byte) error {
+	cfg, err := decodeConfig(data)
+	if err != nil {
+		return err
+	}
+	var validationErr *config.ValidationError
+	if err := cfg.Validate(); errors.As(err, &validationErr) && validationErr.IsFatal() {
+		return err
+	}
 	return nil
 }
 
-// MQTT client options initialization
-func InitializeMQTTClientOptions() (*MQTT.ClientOptions, error) {
-	// Config validation
-	if config.Current.MQTT.Username == "" {
-		return nil, fmt.Errorf("MQTT username not configured")
+func decodeConfig(data []byte) (config.Config, error) {
+	var cfg config.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to decode configuration: %v", err)
 	}
-	if config.Current.MQTT.Password == "" {
-		return nil, fmt.Errorf("MQTT password not configured")
+
+	var err error
+
+	// Secret files take precedence over inline values
+	if cfg.MQTT.PasswordFile != "" {
+		if cfg.MQTT.Password, err = readSecretFile(cfg.MQTT.PasswordFile); err != nil {
+			return cfg, fmt.Errorf("mqtt.password_file: %v", err)
+		}
+	}
+	if cfg.Fallback.TokenFile != "" {
+		if cfg.Fallback.Token, err = readSecretFile(cfg.Fallback.TokenFile); err != nil {
+			return cfg, fmt.Errorf("fallback.token_file: %v", err)
+		}
+	}
+	if cfg.UpdaterService.PasswordFile != "" {
+		if cfg.UpdaterService.Password, err = readSecretFile(cfg.UpdaterService.PasswordFile); err != nil {
+			return cfg, fmt.Errorf("updater_service.password_file: %v", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Reads and trims a secret file; its contents are never logged
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %v", path, err)
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
 	}
+	return secret, nil
+}
 
-	brokerAddress := helpers.ResolveBroker()
-	logger.LogMessage("DEBUG", fmt.Sprintf("Resolved broker address: %s", brokerAddress))
-	logger.LogMessage("DEBUG", fmt.Sprintf("Using username: %s", config.Current.MQTT.Username))
+// MQTT client options initialization for the publishing connection, which drives broker failover
+func InitializeMQTTClientOptions() (*MQTT.ClientOptions, error) {
+	return newClientOptions(true)
+}
 
+// Client options for a listener connection: it follows the broker the publishing connection prefers, without
+// changing the failover state
+func InitializeListenerOptions() (*MQTT.ClientOptions, error) {
+	return newClientOptions(false)
+}
+
+func newClientOptions(failover bool) (*MQTT.ClientOptions, error) {
+	cfg := config.Current()
+	logger.LogMessage("DEBUG", fmt.Sprintf("Using username: %s", cfg.MQTT.Username))
+
+	// paho tries the brokers in order until one accepts the connection
 	opts := MQTT.NewClientOptions()
-	brokerURL := fmt.Sprintf("ssl://%s:%d", brokerAddress, config.Current.MQTT.Port)
-	opts.AddBroker(brokerURL)
+	addBrokers(opts, failover)
 
 	// Client ID from eth0 MAC
 	eth0MAC, err := helpers.GetMACAddress("eth0")
@@ -75,8 +184,8 @@ func InitializeMQTTClientOptions() (*MQTT.ClientOptions, error) {
 	opts.SetClientID(clientID)
 
 	// Auth credentials
-	opts.SetUsername(config.Current.MQTT.Username)
-	opts.SetPassword(config.Current.MQTT.Password)
+	opts.SetUsername(cfg.MQTT.Username)
+	opts.SetPassword(cfg.MQTT.Password)
 
 	// Connection stability params
 	opts.SetConnectTimeout(30 * time.Second)
@@ -89,36 +198,186 @@ func InitializeMQTTClientOptions() (*MQTT.ClientOptions, error) {
 	opts.SetOrderMatters(false)
 	opts.SetResumeSubs(true)
 
-	// TLS setup
-	caCertPool, err := loadCACertificate()
-	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to load CA certificate: %s", err))
-		return nil, err
-	}
+	// TLS setup, only for TLS schemes
+	if IsTLSScheme(cfg.MQTT.Scheme) {
+		caCertPool, err := loadCACertificate()
+		if err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to load CA certificate: %s", err))
+			return nil, err
+		}
 
-	tlsConfig := &tls.Config{
-		RootCAs:            caCertPool,
-		MinVersion:         tls.VersionTLS12,
-		InsecureSkipVerify: false,
+		tlsConfig := &tls.Config{
+			RootCAs:            caCertPool,
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: false,
+		}
+		tlsConfig.VerifyConnection = recordBrokerCertificate
+		opts.SetTLSConfig(tlsConfig)
 	}
-	opts.SetTLSConfig(tlsConfig)
 
 	return opts, nil
 }
 
+// Builds the broker URL for the configured scheme (ssl, tcp, ws, wss)
+func BrokerURL(brokerAddress string, port int) string {
+	cfg := config.Current()
+	scheme := cfg.MQTT.Scheme
+	if scheme == "" {
+		scheme = "ssl"
+	}
+
+	hostPort := net.JoinHostPort(brokerAddress, strconv.Itoa(port))
+	if scheme == "ws" || scheme == "wss" {
+		path := cfg.MQTT.WebSocketPath
+		if path == "" {
+			path = config.DefaultWebSocketPath
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return fmt.Sprintf("%s://%s%s", scheme, hostPort, path)
+	}
+	return fmt.Sprintf("%s://%s", scheme, hostPort)
+}
+
+func IsTLSScheme(scheme string) bool {
+	return scheme == "" || scheme == "ssl" || scheme == "wss"
+}
+
 // CA cert loader
 func loadCACertificate() (*x509.CertPool, error) {
+	certs, caPath, err := readCACertificates()
+	if err != nil {
+		return nil, err
+	}
+
 	caCertPool := x509.NewCertPool()
+	if config.Current().MQTT.UseSystemCAs {
+		if systemPool, err := x509.SystemCertPool(); err == nil {
+			caCertPool = systemPool
+		} else {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to load system CA pool: %s", err))
+		}
+	}
+
+	for _, cert := range certs {
+		caCertPool.AddCert(cert)
+	}
 
-	caCert, err := os.ReadFile("cacert.pem")
+	logger.LogMessage("DEBUG", fmt.Sprintf("Loaded %d CA certificate(s) from %s", len(certs), caPath))
+	return caCertPool, nil
+}
+
+// Logs subject and expiry of each configured CA, warning about those expiring soon
+func LogCACertificates() {
+	if !IsTLSScheme(config.Current().MQTT.Scheme) {
+		return
+	}
+
+	certs, caPath, err := readCACertificates()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CA certificate from file: %s", err)
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to load CA certificate: %s", err))
+		return
 	}
 
-	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-		return nil, fmt.Errorf("failed to append CA certificate from file")
+	recordCAExpiry(certs)
+	for _, cert := range certs {
+		logger.LogMessage("INFO", fmt.Sprintf("CA certificate from %s: subject=%q expires=%s",
+			caPath, cert.Subject.String(), cert.NotAfter.UTC().Format(time.RFC3339)))
+		logCertExpiry(fmt.Sprintf("CA certificate %q", cert.Subject.String()), cert.NotAfter)
 	}
+}
 
-	logger.LogMessage("INFO", "Loaded CA certificate from file")
-	return caCertPool, nil
+// Resolves mqtt.ca_file relative to the config file, defaulting to cacert.pem next to the config or in the cwd
+func caFilePath() string {
+	caPath := config.Current().MQTT.CAFile
+	if caPath == "" {
+		caPath = "cacert.pem"
+		if config.Path != "" {
+			if candidate := filepath.Join(filepath.Dir(config.Path), caPath); fileExists(candidate) {
+				return candidate
+			}
+		}
+		return caPath
+	}
+
+	if !filepath.IsAbs(caPath) && config.Path != "" {
+		return filepath.Join(filepath.Dir(config.Path), caPath)
+	}
+	return caPath
+}
+
+// Reads CA certificates from a PEM bundle or from every PEM file in a directory
+func readCACertificates() ([]*x509.Certificate, string, error) {
+	caPath := caFilePath()
+
+	info, err := os.Stat(caPath)
+	if err != nil {
+		return nil, caPath, fmt.Errorf("failed to read CA certificate from file: %s", err)
+	}
+
+	files, err := caFiles(caPath, info)
+	if err != nil {
+		return nil, caPath, err
+	}
+
+	var certs []*x509.Certificate
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, caPath, fmt.Errorf("failed to read CA certificate from file: %s", err)
+		}
+		certs = append(certs, parsePEMCertificates(data, file)...)
+	}
+
+	if len(certs) == 0 {
+		return nil, caPath, fmt.Errorf("no CA certificates found in %s", caPath)
+	}
+	return certs, caPath, nil
+}
+
+// Lists the PEM files of a CA bundle or directory, sorted by name
+func caFiles(caPath string, info os.FileInfo) ([]string, error) {
+	if !info.IsDir() {
+		return []string{caPath}, nil
+	}
+
+	entries, err := os.ReadDir(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA directory: %s", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, filepath.Join(caPath, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// Parses every CERTIFICATE block in a PEM bundle, skipping ones that fail to parse
+func parsePEMCertificates(data []byte, source string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Skipping invalid certificate in %s: %s", source, err))
+			continue
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
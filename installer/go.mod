@@ -2,6 +2,9 @@ module main.go
 
 go 1.23.2
 
-require golang.org/x/crypto v0.28.0
+require (
+	golang.org/x/crypto v0.28.0
+	golang.org/x/term v0.25.0
+)
 
 require golang.org/x/sys v0.26.0 // indirect
@@ -3,20 +3,46 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"debug/buildinfo"
+	"debug/elf"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 func main() {
+	credentialsFile := flag.String("credentials-file", "", "JSON file with usernames, passwords and key paths, overriding config.json")
+	site := flag.String("set-site", "", "site written into the device config during install")
+	force := flag.Bool("force", false, "overwrite a device's config without asking when keys would be removed or changed")
+	maxDuration := flag.Duration("max-duration", 0, "abort the run when the installs take longer than this, e.g. 2h; 0 means no limit")
+	webhookURL := flag.String("webhook-url", "", "URL the JSON results summary is POSTed to when the run ends")
+	port := flag.String("port", "22", "SSH port of hosts listed without one in iplist")
+	flag.DurationVar(&stallTimeout, "stall-timeout", stallTimeout, "abort and retry a file transfer when no bytes moved for this long")
+	flag.IntVar(&transferRetries, "transfer-retries", transferRetries, "stalled transfers retried per host before its install fails")
+	flag.Parse()
+	// Webhook URLs such as Slack's carry their token in the path
+	registerSecret(*webhookURL)
+
 	config, err := os.ReadFile("config.json")
 	if err != nil {
 		fmt.Printf("Failed to read config.json: %v\n", err)
@@ -28,6 +54,12 @@ func main() {
 		fmt.Printf("Failed to unmarshal config.json: %v\n", err)
 		return
 	}
+	if *credentialsFile != "" {
+		if err := mergeCredentialsFile(configMap, *credentialsFile); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
 
 	logFile, err := os.OpenFile("installer.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -45,24 +77,15 @@ func main() {
 	fmt.Print("Enter your choice (1 or 2): ")
 	fmt.Scanln(&choice)
 
-	var usernames []string
-	var credentials map[string]string
-
-	switch choice {
-	case "1":
-		usernames = []string{configMap["username1"]}
-		credentials = map[string]string{
-			configMap["username1"]: configMap["password1"],
-		}
-	case "2":
-		usernames = []string{configMap["username2"]}
-		credentials = map[string]string{
-			configMap["username2"]: configMap["password2"],
-		}
-	default:
+	if choice != "1" && choice != "2" {
 		logAndPrint("Invalid choice. Exiting.")
 		return
 	}
+	credentials, err := deviceCredentialList(configMap, choice)
+	if err != nil {
+		logAndPrint(err.Error())
+		return
+	}
 
 	ips, err := readIPsFromFile("iplist")
 	if err != nil {
@@ -70,8 +93,6 @@ func main() {
 		return
 	}
 
-	port := "22"
-
 	debFiles, err := filepath.Glob("*.deb")
 	if err != nil || len(debFiles) == 0 {
 		logAndPrint("No .deb files found in the current directory.")
@@ -103,49 +124,112 @@ func main() {
 	fmt.Print("Do you want to install lldpd on all devices? (y/n): ")
 	var lldpdChoice string
 	fmt.Scanln(&lldpdChoice)
-	installLldpd := strings.ToLower(lldpdChoice) == "y"
+	// Read once here rather than by every host goroutine
+	var lldpdZip []byte
+	if strings.ToLower(lldpdChoice) == "y" {
+		lldpdZip, err = os.ReadFile(lldpdZipFile)
+		if err != nil {
+			logAndPrint(fmt.Sprintf("Failed to read %s: %v\n", lldpdZipFile, err))
+			return
+		}
+	}
+
+	// Ctrl-C and -max-duration abort the same way: hosts not started yet are skipped and open SSH
+	// connections are closed, failing the installs in progress. A second Ctrl-C exits immediately.
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stopSignals)
+	if *maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, *maxDuration, fmt.Errorf("maximum run duration of %s exceeded", *maxDuration))
+		defer cancel()
+	}
+	started := time.Now()
 
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, 10) // Max 10 concurrent connections
 	var failedInstalls []string
+	var failedLldpd []string
+	var skipped []string
+	timings := make(map[string]*hostTiming)
+	configDiffs := make(map[string][]string)
 	var mu sync.Mutex
 
-	for _, host := range ips {
+	stopProgress := make(chan struct{})
+	go showProgress(stopProgress)
+
+	for _, target := range resolveTargets(ips) {
 		wg.Add(1)
-		go func(host string) {
+		go func(target installTarget) {
 			defer wg.Done()
-			sem <- struct{}{}
+			host := target.String()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				mu.Lock()
+				skipped = append(skipped, host)
+				mu.Unlock()
+				return
+			}
 			defer func() { <-sem }()
 
+			if target.err != nil {
+				logAndPrint(fmt.Sprintf("DNS resolution failed for %s: %v\n", target.entry, target.err))
+				mu.Lock()
+				failedInstalls = append(failedInstalls, fmt.Sprintf("%s (DNS resolution failed)", target.entry))
+				mu.Unlock()
+				return
+			}
+
 			logAndPrint(fmt.Sprintf("Processing host: %s\n", host))
 
-			var client *ssh.Client
-			var err error
-			var successfulUser string
+			timing := &hostTiming{}
+			started := time.Now()
+			mu.Lock()
+			timings[host] = timing
+			mu.Unlock()
+			defer func() { timing.TotalMs = time.Since(started).Milliseconds() }()
 
-			for _, user := range usernames {
-				client, err = connectSSH(host, user, credentials[user], port)
-				if err == nil {
-					successfulUser = user
-					break
-				}
-				logAndPrint(fmt.Sprintf("Failed to connect to %s with user %s: %v\n", host, user, err))
+			hostPort := *port
+			if target.port != "" {
+				hostPort = target.port
 			}
-
+			client, cred, err := connectSSH(ctx, target.ip, hostPort, credentials, timing)
 			if err != nil {
-				logAndPrint(fmt.Sprintf("Failed to connect to %s with any user\n", host))
+				logAndPrint(fmt.Sprintf("Failed to connect to %s: %v\n", host, err))
 				mu.Lock()
 				failedInstalls = append(failedInstalls, host)
 				mu.Unlock()
 				return
 			}
+			timing.ConnectMs = time.Since(started).Milliseconds()
 			defer client.Close()
+			defer context.AfterFunc(ctx, func() { client.Close() })()
+			trackTransfers(client, host)
 
+			var configChanges []string
 			isBuildroot := checkBuildroot(client)
 			if isBuildroot {
-				err = installBuildroot(client)
+				configChanges, err = installBuildroot(client, host, *site, *force)
 			} else {
-				err = installDeb(client, debData, debFile, credentials[successfulUser], installLldpd)
+				var lldpdErr error
+				configChanges, lldpdErr, err = installDeb(client, debData, debFile, cred.password, lldpdZip, *site)
+				if lldpdErr != nil {
+					logAndPrint(fmt.Sprintf("Failed to install lldpd on %s: %v\n", host, lldpdErr))
+					mu.Lock()
+					failedLldpd = append(failedLldpd, host)
+					mu.Unlock()
+				}
+				if len(configChanges) > 0 {
+					logAndPrint(fmt.Sprintf("Config changes on %s: %s", host, strings.Join(configChanges, "; ")))
+				}
+			}
+			if len(configChanges) > 0 {
+				mu.Lock()
+				configDiffs[host] = configChanges
+				mu.Unlock()
 			}
 
 			if err != nil {
@@ -156,10 +240,16 @@ func main() {
 			} else {
 				logAndPrint(fmt.Sprintf("Successfully installed on %s\n", host))
 			}
-		}(host)
+		}(target)
 	}
 
 	wg.Wait()
+	close(stopProgress)
+
+	aborted := ctx.Err() != nil
+	if aborted {
+		logAndPrint(fmt.Sprintf("Run aborted: %v", context.Cause(ctx)))
+	}
 
 	if len(failedInstalls) > 0 {
 		logAndPrint("Failed installs on the following hosts:")
@@ -168,9 +258,307 @@ func main() {
 		}
 	}
 
-	logAndPrint(fmt.Sprintf("Total hosts: %d", len(ips)))
-	logAndPrint(fmt.Sprintf("Successful installs: %d", len(ips)-len(failedInstalls)))
-	logAndPrint(fmt.Sprintf("Failed installs: %d", len(failedInstalls)))
+	// Reported apart: status-updater itself was installed on these hosts
+	if len(failedLldpd) > 0 {
+		logAndPrint("lldpd failed to install on the following hosts:")
+		for _, host := range failedLldpd {
+			logAndPrint(host)
+		}
+	}
+
+	if len(skipped) > 0 {
+		logAndPrint("Not started before the run was aborted:")
+		for _, host := range skipped {
+			logAndPrint(host)
+		}
+	}
+
+	// Chronically slow links stand out here
+	throughput := transferThroughput()
+	if len(throughput) > 0 {
+		logAndPrint("Transfer throughput per host:")
+		hosts := make([]string, 0, len(throughput))
+		for host := range throughput {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			logAndPrint(fmt.Sprintf("%s: %s/s", host, formatBytes(throughput[host])))
+		}
+	}
+
+	if len(timings) > 0 {
+		logAndPrint("Connection timing per host:")
+		hosts := make([]string, 0, len(timings))
+		for host := range timings {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			t := timings[host]
+			connected := "not connected"
+			if t.ConnectMs > 0 {
+				connected = fmt.Sprintf("connected after %dms", t.ConnectMs)
+			}
+			logAndPrint(fmt.Sprintf("%s: port probe %dms, %s in %d login attempts, total %s", host, t.ProbeMs, connected, len(t.Attempts),
+				(time.Duration(t.TotalMs) * time.Millisecond).Round(time.Second)))
+		}
+	}
+
+	summary := runSummary{
+		Total:            len(ips),
+		Successful:       len(ips) - len(failedInstalls) - len(skipped),
+		Failed:           len(failedInstalls),
+		Skipped:          len(skipped),
+		LldpdFailed:      len(failedLldpd),
+		DurationSeconds:  int64(time.Since(started).Seconds()),
+		Aborted:          aborted,
+		FailedHosts:      failedInstalls,
+		SkippedHosts:     skipped,
+		LldpdFailedHosts: failedLldpd,
+		Throughput:       throughput,
+		Timings:          timings,
+		ConfigDiff:       configDiffs,
+	}
+	if aborted {
+		summary.AbortReason = context.Cause(ctx).Error()
+	}
+
+	logAndPrint(fmt.Sprintf("Total hosts: %d", summary.Total))
+	logAndPrint(fmt.Sprintf("Successful installs: %d", summary.Successful))
+	logAndPrint(fmt.Sprintf("Failed installs: %d", summary.Failed))
+	if summary.Skipped > 0 {
+		logAndPrint(fmt.Sprintf("Skipped hosts: %d", summary.Skipped))
+	}
+
+	// A failing webhook is only logged, it doesn't change the outcome of the run
+	if *webhookURL != "" {
+		if err := postSummary(*webhookURL, summary); err != nil {
+			logAndPrint(fmt.Sprintf("Failed to post results to webhook: %v", err))
+		}
+	}
+
+	if aborted || summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Results of a run, POSTed to -webhook-url
+type runSummary struct {
+	Total            int      `json:"total"`
+	Successful       int      `json:"successful"`
+	Failed           int      `json:"failed"`
+	Skipped          int      `json:"skipped"`
+	LldpdFailed      int      `json:"lldpd_failed"`
+	DurationSeconds  int64    `json:"duration_seconds"`
+	Aborted          bool     `json:"aborted"`
+	AbortReason      string   `json:"abort_reason,omitempty"`
+	FailedHosts      []string `json:"failed_hosts"`
+	SkippedHosts     []string `json:"skipped_hosts"`
+	LldpdFailedHosts []string `json:"lldpd_failed_hosts"`
+	// Bytes per second of each host's file transfers
+	Throughput map[string]int64 `json:"throughput_bytes_per_second"`
+	// Port probe, login attempts and install duration of each host
+	Timings map[string]*hostTiming `json:"timings"`
+	// Masked key-level differences between each host's existing config and the uploaded one
+	ConfigDiff map[string][]string `json:"config_diff,omitempty"`
+	// One-line summary, shown as the message by Slack incoming webhooks
+	Text string `json:"text"`
+}
+
+const webhookTimeout = 10 * time.Second
+
+func postSummary(url string, summary runSummary) error {
+	summary.Text = fmt.Sprintf("Installer run: %d/%d hosts installed, %d failed, %d skipped in %s",
+		summary.Successful, summary.Total, summary.Failed, summary.Skipped, time.Duration(summary.DurationSeconds)*time.Second)
+	if summary.Aborted {
+		summary.Text += " (aborted: " + summary.AbortReason + ")"
+	}
+	if len(summary.FailedHosts) > 0 {
+		summary.Text += "\nFailed: " + strings.Join(summary.FailedHosts, ", ")
+	}
+	// Encoded as [] rather than null when empty
+	for _, hosts := range []*[]string{&summary.FailedHosts, &summary.SkippedHosts, &summary.LldpdFailedHosts} {
+		if *hosts == nil {
+			*hosts = []string{}
+		}
+	}
+
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %v", err)
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	logAndPrint("Results posted to webhook")
+	return nil
+}
+
+// Overlays config.json with the values of a credentials file kept outside the working directory, e.g. root-only
+func mergeCredentialsFile(configMap map[string]string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file %s: %v", path, err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		fmt.Printf("Warning: credentials file %s is accessible by other users (mode %v)\n", path, info.Mode().Perm())
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file %s: %v", path, err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("failed to parse credentials file %s: %v", path, err)
+	}
+	for key, value := range values {
+		configMap[key] = value
+	}
+	return nil
+}
+
+// Returns the credentials of a device family (choice "1" or "2"): username<n> first, then any extra pairs
+// configured as username<n>_2, username<n>_3, ... with the matching password and ssh_key keys
+func deviceCredentialList(configMap map[string]string, choice string) ([]credential, error) {
+	var credentials []credential
+	for i := 1; ; i++ {
+		suffix := choice
+		if i > 1 {
+			suffix = fmt.Sprintf("%s_%d", choice, i)
+		}
+		username := configMap["username"+suffix]
+		if i > 1 && username == "" {
+			return credentials, nil
+		}
+		password, signer, err := deviceCredentials(configMap, suffix, username)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential{user: username, password: password, signer: signer})
+	}
+}
+
+// Returns the password and optional SSH key of a device family (suffix "1" or "2"). A password missing from
+// the config is prompted for without echo; with ssh_key<n> set it may be left empty for key-only logins.
+// Both are registered as secrets so they never reach installer.log.
+func deviceCredentials(configMap map[string]string, suffix, username string) (string, ssh.Signer, error) {
+	var signer ssh.Signer
+	if keyPath := configMap["ssh_key"+suffix]; keyPath != "" {
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read ssh_key%s %s: %v", suffix, keyPath, err)
+		}
+		signer, err = ssh.ParsePrivateKey(key)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse ssh_key%s %s: %v", suffix, keyPath, err)
+		}
+	}
+
+	password, err := configSecret(configMap, "password"+suffix)
+	if err != nil {
+		return "", nil, err
+	}
+	if password == "" {
+		prompt := fmt.Sprintf("Password for %s: ", username)
+		if signer != nil {
+			prompt = fmt.Sprintf("Password for %s (empty to use the SSH key only): ", username)
+		}
+		password, err = promptSecret(prompt)
+		if err != nil {
+			return "", nil, err
+		}
+		if password == "" && signer == nil {
+			return "", nil, fmt.Errorf("no password given for %s", username)
+		}
+	}
+	registerSecret(password)
+	return password, signer, nil
+}
+
+// Reads a line from the terminal with echo disabled
+func promptSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// Returns key's value, preferring the contents of the file named by key_file
+func configSecret(configMap map[string]string, key string) (string, error) {
+	path := configMap[key+"_file"]
+	if path == "" {
+		return configMap[key], nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_file %s: %v", key, path, err)
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("%s_file %s is empty", key, path)
+	}
+	return secret, nil
+}
+
+const resolveTimeout = 5 * time.Second
+
+// Entry of the IP list with the address it resolved to and the SSH port given as host:port, if any
+type installTarget struct {
+	entry string
+	ip    string
+	port  string
+	err   error
+}
+
+// Shows the entry as written plus the resolved IP, so logs can be matched against DHCP leases
+func (t installTarget) String() string {
+	if t.ip == "" || t.ip == t.entry || (t.port != "" && net.JoinHostPort(t.ip, t.port) == t.entry) {
+		return t.entry
+	}
+	return fmt.Sprintf("%s (%s)", t.entry, t.ip)
+}
+
+// Resolves every hostname up front; IP addresses are taken as they are. An entry may name its SSH port as
+// host:port, or [address]:port for IPv6.
+func resolveTargets(entries []string) []installTarget {
+	targets := make([]installTarget, 0, len(entries))
+	for _, entry := range entries {
+		target := installTarget{entry: entry}
+		host := entry
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			host, target.port = h, p
+		}
+		if net.ParseIP(host) != nil {
+			target.ip = host
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+			addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+			cancel()
+			switch {
+			case err != nil:
+				target.err = err
+			case len(addrs) == 0:
+				target.err = fmt.Errorf("no addresses")
+			default:
+				target.ip = addrs[0]
+				log.Printf("Resolved %s to %s", host, target.ip)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets
 }
 
 func readIPsFromFile(filename string) ([]string, error) {
@@ -196,7 +584,184 @@ func readIPsFromFile(filename string) ([]string, error) {
 	return ips, nil
 }
 
-func transferFile(client *ssh.Client, data []byte, remotePath string) error {
+// Stall detection for file transfers, set from -stall-timeout and -transfer-retries
+var (
+	stallTimeout    = 60 * time.Second
+	transferRetries = 2
+)
+
+// Size of the writes scpSend makes, so progress moves during large files
+const transferChunkSize = 32 * 1024
+
+var errStalled = errors.New("transfer stalled")
+
+// Progress of the transfer running on a host and the totals of the ones that completed
+type hostTransfer struct {
+	mu       sync.Mutex
+	host     string
+	file     string
+	size     int64
+	sent     int64
+	started  time.Time
+	lastMove time.Time
+	retries  int
+	bytes    int64
+	elapsed  time.Duration
+}
+
+var (
+	transfersMutex sync.Mutex
+	transfers      = make(map[*ssh.Client]*hostTransfer)
+)
+
+// Starts tracking the transfers made over client for host
+func trackTransfers(client *ssh.Client, host string) {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+	transfers[client] = &hostTransfer{host: host}
+}
+
+func transferOf(client *ssh.Client) *hostTransfer {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+	t, ok := transfers[client]
+	if !ok {
+		t = &hostTransfer{host: client.RemoteAddr().String()}
+		transfers[client] = t
+	}
+	return t
+}
+
+func (t *hostTransfer) start(file string, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.file, t.size, t.sent, t.started, t.lastMove = file, int64(size), 0, now, now
+}
+
+func (t *hostTransfer) progress(n int) {
+	if n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent += int64(n)
+	t.lastMove = time.Now()
+}
+
+func (t *hostTransfer) stalled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.lastMove) > stallTimeout
+}
+
+// Ends the running transfer, adding it to the totals when it completed
+func (t *hostTransfer) finish(completed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if completed {
+		t.bytes += t.size
+		t.elapsed += time.Since(t.started)
+	}
+	t.file = ""
+}
+
+// Uses up one of the host's retries, reporting whether one was left
+func (t *hostTransfer) retry() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.retries >= transferRetries {
+		return false
+	}
+	t.retries++
+	return true
+}
+
+// Counts the bytes written through it towards the host's transfer
+type progressWriter struct {
+	w io.Writer
+	t *hostTransfer
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.t.progress(n)
+	return n, err
+}
+
+// Prints the transfers in progress every 10 seconds until stop is closed
+func showProgress(stop <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		transfersMutex.Lock()
+		var lines []string
+		for _, t := range transfers {
+			t.mu.Lock()
+			if t.file != "" && t.size > 0 {
+				rate := float64(t.sent) / time.Since(t.started).Seconds()
+				lines = append(lines, fmt.Sprintf("%s: %s %d%% (%s of %s, %s/s)", t.host, t.file, t.sent*100/t.size,
+					formatBytes(t.sent), formatBytes(t.size), formatBytes(int64(rate))))
+			}
+			t.mu.Unlock()
+		}
+		transfersMutex.Unlock()
+
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+}
+
+// Bytes per second of every host's completed transfers
+func transferThroughput() map[string]int64 {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+	throughput := make(map[string]int64)
+	for _, t := range transfers {
+		t.mu.Lock()
+		if t.bytes > 0 && t.elapsed > 0 {
+			throughput[t.host] = int64(float64(t.bytes) / t.elapsed.Seconds())
+		}
+		t.mu.Unlock()
+	}
+	return throughput
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Copies data to remotePath over scp, starting over when the transfer stalls for -stall-timeout, as long as
+// the host has -transfer-retries left
+func transferFile(client *ssh.Client, data []byte, remotePath string, mode os.FileMode) error {
+	t := transferOf(client)
+	for {
+		err := transferOnce(client, t, data, remotePath, mode)
+		if !errors.Is(err, errStalled) {
+			return err
+		}
+		if !t.retry() {
+			return fmt.Errorf("%v, no transfer retries left", err)
+		}
+		logAndPrint(fmt.Sprintf("Transfer of %s to %s stalled for %s, retrying from the start", remotePath, t.host, stallTimeout))
+	}
+}
+
+func transferOnce(client *ssh.Client, t *hostTransfer, data []byte, remotePath string, mode os.FileMode) error {
 	session, err := client.NewSession()
 	if err != nil {
 		return fmt.Errorf("failed to create session: %v", err)
@@ -205,53 +770,320 @@ func transferFile(client *ssh.Client, data []byte, remotePath string) error {
 
 	var stderr bytes.Buffer
 	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open scp stdin: %v", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open scp stdout: %v", err)
+	}
 
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
+	scpCmd := fmt.Sprintf("/usr/bin/scp -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -t %s", remotePath)
+	logAndPrint(fmt.Sprintf("Running SCP command: %s", scpCmd))
+	if err := session.Start(scpCmd); err != nil {
+		return fmt.Errorf("failed to start scp: %v", err)
+	}
 
-		fmt.Fprintf(w, "C0644 %d %s\n", len(data), filepath.Base(remotePath))
-		w.Write(data)
-		fmt.Fprint(w, "\x00")
+	t.start(remotePath, len(data))
+	completed := false
+	defer func() { t.finish(completed) }()
+
+	// The writer reports the first protocol error; closing stdin tells the remote scp we're done
+	sendErr := make(chan error, 1)
+	go func() {
+		defer stdin.Close()
+		sendErr <- scpSend(progressWriter{stdin, t}, bufio.NewReader(stdout), data, filepath.Base(remotePath), mode)
 	}()
 
-	scpCmd := fmt.Sprintf("/usr/bin/scp -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -t %s", remotePath)
-	logAndPrint(fmt.Sprintf("Running SCP command: %s", scpCmd))
-	if err := session.Run(scpCmd); err != nil {
-		return fmt.Errorf("scp command failed: %v, stderr: %s", err, stderr.String())
+	// Closing the session unblocks a writer waiting on a link that stopped moving
+	stalled := false
+	watchdog := time.NewTicker(time.Second)
+	defer watchdog.Stop()
+	for done := false; !done; {
+		select {
+		case err = <-sendErr:
+			done = true
+		case <-watchdog.C:
+			if !stalled && t.stalled() {
+				stalled = true
+				session.Close()
+			}
+		}
+	}
+
+	waitErr := session.Wait()
+	if stalled {
+		return fmt.Errorf("scp to %s: %w", remotePath, errStalled)
+	}
+	if err != nil {
+		return fmt.Errorf("scp to %s failed: %v, stderr: %s", remotePath, err, stderr.String())
+	}
+	if waitErr != nil {
+		return fmt.Errorf("scp command failed: %v, stderr: %s", waitErr, stderr.String())
+	}
+	completed = true
+	return nil
+}
+
+// Speaks the source side of the scp protocol; the sink acknowledges readiness, the header and the data
+// with 0x00, or rejects them with 0x01/0x02 followed by a message
+func scpSend(w io.Writer, r *bufio.Reader, data []byte, name string, mode os.FileMode) error {
+	if err := readSCPAck(r); err != nil {
+		return fmt.Errorf("remote not ready: %v", err)
+	}
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", mode.Perm(), len(data), name); err != nil {
+		return fmt.Errorf("failed to send header: %v", err)
+	}
+	if err := readSCPAck(r); err != nil {
+		return fmt.Errorf("remote rejected header: %v", err)
 	}
 
+	for sent := 0; sent < len(data); {
+		chunk := data[sent:min(sent+transferChunkSize, len(data))]
+		n, err := w.Write(chunk)
+		sent += n
+		if err == nil && n < len(chunk) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return fmt.Errorf("short write at byte %d of %d: %v", sent, len(data), err)
+		}
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to send end of data: %v", err)
+	}
+	if err := readSCPAck(r); err != nil {
+		return fmt.Errorf("remote rejected data: %v", err)
+	}
 	return nil
 }
 
+// Reads one scp acknowledgement: nil for 0x00, the sink's message for a warning or error
+func readSCPAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("no acknowledgement: %v", err)
+	}
+	if code == 0 {
+		return nil
+	}
+	message, _ := r.ReadString('\n')
+	message = strings.TrimSpace(message)
+	if code != 1 && code != 2 {
+		return fmt.Errorf("unexpected acknowledgement byte 0x%02x", code)
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// Secret values masked in every logged line
+var secrets []string
+
+// Credentials that show up in command lines, URLs and HTTP headers, with the replacement keeping their context
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)((?:password|passwd|token)\s*[=:]\s*)("[^"]*"|\S+)`), "${1}****"},
+	{regexp.MustCompile(`(?i)(Authorization:\s*(?:Basic|Bearer)\s+)\S+`), "${1}****"},
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}****@"},
+	{regexp.MustCompile(`(echo )\S+( \| sudo -S)`), "${1}****${2}"},
+}
+
+func registerSecret(secret string) {
+	if secret != "" {
+		secrets = append(secrets, secret)
+	}
+}
+
+// Masks registered secrets and common credential patterns
+func sanitize(message string) string {
+	for _, secret := range secrets {
+		message = strings.ReplaceAll(message, secret, "****")
+	}
+	for _, p := range secretPatterns {
+		message = p.pattern.ReplaceAllString(message, p.replacement)
+	}
+	return message
+}
+
 func logAndPrint(message string) {
+	message = sanitize(message)
 	log.Print(message)
 	fmt.Println(message)
 }
 
-func connectSSH(host, user, password, port string) (*ssh.Client, error) {
-	const maxRetries = 3
-	var client *ssh.Client
-	var err error
+const (
+	// Unreachable hosts and closed ports fail here instead of after every login attempt
+	portProbeTimeout = 5 * time.Second
+	sshTimeout       = 10 * time.Second
+	loginAttempts    = 3
+	parallelLogins   = 3
+)
 
-	for i := 0; i < maxRetries; i++ {
-		config := &ssh.ClientConfig{
-			User: user,
-			Auth: []ssh.AuthMethod{
-				ssh.Password(password),
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         10 * time.Second,
-		}
-		client, err = ssh.Dial("tcp", host+":"+port, config)
+// SSH login of a device family; several can be configured, see deviceCredentialList
+type credential struct {
+	user     string
+	password string
+	signer   ssh.Signer
+}
+
+// One SSH login attempt, reported in the results
+type connectAttempt struct {
+	User       string `json:"user"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Time spent reaching and installing on a host, reported in the results so slow sites are measurable
+type hostTiming struct {
+	mu        sync.Mutex
+	ProbeMs   int64            `json:"probe_ms"`
+	ConnectMs int64            `json:"connect_ms"`
+	TotalMs   int64            `json:"total_ms"`
+	Attempts  []connectAttempt `json:"attempts"`
+}
+
+func (t *hostTiming) record(attempt connectAttempt) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Attempts = append(t.Attempts, attempt)
+}
+
+// Probes the port, then tries the credentials at most parallelLogins at a time; the first login that succeeds
+// is returned and the others are cancelled
+func connectSSH(ctx context.Context, host, port string, credentials []credential, timing *hostTiming) (*ssh.Client, credential, error) {
+	addr := net.JoinHostPort(host, port)
+	start := time.Now()
+	dialer := net.Dialer{Timeout: portProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	timing.ProbeMs = time.Since(start).Milliseconds()
+	if err != nil {
+		return nil, credential{}, fmt.Errorf("port %s is not reachable: %v", port, err)
+	}
+	conn.Close()
+
+	loginCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type login struct {
+		client     *ssh.Client
+		credential credential
+	}
+	logins := make(chan login, len(credentials))
+	sem := make(chan struct{}, parallelLogins)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lastErr error
+
+	for _, cred := range credentials {
+		wg.Add(1)
+		go func(cred credential) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-loginCtx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			client, err := loginSSH(loginCtx, addr, cred, timing)
+			if err != nil {
+				mu.Lock()
+				lastErr = err
+				mu.Unlock()
+				return
+			}
+			logins <- login{client: client, credential: cred}
+			cancel()
+		}(cred)
+	}
+	go func() {
+		wg.Wait()
+		close(logins)
+	}()
+
+	first, ok := <-logins
+	if !ok {
+		mu.Lock()
+		defer mu.Unlock()
+		return nil, credential{}, fmt.Errorf("every credential failed, last: %v", lastErr)
+	}
+	// The cancelled logins return right away; any that succeeded at the same time as the first aren't needed
+	cancel()
+	for extra := range logins {
+		extra.client.Close()
+	}
+	return first.client, first.credential, nil
+}
+
+// Logs in with one credential, retrying connection problems but not a rejected login. Authenticates with the SSH
+// key when one is configured, then the password.
+func loginSSH(ctx context.Context, addr string, cred credential, timing *hostTiming) (*ssh.Client, error) {
+	var auth []ssh.AuthMethod
+	if cred.signer != nil {
+		auth = append(auth, ssh.PublicKeys(cred.signer))
+	}
+	if cred.password != "" {
+		auth = append(auth, ssh.Password(cred.password))
+	}
+	config := &ssh.ClientConfig{
+		User:            cred.user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sshTimeout,
+	}
+
+	var err error
+	for i := 0; i < loginAttempts; i++ {
+		start := time.Now()
+		var client *ssh.Client
+		client, err = dialSSH(ctx, addr, config)
+		attempt := connectAttempt{User: cred.user, DurationMs: time.Since(start).Milliseconds()}
 		if err == nil {
+			timing.record(attempt)
 			return client, nil
 		}
-		logAndPrint(fmt.Sprintf("SSH connection to %s@%s:%s failed (attempt %d/%d): %v", user, host, port, i+1, maxRetries, err))
-		time.Sleep(2 * time.Second)
+		if ctx.Err() != nil {
+			attempt.Error = "cancelled"
+			timing.record(attempt)
+			return nil, ctx.Err()
+		}
+		attempt.Error = err.Error()
+		timing.record(attempt)
+		logAndPrint(fmt.Sprintf("SSH connection to %s@%s failed (attempt %d/%d): %v", cred.user, addr, i+1, loginAttempts, err))
+
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			break
+		}
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, fmt.Errorf("SSH connection to %s@%s failed: %v", cred.user, addr, err)
+}
+
+// Like ssh.Dial, but the handshake is bounded by the timeout too and aborted when ctx is cancelled
+func dialSSH(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
 	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
-	return nil, fmt.Errorf("SSH connection to %s@%s:%s failed after %d attempts: %v", user, host, port, maxRetries, err)
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
 func checkBuildroot(client *ssh.Client) bool {
@@ -271,43 +1103,80 @@ func checkBuildroot(client *ssh.Client) bool {
 	return strings.Contains(stdout.String(), "Buildroot")
 }
 
-func installBuildroot(client *ssh.Client) error {
+const (
+	buildrootDir    = "/opt/status-updater"
+	buildrootBinary = buildrootDir + "/status-updater"
+	initScriptPath  = "/etc/init.d/status-updater"
+)
+
+// Installs on Buildroot: the binary is uploaded under a temporary name and verified, the running service
+// is stopped, and the binary and init script are renamed into place; a failed start restores the old binary.
+// Returns the differences between the device's config and the uploaded one, also when the install fails later.
+func installBuildroot(client *ssh.Client, host, site string, force bool) (configChanges []string, err error) {
 	files := map[string]string{
-		"status-updater": "/opt/status-updater/status-updater",
-		"cacert.pem":     "/opt/status-updater/cacert.pem",
-		"config":         "/opt/status-updater/config",
+		"cacert.pem": buildrootDir + "/cacert.pem",
+		"config":     buildrootDir + "/config",
 	}
 
-	for localFile := range files {
+	for _, localFile := range []string{"status-updater", "cacert.pem", "config"} {
 		if _, err := os.Stat(localFile); os.IsNotExist(err) {
-			return fmt.Errorf("local file %s does not exist", localFile)
+			return configChanges, fmt.Errorf("local file %s does not exist", localFile)
 		}
 	}
+	binary, err := os.ReadFile("status-updater")
+	if err != nil {
+		return configChanges, fmt.Errorf("failed to read file status-updater: %v", err)
+	}
 
-	for _, remotePath := range files {
-		dir := filepath.Dir(remotePath)
-		session, err := client.NewSession()
-		if err != nil {
-			return fmt.Errorf("failed to create session: %v", err)
-		}
-		err = session.Run(fmt.Sprintf("mkdir -p %s", dir))
-		session.Close()
-		if err != nil {
-			return fmt.Errorf("failed to create directory %s: %v", dir, err)
-		}
+	if _, err := runRemote(client, "mkdir -p "+buildrootDir); err != nil {
+		return configChanges, fmt.Errorf("failed to create directory %s: %v", buildrootDir, err)
 	}
 
 	for localFile, remoteFile := range files {
 		data, err := os.ReadFile(localFile)
 		if err != nil {
-			return fmt.Errorf("failed to read file %s: %v", localFile, err)
+			return configChanges, fmt.Errorf("failed to read file %s: %v", localFile, err)
+		}
+		if localFile == "config" && site != "" {
+			if data, err = setSite(data, site); err != nil {
+				return configChanges, fmt.Errorf("failed to set site in %s: %v", localFile, err)
+			}
 		}
-		err = transferFile(client, data, remoteFile)
+		// The config holds the MQTT and updater credentials
+		mode := os.FileMode(0644)
+		if localFile == "config" {
+			mode = 0600
+			var intended []string
+			if site != "" {
+				intended = append(intended, "site")
+			}
+			if configChanges, err = confirmConfigOverwrite(client, host, remoteFile, data, intended, force); err != nil {
+				return configChanges, err
+			}
+		}
+		err = transferFile(client, data, remoteFile, mode)
 		if err != nil {
-			return fmt.Errorf("failed to transfer file %s: %v", localFile, err)
+			return configChanges, fmt.Errorf("failed to transfer file %s: %v", localFile, err)
+		}
+		// scp keeps the mode of a file it overwrites
+		if _, err := runRemote(client, fmt.Sprintf("chmod %04o %s", mode, remoteFile)); err != nil {
+			return configChanges, fmt.Errorf("failed to set the mode of %s: %v", remoteFile, err)
 		}
 	}
 
+	newBinary := buildrootBinary + ".new"
+	if err := transferFile(client, binary, newBinary, 0755); err != nil {
+		return configChanges, fmt.Errorf("failed to transfer file status-updater: %v", err)
+	}
+	if _, err := runRemote(client, "chmod 0755 "+newBinary); err != nil {
+		cleanupRemote(client, newBinary)
+		return configChanges, fmt.Errorf("failed to make %s executable: %v", newBinary, err)
+	}
+	if err := verifyBinary(client, binary, newBinary); err != nil {
+		cleanupRemote(client, newBinary)
+		return configChanges, err
+	}
+
 	rand.Seed(time.Now().UnixNano())
 	randomDelay := rand.Intn(600)
 
@@ -364,133 +1233,429 @@ case "$1" in
 esac
 exit 0`, randomDelay)
 
-	err := transferFile(client, []byte(initScript), "/etc/init.d/status-updater")
+	newInitScript := initScriptPath + ".new"
+	if err := transferFile(client, []byte(initScript), newInitScript, 0755); err != nil {
+		cleanupRemote(client, newBinary)
+		return configChanges, fmt.Errorf("failed to create init script: %v", err)
+	}
+
+	// Nothing may run from the files while they are replaced
+	if _, err := runRemote(client, fmt.Sprintf("if [ -x %s ]; then %s stop; fi", initScriptPath, initScriptPath)); err != nil {
+		logAndPrint(fmt.Sprintf("Stopping the running service failed, replacing it anyway: %v", err))
+	}
+
+	// rename(2) swaps each file atomically; the hard link keeps the old binary for a rollback
+	swap := fmt.Sprintf("if [ -e %s ]; then ln -f %s %s.old; fi && mv -f %s %s && mv -f %s %s",
+		buildrootBinary, buildrootBinary, buildrootBinary, newBinary, buildrootBinary, newInitScript, initScriptPath)
+	if _, err := runRemote(client, swap); err != nil {
+		cleanupRemote(client, newBinary, newInitScript)
+		return configChanges, fmt.Errorf("failed to move the new files into place: %v", err)
+	}
+
+	if _, err := runRemote(client, "update-rc.d status-updater defaults"); err != nil {
+		return configChanges, fmt.Errorf("failed to enable service: %v", err)
+	}
+
+	if err := startBuildrootService(client); err != nil {
+		rollback := fmt.Sprintf("if [ -e %s.old ]; then mv -f %s.old %s && %s start; fi", buildrootBinary, buildrootBinary, buildrootBinary, initScriptPath)
+		if _, rollbackErr := runRemote(client, rollback); rollbackErr != nil {
+			return configChanges, fmt.Errorf("%v; rolling back the binary failed too: %v", err, rollbackErr)
+		}
+		return configChanges, fmt.Errorf("%v; rolled back to the previous binary", err)
+	}
+
+	cleanupRemote(client, buildrootBinary+".old")
+	return configChanges, nil
+}
+
+// Starts the service and checks that the process is running
+func startBuildrootService(client *ssh.Client) error {
+	if _, err := runRemote(client, initScriptPath+" start"); err != nil {
+		return fmt.Errorf("failed to start service: %v", err)
+	}
+	if _, err := runRemote(client, "ps aux | grep status-updater | grep -v grep"); err != nil {
+		return fmt.Errorf("service verification failed - status-updater might not be running: %v", err)
+	}
+	return nil
+}
+
+// ELF machine names as reported by uname -m on the devices
+var unameMachines = map[elf.Machine][]string{
+	elf.EM_ARM:     {"armv6l", "armv7l", "armv7", "arm"},
+	elf.EM_AARCH64: {"aarch64", "arm64"},
+	elf.EM_386:     {"i386", "i486", "i586", "i686"},
+	elf.EM_X86_64:  {"x86_64", "amd64"},
+}
+
+// Checks that the uploaded binary is complete, built for the device's architecture and reports the version it was
+// built with when run with -version
+func verifyBinary(client *ssh.Client, binary []byte, remotePath string) error {
+	executable, err := elf.NewFile(bytes.NewReader(binary))
 	if err != nil {
-		return fmt.Errorf("failed to create init script: %v", err)
+		return fmt.Errorf("status-updater is not a valid ELF binary: %v", err)
 	}
+	defer executable.Close()
 
-	session, err := client.NewSession()
+	size, err := runRemote(client, "wc -c < "+remotePath)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return fmt.Errorf("failed to check %s: %v", remotePath, err)
+	}
+	if strings.TrimSpace(size) != strconv.Itoa(len(binary)) {
+		return fmt.Errorf("uploaded binary is %s bytes, expected %d", strings.TrimSpace(size), len(binary))
+	}
+
+	machine, err := runRemote(client, "uname -m")
+	if err != nil {
+		return fmt.Errorf("failed to query device architecture: %v", err)
+	}
+	machine = strings.TrimSpace(machine)
+	supported := false
+	for _, name := range unameMachines[executable.Machine] {
+		supported = supported || machine == name
+	}
+	if !supported {
+		return fmt.Errorf("status-updater is built for %v, device is %s", executable.Machine, machine)
 	}
-	defer session.Close()
 
-	err = session.Run("chmod +x /etc/init.d/status-updater")
+	// Binaries built without build information may predate -version, which would start the daemon instead
+	version := embeddedVersion(binary)
+	if version == "" {
+		logAndPrint("status-updater has no embedded version, skipping the -version check")
+		return nil
+	}
+	output, err := runRemote(client, remotePath+" -version")
 	if err != nil {
-		return fmt.Errorf("failed to make init script executable: %v", err)
+		return fmt.Errorf("uploaded binary doesn't run on the device: %v", err)
+	}
+	if reported := reportedVersion(output); reported != version {
+		return fmt.Errorf("uploaded binary reports version %q, expected %q", reported, version)
 	}
+	return nil
+}
 
-	err = session.Run("update-rc.d status-updater defaults")
+// Version set with -X status-updater/buildinfo.Version, read from the -ldflags Go records in the binary; empty when
+// it was built without one
+func embeddedVersion(binary []byte) string {
+	info, err := buildinfo.Read(bytes.NewReader(binary))
 	if err != nil {
-		return fmt.Errorf("failed to enable service: %v", err)
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key != "-ldflags" {
+			continue
+		}
+		for _, field := range strings.Fields(setting.Value) {
+			if version, ok := strings.CutPrefix(field, "status-updater/buildinfo.Version="); ok {
+				return version
+			}
+		}
 	}
+	return ""
+}
+
+// Version in the output of -version, e.g. "version 1.2.3, commit abc1234, built ..."
+func reportedVersion(output string) string {
+	banner, _, _ := strings.Cut(strings.TrimSpace(output), ",")
+	version, _ := strings.CutPrefix(banner, "version ")
+	return version
+}
 
-	session, err = client.NewSession()
+// Runs a command in a new session, returning its output; a failure includes the output
+func runRemote(client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session for service start: %v", err)
+		return "", fmt.Errorf("failed to create session: %v", err)
 	}
 	defer session.Close()
 
-	err = session.Run("/etc/init.d/status-updater start")
+	output, err := session.CombinedOutput(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to start service: %v", err)
+		return string(output), fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
 	}
+	return string(output), nil
+}
 
-	session, err = client.NewSession()
+// Runs a command through sudo in a new session. The password is written to sudo's stdin rather than the command
+// line, so it is safe to quote and doesn't show up in ps; without one, for key-only logins, sudo must not ask for it.
+func runSudo(client *ssh.Client, password, cmd string) (string, error) {
+	session, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session for status check: %v", err)
+		return "", fmt.Errorf("failed to create session: %v", err)
 	}
 	defer session.Close()
 
-	var stdout bytes.Buffer
-	session.Stdout = &stdout
-	err = session.Run("ps aux | grep status-updater | grep -v grep")
+	sudo := "sudo -n "
+	if password != "" {
+		sudo = "sudo -S -p '' "
+		session.Stdin = strings.NewReader(password + "\n")
+	}
+	output, err := session.CombinedOutput(sudo + cmd)
 	if err != nil {
-		return fmt.Errorf("service verification failed - status-updater might not be running: %v", err)
+		err = fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+		if password == "" {
+			err = fmt.Errorf("%v (no password given, key-only logins need passwordless sudo)", err)
+		}
+		return string(output), err
 	}
-
-	return nil
+	return string(output), nil
 }
 
-func installDeb(client *ssh.Client, debData []byte, debFile string, password string, installLldpd bool) error {
-	if installLldpd {
-		zipFile := "lldpd-packages.zip"
-		zipData, err := os.ReadFile(zipFile)
-		if err != nil {
-			return fmt.Errorf("failed to read zip file: %v", err)
-		}
+const (
+	lldpdZipFile = "lldpd-packages.zip"
+	lldpdDir     = "/tmp/lldpd-packages"
+)
 
-		remoteZipFile := "/tmp/" + filepath.Base(zipFile)
-		err = transferFile(client, zipData, remoteZipFile)
-		if err != nil {
-			return fmt.Errorf("failed to transfer zip file: %v", err)
-		}
+// Installs the lldpd packages from the zip; the uploaded zip and unpacked packages are removed
+// in a separate session afterwards, whether or not dpkg succeeded
+func installLldpd(client *ssh.Client, zipData []byte, password string) error {
+	remoteZipFile := "/tmp/" + lldpdZipFile
+	defer cleanupRemote(client, lldpdDir, remoteZipFile)
 
-		session, err := client.NewSession()
-		if err != nil {
-			return fmt.Errorf("failed to create session for zip handling: %v", err)
-		}
-		defer session.Close()
+	if err := transferFile(client, zipData, remoteZipFile, 0644); err != nil {
+		return fmt.Errorf("failed to transfer zip file: %v", err)
+	}
 
-		var stderr bytes.Buffer
-		session.Stderr = &stderr
+	if _, err := runRemote(client, fmt.Sprintf("unzip -o %s -d %s", remoteZipFile, lldpdDir)); err != nil {
+		return fmt.Errorf("failed to unpack lldpd zip: %v", err)
+	}
+	if _, err := runSudo(client, password, fmt.Sprintf("dpkg -i %s/*.deb", lldpdDir)); err != nil {
+		return fmt.Errorf("failed to install lldpd from zip: %v", err)
+	}
+	return nil
+}
 
-		cmd := fmt.Sprintf(`
-			unzip -o %s -d /tmp/lldpd-packages && \
-			echo %s | sudo -S dpkg -i /tmp/lldpd-packages/*.deb && \
-			rm -rf /tmp/lldpd-packages %s
-		`, remoteZipFile, password, remoteZipFile)
+// Removes temporary files from the device, logging rather than returning a failure
+func cleanupRemote(client *ssh.Client, paths ...string) {
+	session, err := client.NewSession()
+	if err != nil {
+		logAndPrint(fmt.Sprintf("Failed to create session for cleanup: %v", err))
+		return
+	}
+	defer session.Close()
 
-		err = session.Run(cmd)
-		if err != nil {
-			return fmt.Errorf("failed to install lldpd from zip: %v, stderr: %s", err, stderr.String())
-		}
+	if output, err := session.CombinedOutput("rm -rf " + strings.Join(paths, " ")); err != nil {
+		logAndPrint(fmt.Sprintf("Failed to remove %s: %v, output: %s", strings.Join(paths, ", "), err, output))
+	}
+}
+
+// Installs the status-updater package, returning the masked config diff of a -set-site rewrite for the results
+// report; an lldpd failure is returned separately as lldpdErr and doesn't stop the status-updater install
+func installDeb(client *ssh.Client, debData []byte, debFile string, password string, lldpdZip []byte, site string) (configChanges []string, lldpdErr error, err error) {
+	if lldpdZip != nil {
+		lldpdErr = installLldpd(client, lldpdZip, password)
 	}
 
 	remoteFile := "/tmp/" + filepath.Base(debFile)
-	err := transferFile(client, debData, remoteFile)
+	err = transferFile(client, debData, remoteFile, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to transfer file: %v", err)
+		return nil, lldpdErr, fmt.Errorf("failed to transfer file: %v", err)
 	}
 
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+	if _, err := runSudo(client, password, "dpkg -i "+remoteFile); err != nil {
+		return nil, lldpdErr, fmt.Errorf("failed to install .deb file: %v", err)
 	}
-	defer session.Close()
 
-	var stderr bytes.Buffer
-	session.Stderr = &stderr
+	if site != "" {
+		if configChanges, err = pushSite(client, debianConfigPath, site, password); err != nil {
+			return configChanges, lldpdErr, err
+		}
+	}
+
+	if _, err := runSudo(client, password, "systemctl start status-updater"); err != nil {
+		return configChanges, lldpdErr, fmt.Errorf("failed to start service: %v", err)
+	}
+	if _, err := runSudo(client, password, "systemctl status status-updater"); err != nil {
+		return configChanges, lldpdErr, fmt.Errorf("service verification failed - status-updater might not be running: %v", err)
+	}
+
+	return configChanges, lldpdErr, nil
+}
+
+const debianConfigPath = "/etc/status-updater/config.json"
 
-	cmd := fmt.Sprintf("echo %s | sudo -S dpkg -i %s", password, remoteFile)
-	err = session.Run(cmd)
+// Sets the top-level site field in a status-updater config, leaving every other key as it was
+func setSite(data []byte, site string) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(site)
 	if err != nil {
-		return fmt.Errorf("failed to install .deb file: %v, stderr: %s", err, stderr.String())
+		return nil, err
 	}
+	config["site"] = value
+	return json.MarshalIndent(config, "", "  ")
+}
 
-	session, err = client.NewSession()
+// Sets the site in a config, returning the new config and its masked diff against the current one
+func siteChange(current []byte, site string) ([]byte, []string, error) {
+	data, err := setSite(current, site)
 	if err != nil {
-		return fmt.Errorf("failed to create session for service start: %v", err)
+		return nil, nil, err
 	}
-	defer session.Close()
+	lines, _, err := configDiff(current, data, []string{"site"})
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, lines, nil
+}
 
-	cmd = fmt.Sprintf("echo %s | sudo -S systemctl start status-updater", password)
-	err = session.Run(cmd)
+// Writes the site into the installed config on a Debian device and has a running service pick it up. Returns the
+// masked diff lines; a config that already has the site is left alone.
+func pushSite(client *ssh.Client, path, site, password string) ([]string, error) {
+	current, err := runSudo(client, password, "cat "+path)
 	if err != nil {
-		return fmt.Errorf("failed to start service: %v, stderr: %s", err, stderr.String())
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	data, lines, err := siteChange([]byte(current), site)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set site in %s: %v", path, err)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	tmpFile := "/tmp/status-updater-config.json"
+	if err := transferFile(client, data, tmpFile, 0600); err != nil {
+		return lines, fmt.Errorf("failed to transfer config: %v", err)
+	}
+	defer cleanupRemote(client, tmpFile)
+	// cp keeps the owner and mode of the existing file
+	if _, err := runSudo(client, password, "cp "+tmpFile+" "+path); err != nil {
+		return lines, fmt.Errorf("failed to update %s: %v", path, err)
 	}
+	if _, err := runSudo(client, password, "systemctl try-reload-or-restart status-updater"); err != nil {
+		return lines, fmt.Errorf("failed to reload status-updater: %v", err)
+	}
+	return lines, nil
+}
 
-	session, err = client.NewSession()
+// Keys whose values are masked in config diffs
+var secretKey = regexp.MustCompile(`(?i)password|token|secret`)
+
+// Serializes confirmation prompts of the concurrent host installs
+var promptMutex sync.Mutex
+
+// Compares the config already on the device with the one about to replace it and logs the differences. When keys
+// other than the intended ones would be removed or changed, asks before overwriting unless force is set. Returns the
+// masked diff lines for the results report.
+func confirmConfigOverwrite(client *ssh.Client, host, remotePath string, data []byte, intended []string, force bool) ([]string, error) {
+	current, err := runRemote(client, "cat "+remotePath)
 	if err != nil {
-		return fmt.Errorf("failed to create session for status check: %v", err)
+		// Nothing to lose on a first install
+		return nil, nil
+	}
+	lines, unintended, err := configDiff([]byte(current), data, intended)
+	if err != nil {
+		logAndPrint(fmt.Sprintf("Can't compare the existing config on %s: %v\n", host, err))
+		unintended = true
+	}
+	if len(lines) == 0 && !unintended {
+		return nil, nil
+	}
+
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+	logAndPrint(fmt.Sprintf("Config changes on %s:", host))
+	for _, line := range lines {
+		logAndPrint("  " + line)
+	}
+	if !unintended {
+		return lines, nil
+	}
+	if force {
+		logAndPrint(fmt.Sprintf("Overwriting the config on %s (-force)", host))
+		return lines, nil
 	}
-	defer session.Close()
 
-	cmd = fmt.Sprintf("echo %s | sudo -S systemctl status status-updater", password)
-	err = session.Run(cmd)
+	fmt.Printf("The config on %s has keys that would be removed or changed. Overwrite it? (y/n): ", host)
+	var answer string
+	fmt.Scanln(&answer)
+	if strings.ToLower(answer) != "y" {
+		return lines, fmt.Errorf("existing config on %s not overwritten, rerun with -force to replace it", host)
+	}
+	log.Printf("Overwrite of the config on %s confirmed", host)
+	return lines, nil
+}
+
+// Key-level differences between two configs, one line per key: "+" added, "-" removed, "~" changed. unintended
+// reports removed or changed keys outside intended.
+func configDiff(current, next []byte, intended []string) (lines []string, unintended bool, err error) {
+	before, err := flattenConfig(current)
 	if err != nil {
-		return fmt.Errorf("service verification failed - status-updater might not be running: %v", err)
+		return nil, false, fmt.Errorf("existing config: %v", err)
+	}
+	after, err := flattenConfig(next)
+	if err != nil {
+		return nil, false, fmt.Errorf("new config: %v", err)
 	}
 
-	return nil
+	isIntended := func(key string) bool {
+		for _, name := range intended {
+			if key == name || strings.HasPrefix(key, name+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		old, hadOld := before[key]
+		value, hasNew := after[key]
+		switch {
+		case !hadOld:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", key, maskValue(key, value)))
+		case !hasNew:
+			lines = append(lines, fmt.Sprintf("- %s: %s", key, maskValue(key, old)))
+			unintended = unintended || !isIntended(key)
+		case old != value:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", key, maskValue(key, old), maskValue(key, value)))
+			unintended = unintended || !isIntended(key)
+		}
+	}
+	return lines, unintended, nil
+}
+
+// Flattens a JSON config into dotted keys and their compact JSON values
+func flattenConfig(data []byte) (map[string]string, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	flat := make(map[string]string)
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		if object, ok := value.(map[string]interface{}); ok && len(object) > 0 {
+			for name, nested := range object {
+				walk(prefix+name+".", nested)
+			}
+			return
+		}
+		encoded, _ := json.Marshal(value)
+		flat[strings.TrimSuffix(prefix, ".")] = string(encoded)
+	}
+	for name, value := range document {
+		walk(name+".", value)
+	}
+	return flat, nil
+}
+
+func maskValue(key, value string) string {
+	if secretKey.MatchString(key) && value != `""` {
+		return "****"
+	}
+	return sanitize(value)
 }
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Most recent ERROR entry of a subsystem
+type ErrorRecord struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Entries per level and the last error per subsystem since startup, for the payload's health summary
+var (
+	statsMutex  sync.Mutex
+	lastErrors  = make(map[string]ErrorRecord)
+	levelCounts = make(map[string]int)
+)
+
+func recordStats(level, subsystem, message string, now time.Time) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	levelCounts[level]++
+	if level == "ERROR" {
+		lastErrors[subsystem] = ErrorRecord{Time: now, Message: message}
+	}
+}
+
+// Returns the most recent error of every subsystem that logged one, keyed by package name
+func LastErrors() map[string]ErrorRecord {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	errors := make(map[string]ErrorRecord, len(lastErrors))
+	for subsystem, record := range lastErrors {
+		errors[subsystem] = record
+	}
+	return errors
+}
+
+// Returns the number of entries logged per level since startup, including filtered and suppressed ones
+func LevelCounts() map[string]int {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	counts := make(map[string]int, len(levelCounts))
+	for level, count := range levelCounts {
+		counts[level] = count
+	}
+	return counts
+}
+
+// Package of the function skip frames up, e.g. "mqtt" for status-updater/mqtt.publishWithRetries
+func subsystemOf(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	if dot := strings.Index(name, "."); dot >= 0 {
+		name = name[:dot]
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"status-updater/config"
+	"time"
+)
+
+const (
+	ringBufferSize       = 500
+	fileFailureThreshold = 3
+	fileRetryInterval    = time.Minute
+)
+
+// In-memory copy of recent entries and fallback state; guarded by logMutex
+var (
+	ringBuffer    = make([]string, 0, ringBufferSize)
+	ringNext      int
+	fileFailures  int
+	degraded      bool
+	lastFileRetry time.Time
+)
+
+// Keeps the most recent entries in memory so they survive a broken log disk
+func recordEntry(logEntry string) {
+	if len(ringBuffer) < ringBufferSize {
+		ringBuffer = append(ringBuffer, logEntry)
+		return
+	}
+	ringBuffer[ringNext] = logEntry
+	ringNext = (ringNext + 1) % ringBufferSize
+}
+
+// Returns up to n of the most recent log entries, oldest first
+func RecentEntries(n int) []string {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	ordered := append(append([]string{}, ringBuffer[ringNext:]...), ringBuffer[:ringNext]...)
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// Reports whether logging has fallen back from the configured file to memory and stderr
+func IsDegraded() bool {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	return degraded
+}
+
+// Mirrors an entry to stderr while degraded, unless console mode already did
+func writeFallback(logEntry string) {
+	if !config.Current().Log.Console {
+		os.Stderr.WriteString(logEntry)
+	}
+}
+
+// Counts consecutive file failures, switching to fallback mode once they persist
+func handleFileFailure(logFile, logEntry string, err error) {
+	fileFailures++
+	if !degraded && fileFailures < fileFailureThreshold {
+		fmt.Printf("ERROR: %v\n", err)
+		return
+	}
+
+	if !degraded {
+		degraded = true
+		fmt.Fprintf(os.Stderr, "ERROR: Log file %s is unwritable (%v), falling back to memory and stderr\n", logFile, err)
+	}
+	lastFileRetry = time.Now()
+	writeFallback(logEntry)
+}
+
+// Leaves fallback mode after a successful write to the configured file
+func handleFileRecovered(logFile string) {
+	fileFailures = 0
+	if !degraded {
+		return
+	}
+	degraded = false
+	note := formatEntry(time.Now().UTC(), "INFO", fmt.Sprintf("Log file %s is writable again, leaving fallback mode", logFile), "logger", "")
+	recordEntry(note)
+	writeLogFile(logFile, note)
+}
@@ -1,58 +1,281 @@
 package logger
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"status-updater/config"
+	"sync"
 	"time"
 )
 
+const (
+	defaultMaxSizeMB = 10
+	defaultMaxFiles  = 3
+)
+
+// Log file handle kept open across messages
+var (
+	logMutex    sync.Mutex
+	logFilePath string
+	logHandle   *os.File
+	logSize     int64
+)
+
 func LogMessage(level string, message string) {
-	logFile := config.Current.Log.File
-	if logFile == "" {
+	logMessage(level, message, "")
+}
+
+// Logs message with the stack of the calling goroutine; for recovered panics, where the stack is worth its size
+func LogMessageWithStack(level string, message string) {
+	logMessage(level, message, string(debug.Stack()))
+}
+
+func logMessage(level, message, stackTrace string) {
+	cfg := config.Current()
+	if cfg.Log.File == "" && !cfg.Log.Syslog {
 		fmt.Printf("ERROR: LOG_FILE is not set in the configuration\n")
 		return
 	}
 
-	configuredLevel := config.Current.Log.Level
+	configuredLevel := cfg.Log.Level
 	if configuredLevel == "" {
 		configuredLevel = "INFO"
 	}
 
+	message = Sanitize(message)
+	recordStats(level, subsystemOf(3), message, time.Now().UTC())
+
 	if config.LogLevels[level] < config.LogLevels[configuredLevel] {
 		return
 	}
 
-	logEntry := fmt.Sprintf("%s [%s] %s\n", time.Now().UTC().Format(time.RFC3339), level, message)
+	caller := callerOf(3)
+	now := time.Now().UTC()
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	for _, summary := range expireRepeats(now, false) {
+		writeEntry(summary.level, summary.message, formatEntry(now, summary.level, summary.message, "logger", ""))
+	}
+	if shouldSuppress(configuredLevel, level, message, now) {
+		return
+	}
+
+	writeEntry(level, message, formatEntry(now, level, message, caller, stackTrace))
+}
+
+// Writes a formatted entry to the destinations its level is routed to; caller holds logMutex
+func writeEntry(level, message, logEntry string) {
+	recordEntry(logEntry)
+
+	route := routeOf(level)
+	if route.console {
+		os.Stderr.WriteString(logEntry)
+	}
+	if route.syslog {
+		writeSyslog(level, message)
+	}
+	if route.event {
+		queueEvent(level, message)
+	}
+	if !route.file {
+		return
+	}
+	logFile := config.Current().Log.File
+
+	// While degraded only retry the file periodically, mirroring to stderr in between
+	if degraded && time.Since(lastFileRetry) < fileRetryInterval {
+		writeFallback(logEntry)
+		return
+	}
+
+	if err := writeLogFile(logFile, logEntry); err != nil {
+		handleFileFailure(logFile, logEntry, err)
+		return
+	}
+	handleFileRecovered(logFile)
+}
+
+// Appends an entry to the log file, rotating it once it exceeds the size limit
+func writeLogFile(logFile, logEntry string) error {
+	if err := ensureLogFile(logFile); err != nil {
+		return err
+	}
+
+	// Log entry write
+	n, err := logHandle.WriteString(logEntry)
+	logSize += int64(n)
+	if err != nil {
+		closeLogFile()
+		return fmt.Errorf("unable to write to log file %s: %v", logFile, err)
+	}
+
+	if logSize >= maxLogSize() {
+		if err := rotateLogFile(); err != nil {
+			fmt.Printf("ERROR: Unable to rotate log file %s: %v\n", logFile, err)
+		}
+	}
+	return nil
+}
+
+// Formats an entry as plain text (default) or a single-line JSON object
+func formatEntry(timestamp time.Time, level, message, caller, stackTrace string) string {
+	if config.Current().Log.Format == "json" {
+		fields := map[string]string{
+			"timestamp": timestamp.Format(time.RFC3339),
+			"level":     level,
+			"message":   message,
+			"caller":    caller,
+		}
+		if stackTrace != "" {
+			fields["stack_trace"] = stackTrace
+		}
+		if entry, err := json.Marshal(fields); err == nil {
+			return string(entry) + "\n"
+		}
+	}
+
+	entry := fmt.Sprintf("%s [%s] %s\n", timestamp.Format(time.RFC3339), level, message)
+	if stackTrace != "" {
+		entry += fmt.Sprintf("\nStack Trace:\n%s\n", stackTrace)
+	}
+	return entry
+}
+
+// Returns file:line of the function that called into the logger
+func callerOf(skip int) string {
+	_, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}
+
+// Closes the open log file handle and syslog connection
+func Close() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	now := time.Now().UTC()
+	for _, summary := range expireRepeats(now, true) {
+		writeEntry(summary.level, summary.message, formatEntry(now, summary.level, summary.message, "logger", ""))
+	}
+	closeLogFile()
+	closeSyslog()
+}
 
-	// ERROR logs include stack trace
-	if level == "ERROR" {
-		stack := make([]byte, 4096)
-		runtime.Stack(stack, false)
-		logEntry += fmt.Sprintf("\nStack Trace:\n%s", stack)
+// Opens the log file, reopening when the configured path changed or the file was removed underneath us
+func ensureLogFile(logFile string) error {
+	if logHandle != nil && logFilePath == logFile {
+		pathInfo, pathErr := os.Stat(logFile)
+		handleInfo, handleErr := logHandle.Stat()
+		if pathErr == nil && handleErr == nil && os.SameFile(pathInfo, handleInfo) {
+			return nil
+		}
 	}
+	closeLogFile()
 
 	// Create log dir if missing
 	logDir := filepath.Dir(logFile)
 	if err := os.MkdirAll(logDir, 0755); err != nil {
-		fmt.Printf("ERROR: Unable to create log directory %s: %v\n", logDir, err)
-		return
+		return fmt.Errorf("unable to create log directory %s: %v", logDir, err)
 	}
 
 	// Append/create log file
 	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Printf("ERROR: Unable to open or create log file %s: %v\n", logFile, err)
-		return
+		return fmt.Errorf("unable to open or create log file %s: %v", logFile, err)
 	}
-	defer file.Close()
 
-	// Log entry write
-	if _, err := file.WriteString(logEntry); err != nil {
-		fmt.Printf("ERROR: Unable to write to log file %s: %v\n", logFile, err)
-		return
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to stat log file %s: %v", logFile, err)
+	}
+
+	logHandle = file
+	logFilePath = logFile
+	logSize = info.Size()
+	return nil
+}
+
+func closeLogFile() {
+	if logHandle != nil {
+		logHandle.Close()
+		logHandle = nil
+	}
+	logFilePath = ""
+	logSize = 0
+}
+
+func maxLogSize() int64 {
+	maxSizeMB := config.Current().Log.MaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	return int64(maxSizeMB) * 1024 * 1024
+}
+
+// Shifts log -> log.1 -> ... -> log.N, dropping the oldest
+func rotateLogFile() error {
+	cfg := config.Current()
+	logFile := logFilePath
+	closeLogFile()
+
+	maxFiles := cfg.Log.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+	compress := cfg.Log.Compress
+
+	rotatedName := func(index int) string {
+		name := fmt.Sprintf("%s.%d", logFile, index)
+		if compress {
+			name += ".gz"
+		}
+		return name
+	}
+
+	os.Remove(rotatedName(maxFiles))
+	for i := maxFiles - 1; i >= 1; i-- {
+		if _, err := os.Stat(rotatedName(i)); err == nil {
+			if err := os.Rename(rotatedName(i), rotatedName(i+1)); err != nil {
+				return err
+			}
+		}
 	}
 
+	if compress {
+		if err := compressFile(logFile, rotatedName(1)); err != nil {
+			return err
+		}
+		return os.Remove(logFile)
+	}
+	return os.Rename(logFile, rotatedName(1))
+}
+
+func compressFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	return gz.Close()
 }
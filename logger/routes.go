@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"context"
+	"status-updater/config"
+	"sync/atomic"
+)
+
+// Entries waiting to be published as events; dropped when the publisher falls behind
+const eventQueueSize = 64
+
+type eventEntry struct {
+	level   string
+	message string
+}
+
+var (
+	eventQueue = make(chan eventEntry, eventQueueSize)
+	// Set while ForwardEvents runs, so entries aren't queued without a reader
+	eventsEnabled atomic.Bool
+	// Set while an entry is being published, so failing to publish it doesn't queue another
+	eventForwarding atomic.Bool
+)
+
+// Set of destinations an entry is written to
+type destinations struct {
+	file    bool
+	console bool
+	syslog  bool
+	event   bool
+}
+
+// Looks up the destinations of level in log.routes, falling back to the file, console and syslog settings
+func routeOf(level string) destinations {
+	cfg := config.Current()
+	route, ok := cfg.Log.Routes[level]
+	if !ok {
+		return destinations{
+			file:    cfg.Log.File != "",
+			console: cfg.Log.Console,
+			syslog:  cfg.Log.Syslog,
+		}
+	}
+
+	var d destinations
+	for _, name := range route {
+		switch name {
+		case "file":
+			d.file = cfg.Log.File != ""
+		case "console":
+			d.console = true
+		case "syslog":
+			d.syslog = true
+		case "event":
+			d.event = true
+		}
+	}
+	return d
+}
+
+// Passes entries routed to "event" to publish until ctx is done
+func ForwardEvents(ctx context.Context, publish func(level, message string)) {
+	eventsEnabled.Store(true)
+	defer eventsEnabled.Store(false)
+
+	for {
+		select {
+		case entry := <-eventQueue:
+			eventForwarding.Store(true)
+			publish(entry.level, entry.message)
+			eventForwarding.Store(false)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Queues an entry for ForwardEvents; entries logged while one is being published are not forwarded
+func queueEvent(level, message string) {
+	if !eventsEnabled.Load() || eventForwarding.Load() {
+		return
+	}
+	select {
+	case eventQueue <- eventEntry{level: level, message: message}:
+	default:
+	}
+}
@@ -0,0 +1,178 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"strings"
+	"testing"
+)
+
+// Puts a config logging everything to a file in a temporary directory in effect, closing the file and restoring
+// the previous config afterwards
+func useLogFile(t *testing.T, routes config.Routes) string {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Log.File = filepath.Join(t.TempDir(), "status-updater.log")
+	cfg.Log.Level = "DEBUG"
+	cfg.Log.Routes = routes
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() {
+		Close()
+		config.Set(previous)
+	})
+	return cfg.Log.File
+}
+
+func TestRouteOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		console bool
+		syslog  bool
+		routes  config.Routes
+		want    map[string]destinations
+	}{
+		{
+			name:    "no routes",
+			file:    "/var/log/status-updater.log",
+			console: true,
+			want: map[string]destinations{
+				"DEBUG": {file: true, console: true},
+				"INFO":  {file: true, console: true},
+				"WARN":  {file: true, console: true},
+				"ERROR": {file: true, console: true},
+			},
+		},
+		{
+			name:   "no routes without a file",
+			syslog: true,
+			want: map[string]destinations{
+				"DEBUG": {syslog: true},
+				"ERROR": {syslog: true},
+			},
+		},
+		{
+			name: "routed levels",
+			file: "/var/log/status-updater.log",
+			routes: config.Routes{
+				"DEBUG": {"console"},
+				"WARN":  {"file", "syslog"},
+				"ERROR": {"file", "console", "syslog", "event"},
+			},
+			want: map[string]destinations{
+				"DEBUG": {console: true},
+				"INFO":  {file: true},
+				"WARN":  {file: true, syslog: true},
+				"ERROR": {file: true, console: true, syslog: true, event: true},
+			},
+		},
+		{
+			name:   "routed to the file without a file",
+			syslog: true,
+			routes: config.Routes{
+				"ERROR": {"file", "event"},
+			},
+			want: map[string]destinations{
+				"INFO":  {syslog: true},
+				"ERROR": {event: true},
+			},
+		},
+		{
+			name: "routed nowhere",
+			file: "/var/log/status-updater.log",
+			routes: config.Routes{
+				"DEBUG": {},
+			},
+			want: map[string]destinations{
+				"DEBUG": {},
+				"INFO":  {file: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Log.File = tt.file
+			cfg.Log.Console = tt.console
+			cfg.Log.Syslog = tt.syslog
+			cfg.Log.Routes = tt.routes
+			previous := config.Current()
+			config.Set(cfg)
+			t.Cleanup(func() { config.Set(previous) })
+
+			for level, want := range tt.want {
+				if got := routeOf(level); got != want {
+					t.Errorf("routeOf(%q) = %+v, want %+v", level, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventRoute(t *testing.T) {
+	logFile := useLogFile(t, config.Routes{"WARN": {"event"}})
+	eventsEnabled.Store(true)
+	t.Cleanup(func() {
+		eventsEnabled.Store(false)
+		for len(eventQueue) > 0 {
+			<-eventQueue
+		}
+	})
+
+	LogMessage("WARN", "Battery voltage low")
+	LogMessage("INFO", "Status published")
+
+	select {
+	case entry := <-eventQueue:
+		if entry.level != "WARN" || entry.message != "Battery voltage low" {
+			t.Errorf("queued %+v, want the WARN entry", entry)
+		}
+	default:
+		t.Fatal("WARN entry routed to event was not queued")
+	}
+	if len(eventQueue) != 0 {
+		t.Errorf("%d more entries queued, want only the WARN entry", len(eventQueue))
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Battery voltage low") {
+		t.Error("WARN entry routed only to event was written to the file")
+	}
+	if !strings.Contains(string(data), "Status published") {
+		t.Error("INFO entry without a route was not written to the file")
+	}
+}
+
+func TestErrorEntryStack(t *testing.T) {
+	logFile := useLogFile(t, nil)
+
+	LogMessage("ERROR", "Unable to publish status")
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := string(data)
+	if strings.Contains(entry, "Stack Trace") || strings.Contains(entry, "goroutine") {
+		t.Errorf("ERROR entry carries a stack trace:\n%s", entry)
+	}
+	// Timestamp, level and message on a single line
+	if lines := strings.Count(entry, "\n"); lines != 1 || len(entry) > 128 {
+		t.Errorf("ERROR entry is %d bytes over %d lines, want a single short line:\n%s", len(entry), lines, entry)
+	}
+
+	LogMessageWithStack("ERROR", "Recovered from panic")
+	data, err = os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withStack := strings.TrimPrefix(string(data), entry)
+	if !strings.Contains(withStack, "Stack Trace:") || !strings.Contains(withStack, "goroutine") {
+		t.Errorf("LogMessageWithStack entry has no stack trace:\n%s", withStack)
+	}
+}
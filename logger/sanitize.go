@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"regexp"
+	"status-updater/config"
+	"strings"
+)
+
+const redacted = "****"
+
+// Credentials that show up in command lines, URLs and HTTP headers, with the replacement keeping their context
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)((?:password|passwd|token)\s*[=:]\s*)("[^"]*"|\S+)`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)(Authorization:\s*(?:Basic|Bearer)\s+)\S+`), "${1}" + redacted},
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}" + redacted + "@"},
+}
+
+// Configured secret values, read on every call so a reload is picked up
+func configuredSecrets() []string {
+	cfg := config.Current()
+	return []string{
+		cfg.MQTT.Password,
+		cfg.Fallback.Token,
+		cfg.UpdaterService.Password,
+	}
+}
+
+// Masks configured secret values and common credential patterns in a log message
+func Sanitize(message string) string {
+	for _, secret := range configuredSecrets() {
+		if secret != "" {
+			message = strings.ReplaceAll(message, secret, redacted)
+		}
+	}
+	for _, p := range secretPatterns {
+		message = p.pattern.ReplaceAllString(message, p.replacement)
+	}
+	return message
+}
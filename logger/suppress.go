@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"status-updater/config"
+	"time"
+)
+
+const defaultSuppressWindow = 300 * time.Second
+
+var digitsPattern = regexp.MustCompile(`\d+`)
+
+// Occurrences of a (level, message template) pair within the current window
+type repeatState struct {
+	level       string
+	lastMessage string
+	windowStart time.Time
+	suppressed  int
+}
+
+// Guarded by logMutex
+var repeats = make(map[string]*repeatState)
+
+func suppressWindow() time.Duration {
+	window := config.Current().Log.SuppressWindow.Duration()
+	if window < 0 {
+		return 0
+	}
+	if window == 0 {
+		return defaultSuppressWindow
+	}
+	return window
+}
+
+// Reports whether an entry repeats one already logged within the window; disabled entirely at DEBUG level
+func shouldSuppress(configuredLevel, level, message string, now time.Time) bool {
+	window := suppressWindow()
+	if window == 0 || configuredLevel == "DEBUG" {
+		return false
+	}
+	if level == "ERROR" && !config.Current().Log.SuppressErrors {
+		return false
+	}
+
+	// Numbers are masked so "attempt 1/3" and "attempt 2/3" count as the same template
+	key := level + "|" + digitsPattern.ReplaceAllString(message, "#")
+	state, ok := repeats[key]
+	if ok && now.Sub(state.windowStart) < window {
+		state.suppressed++
+		state.lastMessage = message
+		return true
+	}
+
+	repeats[key] = &repeatState{level: level, lastMessage: message, windowStart: now}
+	return false
+}
+
+// Collapsed entry emitted once a window that suppressed repeats expires
+type repeatSummary struct {
+	level   string
+	message string
+}
+
+// Drops expired windows, returning "repeated N times" summaries for those that suppressed entries
+func expireRepeats(now time.Time, flushAll bool) []repeatSummary {
+	window := suppressWindow()
+	var keys []string
+	for key, state := range repeats {
+		if flushAll || now.Sub(state.windowStart) >= window {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var summaries []repeatSummary
+	for _, key := range keys {
+		state := repeats[key]
+		if state.suppressed > 0 {
+			summaries = append(summaries, repeatSummary{
+				level:   state.level,
+				message: fmt.Sprintf("Last message repeated %d times: %s", state.suppressed, state.lastMessage),
+			})
+		}
+		delete(repeats, key)
+	}
+	return summaries
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"log/syslog"
+	"time"
+)
+
+const syslogRetryInterval = time.Minute
+
+// Syslog connection, lazily dialed; absent on Buildroot images without a syslog daemon
+var (
+	syslogWriter     *syslog.Writer
+	syslogLastFailed time.Time
+)
+
+// Sends an entry to the local syslog socket (journald on Debian), silently dropping it when unavailable
+func writeSyslog(level, message string) {
+	if syslogWriter == nil {
+		if !syslogLastFailed.IsZero() && time.Since(syslogLastFailed) < syslogRetryInterval {
+			return
+		}
+		writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "status-updater")
+		if err != nil {
+			syslogLastFailed = time.Now()
+			return
+		}
+		syslogWriter = writer
+	}
+
+	var err error
+	switch level {
+	case "DEBUG":
+		err = syslogWriter.Debug(message)
+	case "WARN":
+		err = syslogWriter.Warning(message)
+	case "ERROR":
+		err = syslogWriter.Err(message)
+	default:
+		err = syslogWriter.Info(message)
+	}
+
+	if err != nil {
+		closeSyslog()
+		syslogLastFailed = time.Now()
+	}
+}
+
+func closeSyslog() {
+	if syslogWriter != nil {
+		syslogWriter.Close()
+		syslogWriter = nil
+	}
+}
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"status-updater/config"
+	"strings"
+)
+
+const tailBlockSize = 64 * 1024
+
+// Returns up to n of the most recent log lines, oldest first: from the configured log file,
+// or from the in-memory ring buffer when file logging is degraded or disabled
+func Tail(n int) ([]string, error) {
+	logMutex.Lock()
+	file, fallback := config.Current().Log.File, degraded
+	logMutex.Unlock()
+
+	if file == "" || fallback {
+		var lines []string
+		for _, entry := range RecentEntries(0) {
+			lines = append(lines, strings.Split(strings.TrimRight(entry, "\n"), "\n")...)
+		}
+		if len(lines) > n {
+			lines = lines[len(lines)-n:]
+		}
+		return lines, nil
+	}
+	return tailFile(file, n)
+}
+
+// Reads the file backwards in blocks until it holds more than n lines
+func tailFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := info.Size()
+	var data []byte
+	for offset > 0 && bytes.Count(data, []byte("\n")) <= n {
+		size := int64(tailBlockSize)
+		if size > offset {
+			size = offset
+		}
+		offset -= size
+		block := make([]byte, size)
+		if _, err := f.ReadAt(block, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(block, data...)
+	}
+
+	text := strings.TrimRight(string(data), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
@@ -0,0 +1,83 @@
+package logwatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE
+
+// Wakes the tailer when something changes in a watched file's directory
+type watcher struct {
+	file    *os.File
+	fd      int
+	dirs    map[string]bool
+	watched map[string]bool
+	buf     []byte
+}
+
+func newWatcher(dirs map[string]bool) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %v", err)
+	}
+
+	w := &watcher{
+		// Non-blocking, so reads go through the runtime poller and honor deadlines
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		dirs:    dirs,
+		watched: make(map[string]bool),
+		buf:     make([]byte, 4096),
+	}
+	w.addWatches()
+	return w, nil
+}
+
+// Directories that don't exist yet are retried on every wait
+func (w *watcher) addWatches() {
+	for dir := range w.dirs {
+		if w.watched[dir] {
+			continue
+		}
+		if _, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask); err == nil {
+			w.watched[dir] = true
+		}
+	}
+}
+
+// Blocks until a change event, the timeout or cancellation, then drains pending events
+func (w *watcher) wait(ctx context.Context, timeout time.Duration) {
+	w.addWatches()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		// Short slices so cancellation is noticed promptly
+		slice := time.Now().Add(time.Second)
+		if slice.After(deadline) {
+			slice = deadline
+		}
+		w.file.SetReadDeadline(slice)
+		if n, err := w.file.Read(w.buf); err == nil && n > 0 {
+			// Coalesce bursts of writes into one wakeup
+			time.Sleep(100 * time.Millisecond)
+			w.file.SetReadDeadline(time.Now())
+			for {
+				if _, err := w.file.Read(w.buf); err != nil {
+					break
+				}
+			}
+			return
+		}
+	}
+}
+
+func (w *watcher) close() {
+	w.file.Close()
+}
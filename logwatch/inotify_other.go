@@ -0,0 +1,20 @@
+//go:build !linux
+
+package logwatch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type watcher struct{}
+
+// inotify is Linux-only; other platforms poll
+func newWatcher(dirs map[string]bool) (*watcher, error) {
+	return nil, errors.New("inotify is not supported on this platform")
+}
+
+func (w *watcher) wait(ctx context.Context, timeout time.Duration) {}
+
+func (w *watcher) close() {}
@@ -0,0 +1,277 @@
+package logwatch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Upper bound on memory per watched file: one read chunk plus one partial line
+	readChunkSize = 64 * 1024
+	maxLineLength = 4 * 1024
+
+	// Files are re-checked at least this often, e.g. to pick up files that didn't exist yet
+	rescanInterval = 10 * time.Second
+	pollInterval   = 2 * time.Second
+
+	maxDetailLength = 200
+)
+
+// A threshold crossing for one watched pattern within the current interval
+type Match struct {
+	Label     string
+	Count     int
+	Threshold int
+	LastLine  string
+}
+
+// Follows one log file from its end, surviving rotation and truncation
+type tailer struct {
+	path  string
+	re    *regexp.Regexp
+	label string
+
+	file    *os.File
+	inode   uint64
+	offset  int64
+	partial []byte
+	missing bool
+}
+
+var (
+	countsMutex sync.Mutex
+	counts      = make(map[string]int)
+	lastLines   = make(map[string]string)
+	notified    = make(map[string]bool)
+)
+
+// Reports whether any log_watch.files entry is configured
+func Enabled() bool {
+	return len(config.Current().LogWatch.Files) > 0
+}
+
+// Tails every configured file until ctx is cancelled, calling onMatch once per interval when a label's count reaches log_watch.event_threshold
+func Run(ctx context.Context, onMatch func(Match)) {
+	var tailers []*tailer
+	dirs := make(map[string]bool)
+	for _, entry := range config.Current().LogWatch.Files {
+		re, err := regexp.Compile(entry.Regex)
+		if err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Invalid log_watch regex %q for %s: %s", entry.Regex, entry.Path, err))
+			continue
+		}
+		label := entry.Label
+		if label == "" {
+			label = filepath.Base(entry.Path)
+		}
+		t := &tailer{path: entry.Path, re: re, label: label}
+		t.open(true)
+		tailers = append(tailers, t)
+		dirs[filepath.Dir(entry.Path)] = true
+	}
+	if len(tailers) == 0 {
+		return
+	}
+	defer func() {
+		for _, t := range tailers {
+			t.close()
+		}
+	}()
+
+	w, err := newWatcher(dirs)
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("inotify unavailable (%v), polling watched log files", err))
+	} else {
+		defer w.close()
+	}
+
+	for {
+		if w != nil {
+			w.wait(ctx, rescanInterval)
+		} else {
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		for _, t := range tailers {
+			t.poll()
+			if match, ok := checkThreshold(t.label); ok {
+				onMatch(match)
+			}
+		}
+	}
+}
+
+// Returns the match counts since the previous call and starts a new interval
+func TakeCounts() map[string]int {
+	countsMutex.Lock()
+	defer countsMutex.Unlock()
+
+	taken := counts
+	counts = make(map[string]int)
+	notified = make(map[string]bool)
+	return taken
+}
+
+func checkThreshold(label string) (Match, bool) {
+	threshold := config.Current().LogWatch.EventThreshold
+	countsMutex.Lock()
+	defer countsMutex.Unlock()
+
+	if threshold <= 0 || notified[label] || counts[label] < threshold {
+		return Match{}, false
+	}
+	notified[label] = true
+	return Match{Label: label, Count: counts[label], Threshold: threshold, LastLine: lastLines[label]}, true
+}
+
+// Opens the file, starting at its end on the first open so old entries aren't counted
+func (t *tailer) open(atEnd bool) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		if !t.missing {
+			logger.LogMessage("DEBUG", fmt.Sprintf("Watched log file %s not available, will retry: %s", t.path, err))
+			t.missing = true
+		}
+		return
+	}
+	t.missing = false
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	t.file = file
+	t.inode = inodeOf(info)
+	t.offset = 0
+	t.partial = t.partial[:0]
+	if atEnd {
+		t.offset = info.Size()
+	}
+}
+
+func (t *tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// Reads whatever was appended since the last poll, reopening after rotation or truncation
+func (t *tailer) poll() {
+	if t.file == nil {
+		// A file that appears later is read from the start
+		t.open(false)
+		if t.file == nil {
+			return
+		}
+	}
+
+	if info, err := os.Stat(t.path); err != nil || inodeOf(info) != t.inode {
+		// Rotated away: finish the old file, then continue with the new one from the start
+		t.read()
+		t.close()
+		t.open(false)
+		if t.file == nil {
+			return
+		}
+	} else if info.Size() < t.offset {
+		// Truncated in place
+		t.offset = 0
+		t.partial = t.partial[:0]
+	}
+
+	t.read()
+}
+
+func (t *tailer) read() {
+	buf := make([]byte, readChunkSize)
+	for {
+		n, err := t.file.ReadAt(buf, t.offset)
+		if n > 0 {
+			t.offset += int64(n)
+			t.consume(buf[:n])
+		}
+		if err != nil || n == 0 {
+			if err != nil && err != io.EOF {
+				logger.LogMessage("WARN", fmt.Sprintf("Failed to read watched log file %s: %s", t.path, err))
+			}
+			return
+		}
+	}
+}
+
+// Splits data into lines, truncating lines longer than maxLineLength
+func (t *tailer) consume(data []byte) {
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			t.appendPartial(data)
+			return
+		}
+		t.appendPartial(data[:i])
+		t.match(string(t.partial))
+		t.partial = t.partial[:0]
+		data = data[i+1:]
+	}
+}
+
+func (t *tailer) appendPartial(data []byte) {
+	if room := maxLineLength - len(t.partial); room > 0 {
+		if len(data) > room {
+			data = data[:room]
+		}
+		t.partial = append(t.partial, data...)
+	}
+}
+
+func (t *tailer) match(line string) {
+	if !t.re.MatchString(line) {
+		return
+	}
+	countsMutex.Lock()
+	counts[t.label]++
+	lastLines[t.label] = sanitize(line)
+	countsMutex.Unlock()
+}
+
+// Keeps printable characters only and truncates for inclusion in an event
+func sanitize(line string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, line)
+	if runes := []rune(clean); len(runes) > maxDetailLength {
+		clean = string(runes[:maxDetailLength]) + "..."
+	}
+	return clean
+}
+
+func inodeOf(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
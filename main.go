@@ -3,42 +3,119 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
 	"os"
-	"reflect"
+	"status-updater/backoff"
+	"status-updater/boot"
+	"status-updater/capabilities"
+	"status-updater/commands"
 	"status-updater/config"
+	"status-updater/connectivity"
+	"status-updater/duplicate"
+	"status-updater/events"
+	"status-updater/fallback"
 	"status-updater/gatherer"
+	"status-updater/health"
 	"status-updater/helpers"
+	"status-updater/history"
 	"status-updater/initialize"
 	"status-updater/logger"
+	"status-updater/logwatch"
+	"status-updater/maintenance"
+	"status-updater/metrics"
+	"status-updater/modemwatch"
 	"status-updater/mqtt"
+	"status-updater/remoteconfig"
+	"status-updater/servicewatch"
+	"status-updater/state"
+	"status-updater/status"
 	"status-updater/system"
 	"status-updater/updater"
-	"strconv"
+	"status-updater/usage"
+	"status-updater/wifiwatch"
 	"sync"
 	"time"
 )
 
+const (
+	offlinePublishTimeout = 3 * time.Second
+
+	// Backoff for the first status after startup, retried until it is out whatever the initial delay
+	startupRetryMin = 15 * time.Second
+	startupRetryMax = 5 * time.Minute
+)
+
 var (
-	messageBuffer map[string]interface{}
+	// Last published value of each field, JSON-encoded so it compares equal after a reload from disk
+	messageBuffer status.Fields
 	bufferMutex   sync.RWMutex
+
+	// When each buffered field was last published, for payload.field_ttl
+	publishedAt = make(map[string]time.Time)
+
+	// Full payload is published without persisted state, after publish failures and every full_sync_interval
+	forceFullSync = true
+	lastFullSync  time.Time
+
+	// Sequence numbers of status messages, the reserved block persisted with the buffer
+	sequence = status.NewSequence(0)
+
+	// Consecutive cycles with RSS above self.rss_ceiling_mb
+	rssOverCycles int
+
+	// Clock state of the previous cycle, to notice the clock being fixed; the wall-clock reading is kept apart
+	// from the monotonic one in lastCycleTime
+	lastCycleTime      time.Time
+	lastCycleWall      time.Time
+	lastDateUnreliable bool
 )
 
+// How long the status topic is watched for another device's messages after startup
+const duplicateCheckWindow = 15 * time.Minute
+
+// Wall-clock jumps beyond this between two cycles count as the clock being corrected
+const clockJumpThreshold = time.Minute
+
+// How often the health state is checked for transitions
+const healthCheckInterval = 15 * time.Second
+
 func main() {
 	defer system.RecoverFromPanic()
-	if err := initialize.LoadConfig(); err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to load configuration: %v", err))
+
+	configPath := flag.String("config", "", "path to config.json")
+	showVersion := flag.Bool("version", false, "print build information and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(helpers.BuildInfo())
+		return
 	}
 
-	// LOG_FILE validation
-	if config.Current.Log.File == "" {
-		logger.LogMessage("ERROR", "LOG_FILE is not set in the configuration")
-	} else {
-		logger.LogMessage("INFO", fmt.Sprintf("LOG_FILE is set to: %s", config.Current.Log.File))
+	if err := initialize.LoadConfig(*configPath); err != nil {
+		var validationErr *config.ValidationError
+		if !errors.As(err, &validationErr) {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			os.Exit(1)
+		}
+		for _, problem := range validationErr.Fatal {
+			logger.LogMessage("ERROR", fmt.Sprintf("Configuration error: %s", problem))
+		}
+		for _, problem := range validationErr.Warnings {
+			logger.LogMessage("WARN", fmt.Sprintf("Configuration warning: %s", problem))
+		}
+		if validationErr.IsFatal() {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
 	}
+	logger.LogMessage("INFO", fmt.Sprintf("Loaded configuration from %s", config.Path))
+	logger.LogMessage("INFO", fmt.Sprintf("LOG_FILE is set to: %s", config.Current().Log.File))
 
-	logger.LogMessage("INFO", "Status Updater started")
+	logger.LogMessage("INFO", fmt.Sprintf("Status Updater started: %s", helpers.BuildInfo()))
+	initialize.CheckCertificateExpiry()
 
 	deviceType, err := gatherer.GetDeviceType()
 	if err != nil {
@@ -46,243 +123,922 @@ func main() {
 	}
 	logger.LogMessage("INFO", fmt.Sprintf("Device type: %s", deviceType))
 
-	// Default sleep interval: 300s
-	sleepIntervalStr := fmt.Sprintf("%d", config.Current.SleepInterval)
+	logger.LogMessage("INFO", fmt.Sprintf("Status topic: %s", mqtt.StatusTopic(gatherer.GetDeviceID(), deviceType)))
+
+	// Offline status on the way down so the backend doesn't wait for staleness timers
+	system.RegisterComponent("offline status", offlinePublishTimeout+time.Second, func(reason string) {
+		bufferMutex.Lock()
+		metadata, err := nextMetadata(helpers.GetUpdaterVersion())
+		bufferMutex.Unlock()
+		if err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to build offline status: %s", err))
+			return
+		}
+		message, err := json.Marshal(map[string]interface{}{
+			"status":         "Offline",
+			"date":           time.Now().UTC().Format(time.RFC3339),
+			"deviceID":       gatherer.GetDeviceID(),
+			"reason":         reason,
+			"schema_version": metadata.SchemaVersion,
+			"seq":            metadata.Seq,
+			"msg_id":         metadata.MsgID,
+		})
+		if err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal offline status: %s", err))
+			return
+		}
+		topic := mqtt.StatusTopic(gatherer.GetDeviceID(), deviceType)
+		if err := mqtt.PublishMQTTMessageWithTimeout(topic, string(message), offlinePublishTimeout); err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to publish offline status: %s", err))
+		}
+	})
+
+	sleepInterval := config.Current().SleepInterval
+	logger.LogMessage("INFO", fmt.Sprintf("Sleep interval: %s", sleepInterval))
 
-	logger.LogMessage("INFO", fmt.Sprintf("Sleep interval: %s", sleepIntervalStr))
-	if sleepIntervalStr == "" {
-		logger.LogMessage("ERROR", "Sleep interval is not set in the configuration")
-		sleepIntervalStr = "300"
+	if err := state.EnsureDir(); err != nil {
+		logger.LogMessage("ERROR", err.Error())
 	}
-	sleepInterval, err := strconv.Atoi(sleepIntervalStr)
-	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Invalid Sleep interval in config: %s", err))
-		sleepInterval = 300
+	// Features needing root or sudo are switched off when running as an unprivileged user
+	capabilities.Detect(helpers.IsBuildroot())
+	maintenance.Load()
+
+	// How the previous run ended; anything but a clean stop is also raised as an event
+	bootReport := boot.Classify()
+	logger.LogMessage("INFO", fmt.Sprintf("Previous run ended: %s (rebooted: %t)", bootReport.Reason, bootReport.Rebooted))
+	system.RegisterComponent("clean shutdown marker", system.DefaultComponentGrace, func(reason string) {
+		if reason != "panic" {
+			boot.MarkCleanShutdown()
+		}
+	})
+	// Whether an update installed before the restart actually came up
+	updater.ConfirmPending()
+
+	if bootReport.Reason != boot.ReasonClean && bootReport.Reason != boot.ReasonUnknown {
+		go publishEvent(deviceType, events.Event{
+			Type:     "unexpected_stop",
+			State:    events.StateActive,
+			Value:    bootReport.Reason,
+			Date:     time.Now().UTC().Format(time.RFC3339),
+			DeviceID: gatherer.GetDeviceID(),
+		})
+	}
+
+	// Restore the message buffer from the previous run, falling back to a full publish.
+	// The sequence number is kept even when the buffer is invalidated.
+	messageBuffer = make(status.Fields)
+	if saved := state.Load(); saved != nil {
+		sequence = status.NewSequence(saved.Seq)
+		if saved.Matches(status.SchemaVersion, helpers.GetUpdaterVersion()) {
+			messageBuffer = saved.Buffer
+			if saved.PublishedAt != nil {
+				publishedAt = saved.PublishedAt
+			}
+			lastFullSync = saved.LastFullSync
+			forceFullSync = false
+			logger.LogMessage("INFO", fmt.Sprintf("Restored %d buffered fields from %s", len(messageBuffer), state.FilePath()))
+		}
 	}
+	duplicate.Start(sequence.Reserved())
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
+	system.SetRoot(cancel)
+	system.RegisterComponent("state", system.DefaultComponentGrace, func(reason string) {
+		bufferMutex.Lock()
+		saveState(helpers.GetUpdaterVersion())
+		bufferMutex.Unlock()
+		history.Save()
+		connectivity.Save()
+	})
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		system.MonitorNetworkChanges(ctx)
-	}()
-
-	// Initialize message buffer
-	messageBuffer = make(map[string]interface{})
-
-	// Status update with retries
-	sendStatusUpdate := func() {
-		maxRetries := 3
-		retryDelay := time.Second * 180
+	// Status updates run on a single worker; triggers arriving while one is pending are coalesced
+	updateTriggers := make(chan string, 1)
+	requestStatusUpdate := func(source string) {
+		select {
+		case updateTriggers <- source:
+		default:
+			logger.LogMessage("INFO", fmt.Sprintf("Status update already pending, skipping %s trigger", source))
+		}
+	}
 
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			logger.LogMessage("DEBUG", fmt.Sprintf("Starting status update (attempt %d/%d)...", attempt, maxRetries))
+	system.Go(ctx, "status worker", func(ctx context.Context) {
+		published := false
+		retryScheduled := false
+		retryDelay := startupRetryMin
+		for {
+			select {
+			case source := <-updateTriggers:
+				logger.LogMessage("DEBUG", fmt.Sprintf("Status update triggered by %s", source))
+				if source == "startup retry" {
+					retryScheduled = false
+				}
+				if published {
+					sendStatusUpdate(ctx, deviceType, 3)
+					continue
+				}
 
-			if !helpers.IsInternetAvailable() {
-				logger.LogMessage("WARN", fmt.Sprintf("No internet connection (attempt %d/%d), waiting %v before retry",
-					attempt, maxRetries, retryDelay))
-				if attempt < maxRetries {
-					time.Sleep(retryDelay)
+				// A single attempt each, so a failed first status doesn't wait out publish_retry_delay or the initial delay
+				if sendStatusUpdate(ctx, deviceType, 1) == nil {
+					published = true
+					continue
+				}
+				if retryScheduled || ctx.Err() != nil {
 					continue
 				}
+				logger.LogMessage("INFO", fmt.Sprintf("First status not published yet, retrying in %v", retryDelay))
+				retryScheduled = true
+				time.AfterFunc(retryDelay, func() { requestStatusUpdate("startup retry") })
+				retryDelay *= 2
+				if retryDelay > startupRetryMax {
+					retryDelay = startupRetryMax
+				}
+			case <-ctx.Done():
 				return
 			}
+		}
+	})
 
-			// Panic recovery wrapper
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						logger.LogMessage("ERROR", fmt.Sprintf("Recovered from panic in status update: %v", r))
-					}
-				}()
-
-				ipAddress := gatherer.GetIPAddresses()
-				macAddress := gatherer.GetMACAddresses()
-				modemDetails := gatherer.GetModemDetails()
-				temperature := gatherer.GetTemperature()
-				switchName, switchIP, switchPort, switchMacAddress, switchPortVlan, switchSysDescription, switchPortDescription := gatherer.GetLLDPDetails()
-
-				// WLAN interface check
-				var ssid, apMAC string
-				if helpers.HasActiveWLANInterface() {
-					ssid = helpers.GetSSID()
-					apMAC = gatherer.GetAccessPointMAC()
-					logger.LogMessage("DEBUG", fmt.Sprintf("Found WLAN interface with SSID: %s and AP MAC: %s", ssid, apMAC))
-				} else {
-					ssid = "N/A"
-					apMAC = "N/A"
-					logger.LogMessage("DEBUG", "No active WLAN interface found")
-				}
-
-				eth0MAC, err := helpers.GetMACAddress("eth0")
-				if err != nil {
-					logger.LogMessage("ERROR", fmt.Sprintf("Failed to get MAC address for eth0: %s", err))
-					eth0MAC = "unknown"
+	// A change of health state is published right away instead of waiting for the next cycle
+	system.Go(ctx, "health monitor", func(ctx context.Context) {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		lastState := health.Summarize(len(events.ActiveAlerts()) > 0).State
+		for {
+			select {
+			case <-ticker.C:
+				healthState := health.Summarize(len(events.ActiveAlerts()) > 0).State
+				if healthState != lastState {
+					logger.LogMessage("INFO", fmt.Sprintf("Health changed from %s to %s", lastState, healthState))
+					lastState = healthState
+					backoff.Reset()
+					requestStatusUpdate("health change")
 				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
 
-				updaterVersion := helpers.GetUpdaterVersion()
-
-				helpcomConfig, err := gatherer.ReadHelpcomConfig()
-				if err != nil {
-					logger.LogMessage("ERROR", fmt.Sprintf("Failed to read Helpcom configuration: %s", err))
-				}
+	if events.Enabled() {
+		system.Go(ctx, "event checker", func(ctx context.Context) {
+			events.Run(ctx, func(event events.Event) {
+				publishEvent(deviceType, event)
+			})
+		})
+	}
 
-				uptime := gatherer.GetUptime()
-				linuxVersion := gatherer.GetLinuxVersion()
-
-				// Status payload
-				message := map[string]interface{}{
-					"status":                  "Online",
-					"services":                gatherer.GetServiceStatus(),
-					"date":                    time.Now().UTC().Format(time.RFC3339),
-					"deviceID":                eth0MAC,
-					"device_type":             deviceType,
-					"ip_addresses":            json.RawMessage(ipAddress),
-					"mac_addresses":           json.RawMessage(macAddress),
-					"modem":                   json.RawMessage(modemDetails),
-					"temp":                    temperature,
-					"switch_name":             switchName,
-					"switch_ip":               switchIP,
-					"switch_port":             switchPort,
-					"switch_mac_address":      switchMacAddress,
-					"switch_port_vlan":        switchPortVlan,
-					"switch_sys_description":  switchSysDescription,
-					"switch_port_description": switchPortDescription,
-					"wifi_ssid":               ssid,
-					"wifi_ap_mac":             apMAC,
-					"updater_version":         updaterVersion,
-					"helpcom_servers":         helpcomConfig["HelpcomServers"],
-					"helpcom_lifespan":        helpcomConfig["HelpcomLifespan"],
-					"helpcom_rf":              helpcomConfig["HelpcomRF"],
-					"uptime":                  uptime,
-					"os_version":              linuxVersion,
-				}
+	if !config.Current().ServiceWatch.Disabled {
+		system.Go(ctx, "service watcher", func(ctx context.Context) {
+			servicewatch.Run(ctx, func(t servicewatch.Transition) {
+				publishEvent(deviceType, events.Event{
+					Type:     "service_stopped:" + t.Service,
+					State:    events.StateActive,
+					Value:    t.To,
+					Date:     t.Time.UTC().Format(time.RFC3339),
+					DeviceID: gatherer.GetDeviceID(),
+				})
+			})
+		})
+	}
 
-				// Compare with buffer and only send changed fields
-				bufferMutex.RLock()
-				isFirstRun := len(messageBuffer) == 0
-				changedFields := make(map[string]interface{})
-
-				if isFirstRun {
-					changedFields = message
-				} else {
-					// Always include status and deviceID fields
-					changedFields["status"] = "Online"
-					changedFields["deviceID"] = eth0MAC
-
-					// Check other fields for changes
-					for key, value := range message {
-						if key != "status" && key != "deviceID" && !reflect.DeepEqual(messageBuffer[key], value) {
-							changedFields[key] = value
-						}
-					}
-				}
-				bufferMutex.RUnlock()
+	if modemwatch.Enabled() {
+		system.Go(ctx, "modem watcher", func(ctx context.Context) {
+			modemwatch.Run(ctx, func(t modemwatch.Transition) {
+				publishEvent(deviceType, events.Event{
+					Type:     "modem_registration_lost",
+					State:    events.StateActive,
+					Value:    t.To,
+					Detail:   fmt.Sprintf("%s -> %s", t.From, t.To),
+					Date:     t.Time.UTC().Format(time.RFC3339),
+					DeviceID: gatherer.GetDeviceID(),
+				})
+			})
+		})
+	}
 
-				// If there are changes or it's the first run, send the update
-				if len(changedFields) > 0 {
-					messageJSON, err := json.Marshal(changedFields)
-					if err != nil {
-						logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal JSON: %s", err))
-						return
+	// A working modem that vanished or started failing, reported after the rescan attempt
+	if config.Current().GathererEnabled("modem") {
+		system.Go(ctx, "modem status", func(ctx context.Context) {
+			for {
+				select {
+				case t := <-gatherer.ModemTransitions():
+					detail := fmt.Sprintf("%s -> %s", t.From, t.To)
+					if t.Error != "" {
+						detail += ": " + t.Error
 					}
-
-					topic := fmt.Sprintf("%s/status", eth0MAC)
-					logger.LogMessage("INFO", fmt.Sprintf("Sending message to topic: %s with %d changed fields", topic, len(changedFields)))
-					err = mqtt.PublishMQTTMessage(topic, string(messageJSON))
-					if err != nil {
-						logger.LogMessage("ERROR", fmt.Sprintf("Failed to publish message (attempt %d/%d): %s",
-							attempt, maxRetries, err))
-						if attempt < maxRetries {
-							time.Sleep(retryDelay)
-							return
-						}
-					} else {
-						// Update buffer with new values
-						bufferMutex.Lock()
-						for k, v := range changedFields {
-							messageBuffer[k] = v
-						}
-						bufferMutex.Unlock()
-
-						logger.LogMessage("DEBUG", fmt.Sprintf("Status update completed successfully with %d changes.", len(changedFields)))
-						return
+					if t.Recovered {
+						detail += " (recovered after mmcli --scan-modems)"
 					}
-				} else {
-					logger.LogMessage("DEBUG", "No changes detected, skipping status update.")
+					publishEvent(deviceType, events.Event{
+						Type:     "modem_lost",
+						State:    events.StateActive,
+						Value:    t.To,
+						Detail:   detail,
+						Date:     t.Time.UTC().Format(time.RFC3339),
+						DeviceID: gatherer.GetDeviceID(),
+					})
+				case <-ctx.Done():
 					return
 				}
-			}()
+			}
+		})
+	}
 
-			if err != nil {
-				logger.LogMessage("ERROR", fmt.Sprintf("Retrying due to error: %v", err))
-				if attempt < maxRetries {
-					time.Sleep(retryDelay)
-				}
-			} else {
-				break
+	if wifiwatch.Enabled() {
+		system.Go(ctx, "wifi watcher", func(ctx context.Context) {
+			wifiwatch.Run(ctx, func(t wifiwatch.Transition) {
+				publishEvent(deviceType, events.Event{
+					Type:     "wifi_roam",
+					State:    events.StateActive,
+					Value:    t.To,
+					Detail:   fmt.Sprintf("%s -> %s", t.From, t.To),
+					Date:     t.Time.UTC().Format(time.RFC3339),
+					DeviceID: gatherer.GetDeviceID(),
+				})
+			})
+		})
+	}
+
+	system.Go(ctx, "log events", func(ctx context.Context) {
+		logger.ForwardEvents(ctx, func(level, message string) {
+			publishEvent(deviceType, events.Event{
+				Type:     "log",
+				State:    events.StateActive,
+				Value:    level,
+				Detail:   message,
+				Date:     time.Now().UTC().Format(time.RFC3339),
+				DeviceID: gatherer.GetDeviceID(),
+			})
+		})
+	})
+
+	if logwatch.Enabled() {
+		system.Go(ctx, "log watcher", func(ctx context.Context) {
+			logwatch.Run(ctx, func(match logwatch.Match) {
+				publishEvent(deviceType, events.Event{
+					Type:      "log_pattern:" + match.Label,
+					State:     events.StateActive,
+					Value:     match.Count,
+					Threshold: match.Threshold,
+					Detail:    match.LastLine,
+					Date:      time.Now().UTC().Format(time.RFC3339),
+					DeviceID:  gatherer.GetDeviceID(),
+				})
+			})
+		})
+	}
+
+	system.Go(ctx, "network monitor", func(ctx context.Context) {
+		// Only touched from the monitor goroutine
+		uplink := gatherer.GetActiveUplink(initialize.ConnectedBroker())
+		system.MonitorNetworkChanges(ctx, func() {
+			current := gatherer.GetActiveUplink(initialize.ConnectedBroker())
+			if uplink != nil && current != nil && *current != *uplink {
+				logger.LogMessage("WARN", fmt.Sprintf("Broker traffic moved from %s to %s", uplink.Interface, current.Interface))
+				publishEvent(deviceType, events.Event{
+					Type:     "uplink_changed",
+					State:    events.StateActive,
+					Value:    current.Interface,
+					Detail:   fmt.Sprintf("%s (%s) -> %s (%s)", uplink.Interface, uplink.Type, current.Interface, current.Type),
+					Date:     time.Now().UTC().Format(time.RFC3339),
+					DeviceID: gatherer.GetDeviceID(),
+				})
+			}
+			if current != nil {
+				uplink = current
+			}
+			gatherer.InvalidateWANIP()
+			backoff.Reset()
+			requestStatusUpdate("network change")
+		})
+	})
+
+	// Waited for on shutdown so the accumulators are saved
+	system.Go(ctx, "cellular usage", func(ctx context.Context) {
+		usage.Run(ctx)
+	})
+
+	// Shared by SIGHUP and remote config patches, one reload at a time; fails when the current settings were kept
+	var reloadMutex sync.Mutex
+	reloadConfig := func() error {
+		reloadMutex.Lock()
+		defer reloadMutex.Unlock()
+
+		previous := config.Current()
+		err := initialize.ReloadConfig()
+		var validationErr *config.ValidationError
+		switch {
+		case errors.As(err, &validationErr) && !validationErr.IsFatal():
+			for _, problem := range validationErr.Warnings {
+				logger.LogMessage("WARN", fmt.Sprintf("Configuration warning: %s", problem))
 			}
+			logger.LogMessage("INFO", "Configuration reloaded with warnings")
+		case err != nil:
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to reload configuration, keeping current settings: %v", err))
+			return err
+		default:
+			logger.LogMessage("INFO", "Configuration reloaded")
 		}
+		if reloaded := config.Current(); reloaded.Site != previous.Site || reloaded.Label != previous.Label {
+			logger.LogMessage("INFO", fmt.Sprintf("Site changed to %q, publishing full status", reloaded.Site))
+			bufferMutex.Lock()
+			forceFullSync = true
+			bufferMutex.Unlock()
+			requestStatusUpdate("site change")
+		}
+		return nil
+	}
+	system.Go(ctx, "reload handler", func(ctx context.Context) {
+		system.HandleReload(ctx, func() { reloadConfig() })
+	})
+
+	if config.Current().HTTP.Listen != "" {
+		system.Go(ctx, "http server", func(ctx context.Context) {
+			health.Serve(ctx, config.Current().HTTP.Listen)
+		})
 	}
 
 	// Main update loop
-	go func() {
-		sendStatusUpdate()
+	system.Go(ctx, "main loop", func(ctx context.Context) {
+		requestStatusUpdate("startup")
 
-		// Random initial delay (4h max) only on first run
-		if _, err := os.Stat("/var/run/status-updater.initialized"); os.IsNotExist(err) {
-			randomDelay := time.Duration(rand.Intn(4*60*60)) * time.Second
+		// Random initial delay (initial_delay_max) only on the first-ever startup after install, not after reboots
+		if !state.Initialized() {
+			randomDelay := time.Duration(rand.Int63n(int64(config.Current().InitialDelayMax)))
 			logger.LogMessage("INFO", fmt.Sprintf("Initial startup delay of %v until %s", randomDelay, time.Now().Add(randomDelay).Format(time.RFC3339)))
 
 			select {
 			case <-time.After(randomDelay):
-				// Create initialization marker file
-				if err := os.WriteFile("/var/run/status-updater.initialized", []byte{}, 0644); err != nil {
-					logger.LogMessage("ERROR", fmt.Sprintf("Failed to create initialization marker: %v", err))
+				if err := state.MarkInitialized(); err != nil {
+					logger.LogMessage("ERROR", err.Error())
 				}
 			case <-ctx.Done():
 				return
 			}
+		} else if err := state.MarkInitialized(); err != nil {
+			logger.LogMessage("ERROR", err.Error())
 		}
 
-		ticker := time.NewTicker(time.Duration(sleepInterval) * time.Second)
-		defer ticker.Stop()
+		// Interval stretches while nothing changes (backoff.idle_cycles) and snaps back on a change. Ticks land on a
+		// per-device phase of the interval so a fleet installed together doesn't publish in lockstep.
+		deviceID := gatherer.GetDeviceID()
+		logger.LogMessage("INFO", fmt.Sprintf("Status ticks at %v past each %v slot", backoff.Phase(deviceID, backoff.Interval()).Round(time.Second), backoff.Interval()))
+		timer := time.NewTimer(backoff.NextTick(deviceID))
+		defer timer.Stop()
 
+		cycles := 0
 		for {
 			select {
-			case <-ticker.C:
-				sendStatusUpdate()
+			case <-timer.C:
+				requestStatusUpdate("ticker")
+
+				// Metrics for devices without inbound access, every metrics.publish_every cycles
+				cycles++
+				if metrics.PublishDue(cycles) {
+					publishMetrics(deviceType)
+				}
+				timer.Reset(backoff.NextTick(deviceID))
+			case <-backoff.Resets():
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(backoff.NextTick(deviceID))
 			case <-ctx.Done():
 				logger.LogMessage("INFO", "Context cancelled, stopping the main loop")
 				return
 			}
 		}
-	}()
+	})
 
-	updater.CheckForUpdates()
+	// A second device publishing to this topic, e.g. one booted from a cloned image, shows up as status messages this
+	// instance didn't send. The topic is only watched for a while after startup.
+	if *config.Current().MQTT.DuplicateCheck {
+		system.Go(ctx, "duplicate check", func(ctx context.Context) {
+			deviceID := gatherer.GetDeviceID()
+			checkCtx, stopCheck := context.WithTimeout(ctx, duplicateCheckWindow)
+			defer stopCheck()
 
-	// Update checker loop
-	go func() {
-		for {
-			// Random check interval (24h max)
-			randomDelay := time.Duration(rand.Intn(24*60*60)) * time.Second
-			logger.LogMessage("INFO", fmt.Sprintf("Next update check in %v at %s", randomDelay, time.Now().Add(randomDelay).Format(time.RFC3339)))
+			var once sync.Once
+			mqtt.ListenMessages(checkCtx, "dupcheck", mqtt.StatusTopic(deviceID, deviceType)+"/#", func(message mqtt.Message) {
+				// Retained messages predate this run
+				if message.Retained {
+					return
+				}
+				payload, err := mqtt.Decode(message.Topic, message.Payload)
+				if err != nil {
+					return
+				}
+				foreign, ok := duplicate.Check(payload)
+				if !ok {
+					return
+				}
+				once.Do(func() {
+					logger.LogMessage("ERROR", fmt.Sprintf("Another device is publishing to %s (msg_id %s, seq %d), check for a cloned image or overridden MAC", message.Topic, foreign.MsgID, foreign.Seq))
+					publishEvent(deviceType, events.Event{
+						Type:     "duplicate_device_suspected",
+						State:    events.StateActive,
+						Value:    foreign.Seq,
+						Detail:   foreign.MsgID,
+						Date:     time.Now().UTC().Format(time.RFC3339),
+						DeviceID: deviceID,
+					})
+					requestStatusUpdate("duplicate device")
+				})
+			})
+		})
+	}
+
+	// Remote commands on <root>/cmd, answered on <root>/cmd/response
+	if config.Current().Commands.Enabled {
+		system.Go(ctx, "command listener", func(ctx context.Context) {
+			deviceID := gatherer.GetDeviceID()
+			responseTopic := mqtt.DeviceTopic(deviceID, deviceType, "cmd/response")
+			mqtt.Listen(ctx, "cmd", mqtt.DeviceTopic(deviceID, deviceType, "cmd"), func(payload []byte) {
+				commands.Handle(payload, func(response commands.Response) error {
+					message, err := json.Marshal(response)
+					if err != nil {
+						return err
+					}
+					return mqtt.PublishMQTTMessage(responseTopic, string(message))
+				})
+			})
+		})
+	}
+
+	// Config patches on <root>/config/set, acknowledged on <root>/config/ack
+	if config.Current().MQTT.RemoteConfig {
+		system.Go(ctx, "remote config listener", func(ctx context.Context) {
+			deviceID := gatherer.GetDeviceID()
+			ackTopic := mqtt.DeviceTopic(deviceID, deviceType, "config/ack")
+			mqtt.Listen(ctx, "config", mqtt.DeviceTopic(deviceID, deviceType, "config/set"), func(payload []byte) {
+				remoteconfig.Handle(payload, reloadConfig, func(ack remoteconfig.Ack) error {
+					message, err := json.Marshal(ack)
+					if err != nil {
+						return err
+					}
+					return mqtt.PublishMQTTMessage(ackTopic, string(message))
+				})
+			})
+		})
+	}
+
+	// Keepalive so the backend's staleness detection keeps working while the interval is stretched
+	if backoff.Enabled() {
+		system.Go(ctx, "keepalive", func(ctx context.Context) {
+			for {
+				select {
+				case <-time.After(backoff.KeepaliveDue()):
+					if backoff.KeepaliveDue() <= 0 {
+						publishKeepalive(deviceType)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
 
+	// Location on its own topic for dispatch, more often than the status cycle if needed
+	if location := config.Current().Location; location.Enabled && location.PublishInterval > 0 {
+		system.Go(ctx, "location publisher", func(ctx context.Context) {
+			ticker := time.NewTicker(config.Current().Location.PublishInterval.Duration())
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					publishLocation(deviceType)
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	// Daily certificate expiry check, the startup one ran above
+	system.Go(ctx, "certificate check", func(ctx context.Context) {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
 			select {
-			case <-time.After(randomDelay):
-				updater.CheckForUpdates()
+			case <-ticker.C:
+				initialize.CheckCertificateExpiry()
 			case <-ctx.Done():
 				return
 			}
 		}
+	})
+
+	// Update checker on a jittered, persisted schedule
+	system.Go(ctx, "update checker", func(ctx context.Context) {
+		updater.RunSchedule(ctx, func(progress updater.Progress) {
+			publishEvent(deviceType, events.Event{
+				Type:     "update_progress",
+				State:    events.StateActive,
+				Value:    progress,
+				Date:     time.Now().UTC().Format(time.RFC3339),
+				DeviceID: gatherer.GetDeviceID(),
+			})
+		})
+	})
+
+	// Waits for the goroutines started with system.Go and the registered components, then exits
+	system.HandleShutdown()
+}
+
+// Failures that retrying can't fix, such as a payload that fails to marshal
+type nonRetryableError struct {
+	err error
+}
+
+func (e nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+var errNoInternet = errors.New("no internet connection")
+
+// Publisher used for status messages, replaceable with a fake
+var publishMessage = mqtt.Publish
+
+// Status message bound for one topic
+type outgoingStatus struct {
+	topic    string
+	fields   status.Fields
+	retained bool
+}
+
+// Static meta section is retained and sent in full once per boot, then only when it changes
+var metaPublished bool
+
+// Spreads a status update over the section subtopics (mqtt.split_topics); the core status message is always sent
+func splitStatus(deviceID, deviceType string, fields, changedFields status.Fields) []outgoingStatus {
+	changed := status.Split(changedFields)
+	all := status.Split(fields)
+
+	var messages []outgoingStatus
+	for _, name := range status.SectionNames {
+		switch {
+		case name == "status":
+			messages = append(messages, outgoingStatus{mqtt.StatusTopic(deviceID, deviceType), changed[name], false})
+		case name == status.MetaSection:
+			if !metaPublished || changed[name].HasData() {
+				messages = append(messages, outgoingStatus{mqtt.DeviceTopic(deviceID, deviceType, name), all[name], true})
+			}
+		case changed[name].HasData():
+			messages = append(messages, outgoingStatus{mqtt.DeviceTopic(deviceID, deviceType, name), changed[name], false})
+		}
+	}
+	return messages
+}
+
+// Moves the static fields to the retained meta topic (mqtt.split_static), sent in full once per boot and on change;
+// everything else stays on the status topic
+func splitStatic(deviceID, deviceType string, fields, changedFields status.Fields) []outgoingStatus {
+	changed, changedMeta := status.SplitStatic(changedFields)
+	_, meta := status.SplitStatic(fields)
+
+	messages := []outgoingStatus{{mqtt.StatusTopic(deviceID, deviceType), changed, false}}
+	if !metaPublished || changedMeta.HasData() {
+		messages = append(messages, outgoingStatus{mqtt.DeviceTopic(deviceID, deviceType, status.MetaSection), meta, true})
+	}
+	return messages
+}
+
+// Gathers and publishes the status in up to maxRetries attempts, retrying transient failures after publish_retry_delay
+func sendStatusUpdate(ctx context.Context, deviceType string, maxRetries int) error {
+	retryDelay := config.Current().PublishRetryDelay.Duration()
+	health.RecordCycle()
+	boot.WriteHeartbeat()
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		logger.LogMessage("DEBUG", fmt.Sprintf("Starting status update (attempt %d/%d)...", attempt, maxRetries))
+
+		err := publishStatus(ctx, deviceType)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		var permanent nonRetryableError
+		if errors.As(err, &permanent) {
+			logger.LogMessage("ERROR", fmt.Sprintf("Status update failed, not retrying: %v", err))
+			return err
+		}
+		if attempt == maxRetries {
+			logger.LogMessage("ERROR", fmt.Sprintf("Status update failed after %d attempts: %v", maxRetries, err))
+			return err
+		}
+		logger.LogMessage("WARN", fmt.Sprintf("Status update failed (attempt %d/%d), retrying in %v: %v",
+			attempt, maxRetries, retryDelay, err))
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Single gather and publish attempt; only changed fields are sent unless a full sync is due
+func publishStatus(ctx context.Context, deviceType string) (err error) {
+	cfg := config.Current()
+	defer func() {
+		if r := recover(); r != nil {
+			system.RecordPanic(ctx, "status update", r)
+			err = nonRetryableError{fmt.Errorf("panic in status update: %v", r)}
+		}
 	}()
 
-	system.HandleShutdown(cancel, &wg)
+	if !helpers.IsInternetAvailable() {
+		health.RecordPublish(errNoInternet)
+		connectivity.RecordDisconnected()
+		return errNoInternet
+	}
+
+	payload, err := status.Collect(ctx, deviceType)
+	if err != nil {
+		return nonRetryableError{err}
+	}
+	payload.IntervalSeconds = int64(backoff.Interval().Seconds())
+	payload.RecentChangesCount = history.Count()
+	checkRSSCeiling(ctx, payload.Self.RSSBytes)
+
+	fields, err := payload.Fields()
+	if err != nil {
+		return nonRetryableError{err}
+	}
+	health.SetLastPayload(fields)
+	history.Record(fields, diffTolerances(), time.Now())
+
+	// Compare with buffer and only send changed fields, except on a full sync
+	bufferMutex.Lock()
+	if clockCorrected(payload.DateUnreliable) {
+		logger.LogMessage("INFO", "System clock was corrected, publishing full status")
+		forceFullSync = true
+	}
+	fullSync := forceFullSync || len(messageBuffer) == 0 ||
+		time.Since(lastFullSync) >= cfg.FullSyncInterval.Duration()
+	changedFields := status.Diff(messageBuffer, fields, diffTolerances())
+	// A full sync alone doesn't end the backoff, only an actual change does
+	changed := len(messageBuffer) == 0 || status.Significant(changedFields)
+	if fullSync {
+		changedFields = fields
+	} else {
+		// Fields past their payload.field_ttl go out again, without counting as a change
+		status.AddExpired(changedFields, fields, publishedAt, time.Now())
+	}
+	bufferMutex.Unlock()
+
+	// Nothing but always-sent and payload.diff_ignore fields moved; publish only when a heartbeat is due
+	if !fullSync && !status.Reportable(changedFields) && backoff.KeepaliveDue() > 0 {
+		logger.LogMessage("DEBUG", "No reportable changes, skipping publish")
+		backoff.RecordSkipped()
+		return nil
+	}
+
+	messages := []outgoingStatus{{mqtt.StatusTopic(payload.DeviceID, deviceType), changedFields, false}}
+	switch {
+	case cfg.MQTT.SplitTopics:
+		messages = splitStatus(payload.DeviceID, deviceType, fields, changedFields)
+	case cfg.MQTT.SplitStatic:
+		messages = splitStatic(payload.DeviceID, deviceType, fields, changedFields)
+	}
+
+	for _, out := range messages {
+		bufferMutex.Lock()
+		metadata, err := nextMetadata(payload.UpdaterVersion)
+		bufferMutex.Unlock()
+		if err != nil {
+			return nonRetryableError{err}
+		}
+
+		message := metadata.Apply(out.fields)
+		if fullSync || out.retained {
+			message["full"] = json.RawMessage("true")
+		}
+		messageJSON, err := json.Marshal(message)
+		if err != nil {
+			return nonRetryableError{fmt.Errorf("failed to marshal status: %v", err)}
+		}
+
+		logger.LogMessage("INFO", fmt.Sprintf("Sending message %d to topic: %s with %d changed fields", metadata.Seq, out.topic, len(out.fields)))
+		err = publishMessage(out.topic, string(messageJSON), out.retained)
+		if err != nil && fallback.Enabled() {
+			err = postFallback(out.topic, message, err)
+		}
+		health.RecordPublish(err)
+		if err != nil {
+			// Backend may have missed messages while the broker was unreachable
+			bufferMutex.Lock()
+			forceFullSync = true
+			bufferMutex.Unlock()
+			return fmt.Errorf("failed to publish status: %v", err)
+		}
+		if out.retained {
+			metaPublished = true
+		}
+	}
+
+	// Buffer keeps the last published value, so values within tolerance can't drift unreported
+	bufferMutex.Lock()
+	if fullSync {
+		messageBuffer = fields
+		forceFullSync = false
+		lastFullSync = time.Now()
+	} else {
+		messageBuffer.Apply(changedFields)
+		messageBuffer.Prune(fields)
+	}
+	status.TrackPublished(publishedAt, changedFields, fields, time.Now())
+	saveState(payload.UpdaterVersion)
+	bufferMutex.Unlock()
+	boot.MarkReported()
+	updater.MarkAppliedReported()
+	backoff.RecordCycle(changed)
+
+	logger.LogMessage("DEBUG", fmt.Sprintf("Status update completed successfully with %d changes.", len(changedFields)))
+	return nil
+}
+
+// Numeric fields that only count as changed when they move by at least their tolerance
+func diffTolerances() map[string]float64 {
+	cfg := config.Current()
+	return map[string]float64{
+		"temp_c":       cfg.Payload.TempThreshold,
+		"temp":         cfg.Payload.TempThreshold,
+		"temperatures": cfg.Payload.TempThreshold,
+	}
+}
+
+// Reports whether the clock became reliable or jumped since the previous cycle, which makes every buffered date suspect;
+// call with bufferMutex held
+func clockCorrected(dateUnreliable bool) bool {
+	now := time.Now()
+	corrected := lastDateUnreliable && !dateUnreliable
+	if !lastCycleTime.IsZero() {
+		// Sub uses the monotonic clock, Round(0) strips it to compare wall-clock time
+		drift := now.Round(0).Sub(lastCycleWall) - now.Sub(lastCycleTime)
+		if drift > clockJumpThreshold || drift < -clockJumpThreshold {
+			corrected = true
+		}
+	}
+	lastCycleTime, lastCycleWall = now, now.Round(0)
+	lastDateUnreliable = dateUnreliable
+	return corrected
+}
+
+// Publishes a bare status, deviceID and date message to the status topic when no status was sent for backoff.heartbeat_interval
+func publishKeepalive(deviceType string) {
+	bufferMutex.Lock()
+	metadata, err := nextMetadata(helpers.GetUpdaterVersion())
+	bufferMutex.Unlock()
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to build keepalive: %s", err))
+		return
+	}
 
-	wg.Wait()
-	logger.LogMessage("INFO", "All goroutines have completed.")
+	date, _ := json.Marshal(time.Now().UTC().Format(time.RFC3339))
+	deviceID, _ := json.Marshal(gatherer.GetDeviceID())
+	message, err := json.Marshal(metadata.Apply(status.Fields{
+		"status":   json.RawMessage(`"Online"`),
+		"deviceID": deviceID,
+		"date":     date,
+	}))
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal keepalive: %s", err))
+		return
+	}
+
+	topic := mqtt.StatusTopic(gatherer.GetDeviceID(), deviceType)
+	logger.LogMessage("INFO", fmt.Sprintf("Sending keepalive %d to topic: %s", metadata.Seq, topic))
+	err = publishMessage(topic, string(message), false)
+	health.RecordPublish(err)
+	backoff.RecordKeepalive()
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to publish keepalive: %s", err))
+	}
+}
+
+// Delivers a status message over HTTPS after MQTT failed, returning an error covering both transports
+func postFallback(topic string, message status.Fields, mqttErr error) error {
+	logger.LogMessage("WARN", fmt.Sprintf("MQTT publish failed (%s), falling back to HTTP", mqttErr))
+
+	message["topic"], _ = json.Marshal(topic)
+	message["transport"] = json.RawMessage(`"http"`)
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("%v; failed to marshal HTTP fallback message: %v", mqttErr, err)
+	}
+
+	if err := fallback.Post(body); err != nil {
+		return fmt.Errorf("%v; HTTP fallback: %v", mqttErr, err)
+	}
+	logger.LogMessage("INFO", "Status delivered over HTTP fallback")
+	return nil
+}
+
+// Warns when RSS exceeds self.rss_ceiling_mb and restarts cleanly after self.restart_after consecutive cycles over it
+func checkRSSCeiling(ctx context.Context, rssBytes uint64) {
+	cfg := config.Current()
+	ceilingMB := cfg.Self.RSSCeilingMB
+	if ceilingMB == 0 || rssBytes == 0 {
+		return
+	}
+	if rssBytes <= uint64(ceilingMB)*1024*1024 {
+		rssOverCycles = 0
+		return
+	}
+
+	rssOverCycles++
+	logger.LogMessage("WARN", fmt.Sprintf("RSS %d MB exceeds ceiling of %d MB (%d consecutive cycles)",
+		rssBytes/1024/1024, ceilingMB, rssOverCycles))
+	if restartAfter := cfg.Self.RestartAfter; restartAfter > 0 && rssOverCycles >= restartAfter {
+		logger.LogMessage("INFO", "Restarting application to release memory...")
+		system.RequestRestart(ctx, "memory")
+	}
+}
+
+// Claims the next sequence number. The state is only written when a new block of numbers is reserved, before its
+// first number is used, so a restart never reuses one; call with bufferMutex held
+func nextMetadata(updaterVersion string) (status.Metadata, error) {
+	seq, newBlock := sequence.Next()
+	if newBlock {
+		saveState(updaterVersion)
+	}
+	metadata, err := status.NewMetadata(seq)
+	if err != nil {
+		return metadata, err
+	}
+	duplicate.RecordSent(metadata.MsgID)
+	return metadata, nil
+}
+
+// Writes the buffer and sequence number to the state file; call with bufferMutex held
+func saveState(updaterVersion string) {
+	err := state.Save(&state.State{
+		SchemaVersion:  status.SchemaVersion,
+		UpdaterVersion: updaterVersion,
+		LastFullSync:   lastFullSync,
+		Seq:            sequence.Reserved(),
+		Buffer:         messageBuffer,
+		PublishedAt:    publishedAt,
+	})
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to persist status state: %s", err))
+	}
+}
+
+// Publishes a threshold event to <root>/events
+func publishEvent(deviceType string, event events.Event) {
+	if maintenance.Active() {
+		logger.LogMessage("DEBUG", fmt.Sprintf("Event %s %s suppressed during maintenance", event.Type, event.State))
+		return
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal event: %s", err))
+		return
+	}
+
+	logger.LogMessage("INFO", fmt.Sprintf("Event %s %s: value %v", event.Type, event.State, event.Value))
+	topic := mqtt.DeviceTopic(event.DeviceID, deviceType, "events")
+	if err := mqtt.PublishMQTTMessage(topic, string(status.RedactMessage(message))); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to publish event: %s", err))
+	}
+}
+
+// Publishes the modem's location to <root>/location
+func publishLocation(deviceType string) {
+	message, err := json.Marshal(map[string]interface{}{
+		"deviceID": gatherer.GetDeviceID(),
+		"date":     time.Now().UTC().Format(time.RFC3339),
+		"location": gatherer.GetLocation(*config.Current().Location.Precision),
+	})
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal location: %s", err))
+		return
+	}
+
+	topic := mqtt.DeviceTopic(gatherer.GetDeviceID(), deviceType, "location")
+	if err := mqtt.PublishMQTTMessage(topic, string(status.RedactMessage(message))); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to publish location: %s", err))
+	}
+}
+
+// Publishes a JSON snapshot of the daemon's own metrics to <root>/metrics
+func publishMetrics(deviceType string) {
+	snapshot, err := json.Marshal(map[string]interface{}{
+		"deviceID": gatherer.GetDeviceID(),
+		"date":     time.Now().UTC().Format(time.RFC3339),
+		"metrics":  metrics.Snapshot(),
+	})
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to marshal metrics: %s", err))
+		return
+	}
+
+	topic := mqtt.DeviceTopic(gatherer.GetDeviceID(), deviceType, "metrics")
+	if err := mqtt.PublishMQTTMessage(topic, string(snapshot)); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to publish metrics: %s", err))
+	}
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/status"
+	"testing"
+	"time"
+)
+
+// Sets up a config with every gatherer switched off, a fake command runner that answers the connectivity
+// check, an empty message buffer and a fake publisher recording the topics it was given; publish decides the
+// result of each publish by its attempt number, starting at 1
+func useFakePublisher(t *testing.T, publish func(attempt int) error) *[]string {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.MQTT.Broker = "broker.example.com"
+	cfg.MQTT.Username = "device"
+	cfg.MQTT.Password = "secret"
+	cfg.StateDir = t.TempDir()
+	cfg.Log.File = filepath.Join(t.TempDir(), "status-updater.log")
+	cfg.Gatherers = make(map[string]bool)
+	for _, name := range config.GathererNames {
+		cfg.Gatherers[name] = false
+	}
+	if err := cfg.Validate(); err != nil {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) && validationErr.IsFatal() {
+			t.Fatal(err)
+		}
+	}
+	// Below the configurable minimum, so the retries don't slow the tests down
+	cfg.PublishRetryDelay = config.Duration(10 * time.Millisecond)
+	previousConfig := config.Current()
+	config.Set(cfg)
+
+	fake := cmdrunner.NewFakeRunner()
+	fake.Set(cmdrunner.FakeResponse{}, "ping", "-c", "1", "172.233.38.166")
+	fake.Set(cmdrunner.FakeResponse{Stdout: "b8:27:eb:12:34:56\n"}, "cat", "/sys/class/net/eth0/address")
+	previousRunner := cmdrunner.Current
+	cmdrunner.Current = fake
+
+	var topics []string
+	attempts := 0
+	previousPublish := publishMessage
+	publishMessage = func(topic, message string, retained bool) error {
+		attempts++
+		topics = append(topics, topic)
+		return publish(attempts)
+	}
+
+	reset := func() {
+		bufferMutex.Lock()
+		messageBuffer, forceFullSync, sequence = status.Fields{}, false, status.NewSequence(0)
+		lastCycleTime, lastCycleWall, lastDateUnreliable = time.Time{}, time.Time{}, false
+		bufferMutex.Unlock()
+	}
+	reset()
+
+	t.Cleanup(func() {
+		publishMessage = previousPublish
+		cmdrunner.Current = previousRunner
+		config.Set(previousConfig)
+		reset()
+	})
+	return &topics
+}
+
+func TestSendStatusUpdateSucceedsOnSecondAttempt(t *testing.T) {
+	topics := useFakePublisher(t, func(attempt int) error {
+		if attempt == 1 {
+			return errors.New("publish timed out")
+		}
+		return nil
+	})
+
+	if err := sendStatusUpdate(context.Background(), "hc925", 3); err != nil {
+		t.Fatalf("sendStatusUpdate = %v, want success on the second attempt", err)
+	}
+	if len(*topics) != 2 {
+		t.Errorf("published %d times, want 2", len(*topics))
+	}
+
+	bufferMutex.Lock()
+	defer bufferMutex.Unlock()
+	if len(messageBuffer) == 0 {
+		t.Error("message buffer still empty after a successful publish")
+	}
+	if forceFullSync {
+		t.Error("full sync still forced after the retry succeeded")
+	}
+}
+
+func TestSendStatusUpdateExhaustsRetries(t *testing.T) {
+	publishErr := errors.New("publish timed out")
+	topics := useFakePublisher(t, func(int) error { return publishErr })
+
+	err := sendStatusUpdate(context.Background(), "hc925", 3)
+	if err == nil {
+		t.Fatal("sendStatusUpdate succeeded although every publish failed")
+	}
+	if len(*topics) != 3 {
+		t.Errorf("published %d times, want one per attempt (3)", len(*topics))
+	}
+
+	bufferMutex.Lock()
+	defer bufferMutex.Unlock()
+	if len(messageBuffer) != 0 {
+		t.Error("message buffer updated although nothing was published")
+	}
+	if !forceFullSync {
+		t.Error("no full sync forced after failed publishes")
+	}
+}
+
+func TestSendStatusUpdateStopsOnCancel(t *testing.T) {
+	topics := useFakePublisher(t, func(int) error { return errors.New("publish timed out") })
+	cfg := *config.Current()
+	cfg.PublishRetryDelay = config.Duration(time.Minute)
+	config.Set(&cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := sendStatusUpdate(ctx, "hc925", 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sendStatusUpdate = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("sendStatusUpdate took %v, want it to stop waiting for the retry on cancel", elapsed)
+	}
+	if len(*topics) != 1 {
+		t.Errorf("published %d times, want 1 before the cancellation", len(*topics))
+	}
+}
+
+func TestClockJumpForcesFullPublish(t *testing.T) {
+	useFakePublisher(t, func(int) error { return nil })
+	var full []bool
+	publishMessage = func(topic, message string, retained bool) error {
+		var decoded map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(message), &decoded); err != nil {
+			return err
+		}
+		full = append(full, string(decoded["full"]) == "true")
+		return nil
+	}
+	ctx := context.Background()
+
+	if err := publishStatus(ctx, "hc925"); err != nil {
+		t.Fatal(err)
+	}
+	if len(full) != 1 || !full[0] {
+		t.Fatalf("first publish full = %v, want one full publish", full)
+	}
+
+	// Without a jump the next cycle only sends a diff, if anything
+	if err := publishStatus(ctx, "hc925"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range full[1:] {
+		if f {
+			t.Fatal("full publish without a clock jump")
+		}
+	}
+
+	// The wall clock was set an hour ahead since the last cycle, the monotonic clock didn't move with it
+	bufferMutex.Lock()
+	lastCycleWall = lastCycleWall.Add(-time.Hour)
+	bufferMutex.Unlock()
+	published := len(full)
+	if err := publishStatus(ctx, "hc925"); err != nil {
+		t.Fatal(err)
+	}
+	if len(full) != published+1 || !full[published] {
+		t.Errorf("publish after the clock jump full = %v, want a full publish", full[published:])
+	}
+}
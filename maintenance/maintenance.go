@@ -0,0 +1,157 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest maintenance window that can be set, so a forgotten one always ends
+const MaxDuration = 24 * time.Hour
+
+// Sources a window is set by
+const (
+	SetByCommand = "command"
+	SetByFile    = "file"
+)
+
+// Maintenance window reported as "maintenance"
+type Status struct {
+	Active bool   `json:"active"`
+	Until  string `json:"until,omitempty"`
+	SetBy  string `json:"set_by,omitempty"`
+}
+
+// Window set by command, persisted so it survives a restart
+type window struct {
+	Until time.Time `json:"until"`
+	SetBy string    `json:"set_by"`
+}
+
+var (
+	mu          sync.Mutex
+	remote      window
+	wasActive   bool
+	rejectedMod time.Time
+)
+
+// Location of the window set by command inside state_dir
+func statePath() string {
+	return filepath.Join(config.Current().StateDir, "maintenance.json")
+}
+
+// File an on-site technician writes a duration such as "4h" into; the window counts from its modification time
+func LocalFile() string {
+	return filepath.Join(config.Current().StateDir, "maintenance")
+}
+
+// Restores a window set before a restart; an expired or corrupt one is removed
+func Load() {
+	data, err := os.ReadFile(statePath())
+	if err != nil {
+		return
+	}
+	var w window
+	if err := json.Unmarshal(data, &w); err != nil || !time.Now().Before(w.Until) {
+		os.Remove(statePath())
+		return
+	}
+
+	mu.Lock()
+	remote = w
+	mu.Unlock()
+	logger.LogMessage("INFO", fmt.Sprintf("Maintenance mode active until %s", w.Until.UTC().Format(time.RFC3339)))
+}
+
+// Starts a maintenance window of d, at most MaxDuration, replacing any window set before
+func Set(d time.Duration, setBy string) (Status, error) {
+	if d <= 0 || d > MaxDuration {
+		return Status{}, fmt.Errorf("maintenance duration must be between 0 and %s", MaxDuration)
+	}
+	w := window{Until: time.Now().Add(d), SetBy: setBy}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to encode maintenance window: %v", err)
+	}
+	if err := os.WriteFile(statePath(), data, 0644); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to persist maintenance window, it ends on restart: %v", err))
+	}
+
+	mu.Lock()
+	remote = w
+	mu.Unlock()
+	logger.LogMessage("INFO", fmt.Sprintf("Maintenance mode set by %s until %s", setBy, w.Until.UTC().Format(time.RFC3339)))
+	return Current(), nil
+}
+
+// Ends the window set by command; a window from the local file lasts until that file is removed or expires
+func Clear() Status {
+	mu.Lock()
+	remote = window{}
+	mu.Unlock()
+	if err := os.Remove(statePath()); err != nil && !os.IsNotExist(err) {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to remove maintenance window: %v", err))
+	}
+	logger.LogMessage("INFO", "Maintenance mode cleared by command")
+	return Current()
+}
+
+// Returns the current window, the later of the one set by command and the one in the local file
+func Current() Status {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+
+	w := remote
+	if local, ok := readLocalFile(); ok && local.Until.After(w.Until) {
+		w = local
+	}
+	active := now.Before(w.Until)
+	switch {
+	case active && !wasActive && w.SetBy == SetByFile:
+		logger.LogMessage("INFO", fmt.Sprintf("Maintenance mode set by %s until %s", LocalFile(), w.Until.UTC().Format(time.RFC3339)))
+	case !active && wasActive:
+		logger.LogMessage("INFO", "Maintenance mode ended, resuming events and updates")
+	}
+	wasActive = active
+
+	if !active {
+		return Status{}
+	}
+	return Status{Active: true, Until: w.Until.UTC().Format(time.RFC3339), SetBy: w.SetBy}
+}
+
+// Reports whether events and updates are paused
+func Active() bool {
+	return Current().Active
+}
+
+func readLocalFile() (window, bool) {
+	info, err := os.Stat(LocalFile())
+	if err != nil {
+		return window{}, false
+	}
+	data, err := os.ReadFile(LocalFile())
+	if err != nil {
+		return window{}, false
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(string(data)))
+	if err != nil || d <= 0 {
+		// Warned once per write of the file
+		if !info.ModTime().Equal(rejectedMod) {
+			rejectedMod = info.ModTime()
+			logger.LogMessage("WARN", fmt.Sprintf("Ignoring %s, it must hold a duration such as 4h", LocalFile()))
+		}
+		return window{}, false
+	}
+	if d > MaxDuration {
+		d = MaxDuration
+	}
+	return window{Until: info.ModTime().Add(d), SetBy: SetByFile}, true
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Wall-clock duration of one gatherer in a status cycle
+type Timing struct {
+	Source string `json:"source"`
+	Ms     int64  `json:"ms"`
+}
+
+// Per-gatherer breakdown of a completed status cycle, slowest first
+type Cycle struct {
+	Date      string   `json:"date"`
+	TotalMs   int64    `json:"total_ms"`
+	Gatherers []Timing `json:"gatherers"`
+}
+
+// Durations recorded by Time since StartCycle, and the last completed cycle
+var (
+	cycleMutex   sync.Mutex
+	cycleStart   time.Time
+	cycleTimings map[string]time.Duration
+	lastCycle    *Cycle
+)
+
+// Starts recording the durations passed to Time for a new status cycle
+func StartCycle() {
+	cycleMutex.Lock()
+	defer cycleMutex.Unlock()
+	cycleStart = time.Now()
+	cycleTimings = make(map[string]time.Duration)
+}
+
+// Completes the cycle started by StartCycle and returns its breakdown; nil without one
+func EndCycle() *Cycle {
+	cycleMutex.Lock()
+	defer cycleMutex.Unlock()
+	if cycleTimings == nil {
+		return nil
+	}
+
+	cycle := &Cycle{
+		Date:      cycleStart.UTC().Format(time.RFC3339),
+		TotalMs:   time.Since(cycleStart).Milliseconds(),
+		Gatherers: make([]Timing, 0, len(cycleTimings)),
+	}
+	for source, d := range cycleTimings {
+		cycle.Gatherers = append(cycle.Gatherers, Timing{Source: source, Ms: d.Milliseconds()})
+	}
+	sort.Slice(cycle.Gatherers, func(i, j int) bool {
+		if cycle.Gatherers[i].Ms != cycle.Gatherers[j].Ms {
+			return cycle.Gatherers[i].Ms > cycle.Gatherers[j].Ms
+		}
+		return cycle.Gatherers[i].Source < cycle.Gatherers[j].Source
+	})
+	cycleTimings = nil
+	lastCycle = cycle
+	return cycle
+}
+
+// Returns the breakdown of the last completed cycle, nil before the first one
+func LastCycle() *Cycle {
+	cycleMutex.Lock()
+	defer cycleMutex.Unlock()
+	return lastCycle
+}
+
+// Returns the n slowest gatherers of the last completed cycle
+func Slowest(n int) []Timing {
+	cycleMutex.Lock()
+	defer cycleMutex.Unlock()
+	if lastCycle == nil {
+		return nil
+	}
+	if n > len(lastCycle.Gatherers) {
+		n = len(lastCycle.Gatherers)
+	}
+	return append([]Timing(nil), lastCycle.Gatherers[:n]...)
+}
+
+func recordCycle(source string, d time.Duration) {
+	cycleMutex.Lock()
+	defer cycleMutex.Unlock()
+	if cycleTimings != nil {
+		cycleTimings[source] += d
+	}
+}
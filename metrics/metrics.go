@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"status-updater/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stable metric names; dashboards depend on these
+const (
+	PublishTotal            = "status_updater_publish_total"
+	GatherDurationSeconds   = "status_updater_gather_duration_seconds"
+	UpdateChecksTotal       = "status_updater_update_checks_total"
+	MQTTConnectsTotal       = "status_updater_mqtt_connects_total"
+	MQTTConnectFailures     = "status_updater_mqtt_connect_failures_total"
+	MQTTConnectionLostTotal = "status_updater_mqtt_connection_lost_total"
+	MemoryBytes             = "status_updater_memory_bytes"
+	Goroutines              = "status_updater_goroutines"
+	UptimeSeconds           = "status_updater_uptime_seconds"
+	CompressionBytesTotal   = "status_updater_compression_bytes_total"
+	PublishLatencySeconds   = "status_updater_publish_latency_seconds"
+	SlowPublishesTotal      = "status_updater_slow_publishes_total"
+)
+
+type metricInfo struct {
+	kind string
+	help string
+}
+
+var definitions = map[string]metricInfo{
+	PublishTotal:            {"counter", "Status publishes by result."},
+	GatherDurationSeconds:   {"gauge", "Duration of the last gather per data source."},
+	UpdateChecksTotal:       {"counter", "Update checks by outcome."},
+	MQTTConnectsTotal:       {"counter", "Successful MQTT broker connections."},
+	MQTTConnectFailures:     {"counter", "Failed MQTT broker connection attempts."},
+	MQTTConnectionLostTotal: {"counter", "MQTT connections lost after being established."},
+	MemoryBytes:             {"gauge", "Process memory by type."},
+	Goroutines:              {"gauge", "Number of goroutines."},
+	UptimeSeconds:           {"gauge", "Seconds since the daemon started."},
+	CompressionBytesTotal:   {"counter", "Bytes of gzip-compressed payloads before and after compression."},
+	PublishLatencySeconds:   {"gauge", "Publish-to-acknowledgement latency percentiles over the last hour."},
+	SlowPublishesTotal:      {"counter", "Publishes slower than mqtt.slow_publish_threshold."},
+}
+
+// Values keyed by metric name, then by rendered label set
+var (
+	registryMutex sync.Mutex
+	values        = make(map[string]map[string]float64)
+	startTime     = time.Now()
+)
+
+// Adds one to a counter; labels are key, value pairs
+func IncCounter(name string, labels ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	series(name)[renderLabels(labels)]++
+}
+
+// Adds delta to a counter; labels are key, value pairs
+func AddCounter(name string, delta float64, labels ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	series(name)[renderLabels(labels)] += delta
+}
+
+// Sets a gauge; labels are key, value pairs
+func SetGauge(name string, value float64, labels ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	series(name)[renderLabels(labels)] = value
+}
+
+// Runs a gatherer, recording its duration under the given source name
+func Measure[T any](source string, gather func() T) T {
+	var result T
+	Time(source, func() { result = gather() })
+	return result
+}
+
+// Runs fn, recording its duration under the given source name and in the current cycle
+func Time(source string, fn func()) {
+	start := time.Now()
+	fn()
+	elapsed := time.Since(start)
+	SetGauge(GatherDurationSeconds, elapsed.Seconds(), "source", source)
+	recordCycle(source, elapsed)
+}
+
+func series(name string) map[string]float64 {
+	if values[name] == nil {
+		values[name] = make(map[string]float64)
+	}
+	return values[name]
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Samples process-level gauges at read time
+func updateProcessGauges() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	series(MemoryBytes)[renderLabels([]string{"type", "heap_alloc"})] = float64(mem.HeapAlloc)
+	series(MemoryBytes)[renderLabels([]string{"type", "sys"})] = float64(mem.Sys)
+	series(Goroutines)[""] = float64(runtime.NumGoroutine())
+	series(UptimeSeconds)[""] = time.Since(startTime).Seconds()
+}
+
+// Writes all metrics in the Prometheus text exposition format
+func WritePrometheus(w io.Writer) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	updateProcessGauges()
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if info, ok := definitions[name]; ok {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, info.help, name, info.kind)
+		}
+
+		labelSets := make([]string, 0, len(values[name]))
+		for labels := range values[name] {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+		for _, labels := range labelSets {
+			fmt.Fprintf(w, "%s%s %g\n", name, labels, values[name][labels])
+		}
+	}
+}
+
+// Reports whether the snapshot goes out on the given status cycle, counted from 1: every metrics.publish_every cycles
+func PublishDue(cycle int) bool {
+	every := config.Current().Metrics.PublishEvery
+	return every > 0 && cycle > 0 && cycle%every == 0
+}
+
+// Returns all metrics as name -> label set -> value, for publishing as JSON
+func Snapshot() map[string]map[string]float64 {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	updateProcessGauges()
+
+	snapshot := make(map[string]map[string]float64, len(values))
+	for name, labelSets := range values {
+		snapshot[name] = make(map[string]float64, len(labelSets))
+		for labels, value := range labelSets {
+			if labels == "" {
+				labels = "value"
+			}
+			snapshot[name][labels] = value
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"status-updater/config"
+	"strings"
+	"testing"
+)
+
+// Dashboards and alerts query these names; renaming one is a breaking change
+var stableNames = []string{
+	"status_updater_compression_bytes_total",
+	"status_updater_gather_duration_seconds",
+	"status_updater_goroutines",
+	"status_updater_memory_bytes",
+	"status_updater_mqtt_connect_failures_total",
+	"status_updater_mqtt_connection_lost_total",
+	"status_updater_mqtt_connects_total",
+	"status_updater_publish_latency_seconds",
+	"status_updater_publish_total",
+	"status_updater_slow_publishes_total",
+	"status_updater_update_checks_total",
+	"status_updater_uptime_seconds",
+}
+
+func TestMetricNamesAreStable(t *testing.T) {
+	names := make([]string, 0, len(definitions))
+	for name, info := range definitions {
+		names = append(names, name)
+		if info.kind != "counter" && info.kind != "gauge" {
+			t.Errorf("%s has kind %q", name, info.kind)
+		}
+		if strings.HasSuffix(name, "_total") != (info.kind == "counter") {
+			t.Errorf("%s: only counters end in _total", name)
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, "\n") != strings.Join(stableNames, "\n") {
+		t.Errorf("metric names changed:\n%s\nwant\n%s", strings.Join(names, "\n"), strings.Join(stableNames, "\n"))
+	}
+}
+
+// Clears the registry for the test, restoring it afterwards
+func useRegistry(t *testing.T) {
+	t.Helper()
+	registryMutex.Lock()
+	previous := values
+	values = make(map[string]map[string]float64)
+	registryMutex.Unlock()
+	t.Cleanup(func() {
+		registryMutex.Lock()
+		values = previous
+		registryMutex.Unlock()
+	})
+}
+
+func TestWritePrometheus(t *testing.T) {
+	useRegistry(t)
+	IncCounter(PublishTotal, "result", "success")
+	IncCounter(PublishTotal, "result", "success")
+	IncCounter(PublishTotal, "result", "failure")
+	SetGauge(GatherDurationSeconds, 0.25, "source", `modem "wwan0"`)
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf)
+	output := buf.String()
+	for _, want := range []string{
+		"# HELP status_updater_publish_total Status publishes by result.\n# TYPE status_updater_publish_total counter\n",
+		"status_updater_publish_total{result=\"failure\"} 1\nstatus_updater_publish_total{result=\"success\"} 2\n",
+		`status_updater_gather_duration_seconds{source="modem \"wwan0\""} 0.25` + "\n",
+		"# TYPE status_updater_memory_bytes gauge\n",
+		"status_updater_goroutines ",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+
+	snapshot := Snapshot()
+	if got := snapshot[PublishTotal][`{result="success"}`]; got != 2 {
+		t.Errorf("snapshot success count = %v, want 2", got)
+	}
+	if _, ok := snapshot[UptimeSeconds]["value"]; !ok {
+		t.Error("unlabelled gauge missing from the snapshot under \"value\"")
+	}
+}
+
+func TestPublishDue(t *testing.T) {
+	tests := []struct {
+		every int
+		due   []int
+	}{
+		{0, []int{}},
+		{1, []int{1, 2, 3, 4, 5, 6}},
+		{3, []int{3, 6}},
+		{10, []int{}},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Metrics.PublishEvery = tt.every
+		previous := config.Current()
+		config.Set(cfg)
+
+		due := []int{}
+		for cycle := 0; cycle <= 6; cycle++ {
+			if PublishDue(cycle) {
+				due = append(due, cycle)
+			}
+		}
+		config.Set(previous)
+		if !reflect.DeepEqual(due, tt.due) {
+			t.Errorf("publish_every %d: due on cycles %v, want %v", tt.every, due, tt.due)
+		}
+	}
+}
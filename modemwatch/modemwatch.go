@@ -0,0 +1,244 @@
+package modemwatch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Poll interval where mmcli --monitor-state isn't supported
+	pollInterval = 30 * time.Second
+
+	// Wait before looking the modem up again after the monitor ended or no modem was found
+	retryDelay = 10 * time.Second
+
+	// Recent transitions reported in the payload
+	maxTransitions = 10
+)
+
+// A change of the modem's state as reported by ModemManager
+type Transition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+// Registration drops and recent state changes, reported as modem_states
+type Stats struct {
+	DropsLastHour int          `json:"drops_last_hour"`
+	DropsLastDay  int          `json:"drops_last_day"`
+	Transitions   []Transition `json:"transitions"`
+}
+
+var (
+	stateMutex  sync.Mutex
+	running     bool
+	current     string
+	transitions []Transition
+	drops       []time.Time
+	lastEvent   time.Time
+
+	// "Initial state, 'registered'." and "State changed, 'registered' --> 'searching' (Reason: ...)"
+	monitorState = regexp.MustCompile(`(?:Initial state, '|--> ')([a-z-]+)'`)
+)
+
+// Reports whether the modem gatherer is enabled and mmcli is installed
+func Enabled() bool {
+	if !config.Current().GathererEnabled("modem") {
+		return false
+	}
+	_, err := cmdrunner.LookPath("mmcli")
+	return err == nil
+}
+
+// Follows the modem's state until ctx is cancelled, calling onDrop when it loses its registration, at most once
+// per events.cooldown. The modem is looked up again whenever it disappears, since a reset renumbers it.
+func Run(ctx context.Context, onDrop func(Transition)) {
+	stateMutex.Lock()
+	running = true
+	stateMutex.Unlock()
+
+	monitorSupported := true
+	index := -1
+	for {
+		if index < 0 {
+			var err error
+			if index, err = gatherer.ModemIndex(); err != nil {
+				index = -1
+				if !sleep(ctx, retryDelay) {
+					return
+				}
+				continue
+			}
+		}
+
+		if monitorSupported {
+			received, err := monitor(ctx, index, onDrop)
+			if ctx.Err() != nil {
+				return
+			}
+			if !received {
+				logger.LogMessage("WARN", fmt.Sprintf("mmcli --monitor-state unavailable (%v), polling the modem state every %v", err, pollInterval))
+				monitorSupported = false
+			} else {
+				logger.LogMessage("INFO", fmt.Sprintf("Modem state monitor ended (%v), looking up the modem again", err))
+				index = -1
+				if !sleep(ctx, retryDelay) {
+					return
+				}
+			}
+			continue
+		}
+
+		state, err := pollState(index)
+		if err != nil {
+			index = -1
+		} else {
+			record(state, onDrop)
+		}
+		if !sleep(ctx, pollInterval) {
+			return
+		}
+	}
+}
+
+// Returns the drop counts and recent transitions, or nil when the watcher isn't running
+func Snapshot() *Stats {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if !running {
+		return nil
+	}
+
+	now := time.Now()
+	pruneDrops(now)
+	stats := &Stats{DropsLastDay: len(drops), Transitions: append([]Transition{}, transitions...)}
+	for _, drop := range drops {
+		if now.Sub(drop) <= time.Hour {
+			stats.DropsLastHour++
+		}
+	}
+	return stats
+}
+
+// Runs mmcli --monitor-state until it exits, reporting whether it printed any state at all
+func monitor(ctx context.Context, index int, onDrop func(Transition)) (bool, error) {
+	cmd := exec.CommandContext(ctx, "mmcli", "-m", strconv.Itoa(index), "--monitor-state")
+	cmdrunner.KillGroupOnCancel(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+
+	received := false
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if match := monitorState.FindStringSubmatch(scanner.Text()); match != nil {
+			received = true
+			record(match[1], onDrop)
+		}
+	}
+	err = cmd.Wait()
+	if err == nil {
+		err = fmt.Errorf("mmcli exited")
+	}
+	return received, err
+}
+
+// Reads just modem.generic.state from mmcli's key-value output
+func pollState(index int) (string, error) {
+	output, err := cmdrunner.Output("mmcli", "-m", strconv.Itoa(index), "-K")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "modem.generic.state" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", fmt.Errorf("no modem.generic.state in mmcli output")
+}
+
+func record(state string, onDrop func(Transition)) {
+	now := time.Now()
+
+	stateMutex.Lock()
+	previous := current
+	current = state
+	if previous == "" || previous == state {
+		stateMutex.Unlock()
+		return
+	}
+	transition := Transition{From: previous, To: state, Time: now.UTC().Truncate(time.Second)}
+	transitions = append(transitions, transition)
+	if len(transitions) > maxTransitions {
+		transitions = transitions[len(transitions)-maxTransitions:]
+	}
+	isDrop := registered(previous) && lostRegistration(state)
+	cooledDown := false
+	if isDrop {
+		drops = append(drops, now)
+		pruneDrops(now)
+		cooledDown = now.Sub(lastEvent) >= config.Current().Events.Cooldown.Duration()
+		if cooledDown {
+			lastEvent = now
+		}
+	}
+	stateMutex.Unlock()
+
+	logger.LogMessage("INFO", fmt.Sprintf("Modem state changed: %s -> %s", previous, state))
+	if cooledDown {
+		onDrop(transition)
+	}
+}
+
+// Forgets drops older than a day; call with stateMutex held
+func pruneDrops(now time.Time) {
+	keep := 0
+	for keep < len(drops) && now.Sub(drops[keep]) > 24*time.Hour {
+		keep++
+	}
+	drops = drops[keep:]
+}
+
+// Registered with the network, with or without a data connection
+func registered(state string) bool {
+	switch state {
+	case "registered", "connecting", "connected", "disconnecting":
+		return true
+	}
+	return false
+}
+
+func lostRegistration(state string) bool {
+	switch state {
+	case "searching", "denied", "failed":
+		return true
+	}
+	return false
+}
+
+// Waits for d, returning false when ctx was cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,73 @@
+package mqtt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"status-updater/config"
+	"status-updater/metrics"
+	"strings"
+	"sync"
+)
+
+// Appended to the topic of gzip-compressed messages so the backend can tell them apart
+const CompressedSuffix = "/gzip"
+
+// Bytes of compressed payloads before and after compression since startup
+var (
+	compressionMutex  sync.Mutex
+	uncompressedBytes uint64
+	compressedBytes   uint64
+)
+
+// Gzips messages larger than mqtt.compress_above when mqtt.compress is set, returning the topic to publish to;
+// small messages, and those compression doesn't shrink, go out unchanged. Retained messages are never compressed:
+// depending on their size they would alternate between two topics, leaving a stale retained copy on the other one.
+func compressMessage(topic, message string, retained bool) (string, string) {
+	if cfg := config.Current().MQTT; retained || !cfg.Compress || len(message) <= cfg.CompressAbove {
+		return topic, message
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(message)); err != nil {
+		return topic, message
+	}
+	if err := writer.Close(); err != nil || buf.Len() >= len(message) {
+		return topic, message
+	}
+
+	compressionMutex.Lock()
+	uncompressedBytes += uint64(len(message))
+	compressedBytes += uint64(buf.Len())
+	compressionMutex.Unlock()
+	metrics.AddCounter(metrics.CompressionBytesTotal, float64(len(message)), "stage", "uncompressed")
+	metrics.AddCounter(metrics.CompressionBytesTotal, float64(buf.Len()), "stage", "compressed")
+
+	return topic + CompressedSuffix, buf.String()
+}
+
+// Returns the bytes of compressed payloads before and after compression since startup
+func CompressionStats() (uint64, uint64) {
+	compressionMutex.Lock()
+	defer compressionMutex.Unlock()
+	return uncompressedBytes, compressedBytes
+}
+
+// Returns the payload of a message received on topic, gunzipping it when the topic ends in CompressedSuffix
+func Decode(topic string, payload []byte) ([]byte, error) {
+	if !strings.HasSuffix(topic, CompressedSuffix) {
+		return payload, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip payload: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %v", err)
+	}
+	return data, nil
+}
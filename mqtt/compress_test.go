@@ -0,0 +1,47 @@
+package mqtt
+
+import (
+	"status-updater/config"
+	"strings"
+	"testing"
+)
+
+// Puts a config with compression of messages above 64 bytes in effect, restoring the previous config afterwards
+func useCompression(t *testing.T) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.MQTT.Compress = true
+	cfg.MQTT.CompressAbove = 64
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+func TestCompressMessage(t *testing.T) {
+	useCompression(t)
+	message := `{"status":"Online","services":"` + strings.Repeat("sos-web active running ", 20) + `"}`
+
+	topic, compressed := compressMessage("b8:27:eb:12:34:56/status", message, false)
+	if topic != "b8:27:eb:12:34:56/status"+CompressedSuffix {
+		t.Errorf("topic = %q, want the %s suffix", topic, CompressedSuffix)
+	}
+	decoded, err := Decode(topic, []byte(compressed))
+	if err != nil || string(decoded) != message {
+		t.Errorf("Decode = %q, %v, want the original message", decoded, err)
+	}
+
+	topic, small := compressMessage("b8:27:eb:12:34:56/status", `{"status":"Online"}`, false)
+	if topic != "b8:27:eb:12:34:56/status" || small != `{"status":"Online"}` {
+		t.Errorf("small message went out as %q on %q, want it unchanged", small, topic)
+	}
+}
+
+func TestCompressMessageSkipsRetained(t *testing.T) {
+	useCompression(t)
+	message := `{"hostname":"hc925","versions":"` + strings.Repeat("status-updater 2.4.1 ", 20) + `"}`
+
+	topic, sent := compressMessage("b8:27:eb:12:34:56/meta", message, true)
+	if topic != "b8:27:eb:12:34:56/meta" || sent != message {
+		t.Errorf("retained message went out compressed on %q, want it unchanged on the meta topic", topic)
+	}
+}
@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"status-updater/config"
+	"status-updater/initialize"
+	"status-updater/logger"
+	"status-updater/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	// Publish latencies kept for the since-boot percentiles, sampled uniformly over all publishes
+	latencyReservoirSize = 1000
+
+	// Rolling window for the recent percentiles, and the most samples it holds
+	latencyWindow        = time.Hour
+	latencyWindowSamples = 3600
+)
+
+// Publish-to-acknowledgement latency in milliseconds, since boot and over the last hour
+type Latency struct {
+	Count     uint64  `json:"count"`
+	Slow      uint64  `json:"slow"`
+	P50       float64 `json:"p50"`
+	P95       float64 `json:"p95"`
+	HourCount int     `json:"hour_count"`
+	HourP50   float64 `json:"hour_p50"`
+	HourP95   float64 `json:"hour_p95"`
+}
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+var (
+	latencyMutex sync.Mutex
+	published    uint64
+	slowPublish  uint64
+	reservoir    []time.Duration
+	recent       []latencySample
+
+	// Broker the recent window was measured against; a different one starts a new window
+	recentBroker string
+)
+
+// Records how long the broker took to acknowledge a publish, warning when it exceeded mqtt.slow_publish_threshold
+func recordLatency(topic string, d time.Duration) {
+	broker := initialize.ConnectedBroker()
+	now := time.Now()
+
+	latencyMutex.Lock()
+	published++
+	if len(reservoir) < latencyReservoirSize {
+		reservoir = append(reservoir, d)
+	} else if i := rand.Int63n(int64(published)); i < latencyReservoirSize {
+		reservoir[i] = d
+	}
+
+	if broker != recentBroker {
+		recent = nil
+		recentBroker = broker
+	}
+	recent = append(pruneRecent(recent, now), latencySample{at: now, duration: d})
+	if len(recent) > latencyWindowSamples {
+		recent = recent[len(recent)-latencyWindowSamples:]
+	}
+
+	threshold := config.Current().MQTT.SlowPublishThreshold.Duration()
+	slow := threshold > 0 && d > threshold
+	if slow {
+		slowPublish++
+	}
+	p50, p95 := percentiles(windowDurations(recent))
+	latencyMutex.Unlock()
+
+	metrics.SetGauge(metrics.PublishLatencySeconds, p50.Seconds(), "quantile", "0.5")
+	metrics.SetGauge(metrics.PublishLatencySeconds, p95.Seconds(), "quantile", "0.95")
+	if slow {
+		metrics.IncCounter(metrics.SlowPublishesTotal)
+		logger.LogMessage("WARN", fmt.Sprintf("Publish to %s took %v, above mqtt.slow_publish_threshold %v", topic, d.Round(time.Millisecond), threshold))
+	}
+}
+
+// Returns the publish latency summary, nil before the first publish
+func PublishLatency() *Latency {
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+	if published == 0 {
+		return nil
+	}
+
+	recent = pruneRecent(recent, time.Now())
+	p50, p95 := percentiles(reservoir)
+	hourP50, hourP95 := percentiles(windowDurations(recent))
+	return &Latency{
+		Count:     published,
+		Slow:      slowPublish,
+		P50:       milliseconds(p50),
+		P95:       milliseconds(p95),
+		HourCount: len(recent),
+		HourP50:   milliseconds(hourP50),
+		HourP95:   milliseconds(hourP95),
+	}
+}
+
+// Drops samples older than the window; samples are in time order
+func pruneRecent(samples []latencySample, now time.Time) []latencySample {
+	cutoff := now.Add(-latencyWindow)
+	i := 0
+	for i < len(samples) && samples[i].at.Before(cutoff) {
+		i++
+	}
+	return samples[i:]
+}
+
+func windowDurations(samples []latencySample) []time.Duration {
+	durations := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		durations[i] = sample.duration
+	}
+	return durations
+}
+
+// Nearest-rank 50th and 95th percentiles, zero without samples
+func percentiles(durations []time.Duration) (time.Duration, time.Duration) {
+	if len(durations) == 0 {
+		return 0, 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p int) time.Duration {
+		i := (len(sorted)*p + 99) / 100
+		if i < 1 {
+			i = 1
+		}
+		return sorted[i-1]
+	}
+	return rank(50), rank(95)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
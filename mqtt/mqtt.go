@@ -3,8 +3,10 @@ package mqtt
 import (
 	"context"
 	"fmt"
+	"status-updater/connectivity"
 	"status-updater/initialize"
 	"status-updater/logger"
+	"status-updater/metrics"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
@@ -12,6 +14,22 @@ import (
 
 // Publishes messages with retry mechanism
 func PublishMQTTMessage(topic, message string) error {
+	return Publish(topic, message, false)
+}
+
+// Publishes with retries, optionally as a retained message
+func Publish(topic, message string, retained bool) error {
+	topic, message = compressMessage(topic, message, retained)
+	err := publishWithRetries(topic, message, retained)
+	if err != nil {
+		metrics.IncCounter(metrics.PublishTotal, "result", "failure")
+	} else {
+		metrics.IncCounter(metrics.PublishTotal, "result", "success")
+	}
+	return err
+}
+
+func publishWithRetries(topic, message string, retained bool) error {
 	maxRetries := 3
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		logger.LogMessage("DEBUG", fmt.Sprintf("MQTT publish attempt %d/%d", attempt, maxRetries))
@@ -31,6 +49,9 @@ func PublishMQTTMessage(topic, message string) error {
 
 		opts.SetOnConnectHandler(func(client MQTT.Client) {
 			logger.LogMessage("DEBUG", "Connected to MQTT broker")
+			initialize.RecordConnected()
+			connectivity.RecordConnected()
+			metrics.IncCounter(metrics.MQTTConnectsTotal)
 			select {
 			case connectionSuccess <- true:
 			default:
@@ -39,6 +60,8 @@ func PublishMQTTMessage(topic, message string) error {
 
 		opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
 			logger.LogMessage("WARN", fmt.Sprintf("Connection lost: %v", err))
+			metrics.IncCounter(metrics.MQTTConnectionLostTotal)
+			connectivity.RecordDisconnected()
 			select {
 			case connectionFailed <- err:
 			default:
@@ -48,7 +71,13 @@ func PublishMQTTMessage(topic, message string) error {
 		client := MQTT.NewClient(opts)
 
 		if token := client.Connect(); token.Wait() && token.Error() != nil {
-			logger.LogMessage("ERROR", fmt.Sprintf("Connection error: %v", token.Error()))
+			if reason := initialize.ClassifyTLSError(token.Error()); reason != "" {
+				logger.LogMessage("ERROR", fmt.Sprintf("Connection error: %s: %v", reason, token.Error()))
+			} else {
+				logger.LogMessage("ERROR", fmt.Sprintf("Connection error: %v", token.Error()))
+			}
+			metrics.IncCounter(metrics.MQTTConnectFailures)
+			connectivity.RecordDisconnected()
 			client.Disconnect(250)
 			if attempt == maxRetries {
 				return token.Error()
@@ -68,6 +97,7 @@ func PublishMQTTMessage(topic, message string) error {
 			time.Sleep(time.Duration(attempt) * time.Second)
 			continue
 		case <-time.After(5 * time.Second):
+			connectivity.RecordDisconnected()
 			client.Disconnect(250)
 			if attempt == maxRetries {
 				return fmt.Errorf("connection timeout")
@@ -81,8 +111,9 @@ func PublishMQTTMessage(topic, message string) error {
 		defer cancel()
 		publishComplete := make(chan error, 1)
 
+		start := time.Now()
 		go func() {
-			token := client.Publish(topic, 1, false, message)
+			token := client.Publish(topic, 1, retained, message)
 			token.Wait()
 			publishComplete <- token.Error()
 		}()
@@ -98,6 +129,7 @@ func PublishMQTTMessage(topic, message string) error {
 				time.Sleep(time.Duration(attempt) * time.Second)
 				continue
 			}
+			recordLatency(topic, time.Since(start))
 			logger.LogMessage("INFO", fmt.Sprintf("Successfully published message to %s", topic))
 			time.Sleep(100 * time.Millisecond) // Buffer for message delivery
 			client.Disconnect(250)
@@ -114,3 +146,18 @@ func PublishMQTTMessage(topic, message string) error {
 
 	return fmt.Errorf("failed to publish after %d attempts", maxRetries)
 }
+
+// Publishes with an overall deadline so a hung broker can't block the caller, e.g. during shutdown
+func PublishMQTTMessageWithTimeout(topic, message string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- PublishMQTTMessage(topic, message)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("publish to %s timed out after %v", topic, timeout)
+	}
+}
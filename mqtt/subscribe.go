@@ -0,0 +1,84 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"status-updater/initialize"
+	"status-updater/logger"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Delay between connection attempts of the listener
+const listenRetryDelay = 30 * time.Second
+
+// Keeps a connection subscribed to topic and calls handle for every message, until ctx is cancelled.
+// Each listener uses its own client ID (the publishing one plus "-" and name) so listeners don't take over each
+// other's or the publishing connections.
+func Listen(ctx context.Context, name, topic string, handle func(payload []byte)) {
+	ListenMessages(ctx, name, topic, func(message Message) {
+		handle(message.Payload)
+	})
+}
+
+// Message delivered to a listener; topic may be one of several matching a wildcard subscription
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// Like Listen, passing the topic and retained flag along with the payload
+func ListenMessages(ctx context.Context, name, topic string, handle func(Message)) {
+	for {
+		client, err := connectListener(name, topic, handle)
+		if err == nil {
+			logger.LogMessage("INFO", fmt.Sprintf("Listening on %s", topic))
+			<-ctx.Done()
+			client.Disconnect(250)
+			return
+		}
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to subscribe to %s, retrying in %v: %v", topic, listenRetryDelay, err))
+
+		select {
+		case <-time.After(listenRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func connectListener(name, topic string, handle func(Message)) (MQTT.Client, error) {
+	opts, err := initialize.InitializeListenerOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.SetClientID(opts.ClientID + "-" + name)
+
+	// Subscribes again after every reconnect, the session is not persisted. Outage statistics and broker failover
+	// only follow the publishing connection: a listener reconnecting at its own pace would end or start outages it
+	// didn't see, or move the preferred broker.
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		token := client.Subscribe(topic, 1, func(client MQTT.Client, message MQTT.Message) {
+			handle(Message{Topic: message.Topic(), Payload: message.Payload(), Retained: message.Retained()})
+		})
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to subscribe to %s: %v", topic, token.Error()))
+		}
+	})
+	opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
+		logger.LogMessage("WARN", fmt.Sprintf("Listener connection for %s lost: %v", topic, err))
+	})
+
+	client := MQTT.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(30 * time.Second) {
+		client.Disconnect(250)
+		return nil, fmt.Errorf("connection timeout")
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
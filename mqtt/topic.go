@@ -0,0 +1,32 @@
+package mqtt
+
+import (
+	"status-updater/config"
+	"strings"
+)
+
+// Expands the configured status topic template for a device
+func StatusTopic(deviceID, deviceType string) string {
+	cfg := config.Current()
+	template := cfg.MQTT.TopicTemplate
+	if template == "" {
+		template = config.DefaultTopicTemplate
+	}
+
+	replacer := strings.NewReplacer(
+		"{deviceID}", deviceID,
+		"{deviceType}", deviceType,
+		"{site}", cfg.Site,
+	)
+	return replacer.Replace(template)
+}
+
+// Returns a topic under the same root as the status topic, e.g. "<root>/command"
+func DeviceTopic(deviceID, deviceType, name string) string {
+	statusTopic := StatusTopic(deviceID, deviceType)
+	root := strings.TrimSuffix(statusTopic, "/status")
+	if root == statusTopic {
+		return statusTopic + "/" + name
+	}
+	return root + "/" + name
+}
@@ -0,0 +1,218 @@
+package remoteconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"status-updater/config"
+	"status-updater/initialize"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message published to the config ack topic
+type Ack struct {
+	Status     string   `json:"status"`
+	Keys       []string `json:"keys,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+	ConfigHash string   `json:"config_hash"`
+	Date       string   `json:"date"`
+}
+
+// Ack statuses
+const (
+	StatusApplied   = "applied"
+	StatusUnchanged = "unchanged"
+	StatusRejected  = "rejected"
+	StatusError     = "error"
+)
+
+// Keys a remote patch may set; credentials, URLs, paths and broker settings are never accepted. Gatherer toggles
+// (gatherers.<name>) are checked against config.GathererNames.
+var permittedKeys = map[string]bool{
+	"sleep_interval":             true,
+	"sleep_interval_jitter_pct":  true,
+	"full_sync_interval":         true,
+	"log.level":                  true,
+	"backoff.idle_cycles":        true,
+	"backoff.max_interval":       true,
+	"backoff.heartbeat_interval": true,
+	"events.check_interval":      true,
+	"events.cooldown":            true,
+	"events.temp_above":          true,
+	"events.disk_above_pct":      true,
+	"events.signal_below_pct":    true,
+	"events.service_inactive":    true,
+	"events.vpn_down":            true,
+	"events.storage_critical":    true,
+	"events.mains_lost":          true,
+}
+
+// Patches are applied one at a time, each reading the file the previous one wrote
+var applyMutex sync.Mutex
+
+// Applies a patch received on the config set topic: permitted keys are merged into the config file, which is
+// checked, written and reloaded through reload, then acknowledged through respond
+func Handle(payload []byte, reload func() error, respond func(Ack) error) {
+	// Clearing the retained message delivers an empty one
+	if len(bytes.TrimSpace(payload)) == 0 {
+		return
+	}
+
+	applyMutex.Lock()
+	ack := apply(payload, reload)
+	applyMutex.Unlock()
+	ack.ConfigHash = config.Current().Hash
+	ack.Date = time.Now().UTC().Format(time.RFC3339)
+	if ack.Status == StatusRejected || ack.Status == StatusError {
+		logger.LogMessage("WARN", fmt.Sprintf("Remote config patch %s: %s", ack.Status, ack.Reason))
+	}
+	if err := respond(ack); err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to acknowledge remote config patch: %v", err))
+	}
+}
+
+func apply(payload []byte, reload func() error) Ack {
+	var patch map[string]interface{}
+	if err := json.Unmarshal(payload, &patch); err != nil {
+		return Ack{Status: StatusRejected, Reason: fmt.Sprintf("patch is not a JSON object: %v", err)}
+	}
+	keys, err := checkPatch(patch, "")
+	if err != nil {
+		return Ack{Status: StatusRejected, Reason: err.Error()}
+	}
+	if len(keys) == 0 {
+		return Ack{Status: StatusRejected, Reason: "patch sets no keys"}
+	}
+
+	current, err := os.ReadFile(config.Path)
+	if err != nil {
+		return Ack{Status: StatusError, Keys: keys, Reason: fmt.Sprintf("failed to read %s: %v", config.Path, err)}
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(current, &document); err != nil {
+		return Ack{Status: StatusError, Keys: keys, Reason: fmt.Sprintf("failed to decode %s: %v", config.Path, err)}
+	}
+	before, _ := json.Marshal(document)
+	merge(document, patch)
+	after, _ := json.Marshal(document)
+
+	// A retained patch is delivered again on every reconnect
+	if bytes.Equal(before, after) {
+		return Ack{Status: StatusUnchanged, Keys: keys}
+	}
+
+	data, err := json.MarshalIndent(document, "", "    ")
+	if err != nil {
+		return Ack{Status: StatusError, Keys: keys, Reason: fmt.Sprintf("failed to encode config: %v", err)}
+	}
+	data = append(data, '\n')
+	if err := initialize.CheckConfig(data); err != nil {
+		return Ack{Status: StatusRejected, Keys: keys, Reason: err.Error()}
+	}
+	if err := writeFile(config.Path, data); err != nil {
+		return Ack{Status: StatusError, Keys: keys, Reason: err.Error()}
+	}
+
+	logger.LogMessage("INFO", fmt.Sprintf("Remote config patch written to %s: %s", config.Path, strings.Join(keys, ", ")))
+	if err := reload(); err != nil {
+		return Ack{Status: StatusError, Keys: keys, Reason: fmt.Sprintf("config written but not reloaded: %v", err)}
+	}
+	return Ack{Status: StatusApplied, Keys: keys}
+}
+
+// Returns the dotted keys a patch sets, or an error naming the first one that isn't permitted
+func checkPatch(patch map[string]interface{}, prefix string) ([]string, error) {
+	var keys []string
+	for name, value := range patch {
+		key := prefix + name
+		if nested, ok := value.(map[string]interface{}); ok && !permitted(key) {
+			sub, err := checkPatch(nested, key+".")
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, sub...)
+			continue
+		}
+		if !permitted(key) {
+			return nil, fmt.Errorf("key %s is not permitted", key)
+		}
+		if value == nil {
+			return nil, fmt.Errorf("key %s can't be removed", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func permitted(key string) bool {
+	if permittedKeys[key] {
+		return true
+	}
+	if name, ok := strings.CutPrefix(key, "gatherers."); ok {
+		for _, gatherer := range config.GathererNames {
+			if name == gatherer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Copies the patch into the config document, creating intermediate objects as needed
+func merge(document, patch map[string]interface{}) {
+	for name, value := range patch {
+		nested, isObject := value.(map[string]interface{})
+		existing, hasObject := document[name].(map[string]interface{})
+		switch {
+		case isObject && hasObject:
+			merge(existing, nested)
+		case isObject:
+			created := make(map[string]interface{})
+			merge(created, nested)
+			document[name] = created
+		default:
+			document[name] = value
+		}
+	}
+}
+
+// Replaces the config file atomically, keeping its permissions
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary config file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary config file: %v", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set config file permissions: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary config file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary config file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package remoteconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"status-updater/config"
+	"strings"
+	"testing"
+)
+
+// Decodes a patch the way apply does
+func patchOf(t *testing.T, payload string) map[string]interface{} {
+	t.Helper()
+	var patch map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &patch); err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
+
+func TestCheckPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "permitted keys",
+			patch: `{"sleep_interval": "5m", "log": {"level": "DEBUG"}, "events": {"temp_above": 80, "vpn_down": true}}`,
+			want:  []string{"events.temp_above", "events.vpn_down", "log.level", "sleep_interval"},
+		},
+		{
+			name:  "gatherer toggles",
+			patch: `{"gatherers": {"wifi": false, "modem": true}}`,
+			want:  []string{"gatherers.modem", "gatherers.wifi"},
+		},
+		{
+			name:    "unknown gatherer",
+			patch:   `{"gatherers": {"wifi": false, "bluetooth": true}}`,
+			wantErr: "key gatherers.bluetooth is not permitted",
+		},
+		{
+			name:    "broker",
+			patch:   `{"mqtt": {"broker": "attacker.example.com"}}`,
+			wantErr: "key mqtt.broker is not permitted",
+		},
+		{
+			name:    "broker list",
+			patch:   `{"mqtt": {"brokers": ["attacker.example.com:8883"]}}`,
+			wantErr: "key mqtt.brokers is not permitted",
+		},
+		{
+			name:    "password",
+			patch:   `{"mqtt": {"password": "guessed"}}`,
+			wantErr: "key mqtt.password is not permitted",
+		},
+		{
+			name:    "updater password",
+			patch:   `{"updater_service": {"password": "guessed"}}`,
+			wantErr: "key updater_service.password is not permitted",
+		},
+		{
+			name:    "updater URL",
+			patch:   `{"updater_service": {"metadata_url": "https://attacker.example.com/meta.json"}}`,
+			wantErr: "key updater_service.metadata_url is not permitted",
+		},
+		{
+			name:    "fallback token",
+			patch:   `{"fallback": {"token": "guessed"}}`,
+			wantErr: "key fallback.token is not permitted",
+		},
+		{
+			name:    "credential next to a permitted key",
+			patch:   `{"log": {"level": "DEBUG", "file": "/etc/shadow"}}`,
+			wantErr: "key log.file is not permitted",
+		},
+		{
+			name:    "whole section replaced",
+			patch:   `{"mqtt": "attacker.example.com"}`,
+			wantErr: "key mqtt is not permitted",
+		},
+		{
+			name:    "permitted key removed",
+			patch:   `{"sleep_interval": null}`,
+			wantErr: "key sleep_interval can't be removed",
+		},
+		{
+			name:    "nested permitted key removed",
+			patch:   `{"log": {"level": null}}`,
+			wantErr: "key log.level can't be removed",
+		},
+		{
+			name:    "section removed",
+			patch:   `{"mqtt": null}`,
+			wantErr: "key mqtt is not permitted",
+		},
+		{
+			name:  "empty",
+			patch: `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := checkPatch(patchOf(t, tt.patch), "")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("checkPatch = %v, %v, want error %q", keys, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkPatch: %v", err)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys = %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	tests := map[string]bool{
+		"sleep_interval":               true,
+		"log.level":                    true,
+		"backoff.heartbeat_interval":   true,
+		"events.mains_lost":            true,
+		"gatherers.wifi":               true,
+		"gatherers.storage_health":     true,
+		"gatherers.bluetooth":          false,
+		"gatherers":                    false,
+		"log":                          false,
+		"log.file":                     false,
+		"mqtt.broker":                  false,
+		"mqtt.brokers":                 false,
+		"mqtt.port":                    false,
+		"mqtt.username":                false,
+		"mqtt.password":                false,
+		"mqtt.password_file":           false,
+		"ca_file":                      false,
+		"state_dir":                    false,
+		"updater_service.metadata_url": false,
+		"updater_service.password":     false,
+		"fallback.url":                 false,
+		"fallback.token":               false,
+	}
+	for key, want := range tests {
+		if got := permitted(key); got != want {
+			t.Errorf("permitted(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+	}{
+		{
+			name:     "top-level key replaced",
+			document: `{"sleep_interval": "60s", "mqtt": {"broker": "broker.example.com"}}`,
+			patch:    `{"sleep_interval": "5m"}`,
+			want:     `{"sleep_interval": "5m", "mqtt": {"broker": "broker.example.com"}}`,
+		},
+		{
+			name:     "nested key merged into its section",
+			document: `{"log": {"level": "INFO", "file": "/var/log/status-updater.log"}}`,
+			patch:    `{"log": {"level": "DEBUG"}}`,
+			want:     `{"log": {"level": "DEBUG", "file": "/var/log/status-updater.log"}}`,
+		},
+		{
+			name:     "missing section created",
+			document: `{"sleep_interval": "60s"}`,
+			patch:    `{"gatherers": {"wifi": false}}`,
+			want:     `{"sleep_interval": "60s", "gatherers": {"wifi": false}}`,
+		},
+		{
+			name:     "non-object value replaced by a section",
+			document: `{"events": true}`,
+			patch:    `{"events": {"temp_above": 80}}`,
+			want:     `{"events": {"temp_above": 80}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := patchOf(t, tt.document)
+			merge(document, patchOf(t, tt.patch))
+			if want := patchOf(t, tt.want); !reflect.DeepEqual(document, want) {
+				t.Errorf("merged = %v, want %v", document, want)
+			}
+		})
+	}
+}
+
+func TestApplyReload(t *testing.T) {
+	tests := []struct {
+		name       string
+		reloadErr  error
+		wantStatus string
+		wantReason string
+	}{
+		{name: "reloaded", wantStatus: StatusApplied},
+		{name: "reload failed", reloadErr: errors.New("mqtt.password_file: secret file is empty"), wantStatus: StatusError, wantReason: "config written but not reloaded: mqtt.password_file: secret file is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousPath := config.Path
+			config.Path = filepath.Join(t.TempDir(), "config.json")
+			t.Cleanup(func() { config.Path = previousPath })
+			document := `{"mqtt": {"broker": "broker.example.com", "port": 8883, "username": "device", "password": "secret"}, "sleep_interval": "60s"}`
+			if err := os.WriteFile(config.Path, []byte(document), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			reloads := 0
+			ack := apply([]byte(`{"sleep_interval": "5m"}`), func() error {
+				reloads++
+				return tt.reloadErr
+			})
+			if ack.Status != tt.wantStatus || ack.Reason != tt.wantReason {
+				t.Errorf("ack = %s %q, want %s %q", ack.Status, ack.Reason, tt.wantStatus, tt.wantReason)
+			}
+			if reloads != 1 {
+				t.Errorf("reloaded %d times, want once", reloads)
+			}
+			if written, err := os.ReadFile(config.Path); err != nil || !strings.Contains(string(written), `"5m"`) {
+				t.Errorf("config file after the patch = %s, %v, want sleep_interval 5m", written, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package servicewatch
+
+import (
+	"context"
+	"fmt"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/helpers"
+	"status-updater/logger"
+	"sync"
+	"time"
+)
+
+// Poll interval where systemd signals aren't available (Buildroot, no D-Bus)
+const pollInterval = 15 * time.Second
+
+// A monitored service stopping after it was running
+type Transition struct {
+	Service string
+	From    string
+	To      string
+	Time    time.Time
+}
+
+var (
+	stateMutex sync.Mutex
+	running    = make(map[string]bool)
+	stops      = make(map[string]int)
+	lastEvent  = make(map[string]time.Time)
+)
+
+// Watches the monitored services until ctx is cancelled, calling onStop for each running→stopped transition
+// at most once per service_watch.cooldown per service
+func Run(ctx context.Context, onStop func(Transition)) {
+	// Baseline so services that were already down at startup don't raise events
+	states, err := gatherer.GetServiceStates()
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to get initial service states: %s", err))
+	}
+	for _, state := range states {
+		record(state.Name, state.ActiveState, isRunning(state.ActiveState, state.SubState), false, onStop)
+	}
+
+	if services := gatherer.MonitoredServices(); len(services) > 0 {
+		err := helpers.WatchServiceStates(ctx, services, func(name, subState string) {
+			record(name, subState, subState == "running", isStopped("", subState), onStop)
+		})
+		if err == nil {
+			return
+		}
+		logger.LogMessage("WARN", fmt.Sprintf("Service state subscription unavailable (%v), polling every %v", err, pollInterval))
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			states, err := gatherer.GetServiceStates()
+			if err != nil {
+				continue
+			}
+			for _, state := range states {
+				record(state.Name, state.ActiveState, isRunning(state.ActiveState, state.SubState), isStopped(state.ActiveState, state.SubState), onStop)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Returns how often each service stopped unexpectedly since the daemon started
+func StopCounts() map[string]int {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	counts := make(map[string]int, len(stops))
+	for name, count := range stops {
+		counts[name] = count
+	}
+	return counts
+}
+
+func record(name, state string, nowRunning, nowStopped bool, onStop func(Transition)) {
+	now := time.Now()
+
+	stateMutex.Lock()
+	wasRunning := running[name]
+	if nowRunning || nowStopped {
+		running[name] = nowRunning
+	}
+	if !wasRunning || !nowStopped {
+		stateMutex.Unlock()
+		return
+	}
+	stops[name]++
+	cooledDown := now.Sub(lastEvent[name]) >= config.Current().ServiceWatch.Cooldown.Duration()
+	if cooledDown {
+		lastEvent[name] = now
+	}
+	stateMutex.Unlock()
+
+	logger.LogMessage("WARN", fmt.Sprintf("Service %s stopped: %s at %s", name, state, now.UTC().Format(time.RFC3339)))
+	if cooledDown {
+		onStop(Transition{Service: name, From: "active", To: state, Time: now})
+	}
+}
+
+// systemd "active"/"running", or the init.d status on Buildroot
+func isRunning(activeState, subState string) bool {
+	if subState != "" {
+		return subState == "running"
+	}
+	return activeState == "active" || activeState == "running"
+}
+
+// Failed, inactive, or crashed and waiting for systemd's automatic restart
+func isStopped(activeState, subState string) bool {
+	switch subState {
+	case "failed", "dead", "auto-restart":
+		return true
+	}
+	switch activeState {
+	case "failed", "inactive", "stopped":
+		return true
+	}
+	return false
+}
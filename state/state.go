@@ -0,0 +1,121 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"strings"
+	"time"
+)
+
+// Marker left by earlier versions on /var/run, a tmpfs that is cleared on every reboot
+const legacyMarkerPath = "/var/run/status-updater.initialized"
+
+// Location of the persisted publish state inside state_dir
+func FilePath() string {
+	return filepath.Join(config.Current().StateDir, "state.json")
+}
+
+// Location of the marker written once the first-ever startup delay has passed
+func markerPath() string {
+	return filepath.Join(config.Current().StateDir, "initialized")
+}
+
+// Creates state_dir, readable only by the daemon's user and group
+func EnsureDir() error {
+	cfg := config.Current()
+	if err := os.MkdirAll(cfg.StateDir, 0750); err != nil {
+		return fmt.Errorf("failed to create state directory %s: %v", cfg.StateDir, err)
+	}
+	return nil
+}
+
+// Reports whether the daemon has started before on this install; the legacy marker and an existing state file count too
+func Initialized() bool {
+	for _, path := range []string{markerPath(), legacyMarkerPath, FilePath()} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Records that the first-ever startup delay has passed
+func MarkInitialized() error {
+	if err := os.WriteFile(markerPath(), []byte{}, 0644); err != nil {
+		return fmt.Errorf("failed to create initialization marker: %v", err)
+	}
+	return nil
+}
+
+// Publish state kept across restarts so the first cycle doesn't resend unchanged fields
+type State struct {
+	SchemaVersion  int                        `json:"schema_version"`
+	UpdaterVersion string                     `json:"updater_version"`
+	LastFullSync   time.Time                  `json:"last_full_sync"`
+	Seq            uint64                     `json:"seq"`
+	Buffer         map[string]json.RawMessage `json:"buffer"`
+	PublishedAt    map[string]time.Time       `json:"published_at,omitempty"`
+}
+
+// Loads the state file, returning nil when it is missing or corrupt
+func Load() *State {
+	data, err := os.ReadFile(FilePath())
+	if err != nil {
+		return nil
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil
+	}
+	return &s
+}
+
+// Reports whether the buffer was written by the same payload schema and updater version
+func (s *State) Matches(schemaVersion int, updaterVersion string) bool {
+	return s.SchemaVersion == schemaVersion && s.UpdaterVersion == updaterVersion && len(s.Buffer) > 0
+}
+
+// Writes the state file atomically via a temp file and rename
+func Save(s *State) error {
+	return WriteJSON(FilePath(), s)
+}
+
+// Writes v as JSON to path atomically: a temp file in the same directory is synced, then renamed over path
+func WriteJSON(path string, v interface{}) error {
+	name := filepath.Base(path)
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", name, err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+strings.TrimSuffix(name, ".json")+"-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %v", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %v", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", name, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return nil
+}
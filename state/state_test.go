@@ -0,0 +1,69 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"testing"
+	"time"
+)
+
+func TestPublishedAtSurvivesRestart(t *testing.T) {
+	cfg := &config.Config{StateDir: t.TempDir()}
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+
+	sent := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	saved := &State{
+		SchemaVersion: 3,
+		Seq:           200,
+		Buffer:        map[string]json.RawMessage{"modem": json.RawMessage(`{"state":"connected"}`)},
+		PublishedAt:   map[string]time.Time{"modem": sent, "services": sent.Add(-10 * time.Minute)},
+	}
+	if err := Save(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := Load()
+	if loaded == nil {
+		t.Fatal("Load returned nil after Save")
+	}
+	for key, want := range saved.PublishedAt {
+		if got := loaded.PublishedAt[key]; !got.Equal(want) {
+			t.Errorf("published_at[%s] = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	path := filepath.Join(dir, "usage.json")
+	for _, counters := range []map[string]int{{"rx": 1}, {"rx": 2, "tx": 3}} {
+		if err := WriteJSON(path, counters); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var written map[string]int
+		if err := json.Unmarshal(data, &written); err != nil || len(written) != len(counters) || written["rx"] != counters["rx"] {
+			t.Errorf("written = %s, %v, want %v", data, err, counters)
+		}
+	}
+
+	// The temp file is renamed or removed, never left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("state directory holds %d files, want only usage.json", len(entries))
+	}
+
+	if err := WriteJSON(path, func() {}); err == nil {
+		t.Error("WriteJSON of a value that can't be marshalled succeeded")
+	}
+}
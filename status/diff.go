@@ -0,0 +1,245 @@
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"status-updater/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payload fields by JSON key, each holding its compact encoding
+type Fields map[string]json.RawMessage
+
+// Fields included in every publish so the backend can route a diff, besides payload.always_send
+var alwaysIncluded = []string{"status", "deviceID"}
+
+func alwaysSent() []string {
+	return append(append([]string{}, alwaysIncluded...), config.Current().Payload.AlwaysSend...)
+}
+
+func diffIgnored(key string) bool {
+	for _, ignored := range config.Current().Payload.DiffIgnore {
+		if key == ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits the payload into per-key encodings that compare equal across runs and reloads from disk
+func (p *Payload) Fields() (Fields, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal status: %v", err)
+	}
+
+	var fields Fields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to split status into fields: %v", err)
+	}
+	// Before anything is diffed, buffered or persisted
+	return Redact(fields), nil
+}
+
+// Returns the fields of next that differ from prev plus the always-sent ones; fields missing from next are sent as
+// null unless payload.null_removed is off. Numeric fields listed in tolerances only count as changed when they move
+// by at least the tolerance.
+func Diff(prev, next Fields, tolerances map[string]float64) Fields {
+	changed := make(Fields)
+	for key, value := range next {
+		old, ok := prev[key]
+		if ok && sameValue(old, value) {
+			continue
+		}
+		if tolerance, hasTolerance := tolerances[key]; ok && hasTolerance && withinTolerance(old, value, tolerance) {
+			continue
+		}
+		changed[key] = value
+	}
+	if nullRemoved := config.Current().Payload.NullRemoved; nullRemoved == nil || *nullRemoved {
+		for key := range prev {
+			if _, ok := next[key]; !ok {
+				changed[key] = json.RawMessage("null")
+			}
+		}
+	}
+	for _, key := range alwaysSent() {
+		if value, ok := next[key]; ok {
+			changed[key] = value
+		}
+	}
+	return changed
+}
+
+// Fields that change on nearly every cycle and don't count as a change of the device's state
+var volatileFields = map[string]bool{
+	"status":               true,
+	"deviceID":             true,
+	"date":                 true,
+	"uptime":               true,
+	"uptime_seconds":       true,
+	"self":                 true,
+	"interval_seconds":     true,
+	"boot_seconds":         true,
+	"health":               true,
+	"broker_latency_ms":    true,
+	"recent_changes_count": true,
+	"wifi":                 true,
+	"app_checks":           true,
+	"connectivity":         true,
+}
+
+// Returns the fields that changed between prev and next, without the volatile, always-sent and payload.diff_ignore
+// ones that didn't change in their own right
+func Changes(prev, next Fields, tolerances map[string]float64) Fields {
+	changed := Diff(prev, next, tolerances)
+	for key, value := range changed {
+		old, ok := prev[key]
+		if volatileFields[key] || diffIgnored(key) || (ok && sameValue(old, value)) {
+			delete(changed, key)
+		}
+	}
+	return changed
+}
+
+// Adds the unchanged fields of next whose last publish is older than their payload.field_ttl; a field never
+// published counts as expired
+func AddExpired(changed, next Fields, publishedAt map[string]time.Time, now time.Time) {
+	for key, ttl := range config.Current().Payload.FieldTTL {
+		value, ok := next[key]
+		if !ok {
+			continue
+		}
+		if _, included := changed[key]; included {
+			continue
+		}
+		if sent, ok := publishedAt[key]; !ok || now.Sub(sent) >= ttl.Duration() {
+			changed[key] = value
+		}
+	}
+}
+
+// Records when the published fields went out, forgetting fields missing from current
+func TrackPublished(publishedAt map[string]time.Time, published, current Fields, now time.Time) {
+	for key := range published {
+		publishedAt[key] = now
+	}
+	for key := range publishedAt {
+		if _, ok := current[key]; !ok {
+			delete(publishedAt, key)
+		}
+	}
+}
+
+// Reports whether a diff holds anything besides the volatile fields
+func Significant(changed Fields) bool {
+	for key := range changed {
+		if !volatileFields[key] && !diffIgnored(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a diff holds a change worth publishing: any field besides the always-sent ones and payload.diff_ignore
+func Reportable(changed Fields) bool {
+	sent := make(map[string]bool)
+	for _, key := range alwaysSent() {
+		sent[key] = true
+	}
+	for key := range changed {
+		if !sent[key] && !diffIgnored(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merges a published diff into the buffer, dropping fields that were sent as null
+func (f Fields) Apply(changed Fields) {
+	for key, value := range changed {
+		if bytes.Equal(value, []byte("null")) {
+			delete(f, key)
+			continue
+		}
+		f[key] = value
+	}
+}
+
+// Drops fields missing from current, e.g. ones removed in a new version or of a disabled gatherer
+func (f Fields) Prune(current Fields) {
+	for key := range f {
+		if _, ok := current[key]; !ok {
+			delete(f, key)
+		}
+	}
+}
+
+// Compares two encodings by value, so key order, whitespace or JSON carried in a string such as an older
+// ip_addresses encoding doesn't count as a change
+func sameValue(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	canonicalA, okA := canonical(a)
+	canonicalB, okB := canonical(b)
+	return okA && okB && bytes.Equal(canonicalA, canonicalB)
+}
+
+// Re-encodes a value with sorted keys, decoding a string that holds a JSON object or array first
+func canonical(raw json.RawMessage) ([]byte, bool) {
+	var value interface{}
+	if json.Unmarshal(raw, &value) != nil {
+		return nil, false
+	}
+	if text, ok := value.(string); ok {
+		trimmed := strings.TrimSpace(text)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var inner interface{}
+			if json.Unmarshal([]byte(trimmed), &inner) == nil {
+				value = inner
+			}
+		}
+	}
+	data, err := json.Marshal(value)
+	return data, err == nil
+}
+
+// Numbers, or objects of numbers with the same keys such as temperatures, that all moved less than tolerance
+func withinTolerance(old, value json.RawMessage, tolerance float64) bool {
+	a, okA := numericValue(old)
+	b, okB := numericValue(value)
+	if okA && okB {
+		return math.Abs(a-b) < tolerance
+	}
+
+	var oldMap, newMap map[string]float64
+	if json.Unmarshal(old, &oldMap) != nil || json.Unmarshal(value, &newMap) != nil || len(oldMap) != len(newMap) {
+		return false
+	}
+	for key, b := range newMap {
+		a, ok := oldMap[key]
+		if !ok || math.Abs(a-b) >= tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// Reads a JSON number or a numeric string such as the legacy "48.31" temperature
+func numericValue(raw json.RawMessage) (float64, bool) {
+	var number float64
+	if json.Unmarshal(raw, &number) == nil {
+		return number, true
+	}
+	var text string
+	if json.Unmarshal(raw, &text) != nil {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	return number, err == nil
+}
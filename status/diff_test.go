@@ -0,0 +1,350 @@
+package status
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"status-updater/config"
+	"testing"
+	"time"
+)
+
+// Puts cfg in effect for the test, restoring the previous config afterwards
+func useConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+// Builds Fields from JSON encodings by key
+func fieldsOf(encodings map[string]string) Fields {
+	fields := make(Fields, len(encodings))
+	for key, value := range encodings {
+		fields[key] = json.RawMessage(value)
+	}
+	return fields
+}
+
+func keysOf(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDiff(t *testing.T) {
+	base := map[string]string{
+		"status":   `"Online"`,
+		"deviceID": `"b8:27:eb:12:34:56"`,
+		"uptime":   `"1h0m0s"`,
+		"modem":    `{"manufacturer":"QUALCOMM","signal_quality":"67","state":"connected"}`,
+	}
+	with := func(changes map[string]string) Fields {
+		encodings := make(map[string]string, len(base))
+		for key, value := range base {
+			encodings[key] = value
+		}
+		for key, value := range changes {
+			if value == "" {
+				delete(encodings, key)
+				continue
+			}
+			encodings[key] = value
+		}
+		return fieldsOf(encodings)
+	}
+
+	tests := []struct {
+		name string
+		prev Fields
+		next Fields
+		want map[string]string
+	}{
+		{
+			name: "first run sends everything",
+			prev: Fields{},
+			next: with(nil),
+			want: base,
+		},
+		{
+			name: "nothing changed sends the always included fields",
+			prev: with(nil),
+			next: with(nil),
+			want: map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`},
+		},
+		{
+			name: "top-level change",
+			prev: with(nil),
+			next: with(map[string]string{"uptime": `"1h5m0s"`}),
+			want: map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`, "uptime": `"1h5m0s"`},
+		},
+		{
+			name: "nested raw message with reordered keys is unchanged",
+			prev: with(nil),
+			next: with(map[string]string{"modem": `{"state":"connected", "signal_quality":"67", "manufacturer":"QUALCOMM"}`}),
+			want: map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`},
+		},
+		{
+			name: "nested raw message change sends the whole object",
+			prev: with(nil),
+			next: with(map[string]string{"modem": `{"manufacturer":"QUALCOMM","signal_quality":"40","state":"connected"}`}),
+			want: map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`,
+				"modem": `{"manufacturer":"QUALCOMM","signal_quality":"40","state":"connected"}`},
+		},
+		{
+			name: "removed field is sent as null",
+			prev: with(nil),
+			next: with(map[string]string{"modem": ""}),
+			want: map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`, "modem": `null`},
+		},
+		{
+			name: "added field",
+			prev: with(nil),
+			next: with(map[string]string{"wan_ip": `"203.0.113.7"`}),
+			want: map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`, "wan_ip": `"203.0.113.7"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, &config.Config{})
+			got := Diff(tt.prev, tt.next, nil)
+			if want := fieldsOf(tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("Diff = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDiffWithoutNullRemoved(t *testing.T) {
+	cfg := &config.Config{}
+	nullRemoved := false
+	cfg.Payload.NullRemoved = &nullRemoved
+	useConfig(t, cfg)
+
+	prev := fieldsOf(map[string]string{"status": `"Online"`, "modem": `{"state":"connected"}`})
+	next := fieldsOf(map[string]string{"status": `"Online"`})
+	if got := keysOf(Diff(prev, next, nil)); !reflect.DeepEqual(got, []string{"status"}) {
+		t.Errorf("Diff keys = %v, want only status with payload.null_removed off", got)
+	}
+}
+
+func TestDiffTolerance(t *testing.T) {
+	useConfig(t, &config.Config{})
+	tolerances := map[string]float64{"temp": 1, "temperatures": 1}
+
+	prev := fieldsOf(map[string]string{"temp": `"48.31"`, "temperatures": `{"cpu":48.3,"modem":40.1}`})
+	small := fieldsOf(map[string]string{"temp": `"48.90"`, "temperatures": `{"cpu":48.9,"modem":40.5}`})
+	if got := Diff(prev, small, tolerances); len(got) != 0 {
+		t.Errorf("Diff within tolerance = %s, want no changes", got)
+	}
+
+	large := fieldsOf(map[string]string{"temp": `"49.50"`, "temperatures": `{"cpu":48.9,"modem":42.0}`})
+	if got := keysOf(Diff(prev, large, tolerances)); !reflect.DeepEqual(got, []string{"temp", "temperatures"}) {
+		t.Errorf("Diff keys beyond tolerance = %v, want temp and temperatures", got)
+	}
+}
+
+func TestApplyAndPrune(t *testing.T) {
+	buffer := fieldsOf(map[string]string{"status": `"Online"`, "modem": `{"state":"connected"}`, "wifi_ssid": `"office"`})
+	buffer.Apply(fieldsOf(map[string]string{"modem": `null`, "uptime": `"2h"`}))
+	if got := keysOf(buffer); !reflect.DeepEqual(got, []string{"status", "uptime", "wifi_ssid"}) {
+		t.Errorf("buffer keys after Apply = %v, want modem dropped and uptime added", got)
+	}
+
+	buffer.Prune(fieldsOf(map[string]string{"status": `"Online"`, "uptime": `"2h"`}))
+	if got := keysOf(buffer); !reflect.DeepEqual(got, []string{"status", "uptime"}) {
+		t.Errorf("buffer keys after Prune = %v, want wifi_ssid dropped", got)
+	}
+}
+
+func TestIPAddressesEncodingDrift(t *testing.T) {
+	useConfig(t, &config.Config{})
+	array := `[{"interface":"eth0","ip":"192.168.1.20"},{"interface":"wwan0","ip":"10.64.3.2"}]`
+
+	tests := []struct {
+		name    string
+		prev    string
+		next    string
+		changed bool
+	}{
+		{"string holding the array vs the array", `"[{\"interface\":\"eth0\",\"ip\":\"192.168.1.20\"},{\"interface\":\"wwan0\",\"ip\":\"10.64.3.2\"}]"`, array, false},
+		{"array vs string holding the array", array, `"[{\"interface\":\"eth0\",\"ip\":\"192.168.1.20\"},{\"interface\":\"wwan0\",\"ip\":\"10.64.3.2\"}]"`, false},
+		{"reordered keys and whitespace", `[ {"ip":"192.168.1.20", "interface":"eth0"}, {"ip":"10.64.3.2", "interface":"wwan0"} ]`, array, false},
+		{"string holding a different address", `"[{\"interface\":\"eth0\",\"ip\":\"192.168.1.21\"},{\"interface\":\"wwan0\",\"ip\":\"10.64.3.2\"}]"`, array, true},
+		{"interface order changed", `[{"interface":"wwan0","ip":"10.64.3.2"},{"interface":"eth0","ip":"192.168.1.20"}]`, array, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := fieldsOf(map[string]string{"ip_addresses": tt.prev})
+			next := fieldsOf(map[string]string{"ip_addresses": tt.next})
+			if _, changed := Diff(prev, next, nil)["ip_addresses"]; changed != tt.changed {
+				t.Errorf("ip_addresses in Diff = %v, want %v", changed, tt.changed)
+			}
+			if changed := len(Changes(prev, next, nil)) > 0; changed != tt.changed {
+				t.Errorf("ip_addresses in Changes = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestDateOnlyChangeIsNotReportable(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Payload.AlwaysSend = []string{"date"}
+	cfg.Payload.DiffIgnore = []string{"date"}
+	useConfig(t, cfg)
+
+	prev := fieldsOf(map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`, "date": `"2026-10-15T09:00:00Z"`, "temp": `"48.31"`})
+	dateOnly := fieldsOf(map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`, "date": `"2026-10-15T09:01:00Z"`, "temp": `"48.31"`})
+
+	changed := Diff(prev, dateOnly, nil)
+	if got := keysOf(changed); !reflect.DeepEqual(got, []string{"date", "deviceID", "status"}) {
+		t.Errorf("Diff keys = %v, want the always-sent fields only", got)
+	}
+	if Reportable(changed) {
+		t.Error("a date-only change is reportable")
+	}
+	if Significant(changed) {
+		t.Error("a date-only change is significant")
+	}
+	if got := Changes(prev, dateOnly, nil); len(got) != 0 {
+		t.Errorf("Changes = %s, want none for a date-only change", got)
+	}
+
+	withTemp := fieldsOf(map[string]string{"status": `"Online"`, "deviceID": `"b8:27:eb:12:34:56"`, "date": `"2026-10-15T09:01:00Z"`, "temp": `"52.00"`})
+	changed = Diff(prev, withTemp, nil)
+	if !Reportable(changed) || !Significant(changed) {
+		t.Error("a temp change next to the date is not reportable")
+	}
+}
+
+func TestAlwaysSendWithoutDiffIgnore(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Payload.AlwaysSend = []string{"temp"}
+	useConfig(t, cfg)
+
+	fields := fieldsOf(map[string]string{"status": `"Online"`, "date": `"2026-10-15T09:00:00Z"`, "temp": `"48.31"`})
+	changed := Diff(fields, fields, nil)
+	if got := keysOf(changed); !reflect.DeepEqual(got, []string{"status", "temp"}) {
+		t.Errorf("Diff keys = %v, want status and the always-sent temp", got)
+	}
+	if Reportable(changed) {
+		t.Error("an unchanged always-sent field is reportable")
+	}
+}
+
+func TestFieldTTL(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Payload.FieldTTL = map[string]config.Duration{
+		"modem":    config.Duration(time.Hour),
+		"services": config.Duration(30 * time.Minute),
+	}
+	useConfig(t, cfg)
+
+	start := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	fields := fieldsOf(map[string]string{
+		"status":   `"Online"`,
+		"modem":    `{"state":"connected"}`,
+		"services": `[{"name":"sos-web","active":"active"}]`,
+		"uptime":   `"1h"`,
+	})
+
+	t.Run("never published counts as expired", func(t *testing.T) {
+		changed := Fields{}
+		AddExpired(changed, fields, map[string]time.Time{}, start)
+		if got := keysOf(changed); !reflect.DeepEqual(got, []string{"modem", "services"}) {
+			t.Errorf("expired keys = %v, want modem and services", got)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		publishedAt := map[string]time.Time{}
+		TrackPublished(publishedAt, fields, fields, start)
+
+		changed := Fields{}
+		AddExpired(changed, fields, publishedAt, start.Add(29*time.Minute))
+		if len(changed) != 0 {
+			t.Errorf("expired before any TTL passed: %v", keysOf(changed))
+		}
+
+		AddExpired(changed, fields, publishedAt, start.Add(30*time.Minute))
+		if got := keysOf(changed); !reflect.DeepEqual(got, []string{"services"}) {
+			t.Errorf("expired keys after 30m = %v, want services", got)
+		}
+
+		changed = Fields{}
+		AddExpired(changed, fields, publishedAt, start.Add(time.Hour))
+		if got := keysOf(changed); !reflect.DeepEqual(got, []string{"modem", "services"}) {
+			t.Errorf("expired keys after 1h = %v, want modem and services", got)
+		}
+	})
+
+	t.Run("reset on change", func(t *testing.T) {
+		publishedAt := map[string]time.Time{}
+		TrackPublished(publishedAt, fields, fields, start)
+
+		// The modem changed after 50 minutes and went out in a diff
+		later := start.Add(50 * time.Minute)
+		TrackPublished(publishedAt, Fields{"modem": fields["modem"]}, fields, later)
+
+		changed := Fields{}
+		AddExpired(changed, fields, publishedAt, start.Add(time.Hour))
+		if _, ok := changed["modem"]; ok {
+			t.Error("modem expired an hour after the first publish although it was sent again since")
+		}
+		changed = Fields{}
+		AddExpired(changed, fields, publishedAt, later.Add(time.Hour))
+		if _, ok := changed["modem"]; !ok {
+			t.Error("modem didn't expire an hour after its last publish")
+		}
+	})
+
+	t.Run("changed field is kept as is", func(t *testing.T) {
+		changedModem := json.RawMessage(`{"state":"registered"}`)
+		changed := Fields{"modem": changedModem}
+		AddExpired(changed, fields, map[string]time.Time{}, start)
+		if string(changed["modem"]) != string(changedModem) {
+			t.Errorf("modem = %s, want the changed value kept", changed["modem"])
+		}
+	})
+
+	t.Run("full sync restarts every timer", func(t *testing.T) {
+		publishedAt := map[string]time.Time{}
+		TrackPublished(publishedAt, fields, fields, start)
+
+		// A full sync publishes every field, just before services would have expired
+		fullSync := start.Add(25 * time.Minute)
+		TrackPublished(publishedAt, fields, fields, fullSync)
+
+		changed := Fields{}
+		AddExpired(changed, fields, publishedAt, start.Add(30*time.Minute))
+		if len(changed) != 0 {
+			t.Errorf("expired right after a full sync: %v", keysOf(changed))
+		}
+		AddExpired(changed, fields, publishedAt, fullSync.Add(30*time.Minute))
+		if got := keysOf(changed); !reflect.DeepEqual(got, []string{"services"}) {
+			t.Errorf("expired keys 30m after the full sync = %v, want services", got)
+		}
+	})
+
+	t.Run("missing field is forgotten", func(t *testing.T) {
+		publishedAt := map[string]time.Time{}
+		TrackPublished(publishedAt, fields, fields, start)
+
+		withoutModem := fieldsOf(map[string]string{"status": `"Online"`, "services": `[]`})
+		TrackPublished(publishedAt, Fields{"modem": json.RawMessage("null")}, withoutModem, start.Add(time.Minute))
+		if _, ok := publishedAt["modem"]; ok {
+			t.Error("publish time of a removed field is still tracked")
+		}
+
+		changed := Fields{}
+		AddExpired(changed, withoutModem, publishedAt, start.Add(2*time.Hour))
+		if _, ok := changed["modem"]; ok {
+			t.Error("a field missing from the payload was added as expired")
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package status
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Bumped whenever payload field semantics change, so the backend can tell firmware generations apart
+const SchemaVersion = 2
+
+// Fields added to every status message
+//
+// Seq increases by one per publish attempt and keeps increasing across restarts, see Sequence. It only
+// starts over at 1 when the state file is lost or corrupt, or after wrapping around past the uint64 maximum;
+// the backend should then accept a lower seq from a message whose uptime_seconds is lower too.
+type Metadata struct {
+	SchemaVersion int    `json:"schema_version"`
+	Seq           uint64 `json:"seq"`
+	MsgID         string `json:"msg_id"`
+}
+
+func NewMetadata(seq uint64) (Metadata, error) {
+	id, err := newUUID()
+	if err != nil {
+		return Metadata{}, err
+	}
+	return Metadata{SchemaVersion: SchemaVersion, Seq: seq, MsgID: id}, nil
+}
+
+// Sequence numbers reserved at a time
+const SeqBlock = 100
+
+// Hands out message sequence numbers, reserving them in blocks of SeqBlock so the state file is written once per
+// block rather than for every message. After a restart numbering continues past the last reserved block, so the
+// unused rest of a block is skipped but no number is ever reused.
+type Sequence struct {
+	last     uint64
+	reserved uint64
+}
+
+// Starts numbering after reserved, the highest number persisted before the restart
+func NewSequence(reserved uint64) *Sequence {
+	return &Sequence{last: reserved, reserved: reserved}
+}
+
+// Returns the next sequence number, and true when it starts a new block whose end (Reserved) must be persisted
+// before the number is used. Past math.MaxUint64 numbering wraps around to 1.
+func (s *Sequence) Next() (uint64, bool) {
+	s.last++
+	if s.last == 0 {
+		s.last, s.reserved = 1, 0
+	}
+	if s.last <= s.reserved {
+		return s.last, false
+	}
+	s.reserved = s.last + SeqBlock - 1
+	if s.reserved < s.last {
+		s.reserved = math.MaxUint64
+	}
+	return s.last, true
+}
+
+// Highest sequence number reserved so far, the one to persist
+func (s *Sequence) Reserved() uint64 {
+	return s.reserved
+}
+
+// Returns a copy of fields with the metadata added
+func (m Metadata) Apply(fields Fields) Fields {
+	message := make(Fields, len(fields)+3)
+	for key, value := range fields {
+		message[key] = value
+	}
+	message["schema_version"], _ = json.Marshal(m.SchemaVersion)
+	message["seq"], _ = json.Marshal(m.Seq)
+	message["msg_id"], _ = json.Marshal(m.MsgID)
+	return message
+}
+
+// Random (version 4) UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate message ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,90 @@
+package status
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"testing"
+)
+
+func TestSequenceIsMonotonic(t *testing.T) {
+	seq := NewSequence(0)
+	var previous uint64
+	blocks := 0
+	for i := 0; i < 3*SeqBlock; i++ {
+		n, newBlock := seq.Next()
+		if n != previous+1 {
+			t.Fatalf("Next = %d after %d, want %d", n, previous, previous+1)
+		}
+		if newBlock {
+			blocks++
+		}
+		if seq.Reserved() < n {
+			t.Fatalf("Reserved = %d below the handed out %d", seq.Reserved(), n)
+		}
+		previous = n
+	}
+	if blocks != 3 {
+		t.Errorf("reserved %d blocks for %d numbers, want 3", blocks, 3*SeqBlock)
+	}
+}
+
+func TestSequenceContinuesAfterRestart(t *testing.T) {
+	before := NewSequence(0)
+	var lastUsed uint64
+	for i := 0; i < 10; i++ {
+		lastUsed, _ = before.Next()
+	}
+
+	// Only the reserved block end was persisted
+	after := NewSequence(before.Reserved())
+	n, newBlock := after.Next()
+	if n <= lastUsed {
+		t.Errorf("first number after restart %d, want above %d used before", n, lastUsed)
+	}
+	if !newBlock {
+		t.Error("first number after restart did not reserve a new block")
+	}
+}
+
+func TestSequenceWrapsAround(t *testing.T) {
+	seq := NewSequence(math.MaxUint64 - 2)
+
+	n, newBlock := seq.Next()
+	if n != math.MaxUint64-1 || !newBlock {
+		t.Fatalf("Next = %d, %v, want %d and a new block", n, newBlock, uint64(math.MaxUint64-1))
+	}
+	if seq.Reserved() != math.MaxUint64 {
+		t.Errorf("Reserved = %d, want the block capped at math.MaxUint64", seq.Reserved())
+	}
+	if n, newBlock = seq.Next(); n != math.MaxUint64 || newBlock {
+		t.Fatalf("Next = %d, %v, want math.MaxUint64 within the block", n, newBlock)
+	}
+
+	n, newBlock = seq.Next()
+	if n != 1 || !newBlock {
+		t.Errorf("Next past math.MaxUint64 = %d, %v, want 1 and a new block", n, newBlock)
+	}
+	if seq.Reserved() != SeqBlock {
+		t.Errorf("Reserved after wrapping = %d, want %d", seq.Reserved(), SeqBlock)
+	}
+}
+
+func TestMetadataApply(t *testing.T) {
+	metadata, err := NewMetadata(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(metadata.MsgID) {
+		t.Errorf("msg_id %q is not a version 4 UUID", metadata.MsgID)
+	}
+
+	fields := Fields{"status": json.RawMessage(`"Online"`)}
+	message := metadata.Apply(fields)
+	if string(message["seq"]) != "42" || string(message["status"]) != `"Online"` {
+		t.Errorf("message = %s", message)
+	}
+	if _, ok := fields["seq"]; ok {
+		t.Error("Apply modified the fields it was given")
+	}
+}
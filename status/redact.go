@@ -0,0 +1,84 @@
+package status
+
+import (
+	"encoding/json"
+	"status-updater/config"
+	"strings"
+)
+
+// Value sent in place of a field listed in payload.redact
+const Redacted = "REDACTED"
+
+// Fields that can't be redacted, the backend routes on them
+var unredactable = map[string]bool{"status": true, "deviceID": true}
+
+// Replaces or, with payload.redact_omit, drops every field listed in payload.redact; a dotted path such as
+// "modem.imsi" reaches into nested objects. Fields is modified in place and returned.
+func Redact(fields Fields) Fields {
+	for _, path := range config.Current().Payload.Redact {
+		key, rest, nested := strings.Cut(path, ".")
+		value, ok := fields[key]
+		if !ok || unredactable[key] {
+			continue
+		}
+		if !nested {
+			redactKey(fields, key)
+			continue
+		}
+		if redacted, ok := redactPath(value, rest); ok {
+			fields[key] = redacted
+		}
+	}
+	return fields
+}
+
+// Redacts path inside a JSON object, reporting false when the value isn't an object or lacks the path
+func redactPath(value json.RawMessage, path string) (json.RawMessage, bool) {
+	var object Fields
+	if err := json.Unmarshal(value, &object); err != nil || object == nil {
+		return nil, false
+	}
+
+	key, rest, nested := strings.Cut(path, ".")
+	inner, ok := object[key]
+	if !ok {
+		return nil, false
+	}
+	if nested {
+		if object[key], ok = redactPath(inner, rest); !ok {
+			return nil, false
+		}
+	} else {
+		redactKey(object, key)
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func redactKey(fields Fields, key string) {
+	if config.Current().Payload.RedactOmit {
+		delete(fields, key)
+		return
+	}
+	fields[key] = json.RawMessage(`"` + Redacted + `"`)
+}
+
+// Applies Redact to any JSON object message, e.g. an event, so redacted fields stay off every topic
+func RedactMessage(message []byte) []byte {
+	if len(config.Current().Payload.Redact) == 0 {
+		return message
+	}
+	var fields Fields
+	if err := json.Unmarshal(message, &fields); err != nil || fields == nil {
+		return message
+	}
+	data, err := json.Marshal(Redact(fields))
+	if err != nil {
+		return message
+	}
+	return data
+}
@@ -0,0 +1,98 @@
+package status
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"status-updater/initialize"
+	"testing"
+)
+
+// Writes a config file with the given payload section, as a config push would
+func writeConfigFile(t *testing.T, path, payload string) {
+	t.Helper()
+	data := `{
+  "mqtt": {"broker": "broker.example.com", "username": "device", "password": "secret"},
+  "state_dir": "` + filepath.Dir(path) + `",
+  "payload": ` + payload + `
+}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ignoreWarnings(t *testing.T, err error) {
+	t.Helper()
+	var validationErr *config.ValidationError
+	if err != nil && (!errors.As(err, &validationErr) || validationErr.IsFatal()) {
+		t.Fatal(err)
+	}
+}
+
+func redactedFields() Fields {
+	return Redact(fieldsOf(map[string]string{
+		"wifi_ssid": `"office-guest"`,
+		"modem":     `{"imsi":"204081234567890","state":"connected"}`,
+		"hostname":  `"hc925"`,
+	}))
+}
+
+func TestRedactSurvivesReload(t *testing.T) {
+	previous, previousPath := config.Current(), config.Path
+	t.Cleanup(func() { config.Set(previous); config.Path = previousPath })
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, `{"redact": ["wifi_ssid", "modem.imsi"]}`)
+	ignoreWarnings(t, initialize.LoadConfig(path))
+
+	want := fieldsOf(map[string]string{
+		"wifi_ssid": `"REDACTED"`,
+		"modem":     `{"imsi":"REDACTED","state":"connected"}`,
+		"hostname":  `"hc925"`,
+	})
+	if got := redactedFields(); !sameFields(got, want) {
+		t.Fatalf("after load: %s, want %s", got, want)
+	}
+
+	// A reload of an otherwise changed file keeps the list
+	writeConfigFile(t, path, `{"redact": ["wifi_ssid", "modem.imsi"], "temp_threshold": 2}`)
+	ignoreWarnings(t, initialize.ReloadConfig())
+	if got := redactedFields(); !sameFields(got, want) {
+		t.Errorf("after reload: %s, want %s", got, want)
+	}
+
+	// A fatally invalid file keeps the config in effect
+	if err := os.WriteFile(path, []byte(`{"payload": {"redact": [`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := initialize.ReloadConfig(); err == nil {
+		t.Fatal("reload of a broken file succeeded")
+	}
+	if got := redactedFields(); !sameFields(got, want) {
+		t.Errorf("after a failed reload: %s, want %s", got, want)
+	}
+
+	// Switching to omitting takes effect on reload too
+	writeConfigFile(t, path, `{"redact": ["wifi_ssid", "modem.imsi"], "redact_omit": true}`)
+	ignoreWarnings(t, initialize.ReloadConfig())
+	omitted := fieldsOf(map[string]string{
+		"modem":    `{"state":"connected"}`,
+		"hostname": `"hc925"`,
+	})
+	if got := redactedFields(); !sameFields(got, omitted) {
+		t.Errorf("after reload with redact_omit: %s, want %s", got, omitted)
+	}
+}
+
+func sameFields(a, b Fields) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !sameValue(value, other) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package status
+
+// Subtopics used with mqtt.split_topics, in publish order; "status" is the core liveness message
+var SectionNames = []string{"status", "network", "modem", "system", "meta"}
+
+// Retained section holding static device info, published once per boot and on change
+const MetaSection = "meta"
+
+// Section of every payload key; keys not listed stay in "status"
+var sectionOf = map[string]string{
+	"ip_addresses":            "network",
+	"switch_name":             "network",
+	"switch_ip":               "network",
+	"switch_port":             "network",
+	"switch_mac_address":      "network",
+	"switch_port_vlan":        "network",
+	"switch_sys_description":  "network",
+	"switch_port_description": "network",
+	"wifi_ssid":               "network",
+	"wifi_ap_mac":             "network",
+	"wifi":                    "network",
+	"vpn":                     "network",
+	"wan_ip":                  "network",
+	"wan_interface":           "network",
+	"active_uplink":           "network",
+	"connected_broker":        "network",
+	"broker_latency_ms":       "network",
+	"connectivity":            "network",
+	"modem":                   "modem",
+	"signal_quality_pct":      "modem",
+	"cellular_usage":          "modem",
+	"location":                "modem",
+	"temp":                    "system",
+	"temp_c":                  "system",
+	"temperatures":            "system",
+	"uptime":                  "system",
+	"uptime_seconds":          "system",
+	"services":                "system",
+	"service_states":          "system",
+	"self":                    "system",
+	"storage_health":          "system",
+	"usb_devices":             "system",
+	"power":                   "system",
+	"panics":                  "system",
+	"logging_degraded":        "system",
+	"last_boot_reason":        "system",
+	"log_alerts":              "system",
+	"service_stops":           "system",
+	"modem_states":            "modem",
+	"wifi_roams_24h":          "network",
+	"wifi_transitions":        "network",
+	"previous_uptime":         "system",
+	"update_applied":          "system",
+	"update_available":        "system",
+	"app_checks":              "system",
+	"containers":              "system",
+	"recent_changes_count":    "system",
+	"device_type":             "meta",
+	"hostname":                "meta",
+	"site":                    "meta",
+	"label":                   "meta",
+	"mac_addresses":           "meta",
+	"os_version":              "meta",
+	"updater_version":         "meta",
+	"build_info":              "meta",
+	"helpcom_servers":         "meta",
+	"helpcom_lifespan":        "meta",
+	"helpcom_rf":              "meta",
+	"config_path":             "meta",
+	"config_hash":             "meta",
+	"config":                  "meta",
+	"capabilities":            "meta",
+	"ca_hash":                 "meta",
+	"binary_hash":             "meta",
+	"ca_cert_expires":         "meta",
+	"broker_cert_expires":     "meta",
+}
+
+// Copied into every section so each message identifies its device and time
+var sharedKeys = []string{"deviceID", "date"}
+
+// Splits fields by section; sections without fields of their own are left out
+func Split(fields Fields) map[string]Fields {
+	sections := make(map[string]Fields)
+	for key, value := range fields {
+		name, ok := sectionOf[key]
+		if !ok {
+			name = "status"
+		}
+		if sections[name] == nil {
+			sections[name] = make(Fields)
+		}
+		sections[name][key] = value
+	}
+
+	for _, section := range sections {
+		for _, key := range sharedKeys {
+			if value, ok := fields[key]; ok {
+				section[key] = value
+			}
+		}
+	}
+	return sections
+}
+
+// Splits fields into the meta section and everything else, for mqtt.split_static; both keep the shared keys
+func SplitStatic(fields Fields) (Fields, Fields) {
+	rest, meta := make(Fields), make(Fields)
+	for key, value := range fields {
+		if sectionOf[key] == MetaSection {
+			meta[key] = value
+		} else {
+			rest[key] = value
+		}
+	}
+	for _, key := range sharedKeys {
+		if value, ok := fields[key]; ok {
+			meta[key] = value
+		}
+	}
+	return rest, meta
+}
+
+// Reports whether the section holds anything besides the shared deviceID and date
+func (f Fields) HasData() bool {
+	for key := range f {
+		if key != "deviceID" && key != "date" {
+			return true
+		}
+	}
+	return false
+}
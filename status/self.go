@@ -0,0 +1,125 @@
+package status
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"status-updater/cmdrunner"
+	"status-updater/metrics"
+	"status-updater/mqtt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Daemon resource usage, reported under "self" to spot leaks on long-running devices
+type Self struct {
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	GCCount        uint32 `json:"gc_count"`
+	RSSBytes       uint64 `json:"rss_bytes"`
+	Goroutines     int    `json:"goroutines"`
+	OpenFDs        int    `json:"open_fds"`
+
+	// Bytes of gzip-compressed payloads before and after compression (mqtt.compress)
+	UncompressedBytes uint64 `json:"uncompressed_bytes,omitempty"`
+	CompressedBytes   uint64 `json:"compressed_bytes,omitempty"`
+
+	// Time the broker took to acknowledge publishes, since boot and over the last hour
+	PublishLatency *mqtt.Latency `json:"publish_latency,omitempty"`
+
+	// Child processes spawned for gatherers and how many had to be killed
+	Processes cmdrunner.Stats `json:"processes"`
+
+	// Three slowest gatherers of the previous status cycle
+	SlowestGatherers []metrics.Timing `json:"slowest_gatherers,omitempty"`
+}
+
+func collectSelf() Self {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	uncompressed, compressed := mqtt.CompressionStats()
+
+	return Self{
+		HeapInuseBytes:    mem.HeapInuse,
+		GCCount:           mem.NumGC,
+		RSSBytes:          processRSS(),
+		Goroutines:        runtime.NumGoroutine(),
+		OpenFDs:           openFDs(),
+		UncompressedBytes: uncompressed,
+		CompressedBytes:   compressed,
+		PublishLatency:    mqtt.PublishLatency(),
+		Processes:         cmdrunner.ProcessStats(),
+		SlowestGatherers:  metrics.Slowest(3),
+	}
+}
+
+// Resident set size from VmRSS in /proc/self/status, 0 when unavailable
+func processRSS() uint64 {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+var (
+	binaryHashOnce sync.Once
+	binaryHashSum  string
+)
+
+// SHA-256 of the running binary, computed once; /proc/self/exe still refers to it after an update replaces the file
+func binaryHash() string {
+	binaryHashOnce.Do(func() {
+		file, err := os.Open("/proc/self/exe")
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err == nil {
+			binaryHashSum = hex.EncodeToString(hash.Sum(nil))
+		}
+	})
+	return binaryHashSum
+}
+
+// Number of entries in /proc/self/fd, -1 when unavailable
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// Broker acknowledgement latency over the last hour, reported as "broker_latency_ms"
+type BrokerLatency struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+}
+
+func brokerLatency() *BrokerLatency {
+	latency := mqtt.PublishLatency()
+	if latency == nil || latency.HourCount == 0 {
+		return nil
+	}
+	return &BrokerLatency{P50: int64(math.Round(latency.HourP50)), P95: int64(math.Round(latency.HourP95))}
+}
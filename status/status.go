@@ -0,0 +1,348 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"status-updater/boot"
+	"status-updater/buildinfo"
+	"status-updater/capabilities"
+	"status-updater/config"
+	"status-updater/connectivity"
+	"status-updater/duplicate"
+	"status-updater/events"
+	"status-updater/gatherer"
+	"status-updater/health"
+	"status-updater/helpers"
+	"status-updater/initialize"
+	"status-updater/logger"
+	"status-updater/logwatch"
+	"status-updater/maintenance"
+	"status-updater/metrics"
+	"status-updater/modemwatch"
+	"status-updater/servicewatch"
+	"status-updater/system"
+	"status-updater/updater"
+	"status-updater/usage"
+	"status-updater/wifiwatch"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status message published to the status topic; json tags are the wire format the backend relies on
+type Payload struct {
+	Status                string                   `json:"status"`
+	Services              string                   `json:"services,omitempty"`
+	ServiceStates         []helpers.ServiceState   `json:"service_states,omitempty"`
+	Date                  string                   `json:"date"`
+	DeviceID              string                   `json:"deviceID"`
+	DeviceType            string                   `json:"device_type"`
+	Hostname              string                   `json:"hostname,omitempty"`
+	Site                  string                   `json:"site,omitempty"`
+	Label                 string                   `json:"label,omitempty"`
+	IPAddresses           json.RawMessage          `json:"ip_addresses"`
+	MACAddresses          json.RawMessage          `json:"mac_addresses"`
+	Modem                 json.RawMessage          `json:"modem,omitempty"`
+	Temp                  string                   `json:"temp,omitempty"`
+	TempC                 *float64                 `json:"temp_c"`
+	SignalQualityPct      *int                     `json:"signal_quality_pct"`
+	SwitchName            string                   `json:"switch_name,omitempty"`
+	SwitchIP              string                   `json:"switch_ip,omitempty"`
+	SwitchPort            string                   `json:"switch_port,omitempty"`
+	SwitchMACAddress      string                   `json:"switch_mac_address,omitempty"`
+	SwitchPortVlan        string                   `json:"switch_port_vlan,omitempty"`
+	SwitchSysDescription  string                   `json:"switch_sys_description,omitempty"`
+	SwitchPortDescription string                   `json:"switch_port_description,omitempty"`
+	WifiSSID              string                   `json:"wifi_ssid,omitempty"`
+	WifiAPMAC             string                   `json:"wifi_ap_mac,omitempty"`
+	Wifi                  []gatherer.WifiLink      `json:"wifi,omitempty"`
+	WifiRoams24h          *int                     `json:"wifi_roams_24h,omitempty"`
+	WifiTransitions       []wifiwatch.Transition   `json:"wifi_transitions,omitempty"`
+	UpdaterVersion        string                   `json:"updater_version"`
+	BuildInfo             buildinfo.Info           `json:"build_info"`
+	HelpcomServers        string                   `json:"helpcom_servers,omitempty"`
+	HelpcomLifespan       string                   `json:"helpcom_lifespan,omitempty"`
+	HelpcomRF             string                   `json:"helpcom_rf,omitempty"`
+	Uptime                string                   `json:"uptime,omitempty"`
+	UptimeSeconds         *int64                   `json:"uptime_seconds"`
+	OSVersion             string                   `json:"os_version"`
+	LoggingDegraded       bool                     `json:"logging_degraded"`
+	ConfigPath            string                   `json:"config_path"`
+	ConfigHash            string                   `json:"config_hash"`
+	Config                *initialize.ActiveConfig `json:"config,omitempty"`
+	CAHash                string                   `json:"ca_hash"`
+	BinaryHash            string                   `json:"binary_hash"`
+	Alerts                []string                 `json:"alerts"`
+	Panics                map[string]int           `json:"panics"`
+	Self                  Self                     `json:"self"`
+	CACertExpires         string                   `json:"ca_cert_expires,omitempty"`
+	BrokerCertExpires     string                   `json:"broker_cert_expires,omitempty"`
+	LastBootReason        string                   `json:"last_boot_reason,omitempty"`
+	LogAlerts             map[string]int           `json:"log_alerts,omitempty"`
+	ServiceStops          map[string]int           `json:"service_stops"`
+	ModemStates           *modemwatch.Stats        `json:"modem_states,omitempty"`
+	PreviousUptime        *int64                   `json:"previous_uptime,omitempty"`
+	IntervalSeconds       int64                    `json:"interval_seconds"`
+	VPN                   []gatherer.Tunnel        `json:"vpn,omitempty"`
+	CellularUsage         map[string]usage.Usage   `json:"cellular_usage,omitempty"`
+	StorageHealth         *gatherer.StorageHealth  `json:"storage_health,omitempty"`
+	Location              *gatherer.Location       `json:"location,omitempty"`
+	USBDevices            []gatherer.USBDevice     `json:"usb_devices,omitempty"`
+	Power                 *gatherer.Power          `json:"power,omitempty"`
+	Temperatures          map[string]float64       `json:"temperatures,omitempty"`
+	DateUnreliable        bool                     `json:"date_unreliable,omitempty"`
+	BootSeconds           *int64                   `json:"boot_seconds,omitempty"`
+	ConnectedBroker       string                   `json:"connected_broker,omitempty"`
+	Connectivity          *connectivity.Stats      `json:"connectivity,omitempty"`
+	BrokerLatency         *BrokerLatency           `json:"broker_latency_ms,omitempty"`
+	WANIP                 string                   `json:"wan_ip,omitempty"`
+	WANInterface          string                   `json:"wan_interface,omitempty"`
+	ActiveUplink          *gatherer.Uplink         `json:"active_uplink,omitempty"`
+	AppChecks             []gatherer.AppCheck      `json:"app_checks,omitempty"`
+	Containers            []gatherer.Container     `json:"containers,omitempty"`
+	UpdateApplied         *updater.Applied         `json:"update_applied,omitempty"`
+	UpdateAvailable       string                   `json:"update_available,omitempty"`
+	Capabilities          capabilities.Set         `json:"capabilities"`
+	Maintenance           maintenance.Status       `json:"maintenance"`
+	RecentChangesCount    int                      `json:"recent_changes_count"`
+	DuplicateSuspected    bool                     `json:"duplicate_device_suspected,omitempty"`
+	Health                health.Summary           `json:"health"`
+}
+
+// Runs every gatherer and builds the Online payload; returns ctx.Err() if cancelled meanwhile
+func Collect(ctx context.Context, deviceType string) (*Payload, error) {
+	cfg := config.Current()
+	metrics.StartCycle()
+	defer func() { reportCycle(metrics.EndCycle()) }()
+
+	p := &Payload{
+		Status:          "Online",
+		Date:            time.Now().UTC().Format(time.RFC3339),
+		DeviceID:        gatherer.GetDeviceID(),
+		DeviceType:      deviceType,
+		Site:            cfg.Site,
+		Label:           cfg.Label,
+		UpdaterVersion:  helpers.GetUpdaterVersion(),
+		BuildInfo:       helpers.BuildInfo(),
+		LoggingDegraded: logger.IsDegraded(),
+		ConfigPath:      config.Path,
+		ConfigHash:      cfg.Hash,
+		Config:          initialize.Active(),
+		CAHash:          initialize.CAHash(),
+		BinaryHash:      binaryHash(),
+		ConnectedBroker: initialize.ConnectedBroker(),
+		BrokerLatency:   brokerLatency(),
+		Connectivity:    connectivity.Snapshot(),
+		UpdateAvailable: updater.AvailableVersion(),
+		Capabilities:    capabilities.Current(),
+		Maintenance:     maintenance.Current(),
+		Alerts:          events.ActiveAlerts(),
+		Panics:          system.PanicCounts(),
+	}
+
+	p.DuplicateSuspected = duplicate.Suspected()
+
+	p.IPAddresses = json.RawMessage(metrics.Measure("ip_addresses", gatherer.GetIPAddresses))
+	p.MACAddresses = json.RawMessage(metrics.Measure("mac_addresses", gatherer.GetMACAddresses))
+	// Disabled gatherers (gatherers section) are skipped and their fields left out
+	enabled := cfg.GathererEnabled
+	if enabled("modem") {
+		p.Modem = json.RawMessage(metrics.Measure("modem", gatherer.GetModemDetails))
+	}
+	if enabled("temperature") {
+		p.Temp = metrics.Measure("temperature", gatherer.GetTemperature)
+		if !helpers.IsBuildroot() {
+			p.Temperatures = metrics.Measure("temperatures", gatherer.GetTemperatures)
+		}
+	}
+	if enabled("lldp") {
+		metrics.Time("lldp", func() {
+			p.SwitchName, p.SwitchIP, p.SwitchPort, p.SwitchMACAddress, p.SwitchPortVlan, p.SwitchSysDescription, p.SwitchPortDescription = gatherer.GetLLDPDetails()
+		})
+	}
+
+	// WLAN interface check
+	if enabled("wifi") {
+		metrics.Time("wifi", func() {
+			p.Wifi = gatherer.GetWifiLinks()
+			// Legacy fields follow the first connected interface
+			p.WifiSSID, p.WifiAPMAC = "N/A", "N/A"
+			for _, link := range p.Wifi {
+				if link.Connected {
+					p.WifiSSID, p.WifiAPMAC = link.SSID, link.APMAC
+					logger.LogMessage("DEBUG", fmt.Sprintf("Found WLAN interface %s with SSID: %s and AP MAC: %s", link.Interface, link.SSID, link.APMAC))
+					break
+				}
+			}
+			if p.WifiSSID == "N/A" {
+				logger.LogMessage("DEBUG", fmt.Sprintf("No connected WLAN interface among %d wireless interfaces", len(p.Wifi)))
+			}
+		})
+	}
+
+	if enabled("helpcom") {
+		var helpcomConfig map[string]string
+		var err error
+		metrics.Time("helpcom", func() {
+			helpcomConfig, err = gatherer.ReadHelpcomConfig()
+		})
+		if err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to read Helpcom configuration: %s", err))
+		}
+		p.HelpcomServers = helpcomConfig["HelpcomServers"]
+		p.HelpcomLifespan = helpcomConfig["HelpcomLifespan"]
+		p.HelpcomRF = helpcomConfig["HelpcomRF"]
+	}
+
+	if enabled("services") {
+		var err error
+		metrics.Time("services", func() {
+			p.ServiceStates, err = gatherer.GetServiceStates()
+		})
+		p.Services = gatherer.FormatServiceStatus(p.ServiceStates)
+		if err != nil {
+			logger.LogMessage("ERROR", fmt.Sprintf("Failed to get service states: %s", err))
+			p.Services = "Unknown"
+		}
+	}
+
+	p.CellularUsage = usage.Snapshot()
+	if enabled("usb") {
+		p.USBDevices = metrics.Measure("usb", gatherer.GetUSBDevices)
+	}
+	if enabled("power") {
+		p.Power = metrics.Measure("power", gatherer.GetPower)
+	}
+	if cfg.Location.Enabled {
+		metrics.Time("location", func() {
+			location := gatherer.GetLocation(*cfg.Location.Precision)
+			p.Location = &location
+		})
+	}
+	if enabled("storage_health") {
+		metrics.Time("storage_health", func() {
+			p.StorageHealth = gatherer.GetStorageHealth()
+		})
+	}
+	metrics.Time("active_uplink", func() {
+		p.ActiveUplink = gatherer.GetActiveUplink(p.ConnectedBroker)
+	})
+	if cfg.WANIP.URL != "" {
+		metrics.Time("wan_ip", func() {
+			p.WANIP, p.WANInterface = gatherer.GetWANIP()
+		})
+	}
+	if enabled("vpn") {
+		metrics.Time("vpn", func() {
+			p.VPN = gatherer.GetVPNTunnels()
+		})
+	}
+	if enabled("containers") {
+		var err error
+		metrics.Time("containers", func() {
+			p.Containers, err = gatherer.GetContainers()
+		})
+		if err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to get container states: %s", err))
+		}
+	}
+	if len(cfg.AppChecks) > 0 {
+		metrics.Time("app_checks", func() {
+			p.AppChecks = gatherer.RunAppChecks(deviceType)
+		})
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		p.Hostname = hostname
+	}
+	p.Uptime = metrics.Measure("uptime", gatherer.GetUptime)
+	p.OSVersion = metrics.Measure("os_version", gatherer.GetLinuxVersion)
+
+	// Numeric variants; null when the source value is unavailable
+	if temp, err := strconv.ParseFloat(p.Temp, 64); err == nil {
+		p.TempC = &temp
+	}
+	p.SignalQualityPct = signalQuality(p.Modem)
+	if uptime, err := gatherer.GetUptimeSeconds(); err == nil {
+		p.UptimeSeconds = &uptime
+	}
+
+	// With an untrusted clock, time since boot orders messages instead of date
+	if gatherer.DateUnreliable() {
+		p.DateUnreliable = true
+		p.BootSeconds = p.UptimeSeconds
+	}
+
+	// String fields superseded by temp_c and uptime_seconds, kept while payload.legacy_fields is set
+	if legacy := cfg.Payload.LegacyFields; legacy != nil && !*legacy {
+		p.Temp = ""
+		p.Uptime = ""
+	}
+
+	p.Self = collectSelf()
+	p.ServiceStops = servicewatch.StopCounts()
+	p.ModemStates = modemwatch.Snapshot()
+	p.WifiRoams24h, p.WifiTransitions = wifiwatch.Snapshot()
+	if counts := logwatch.TakeCounts(); len(counts) > 0 {
+		p.LogAlerts = counts
+	}
+
+	// How the previous run ended, only until the first payload after startup is published
+	if report := boot.PendingReport(); report != nil {
+		p.LastBootReason = report.Reason
+		if report.Rebooted {
+			previousUptime := report.PreviousUptime
+			p.PreviousUptime = &previousUptime
+		}
+	}
+
+	p.UpdateApplied = updater.PendingApplied()
+
+	caExpiry, brokerExpiry := initialize.CertificateExpiry()
+	if !caExpiry.IsZero() {
+		p.CACertExpires = caExpiry.UTC().Format(time.RFC3339)
+	}
+	if !brokerExpiry.IsZero() {
+		p.BrokerCertExpires = brokerExpiry.UTC().Format(time.RFC3339)
+	}
+
+	p.Health = health.Summarize(len(p.Alerts) > 0)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Logs the per-gatherer breakdown of a cycle at DEBUG and warns about gatherers slower than metrics.slow_gather_threshold
+func reportCycle(cycle *metrics.Cycle) {
+	if cycle == nil {
+		return
+	}
+	threshold := config.Current().Metrics.SlowGatherThreshold.Duration()
+	breakdown := make([]string, 0, len(cycle.Gatherers))
+	for _, timing := range cycle.Gatherers {
+		breakdown = append(breakdown, fmt.Sprintf("%s=%dms", timing.Source, timing.Ms))
+		if time.Duration(timing.Ms)*time.Millisecond > threshold {
+			logger.LogMessage("WARN", fmt.Sprintf("Gatherer %s took %dms, above metrics.slow_gather_threshold of %s", timing.Source, timing.Ms, threshold))
+		}
+	}
+	logger.LogMessage("DEBUG", fmt.Sprintf("Status cycle took %dms: %s", cycle.TotalMs, strings.Join(breakdown, " ")))
+}
+
+// Parses the signal_quality percentage out of the modem details
+func signalQuality(modem json.RawMessage) *int {
+	var details struct {
+		SignalQuality string `json:"signal_quality"`
+	}
+	if err := json.Unmarshal(modem, &details); err != nil {
+		return nil
+	}
+	quality, err := strconv.Atoi(details.SignalQuality)
+	if err != nil {
+		return nil
+	}
+	return &quality
+}
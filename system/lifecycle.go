@@ -0,0 +1,171 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"status-updater/cmdrunner"
+	"status-updater/helpers"
+	"status-updater/logger"
+)
+
+const (
+	// Default time a goroutine started with Go gets to return after cancellation
+	DefaultTaskGrace = 10 * time.Second
+
+	// Default time a registered component gets to stop
+	DefaultComponentGrace = 5 * time.Second
+)
+
+// Exit codes per reason, so the service manager's log tells why the process ended. Restarts rely on the unit's
+// Restart=always; a shutdown exits 0 so stopping the service isn't recorded as a failure.
+const (
+	ExitShutdown = 0  // SIGINT or SIGTERM
+	ExitPanic    = 1  // Too many recovered panics, also used for unknown reasons
+	ExitUpdate   = 10 // Restart into a newly installed version
+	ExitRestart  = 11 // Restart requested by a remote command
+	ExitMemory   = 12 // Restart after the RSS stayed above self.rss_ceiling_mb
+	ExitReboot   = 13 // Device reboot requested by a remote command
+)
+
+var exitCodes = map[string]int{
+	"shutdown": ExitShutdown,
+	"panic":    ExitPanic,
+	"update":   ExitUpdate,
+	"restart":  ExitRestart,
+	"memory":   ExitMemory,
+	"reboot":   ExitReboot,
+}
+
+// Returns the exit code for a shutdown reason
+func ExitCode(reason string) int {
+	if code, ok := exitCodes[reason]; ok {
+		return code
+	}
+	return ExitPanic
+}
+
+// Work finished on the way out, e.g. publishing the Offline status or persisting state
+type component struct {
+	name  string
+	grace time.Duration
+	stop  func(reason string)
+}
+
+var (
+	lifecycleMutex sync.Mutex
+	components     []component
+	rootCancel     context.CancelFunc
+	terminateOnce  sync.Once
+)
+
+// Registers the root context's cancel func; goroutines started with Go are waited for after it is called
+func SetRoot(cancel context.CancelFunc) {
+	lifecycleMutex.Lock()
+	defer lifecycleMutex.Unlock()
+	rootCancel = cancel
+}
+
+// Registers a component stopped with the shutdown reason, in registration order, within its grace period
+func RegisterComponent(name string, grace time.Duration, stop func(reason string)) {
+	lifecycleMutex.Lock()
+	defer lifecycleMutex.Unlock()
+	components = append(components, component{name: name, grace: grace, stop: stop})
+}
+
+// Stops the process for good, e.g. on SIGTERM; never returns. ctx identifies the calling task, if any, which
+// isn't waited for.
+func RequestShutdown(ctx context.Context, reason string) {
+	terminate(ctx, reason, "Shutting down", nil)
+}
+
+// Exits so the service manager starts a fresh process, e.g. after an update; never returns
+func RequestRestart(ctx context.Context, reason string) {
+	terminate(ctx, reason, "Restarting", nil)
+}
+
+// Stops like a shutdown, so the Offline status carries the reason, then syncs filesystems and reboots the device; never returns
+func RequestReboot(ctx context.Context, reason string) {
+	terminate(ctx, reason, "Rebooting", rebootDevice)
+}
+
+// Stops everything through shutdown, runs final if set, flushes the log and exits with the reason's code.
+// Only the first caller proceeds; later ones block until the process exits.
+func terminate(ctx context.Context, reason, action string, final func()) {
+	terminateOnce.Do(func() {
+		logger.LogMessage("INFO", fmt.Sprintf("%s (%s)", action, reason))
+		shutdown(ctx, reason)
+
+		if final != nil {
+			final()
+		}
+
+		code := ExitCode(reason)
+		logger.LogMessage("INFO", fmt.Sprintf("%s complete, exiting with code %d", action, code))
+		logger.Close()
+		os.Exit(code)
+	})
+	select {}
+}
+
+// Cancels the root context, waits for the goroutines started with Go except the one ctx belongs to and stops every
+// component in registration order, each within its grace period
+func shutdown(ctx context.Context, reason string) {
+	lifecycleMutex.Lock()
+	cancel := rootCancel
+	stopping := append([]component{}, components...)
+	running := append([]*task{}, tasks...)
+	lifecycleMutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	waitTasks(running, taskFrom(ctx), time.Now())
+
+	for _, c := range stopping {
+		start := time.Now()
+		if waitWithin(c.grace, func() { c.stop(reason) }) {
+			logger.LogMessage("INFO", fmt.Sprintf("Stopped %s in %v", c.name, time.Since(start).Round(time.Millisecond)))
+		} else {
+			logger.LogMessage("WARN", fmt.Sprintf("Stopping %s exceeded its %v grace period, skipped", c.name, c.grace))
+		}
+	}
+}
+
+// Flushes filesystems and asks init to reboot: busybox reboot on Buildroot, systemctl elsewhere
+func rebootDevice() {
+	syscall.Sync()
+
+	name, args := "systemctl", []string{"reboot"}
+	if helpers.IsBuildroot() {
+		name, args = "reboot", nil
+	}
+	if _, err := cmdrunner.Output(name, args...); err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Failed to reboot: %v", err))
+	}
+}
+
+// Runs fn, returning false if it didn't finish within timeout; a stuck fn is left running
+func waitWithin(timeout time.Duration, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				logger.LogMessageWithStack("ERROR", fmt.Sprintf("Recovered from panic during shutdown: %v", r))
+			}
+		}()
+		fn()
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package system
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clears the registered components, tasks and root context before and after a test
+func resetLifecycle(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		lifecycleMutex.Lock()
+		defer lifecycleMutex.Unlock()
+		components, tasks, rootCancel = nil, nil, nil
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestShutdownStopsComponentsInOrder(t *testing.T) {
+	resetLifecycle(t)
+
+	var mu sync.Mutex
+	var stopped []string
+	for _, name := range []string{"offline status", "clean shutdown marker", "publish state", "log"} {
+		name := name
+		RegisterComponent(name, time.Second, func(reason string) {
+			if reason != "update" {
+				t.Errorf("%s stopped with reason %q, want update", name, reason)
+			}
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	SetRoot(cancel)
+
+	shutdown(context.Background(), "update")
+
+	if ctx.Err() == nil {
+		t.Error("root context not cancelled")
+	}
+	want := []string{"offline status", "clean shutdown marker", "publish state", "log"}
+	if !reflect.DeepEqual(stopped, want) {
+		t.Errorf("components stopped in order %v, want %v", stopped, want)
+	}
+}
+
+func TestShutdownSkipsStuckComponent(t *testing.T) {
+	resetLifecycle(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	afterStopped := false
+	RegisterComponent("stuck", 50*time.Millisecond, func(string) { <-release })
+	RegisterComponent("after", time.Second, func(string) { afterStopped = true })
+
+	start := time.Now()
+	shutdown(context.Background(), "shutdown")
+	elapsed := time.Since(start)
+
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %v with a stuck component, want about its 50ms grace period", elapsed)
+	}
+	if !afterStopped {
+		t.Error("component after the stuck one was not stopped")
+	}
+}
+
+func TestShutdownSurvivesPanickingComponent(t *testing.T) {
+	resetLifecycle(t)
+
+	afterStopped := false
+	RegisterComponent("panicking", time.Second, func(string) { panic("boom") })
+	RegisterComponent("after", time.Second, func(string) { afterStopped = true })
+
+	shutdown(context.Background(), "shutdown")
+
+	if !afterStopped {
+		t.Error("component after the panicking one was not stopped")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if code := ExitCode("shutdown"); code != 0 {
+		t.Errorf("ExitCode(shutdown) = %d, want 0", code)
+	}
+	if code := ExitCode("unknown"); code != ExitPanic {
+		t.Errorf("ExitCode(unknown) = %d, want %d", code, ExitPanic)
+	}
+
+	seen := make(map[int]string)
+	for _, reason := range []string{"shutdown", "panic", "update", "restart", "memory", "reboot"} {
+		code := ExitCode(reason)
+		if other, ok := seen[code]; ok {
+			t.Errorf("%s and %s share exit code %d", reason, other, code)
+		}
+		seen[code] = reason
+	}
+}
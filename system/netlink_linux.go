@@ -0,0 +1,227 @@
+package system
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"status-updater/logger"
+)
+
+const (
+	// Receive timeout so the read loop can notice context cancellation
+	netlinkReadTimeout = time.Second
+
+	// rtnetlink multicast groups (linux/rtnetlink.h), not exported by syscall
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// Subscribes to rtnetlink link/address notifications; returns nil on context cancellation
+func monitorNetlink(ctx context.Context, onChange func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("failed to bind netlink socket: %v", err)
+	}
+
+	timeout := syscall.NsecToTimeval(netlinkReadTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return fmt.Errorf("failed to set netlink receive timeout: %v", err)
+	}
+
+	logger.LogMessage("DEBUG", "Subscribed to netlink network change notifications")
+
+	buf := make([]byte, 1<<16)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			if errors.Is(err, syscall.ENOBUFS) {
+				logger.LogMessage("WARN", "Netlink receive buffer overrun, some network changes may have been missed")
+				continue
+			}
+			return fmt.Errorf("failed to read netlink socket: %v", err)
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to parse netlink message: %s", err))
+			continue
+		}
+
+		changed := false
+		for i := range messages {
+			if handleNetlinkMessage(&messages[i]) {
+				changed = true
+			}
+		}
+		if changed {
+			onChange()
+		}
+	}
+}
+
+// Logs relevant link/address events, returning true when one was found
+func handleNetlinkMessage(msg *syscall.NetlinkMessage) bool {
+	var event string
+	switch msg.Header.Type {
+	case syscall.RTM_NEWADDR:
+		event = "address added"
+	case syscall.RTM_DELADDR:
+		event = "address removed"
+	case syscall.RTM_NEWLINK:
+		event = "link changed"
+	case syscall.RTM_DELLINK:
+		event = "link removed"
+	default:
+		return false
+	}
+
+	iface := netlinkInterfaceName(msg)
+	if iface == "" || isIgnoredInterface(iface) {
+		return false
+	}
+
+	logger.LogMessage("INFO", fmt.Sprintf("Network interface change detected: %s %s", iface, event))
+	return true
+}
+
+// Extracts the interface name from link (IFLA_IFNAME) or address (IFA_LABEL / index) messages
+func netlinkInterfaceName(msg *syscall.NetlinkMessage) string {
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err == nil {
+		for _, attr := range attrs {
+			isLinkName := (msg.Header.Type == syscall.RTM_NEWLINK || msg.Header.Type == syscall.RTM_DELLINK) && attr.Attr.Type == syscall.IFLA_IFNAME
+			isAddrLabel := (msg.Header.Type == syscall.RTM_NEWADDR || msg.Header.Type == syscall.RTM_DELADDR) && attr.Attr.Type == syscall.IFA_LABEL
+			if isLinkName || isAddrLabel {
+				return string(trimNull(attr.Value))
+			}
+		}
+	}
+
+	// IPv6 address messages carry no label, resolve the index instead
+	if (msg.Header.Type == syscall.RTM_NEWADDR || msg.Header.Type == syscall.RTM_DELADDR) && len(msg.Data) >= syscall.SizeofIfAddrmsg {
+		index := int(binary.NativeEndian.Uint32(msg.Data[4:8]))
+		if iface, err := net.InterfaceByIndex(index); err == nil {
+			return iface.Name
+		}
+	}
+	return ""
+}
+
+func trimNull(value []byte) []byte {
+	for i, b := range value {
+		if b == 0 {
+			return value[:i]
+		}
+	}
+	return value
+}
+
+// Asks the kernel (RTM_GETROUTE) which interface traffic to dst leaves through
+func RouteInterface(dst net.IP) (string, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return "", fmt.Errorf("failed to open netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	timeout := syscall.NsecToTimeval(netlinkReadTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return "", fmt.Errorf("failed to set netlink receive timeout: %v", err)
+	}
+	if err := syscall.Sendto(fd, routeRequest(dst), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return "", fmt.Errorf("failed to send route request: %v", err)
+	}
+
+	buf := make([]byte, 1<<16)
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to read route reply: %v", err)
+	}
+	index, err := parseRouteReply(buf[:n])
+	if err != nil {
+		return "", err
+	}
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve interface %d: %v", index, err)
+	}
+	return iface.Name, nil
+}
+
+// Builds an RTM_GETROUTE request for a single destination address
+func routeRequest(dst net.IP) []byte {
+	family, addr := syscall.AF_INET, dst.To4()
+	if addr == nil {
+		family, addr = syscall.AF_INET6, dst.To16()
+	}
+	attrLen := syscall.SizeofRtAttr + len(addr)
+	length := syscall.NLMSG_HDRLEN + syscall.SizeofRtMsg + attrLen
+
+	req := make([]byte, length)
+	binary.NativeEndian.PutUint32(req[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(req[4:6], syscall.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+
+	msg := req[syscall.NLMSG_HDRLEN:]
+	msg[0] = byte(family)
+	msg[1] = byte(len(addr) * 8)
+
+	attr := msg[syscall.SizeofRtMsg:]
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(attrLen))
+	binary.NativeEndian.PutUint16(attr[2:4], syscall.RTA_DST)
+	copy(attr[syscall.SizeofRtAttr:], addr)
+	return req
+}
+
+// Extracts the output interface index (RTA_OIF) from an RTM_GETROUTE reply
+func parseRouteReply(reply []byte) (int, error) {
+	messages, err := syscall.ParseNetlinkMessage(reply)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse route reply: %v", err)
+	}
+	for i := range messages {
+		msg := &messages[i]
+		switch msg.Header.Type {
+		case syscall.NLMSG_ERROR:
+			if len(msg.Data) >= 4 {
+				if errno := -int32(binary.NativeEndian.Uint32(msg.Data[0:4])); errno != 0 {
+					return 0, fmt.Errorf("route lookup failed: %v", syscall.Errno(errno))
+				}
+			}
+		case syscall.RTM_NEWROUTE:
+			attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse route attributes: %v", err)
+			}
+			for _, attr := range attrs {
+				if attr.Attr.Type == syscall.RTA_OIF && len(attr.Value) >= 4 {
+					return int(binary.NativeEndian.Uint32(attr.Value[0:4])), nil
+				}
+			}
+		}
+	}
+	return 0, errors.New("route reply has no output interface")
+}
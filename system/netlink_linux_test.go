@@ -0,0 +1,104 @@
+package system
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Builds a netlink message of the given type around payload
+func netlinkMessage(msgType uint16, payload []byte) []byte {
+	length := syscall.NLMSG_HDRLEN + len(payload)
+	msg := make([]byte, (length+syscall.NLMSG_ALIGNTO-1) & ^(syscall.NLMSG_ALIGNTO-1))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	copy(msg[syscall.NLMSG_HDRLEN:], payload)
+	return msg
+}
+
+// RTM_NEWROUTE reply for dst with the given route attributes, as the kernel answers RTM_GETROUTE
+func routeReply(dst net.IP, attrs map[uint16][]byte) []byte {
+	payload := make([]byte, syscall.SizeofRtMsg)
+	payload[0] = syscall.AF_INET
+	payload[1] = 32
+	attrs[syscall.RTA_DST] = dst.To4()
+	for _, attrType := range []uint16{syscall.RTA_DST, syscall.RTA_OIF, syscall.RTA_GATEWAY, syscall.RTA_PREFSRC} {
+		value, ok := attrs[attrType]
+		if !ok {
+			continue
+		}
+		attr := make([]byte, (syscall.SizeofRtAttr+len(value)+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+		binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+		binary.NativeEndian.PutUint16(attr[2:4], attrType)
+		copy(attr[syscall.SizeofRtAttr:], value)
+		payload = append(payload, attr...)
+	}
+	return netlinkMessage(syscall.RTM_NEWROUTE, payload)
+}
+
+func interfaceIndex(index uint32) []byte {
+	value := make([]byte, 4)
+	binary.NativeEndian.PutUint32(value, index)
+	return value
+}
+
+func TestParseRouteReply(t *testing.T) {
+	broker := net.ParseIP("203.0.113.10")
+	reply := routeReply(broker, map[uint16][]byte{
+		syscall.RTA_GATEWAY: net.ParseIP("10.64.3.1").To4(),
+		syscall.RTA_OIF:     interfaceIndex(4),
+		syscall.RTA_PREFSRC: net.ParseIP("10.64.3.2").To4(),
+	})
+	if index, err := parseRouteReply(reply); err != nil || index != 4 {
+		t.Errorf("parseRouteReply = %d, %v, want interface 4", index, err)
+	}
+}
+
+func TestParseRouteReplyErrors(t *testing.T) {
+	unreachable := make([]byte, 4+syscall.NLMSG_HDRLEN)
+	errno := -int32(syscall.ENETUNREACH)
+	binary.NativeEndian.PutUint32(unreachable[0:4], uint32(errno))
+
+	tests := []struct {
+		name  string
+		reply []byte
+		want  string
+	}{
+		{"network unreachable", netlinkMessage(syscall.NLMSG_ERROR, unreachable), "route lookup failed: network is unreachable"},
+		{"no output interface", routeReply(net.ParseIP("203.0.113.10"), map[uint16][]byte{}), "route reply has no output interface"},
+		{"truncated", routeReply(net.ParseIP("203.0.113.10"), map[uint16][]byte{syscall.RTA_OIF: interfaceIndex(4)})[:24],
+			"failed to parse route reply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRouteReply(tt.reply)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("parseRouteReply = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteRequest(t *testing.T) {
+	messages, err := syscall.ParseNetlinkMessage(routeRequest(net.ParseIP("203.0.113.10")))
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("request parses as %d messages, %v", len(messages), err)
+	}
+	if messages[0].Header.Type != syscall.RTM_GETROUTE || messages[0].Data[0] != syscall.AF_INET || messages[0].Data[1] != 32 {
+		t.Errorf("request header %+v, data %v", messages[0].Header, messages[0].Data[:2])
+	}
+	// ParseNetlinkRouteAttr only reads replies, the attributes are laid out the same
+	request := messages[0]
+	request.Header.Type = syscall.RTM_NEWROUTE
+	attrs, err := syscall.ParseNetlinkRouteAttr(&request)
+	if err != nil || len(attrs) != 1 || attrs[0].Attr.Type != syscall.RTA_DST || !net.IP(attrs[0].Value).Equal(net.ParseIP("203.0.113.10")) {
+		t.Errorf("request attributes = %+v, %v, want RTA_DST 203.0.113.10", attrs, err)
+	}
+
+	messages, err = syscall.ParseNetlinkMessage(routeRequest(net.ParseIP("2001:db8::10")))
+	if err != nil || messages[0].Data[0] != syscall.AF_INET6 || messages[0].Data[1] != 128 {
+		t.Errorf("IPv6 request = %v, %v, want AF_INET6 with a /128", messages, err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package system
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Netlink is Linux-only; other platforms use the polling fallback
+func monitorNetlink(ctx context.Context, onChange func()) error {
+	return errors.New("netlink is not supported on this platform")
+}
+
+// Route lookups go through netlink, which is Linux-only
+func RouteInterface(dst net.IP) (string, error) {
+	return "", errors.New("netlink is not supported on this platform")
+}
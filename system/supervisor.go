@@ -0,0 +1,94 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"status-updater/logger"
+)
+
+const (
+	// More than maxPanics panics within panicWindow exits the process so systemd restarts it
+	maxPanics   = 5
+	panicWindow = 10 * time.Minute
+
+	restartBackoffMin = time.Second
+	restartBackoffMax = time.Minute
+)
+
+var (
+	panicMutex  sync.Mutex
+	panicCounts = make(map[string]int)
+	panicTimes  []time.Time
+)
+
+// Runs fn, restarting it with a backoff whenever it panics, until it returns normally or ctx is cancelled
+func Supervise(ctx context.Context, name string, fn func()) {
+	backoff := restartBackoffMin
+	for {
+		if !runRecovered(ctx, name, fn) {
+			return
+		}
+
+		logger.LogMessage("WARN", fmt.Sprintf("Restarting %s in %v", name, backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > restartBackoffMax {
+			backoff = restartBackoffMax
+		}
+	}
+}
+
+// Returns true when fn panicked
+func runRecovered(ctx context.Context, name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			RecordPanic(ctx, name, r)
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
+
+// Logs a recovered panic with its stack and exits once panics exceed the circuit breaker limit; ctx is that of the
+// task the panic happened in
+func RecordPanic(ctx context.Context, name string, r interface{}) {
+	logger.LogMessageWithStack("ERROR", fmt.Sprintf("Recovered from panic in %s: %v", name, r))
+
+	now := time.Now()
+	panicMutex.Lock()
+	panicCounts[name]++
+	recent := panicTimes[:0]
+	for _, t := range panicTimes {
+		if now.Sub(t) < panicWindow {
+			recent = append(recent, t)
+		}
+	}
+	panicTimes = append(recent, now)
+	tripped := len(panicTimes) > maxPanics
+	panicMutex.Unlock()
+
+	if tripped {
+		logger.LogMessage("ERROR", fmt.Sprintf("More than %d panics within %v, exiting", maxPanics, panicWindow))
+		RequestRestart(ctx, "panic")
+	}
+}
+
+// Returns the number of recovered panics per goroutine since startup
+func PanicCounts() map[string]int {
+	panicMutex.Lock()
+	defer panicMutex.Unlock()
+
+	counts := make(map[string]int, len(panicCounts))
+	for name, count := range panicCounts {
+		counts[name] = count
+	}
+	return counts
+}
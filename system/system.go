@@ -4,26 +4,40 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
 	"sort"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
+	"status-updater/cmdrunner"
+	"status-updater/helpers"
 	"status-updater/logger"
 )
 
-func MonitorNetworkChanges(ctx context.Context) {
+// Watches for network changes via netlink, falling back to polling when the socket can't be opened; onChange runs for each change
+func MonitorNetworkChanges(ctx context.Context, onChange func()) {
+	err := monitorNetlink(ctx, onChange)
+	if err == nil {
+		return
+	}
+	logger.LogMessage("WARN", fmt.Sprintf("Netlink subscription unavailable (%v), polling for network changes", err))
+	pollNetworkChanges(ctx, onChange)
+}
+
+// VPN/tunnel interfaces and network.exclude_interfaces are ignored when detecting network changes
+func isIgnoredInterface(iface string) bool {
+	return strings.HasPrefix(iface, "tun") || strings.HasPrefix(iface, "tap") || helpers.ExcludedInterface(iface)
+}
+
+func pollNetworkChanges(ctx context.Context, onChange func()) {
 	var lastMainInterfaces string
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	// Filters out VPN/tunnel interfaces, returns comma-separated interface:ip pairs
+	// Filters out ignored interfaces, returns comma-separated interface:ip pairs
 	getMainInterfaces := func() string {
-		cmd := exec.Command("ip", "-o", "-4", "addr", "list")
-		output, err := cmd.Output()
+		output, err := cmdrunner.Output("ip", "-o", "-4", "addr", "list")
 		if err != nil {
 			logger.LogMessage("ERROR", fmt.Sprintf("Failed to get IP addresses: %s", err))
 			return ""
@@ -36,7 +50,7 @@ func MonitorNetworkChanges(ctx context.Context) {
 				parts := strings.Fields(line)
 				if len(parts) >= 4 {
 					iface := parts[1]
-					if !strings.HasPrefix(iface, "tun") && !strings.HasPrefix(iface, "tap") {
+					if !isIgnoredInterface(iface) {
 						ip := strings.Split(parts[3], "/")[0]
 						interfaces = append(interfaces, fmt.Sprintf("%s:%s", iface, ip))
 					}
@@ -55,7 +69,7 @@ func MonitorNetworkChanges(ctx context.Context) {
 			currentMainInterfaces := getMainInterfaces()
 			if lastMainInterfaces != currentMainInterfaces && lastMainInterfaces != "" {
 				logger.LogMessage("INFO", "Network interface change detected")
-				// Changes will be picked up on next status update
+				onChange()
 			}
 			lastMainInterfaces = currentMainInterfaces
 		case <-ctx.Done():
@@ -64,22 +78,35 @@ func MonitorNetworkChanges(ctx context.Context) {
 	}
 }
 
-func HandleShutdown(cancel context.CancelFunc, wg *sync.WaitGroup) {
+// Blocks until SIGINT or SIGTERM, then shuts down through the lifecycle manager
+func HandleShutdown() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	<-sigChan
 	logger.LogMessage("INFO", "Termination signal received. Initiating graceful shutdown...")
-	cancel()
+	RequestShutdown(context.Background(), "shutdown")
+}
 
-	wg.Wait()
+// Calls reload on every SIGHUP until the context is cancelled
+func HandleReload(ctx context.Context, reload func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
 
-	logger.LogMessage("INFO", "Graceful shutdown complete.")
-	os.Exit(0)
+	for {
+		select {
+		case <-sigChan:
+			logger.LogMessage("INFO", "SIGHUP received, reloading configuration")
+			reload()
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 func RecoverFromPanic() {
 	if r := recover(); r != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Recovered from panic: %v", r))
+		logger.LogMessageWithStack("ERROR", fmt.Sprintf("Recovered from panic: %v", r))
 	}
 }
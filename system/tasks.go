@@ -0,0 +1,62 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"status-updater/logger"
+)
+
+// Long-running goroutine started with Go, waited for on shutdown
+type task struct {
+	name  string
+	grace time.Duration
+	done  chan struct{}
+}
+
+var tasks []*task
+
+// Context key under which Go passes a task its own handle
+type taskKey struct{}
+
+// Runs fn supervised in a goroutine that shutdown waits for, up to DefaultTaskGrace after the root context is cancelled.
+// fn gets a context derived from ctx that identifies the task, to pass on when it requests a shutdown itself.
+func Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	GoWithGrace(ctx, name, DefaultTaskGrace, fn)
+}
+
+// Like Go with its own grace period, for goroutines that save state on the way out
+func GoWithGrace(ctx context.Context, name string, grace time.Duration, fn func(ctx context.Context)) {
+	t := &task{name: name, grace: grace, done: make(chan struct{})}
+	lifecycleMutex.Lock()
+	tasks = append(tasks, t)
+	lifecycleMutex.Unlock()
+
+	taskCtx := context.WithValue(ctx, taskKey{}, t)
+	go func() {
+		defer close(t.done)
+		Supervise(taskCtx, name, func() { fn(taskCtx) })
+	}()
+}
+
+// Returns the task ctx was passed to, or nil outside a task
+func taskFrom(ctx context.Context) *task {
+	t, _ := ctx.Value(taskKey{}).(*task)
+	return t
+}
+
+// Waits for every task in start order, each until its grace period after cancelled ran out. self, the task that
+// requested the shutdown, is skipped since it is blocked in it.
+func waitTasks(running []*task, self *task, cancelled time.Time) {
+	for _, t := range running {
+		if t == self {
+			continue
+		}
+		select {
+		case <-t.done:
+		case <-time.After(time.Until(cancelled.Add(t.grace))):
+			logger.LogMessage("WARN", fmt.Sprintf("%s still running %v after cancellation, continuing shutdown", t.name, t.grace))
+		}
+	}
+}
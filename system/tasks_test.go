@@ -0,0 +1,107 @@
+package system
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForSlowTask(t *testing.T) {
+	resetLifecycle(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	SetRoot(cancel)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+
+	GoWithGrace(ctx, "slow", time.Second, func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		record("slow")
+	})
+	RegisterComponent("state", time.Second, func(string) { record("state") })
+
+	start := time.Now()
+	shutdown(context.Background(), "shutdown")
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("shutdown returned after %v, before the slow task finished", elapsed)
+	}
+	if want := []string{"slow", "state"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("stopped in order %v, want %v", order, want)
+	}
+}
+
+func TestShutdownBoundsStuckTask(t *testing.T) {
+	resetLifecycle(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	SetRoot(cancel)
+
+	release := make(chan struct{})
+	defer close(release)
+	GoWithGrace(ctx, "stuck", 50*time.Millisecond, func(context.Context) { <-release })
+	GoWithGrace(ctx, "quick", time.Second, func(ctx context.Context) { <-ctx.Done() })
+
+	start := time.Now()
+	shutdown(context.Background(), "shutdown")
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %v with a stuck task, want about its 50ms grace period", elapsed)
+	}
+}
+
+func TestShutdownFromTaskSkipsItself(t *testing.T) {
+	resetLifecycle(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	SetRoot(cancel)
+
+	done := make(chan time.Duration)
+	GoWithGrace(ctx, "requester", time.Minute, func(ctx context.Context) {
+		start := time.Now()
+		// A context derived from the task's still identifies it
+		timeoutCtx, cancelTimeout := context.WithTimeout(ctx, time.Minute)
+		defer cancelTimeout()
+		shutdown(timeoutCtx, "update")
+		done <- time.Since(start)
+	})
+
+	select {
+	case elapsed := <-done:
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("shutdown requested by a task took %v, it waited for itself", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown requested by a task did not return")
+	}
+}
+
+func TestTaskFrom(t *testing.T) {
+	if taskFrom(context.Background()) != nil {
+		t.Error("taskFrom found a task outside of one")
+	}
+
+	resetLifecycle(t)
+	found := make(chan *task, 1)
+	Go(context.Background(), "probe", func(ctx context.Context) { found <- taskFrom(ctx) })
+
+	got := <-found
+	lifecycleMutex.Lock()
+	want := tasks[0]
+	lifecycleMutex.Unlock()
+	if got != want {
+		t.Errorf("taskFrom = %p, want the started task %p", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"status-updater/helpers"
+	"status-updater/logger"
+	"strings"
+	"sync"
+)
+
+// Outcome of the update installed before the last restart, reported in the first payload after startup
+type Applied struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Success bool   `json:"success"`
+}
+
+// Written before the restart that should bring up the new version
+type pendingUpdate struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+var (
+	appliedMutex sync.Mutex
+	applied      *Applied
+)
+
+func pendingPath() string {
+	return filepath.Join(config.Current().StateDir, "pending-update.json")
+}
+
+// Holds a version whose install didn't come up, so the next check installs it again
+func failedPath() string {
+	return filepath.Join(config.Current().StateDir, "failed-update")
+}
+
+// Records the update about to be applied by the restart
+func recordPending(from, to string) {
+	data, err := json.Marshal(pendingUpdate{From: from, To: to})
+	if err == nil {
+		err = os.WriteFile(pendingPath(), data, 0644)
+	}
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to record pending update: %s", err))
+	}
+}
+
+// Compares the running version with the update recorded before the restart, if any; call once at startup.
+// A version that didn't come up is remembered for another install attempt and a check is requested.
+func ConfirmPending() *Applied {
+	data, err := os.ReadFile(pendingPath())
+	if err != nil {
+		return nil
+	}
+	os.Remove(pendingPath())
+
+	var pending pendingUpdate
+	if err := json.Unmarshal(data, &pending); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Ignoring corrupt pending update record: %s", err))
+		return nil
+	}
+
+	running := helpers.GetUpdaterVersion()
+	result := &Applied{From: pending.From, To: pending.To, Success: running == pending.To}
+	if result.Success {
+		logger.LogMessage("INFO", fmt.Sprintf("Update from %s to %s applied", pending.From, pending.To))
+		os.Remove(failedPath())
+	} else {
+		logger.LogMessage("ERROR", fmt.Sprintf("Update from %s to %s failed, running %s", pending.From, pending.To, running))
+		if err := os.WriteFile(failedPath(), []byte(pending.To+"\n"), 0644); err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to record failed update: %s", err))
+		}
+		// The metadata is unchanged, so it must be fetched in full again to retry the install
+		forgetValidators()
+		RequestCheck()
+	}
+
+	appliedMutex.Lock()
+	applied = result
+	appliedMutex.Unlock()
+	return result
+}
+
+// Returns the update outcome until MarkAppliedReported is called
+func PendingApplied() *Applied {
+	appliedMutex.Lock()
+	defer appliedMutex.Unlock()
+	return applied
+}
+
+// Called after the first payload carrying the update outcome was published
+func MarkAppliedReported() {
+	appliedMutex.Lock()
+	defer appliedMutex.Unlock()
+	applied = nil
+}
+
+// Reports whether version failed to come up after its last install, so it must not count as current
+func failedVersion(version string) bool {
+	data, err := os.ReadFile(failedPath())
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(data)) == version
+}
@@ -0,0 +1,123 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/logger"
+	"strings"
+	"time"
+)
+
+// Progress of an update download, reported at the start, every quarter and at the end
+type Progress struct {
+	Version   string `json:"version"`
+	Bytes     int64  `json:"bytes"`
+	Total     int64  `json:"total,omitempty"`
+	RateLimit int64  `json:"rate_limit"`
+	Done      bool   `json:"done"`
+}
+
+// Set by RunSchedule; called from the update check's goroutine
+var onProgress func(Progress)
+
+// Download rate in bytes/sec: updater_service.max_download_rate, lowered to cellular_download_rate while the
+// default route is a wwan or ppp interface; 0 is unlimited
+func downloadRate() int64 {
+	cfg := config.Current()
+	rate := cfg.UpdaterService.MaxDownloadRate
+	cellular := cfg.UpdaterService.CellularDownloadRate
+	if cellular <= 0 {
+		return rate
+	}
+	iface := gatherer.DefaultRouteInterface()
+	if !strings.HasPrefix(iface, "wwan") && !strings.HasPrefix(iface, "ppp") {
+		return rate
+	}
+	if rate == 0 || cellular < rate {
+		return cellular
+	}
+	return rate
+}
+
+// Copies an update download to dst at the effective download rate, reporting progress; total is the expected
+// size or 0 when unknown
+func download(dst io.Writer, body io.Reader, version string, total int64) (int64, error) {
+	rate := downloadRate()
+	if rate > 0 {
+		logger.LogMessage("INFO", fmt.Sprintf("Downloading update at up to %d bytes/s", rate))
+	} else {
+		logger.LogMessage("INFO", "Downloading update without a rate limit")
+	}
+
+	reader := &progressReader{r: body, progress: Progress{Version: version, Total: total, RateLimit: rate}}
+	if rate > 0 {
+		reader.r = &rateLimitedReader{r: body, rate: rate, start: time.Now()}
+	}
+	reader.report()
+	written, err := io.Copy(dst, reader)
+	if err == nil {
+		reader.progress.Done = true
+		reader.report()
+	}
+	return written, err
+}
+
+// Size from the metadata, else the Content-Length if the server sent one
+func expectedSize(metadata *UpdateMetadata, contentLength int64) int64 {
+	if metadata.Size > 0 {
+		return metadata.Size
+	}
+	if contentLength > 0 {
+		return contentLength
+	}
+	return 0
+}
+
+// Sleeps as needed so the average rate since start stays at or below rate bytes/sec
+type rateLimitedReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// At most a second's worth per read keeps the bursts small
+	if int64(len(p)) > l.rate {
+		p = p[:l.rate]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	due := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+	if wait := due - time.Since(l.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// Counts the bytes read and reports each quarter of total
+type progressReader struct {
+	r        io.Reader
+	progress Progress
+	quarter  int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.progress.Bytes += int64(n)
+	if p.progress.Total > 0 {
+		if quarter := p.progress.Bytes * 4 / p.progress.Total; quarter > p.quarter && quarter < 4 {
+			p.quarter = quarter
+			p.report()
+		}
+	}
+	return n, err
+}
+
+func (p *progressReader) report() {
+	if onProgress != nil {
+		onProgress(p.progress)
+	}
+}
@@ -0,0 +1,81 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"status-updater/capabilities"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"time"
+)
+
+const (
+	lockRetryInitial = 15 * time.Second
+	lockRetryMax     = 5 * time.Minute
+)
+
+// Returned once the dpkg lock stayed held for the whole update_lock_wait
+var errDpkgLocked = errors.New("dpkg lock held by another process")
+
+// Installs a package with dpkg -i, retrying with backoff while another process (usually unattended-upgrades)
+// holds the dpkg lock. Waiting for the lock ends early when ctx is cancelled, e.g. by a shutdown.
+func installDeb(ctx context.Context, path string) error {
+	cfg := config.Current()
+	deadline := time.Now().Add(cfg.UpdateLockWait.Duration())
+	delay := lockRetryInitial
+	for {
+		err := runDpkg(path)
+		if !errors.Is(err, errDpkgLocked) {
+			return err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("gave up after %s: %w", cfg.UpdateLockWait, err)
+		}
+		logger.LogMessage("WARN", fmt.Sprintf("dpkg lock is held, retrying install in %s", delay))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("stopped waiting for the dpkg lock: %w", ctx.Err())
+		}
+		delay *= 2
+		if delay > lockRetryMax {
+			delay = lockRetryMax
+		}
+	}
+}
+
+// Runs dpkg -i once, including its stderr in the error and flagging lock contention as errDpkgLocked
+func runDpkg(path string) error {
+	name, args := capabilities.Privileged("dpkg", "-i", path)
+	_, stderr, err := cmdrunner.RunLong(installTimeout, name, args...)
+	if err == nil {
+		return nil
+	}
+	msg := strings.TrimSpace(string(stderr))
+	if cmdrunner.ExitCode(err) > 0 && lockHeld(msg) {
+		return fmt.Errorf("%w: %s", errDpkgLocked, lastLine(msg))
+	}
+	if msg == "" {
+		return err
+	}
+	return fmt.Errorf("%v: %s", err, msg)
+}
+
+// Reports whether dpkg output says the lock is held by another process
+func lockHeld(stderr string) bool {
+	lower := strings.ToLower(stderr)
+	return strings.Contains(lower, "could not get lock") || strings.Contains(lower, "dpkg frontend lock") ||
+		strings.Contains(lower, "dpkg status database is locked")
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
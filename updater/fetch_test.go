@@ -0,0 +1,156 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"status-updater/config"
+	"sync"
+	"testing"
+)
+
+const metadataJSON = `{"version": "2.4.1", "debian_url": "https://updates.example.com/a.deb", "debian_checksum": "d41d8cd98f00b204e9800998ecf8427e"}`
+
+// Update server that answers with etag and lastModified as validators, honouring conditional requests, and
+// records the conditional headers it received
+type metadataServer struct {
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	body         string
+	requests     []http.Header
+}
+
+func (s *metadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Header.Clone())
+	if user, password, ok := r.BasicAuth(); !ok || user != "device" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+		if r.Header.Get("If-None-Match") == s.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if s.lastModified != "" {
+		w.Header().Set("Last-Modified", s.lastModified)
+		if s.etag == "" && r.Header.Get("If-Modified-Since") == s.lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Write([]byte(s.body))
+}
+
+func (s *metadataServer) lastRequest() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+// Starts server and points updater_service.metadata_url at it, with a temporary state_dir
+func useMetadataServer(t *testing.T, server *metadataServer) {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	cfg := &config.Config{StateDir: t.TempDir()}
+	cfg.UpdaterService.MetadataURL = httpServer.URL + "/status-updater/meta.json"
+	cfg.UpdaterService.Username = "device"
+	cfg.UpdaterService.Password = "secret"
+	previous := config.Current()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+// Runs a check the way CheckForUpdates does, saving the validators afterwards
+func check(t *testing.T) *UpdateMetadata {
+	t.Helper()
+	metadata, v, err := fetchMetadata(http.DefaultClient)
+	if err != nil {
+		t.Fatalf("fetchMetadata: %v", err)
+	}
+	saveValidators(v)
+	return metadata
+}
+
+func TestFetchMetadataETag(t *testing.T) {
+	server := &metadataServer{etag: `"v1"`, body: metadataJSON}
+	useMetadataServer(t, server)
+
+	if metadata := check(t); metadata == nil || metadata.Version != "2.4.1" {
+		t.Fatalf("first check = %+v, want the full metadata", metadata)
+	}
+	if got := server.lastRequest().Get("If-None-Match"); got != "" {
+		t.Errorf("first request sent If-None-Match %q", got)
+	}
+
+	if metadata := check(t); metadata != nil {
+		t.Errorf("second check = %+v, want nil for 304 Not Modified", metadata)
+	}
+	if got := server.lastRequest().Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %q, want the saved ETag", got)
+	}
+
+	// A 304 keeps the validators for the next check
+	if metadata := check(t); metadata != nil {
+		t.Errorf("third check = %+v, want 304 again", metadata)
+	}
+
+	server.mu.Lock()
+	server.etag, server.body = `"v2"`, `{"version": "2.5.0", "debian_url": "https://updates.example.com/b.deb", "debian_checksum": "0cc175b9c0f1b6a831c399e269772661"}`
+	server.mu.Unlock()
+	if metadata := check(t); metadata == nil || metadata.Version != "2.5.0" {
+		t.Fatalf("check after the ETag changed = %+v, want the new metadata", metadata)
+	}
+	if got := loadValidators().ETag; got != `"v2"` {
+		t.Errorf("saved ETag = %q, want the new one", got)
+	}
+}
+
+func TestFetchMetadataLastModified(t *testing.T) {
+	server := &metadataServer{lastModified: "Wed, 14 Oct 2026 09:00:00 GMT", body: metadataJSON}
+	useMetadataServer(t, server)
+
+	if metadata := check(t); metadata == nil {
+		t.Fatal("first check returned no metadata")
+	}
+	if metadata := check(t); metadata != nil {
+		t.Errorf("second check = %+v, want nil for 304 Not Modified", metadata)
+	}
+	if got := server.lastRequest().Get("If-Modified-Since"); got != "Wed, 14 Oct 2026 09:00:00 GMT" {
+		t.Errorf("If-Modified-Since = %q, want the saved Last-Modified", got)
+	}
+}
+
+func TestFetchMetadataWithoutValidators(t *testing.T) {
+	server := &metadataServer{body: metadataJSON}
+	useMetadataServer(t, server)
+
+	for i := 0; i < 2; i++ {
+		if metadata := check(t); metadata == nil || metadata.Version != "2.4.1" {
+			t.Fatalf("check %d = %+v, want the full metadata", i+1, metadata)
+		}
+		header := server.lastRequest()
+		if header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != "" {
+			t.Errorf("check %d sent conditional headers: %v", i+1, header)
+		}
+	}
+	if _, err := os.Stat(validatorsPath()); !os.IsNotExist(err) {
+		t.Errorf("validators saved for a server without them: %v", err)
+	}
+}
+
+func TestFetchMetadataStaleValidatorsFromAnotherURL(t *testing.T) {
+	server := &metadataServer{etag: `"v1"`, body: metadataJSON}
+	useMetadataServer(t, server)
+	saveValidators(validators{URL: "https://old.example.com/meta.json", ETag: `"v1"`})
+
+	if metadata := check(t); metadata == nil {
+		t.Error("check with validators of another URL returned 304")
+	}
+}
@@ -0,0 +1,205 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+)
+
+// Update metadata served at updater_service.metadata_url; unknown fields are ignored so the server can add more
+type UpdateMetadata struct {
+	Version           string `json:"version"`
+	Channel           string `json:"channel"`
+	ReleaseNotes      string `json:"release_notes"`
+	DebianURL         string `json:"debian_url"`
+	DebianChecksum    string `json:"debian_checksum"`
+	BuildrootURL      string `json:"buildroot_url"`
+	BuildrootChecksum string `json:"buildroot_checksum"`
+	SHA256            string `json:"sha256"`
+	Signature         string `json:"signature"`
+	Arch              string `json:"arch"`
+	Size              int64  `json:"size"`
+}
+
+// Architecture names as uname and Debian report them, mapped to Go's
+var archAliases = map[string]string{
+	"armhf":   "arm",
+	"armv7":   "arm",
+	"armv7l":  "arm",
+	"aarch64": "arm64",
+	"x86_64":  "amd64",
+}
+
+// Trims whitespace, lowercases the checksums and maps arch to Go's name
+func (m *UpdateMetadata) normalize() {
+	for _, field := range []*string{&m.Version, &m.Channel, &m.DebianURL, &m.BuildrootURL, &m.Signature} {
+		*field = strings.TrimSpace(*field)
+	}
+	for _, field := range []*string{&m.DebianChecksum, &m.BuildrootChecksum, &m.SHA256, &m.Arch} {
+		*field = strings.ToLower(strings.TrimSpace(*field))
+	}
+	if alias, ok := archAliases[m.Arch]; ok {
+		m.Arch = alias
+	}
+}
+
+// Checks the metadata is complete and consistent, naming the field at fault
+func (m *UpdateMetadata) Validate() error {
+	if m.Version == "" {
+		return fmt.Errorf("version missing")
+	}
+	if m.DebianURL == "" && m.BuildrootURL == "" {
+		return fmt.Errorf("neither debian_url nor buildroot_url present")
+	}
+	if m.DebianURL != "" && m.DebianChecksum == "" && m.SHA256 == "" {
+		return fmt.Errorf("debian_url present but debian_checksum missing")
+	}
+	if m.BuildrootURL != "" && m.BuildrootChecksum == "" && m.SHA256 == "" {
+		return fmt.Errorf("buildroot_url present but buildroot_checksum missing")
+	}
+	if m.DebianChecksum != "" && m.DebianURL == "" {
+		return fmt.Errorf("debian_checksum present but debian_url missing")
+	}
+	if m.BuildrootChecksum != "" && m.BuildrootURL == "" {
+		return fmt.Errorf("buildroot_checksum present but buildroot_url missing")
+	}
+	if m.SHA256 != "" && len(m.SHA256) != 64 {
+		return fmt.Errorf("sha256 %q is not a SHA-256 hex digest", m.SHA256)
+	}
+	if m.Size < 0 {
+		return fmt.Errorf("size %d is negative", m.Size)
+	}
+	return nil
+}
+
+// Download URL and expected checksum for this device's flavor, sha256 when the server sends one and md5 otherwise
+func (m *UpdateMetadata) artifact(buildroot bool) (url string, checksum string, err error) {
+	url, checksum = m.DebianURL, m.DebianChecksum
+	name := "debian_url"
+	if buildroot {
+		url, checksum = m.BuildrootURL, m.BuildrootChecksum
+		name = "buildroot_url"
+	}
+	if url == "" {
+		return "", "", fmt.Errorf("%s missing", name)
+	}
+	if m.Arch != "" && m.Arch != runtime.GOARCH {
+		return "", "", fmt.Errorf("update is built for %s, this device runs %s", m.Arch, runtime.GOARCH)
+	}
+	if m.SHA256 != "" {
+		checksum = m.SHA256
+	}
+	return url, checksum, nil
+}
+
+// Decodes, normalizes and validates update metadata
+func decodeMetadata(r io.Reader) (*UpdateMetadata, error) {
+	var metadata UpdateMetadata
+	if err := json.NewDecoder(r).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse update metadata: %v", err)
+	}
+	metadata.normalize()
+	if err := metadata.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid update metadata: %v", err)
+	}
+	return &metadata, nil
+}
+
+// Fetches the metadata from updater_service.metadata_url as a conditional request when the last response had
+// an ETag or Last-Modified. Returns nil metadata when the server answers 304 Not Modified; the returned
+// validators are to be saved with saveValidators once the check is done.
+func fetchMetadata(client *http.Client) (*UpdateMetadata, validators, error) {
+	cfg := config.Current()
+	req, err := http.NewRequest("GET", cfg.UpdaterService.MetadataURL, nil)
+	if err != nil {
+		return nil, validators{}, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.SetBasicAuth(cfg.UpdaterService.Username, cfg.UpdaterService.Password)
+
+	previous := loadValidators()
+	if previous.URL == req.URL.String() {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, validators{}, fmt.Errorf("failed to fetch update metadata: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, previous, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, validators{}, fmt.Errorf("failed to fetch update metadata, status code: %d", resp.StatusCode)
+	}
+
+	current := validators{
+		URL:          req.URL.String(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	metadata, err := decodeMetadata(resp.Body)
+	return metadata, current, err
+}
+
+// ETag and Last-Modified of the last metadata response that was acted on
+type validators struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func validatorsPath() string {
+	return filepath.Join(config.Current().StateDir, "update-metadata-validators.json")
+}
+
+func loadValidators() validators {
+	var v validators
+	if data, err := os.ReadFile(validatorsPath()); err == nil {
+		json.Unmarshal(data, &v)
+	}
+	return v
+}
+
+// Remembers the validators once a check finished, so the next one can be answered with 304; a response
+// without validators removes them
+func saveValidators(v validators) {
+	if v.ETag == "" && v.LastModified == "" {
+		forgetValidators()
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = os.WriteFile(validatorsPath(), data, 0644)
+	}
+	if err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to save update metadata validators: %s", err))
+	}
+}
+
+// Makes the next check fetch the metadata in full
+func forgetValidators() {
+	os.Remove(validatorsPath())
+}
+
+// Logs what the server offers on every check
+func logMetadata(metadata *UpdateMetadata, url string) {
+	channel := ""
+	if metadata.Channel != "" {
+		channel = fmt.Sprintf(" on channel %s", metadata.Channel)
+	}
+	logger.LogMessage("INFO", fmt.Sprintf("Update server offers version %s%s at %s", metadata.Version, channel, url))
+}
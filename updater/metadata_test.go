@@ -0,0 +1,140 @@
+package updater
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const sha256Digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func TestDecodeMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want UpdateMetadata
+	}{
+		{
+			name: "current format",
+			json: `{
+				"version": "2.4.1",
+				"debian_url": "https://updates.example.com/status-updater_2.4.1_armhf.deb",
+				"debian_checksum": "D41D8CD98F00B204E9800998ECF8427E",
+				"buildroot_url": "https://updates.example.com/status-updater-2.4.1.tar.gz",
+				"buildroot_checksum": "0cc175b9c0f1b6a831c399e269772661"
+			}`,
+			want: UpdateMetadata{
+				Version:           "2.4.1",
+				DebianURL:         "https://updates.example.com/status-updater_2.4.1_armhf.deb",
+				DebianChecksum:    "d41d8cd98f00b204e9800998ecf8427e",
+				BuildrootURL:      "https://updates.example.com/status-updater-2.4.1.tar.gz",
+				BuildrootChecksum: "0cc175b9c0f1b6a831c399e269772661",
+			},
+		},
+		{
+			name: "new format",
+			json: `{
+				"version": " 2.5.0 ",
+				"channel": "beta",
+				"release_notes": "Per-field TTL",
+				"debian_url": "https://updates.example.com/status-updater_2.5.0_armhf.deb",
+				"sha256": "` + strings.ToUpper(sha256Digest) + `",
+				"signature": "MEUCIQDx",
+				"arch": "armv7l",
+				"size": 5242880
+			}`,
+			want: UpdateMetadata{
+				Version:      "2.5.0",
+				Channel:      "beta",
+				ReleaseNotes: "Per-field TTL",
+				DebianURL:    "https://updates.example.com/status-updater_2.5.0_armhf.deb",
+				SHA256:       sha256Digest,
+				Signature:    "MEUCIQDx",
+				Arch:         "arm",
+				Size:         5242880,
+			},
+		},
+		{
+			name: "unknown fields are ignored",
+			json: `{
+				"version": "2.6.0",
+				"buildroot_url": "https://updates.example.com/status-updater-2.6.0.tar.gz",
+				"buildroot_checksum": "0cc175b9c0f1b6a831c399e269772661",
+				"rollout_percent": 20,
+				"mirrors": ["https://mirror.example.com"]
+			}`,
+			want: UpdateMetadata{
+				Version:           "2.6.0",
+				BuildrootURL:      "https://updates.example.com/status-updater-2.6.0.tar.gz",
+				BuildrootChecksum: "0cc175b9c0f1b6a831c399e269772661",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMetadata(strings.NewReader(tt.json))
+			if err != nil {
+				t.Fatalf("decodeMetadata: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("metadata = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMetadataMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"not JSON", `<html>502 Bad Gateway</html>`, "failed to parse update metadata"},
+		{"truncated", `{"version": "2.4.1", "debian_url": "https://upd`, "failed to parse update metadata"},
+		{"wrong type", `{"version": 2.4, "debian_url": "https://updates.example.com/a.deb"}`, "failed to parse update metadata"},
+		{"empty object", `{}`, "invalid update metadata: version missing"},
+		{"typo in url field", `{"version": "2.4.1", "debain_url": "https://updates.example.com/a.deb", "debian_checksum": "d41d8cd98f00b204e9800998ecf8427e"}`,
+			"invalid update metadata: neither debian_url nor buildroot_url present"},
+		{"missing checksum", `{"version": "2.4.1", "debian_url": "https://updates.example.com/a.deb"}`,
+			"invalid update metadata: debian_url present but debian_checksum missing"},
+		{"checksum without url", `{"version": "2.4.1", "debian_url": "https://updates.example.com/a.deb", "debian_checksum": "d41d8cd98f00b204e9800998ecf8427e", "buildroot_checksum": "0cc175b9c0f1b6a831c399e269772661"}`,
+			"invalid update metadata: buildroot_checksum present but buildroot_url missing"},
+		{"short sha256", `{"version": "2.4.1", "debian_url": "https://updates.example.com/a.deb", "sha256": "abc123"}`,
+			`invalid update metadata: sha256 "abc123" is not a SHA-256 hex digest`},
+		{"negative size", `{"version": "2.4.1", "debian_url": "https://updates.example.com/a.deb", "sha256": "` + sha256Digest + `", "size": -1}`,
+			"invalid update metadata: size -1 is negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeMetadata(strings.NewReader(tt.json))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("decodeMetadata = %v, want an error starting with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataArtifact(t *testing.T) {
+	metadata := UpdateMetadata{
+		Version:           "2.4.1",
+		DebianURL:         "https://updates.example.com/a.deb",
+		DebianChecksum:    "d41d8cd98f00b204e9800998ecf8427e",
+		BuildrootURL:      "https://updates.example.com/a.tar.gz",
+		BuildrootChecksum: "0cc175b9c0f1b6a831c399e269772661",
+	}
+	if url, checksum, err := metadata.artifact(true); err != nil || url != metadata.BuildrootURL || checksum != metadata.BuildrootChecksum {
+		t.Errorf("buildroot artifact = %q, %q, %v", url, checksum, err)
+	}
+
+	metadata.SHA256 = sha256Digest
+	if _, checksum, err := metadata.artifact(false); err != nil || checksum != sha256Digest {
+		t.Errorf("debian checksum with sha256 = %q, %v, want the sha256", checksum, err)
+	}
+
+	metadata.Arch = "mips"
+	if _, _, err := metadata.artifact(false); err == nil || runtime.GOARCH == "mips" {
+		t.Errorf("artifact for another arch: err = %v, want an error", err)
+	}
+}
@@ -0,0 +1,41 @@
+package updater
+
+import (
+	"fmt"
+	"status-updater/capabilities"
+	"status-updater/logger"
+	"sync"
+)
+
+// Newer version the update server offers while the daemon can't install it, reported as "update_available"
+var (
+	availableMutex   sync.Mutex
+	availableVersion string
+)
+
+// Returns the version waiting to be installed by other means, empty when there is none
+func AvailableVersion() string {
+	availableMutex.Lock()
+	defer availableMutex.Unlock()
+	return availableVersion
+}
+
+func setAvailable(version string) {
+	availableMutex.Lock()
+	defer availableMutex.Unlock()
+	availableVersion = version
+}
+
+// Records version as available instead of installing it when the daemon lacks the privileges to install;
+// reports whether the install should be skipped. The validators aren't saved, so the version is found again
+// after a restart.
+func notifyOnly(version string) bool {
+	if capabilities.Current().UpdateInstall {
+		return false
+	}
+	if AvailableVersion() != version {
+		logger.LogMessage("INFO", fmt.Sprintf("Version %s is available, not installing it without the privileges to", version))
+	}
+	setAvailable(version)
+	return true
+}
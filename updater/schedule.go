@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"status-updater/config"
+	"status-updater/logger"
+	"strings"
+	"time"
+)
+
+// Buffered so a request made during a running check isn't lost
+var checkNow = make(chan struct{}, 1)
+
+// Runs an update check as soon as possible and restarts the schedule from it, e.g. for a remote "check now" command
+func RequestCheck() {
+	select {
+	case checkNow <- struct{}{}:
+	default:
+	}
+}
+
+// Checks for updates every update_check_interval ± update_check_jitter_pct, resuming the persisted schedule after a restart.
+// progress is called with the progress of update downloads.
+func RunSchedule(ctx context.Context, progress func(Progress)) {
+	onProgress = progress
+
+	next, ok := loadNextCheck()
+	if !ok {
+		// No schedule yet, check right away as on every start before the schedule was persisted
+		next = time.Now()
+	}
+
+	for {
+		delay := time.Until(next)
+		if delay < 0 {
+			delay = 0
+		}
+		logger.LogMessage("INFO", fmt.Sprintf("Next update check in %v at %s", delay.Round(time.Second), next.Format(time.RFC3339)))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-checkNow:
+			timer.Stop()
+			logger.LogMessage("INFO", "Update check requested")
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		CheckForUpdates(ctx)
+
+		next = time.Now().Add(nextCheckDelay())
+		if err := saveNextCheck(next); err != nil {
+			logger.LogMessage("WARN", fmt.Sprintf("Failed to persist update check schedule: %s", err))
+		}
+	}
+}
+
+// Base interval with uniform jitter of up to update_check_jitter_pct percent either way
+func nextCheckDelay() time.Duration {
+	cfg := config.Current()
+	interval := cfg.UpdateCheckInterval.Duration()
+	jitterPct := 0
+	if cfg.UpdateCheckJitterPct != nil {
+		jitterPct = *cfg.UpdateCheckJitterPct
+	}
+
+	spread := int64(interval) * int64(jitterPct) / 100
+	if spread <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+func scheduleFilePath() string {
+	return filepath.Join(config.Current().StateDir, "next-update-check")
+}
+
+// Returns the persisted next check time; a missing, corrupt or too distant one (e.g. after a clock jump) is ignored
+func loadNextCheck() (time.Time, bool) {
+	data, err := os.ReadFile(scheduleFilePath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	next, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	maxDelay := config.Current().UpdateCheckInterval.Duration() * 2
+	if time.Until(next) > maxDelay {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+func saveNextCheck(next time.Time) error {
+	path := scheduleFilePath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(next.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,35 +1,46 @@
 package updater
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"status-updater/capabilities"
+	"status-updater/cmdrunner"
 	"status-updater/config"
 	"status-updater/helpers"
 	"status-updater/logger"
+	"status-updater/maintenance"
+	"status-updater/metrics"
+	"status-updater/system"
+	"time"
+)
+
+const (
+	dnsCheckTimeout = 2 * time.Second
+	installTimeout  = 10 * time.Minute
 )
 
 func checkAndFixDNS() {
 	// Check wwan0 interface status
-	cmd := exec.Command("ip", "link", "show", "wwan0")
-	if err := cmd.Run(); err != nil {
+	if _, _, err := cmdrunner.Run("ip", "link", "show", "wwan0"); err != nil {
 		logger.LogMessage("DEBUG", "wwan0 interface not found, skipping DNS check")
 		return
 	}
 
 	// DNS resolution test
-	cmd = exec.Command("timeout", "2", "getent", "hosts", "google.com")
-	if err := cmd.Run(); err != nil {
+	if _, _, err := cmdrunner.RunWithTimeout(dnsCheckTimeout, "getent", "hosts", "google.com"); err != nil {
 		logger.LogMessage("WARN", "DNS resolution failed, attempting to fix DNS configuration")
 
 		// Backup resolv.conf
-		if err := exec.Command("cp", "/etc/resolv.conf", "/etc/resolv.conf.backup").Run(); err != nil {
+		if _, _, err := cmdrunner.Run("cp", "/etc/resolv.conf", "/etc/resolv.conf.backup"); err != nil {
 			logger.LogMessage("ERROR", fmt.Sprintf("Failed to backup resolv.conf: %v", err))
 			return
 		}
@@ -39,15 +50,14 @@ func checkAndFixDNS() {
 		if err := os.WriteFile("/etc/resolv.conf", dnsConfig, 0644); err != nil {
 			logger.LogMessage("ERROR", fmt.Sprintf("Failed to update resolv.conf: %v", err))
 			// Restore from backup
-			exec.Command("mv", "/etc/resolv.conf.backup", "/etc/resolv.conf").Run()
+			cmdrunner.Run("mv", "/etc/resolv.conf.backup", "/etc/resolv.conf")
 			return
 		}
 
 		logger.LogMessage("INFO", "Updated DNS configuration to use Cloudflare DNS servers")
 
 		// Verify DNS fix
-		cmd = exec.Command("timeout", "2", "getent", "hosts", "google.com")
-		if err := cmd.Run(); err != nil {
+		if _, _, err := cmdrunner.RunWithTimeout(dnsCheckTimeout, "getent", "hosts", "google.com"); err != nil {
 			logger.LogMessage("ERROR", "DNS resolution still failing after configuration update")
 		} else {
 			logger.LogMessage("INFO", "DNS resolution working after configuration update")
@@ -55,6 +65,7 @@ func checkAndFixDNS() {
 	}
 }
 
+// Compares a file with a SHA-256 or, for a 32-digit checksum, MD5 hex digest
 func verifyChecksum(filePath, expectedChecksum string) bool {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -63,7 +74,10 @@ func verifyChecksum(filePath, expectedChecksum string) bool {
 	}
 	defer file.Close()
 
-	hash := md5.New()
+	hash := sha256.New()
+	if len(expectedChecksum) == md5.Size*2 {
+		hash = md5.New()
+	}
 	if _, err := io.Copy(hash, file); err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to compute checksum: %s", err))
 		return false
@@ -73,68 +87,71 @@ func verifyChecksum(filePath, expectedChecksum string) bool {
 	return computedChecksum == expectedChecksum
 }
 
-func CheckForUpdates() {
+func CheckForUpdates(ctx context.Context) {
+	cfg := config.Current()
+	if cfg.UpdaterService.Disabled {
+		logger.LogMessage("DEBUG", "Updater disabled by configuration, skipping update check")
+		return
+	}
+	if maintenance.Active() {
+		logger.LogMessage("INFO", "Maintenance mode active, skipping update check")
+		return
+	}
 	logger.LogMessage("INFO", "Checking for updates...")
 
-	checkAndFixDNS()
+	if capabilities.Current().DNSFix {
+		checkAndFixDNS()
+	}
 
 	if helpers.IsBuildroot() {
-		UpdateBuildroot()
+		UpdateBuildroot(ctx)
 		return
 	}
 
-	// Debian update flow
-	metadataURL := config.Current.UpdaterService.MetadataURL
-	username := config.Current.UpdaterService.Username
-	password := config.Current.UpdaterService.Password
+	outcome := "error"
+	defer func() { metrics.IncCounter(metrics.UpdateChecksTotal, "result", outcome) }()
 
-	req, err := http.NewRequest("GET", metadataURL, nil)
-	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to create HTTP request: %s", err))
-		return
-	}
-
-	req.SetBasicAuth(username, password)
+	// Debian update flow
+	username := cfg.UpdaterService.Username
+	password := cfg.UpdaterService.Password
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	metadata, validators, err := fetchMetadata(client)
 	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to fetch update metadata: %s", err))
+		logger.LogMessage("ERROR", err.Error())
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to fetch update metadata, status code: %d", resp.StatusCode))
+	if metadata == nil {
+		logger.LogMessage("INFO", "Update metadata unchanged since the last check, no new updates available.")
+		outcome = "up_to_date"
 		return
 	}
+	logMetadata(metadata, metadata.DebianURL)
 
-	var metadata struct {
-		Version        string `json:"version"`
-		DebianURL      string `json:"debian_url"`
-		DebianChecksum string `json:"debian_checksum"`
-		ReleaseNotes   string `json:"release_notes"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to parse update metadata: %s", err))
-		return
-	}
-
-	if metadata.Version == "" || metadata.DebianURL == "" || metadata.DebianChecksum == "" {
-		logger.LogMessage("ERROR", "Invalid update metadata received")
+	downloadURL, checksum, err := metadata.artifact(false)
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Update metadata unusable on this device: %s", err))
 		return
 	}
 
 	currentVersion := helpers.GetUpdaterVersion()
-	if metadata.Version <= currentVersion {
+	if failedVersion(metadata.Version) {
+		logger.LogMessage("WARN", fmt.Sprintf("Version %s didn't come up after its last install, installing again", metadata.Version))
+	} else if metadata.Version <= currentVersion {
 		logger.LogMessage("INFO", "No new updates available.")
+		setAvailable("")
+		saveValidators(validators)
+		outcome = "up_to_date"
+		return
+	}
+	if notifyOnly(metadata.Version) {
+		outcome = "notify_only"
 		return
 	}
 
 	logger.LogMessage("INFO", fmt.Sprintf("New version %s found, downloading update...", metadata.Version))
 
-	updateReq, err := http.NewRequest("GET", metadata.DebianURL, nil)
+	updateReq, err := http.NewRequest("GET", downloadURL, nil)
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to create HTTP request for update: %s", err))
 		return
@@ -161,69 +178,79 @@ func CheckForUpdates() {
 	}
 	defer os.Remove(tmpFile.Name())
 
-	_, err = io.Copy(tmpFile, updateResp.Body)
+	written, err := download(tmpFile, updateResp.Body, metadata.Version, expectedSize(metadata, updateResp.ContentLength))
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to save update: %s", err))
 		return
 	}
+	if metadata.Size > 0 && written != metadata.Size {
+		logger.LogMessage("ERROR", fmt.Sprintf("Downloaded update is %d bytes, metadata says %d", written, metadata.Size))
+		return
+	}
 
-	if !verifyChecksum(tmpFile.Name(), metadata.DebianChecksum) {
+	if !verifyChecksum(tmpFile.Name(), checksum) {
 		logger.LogMessage("ERROR", "Checksum verification failed")
 		return
 	}
 
-	cmd := exec.Command("sudo", "dpkg", "-i", tmpFile.Name())
-	if err := cmd.Run(); err != nil {
+	if err := installDeb(ctx, tmpFile.Name()); err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to install update: %s", err))
+		if errors.Is(err, errDpkgLocked) {
+			outcome = "dpkg_locked"
+		}
 		return
 	}
 
 	logger.LogMessage("INFO", "Update installed successfully. Restarting application...")
-	os.Exit(0) // Force restart via service manager
+	recordPending(currentVersion, metadata.Version)
+	saveValidators(validators)
+	// Recorded directly since the deferred outcome doesn't run on exit
+	metrics.IncCounter(metrics.UpdateChecksTotal, "result", "installed")
+	system.RequestRestart(ctx, "update") // Force restart via service manager
 }
 
-func UpdateBuildroot() {
+func UpdateBuildroot(ctx context.Context) {
+	cfg := config.Current()
+	outcome := "error"
+	defer func() { metrics.IncCounter(metrics.UpdateChecksTotal, "result", outcome) }()
 
-	metadataURL := config.Current.UpdaterService.MetadataURL
-	username := config.Current.UpdaterService.Username
-	password := config.Current.UpdaterService.Password
-
-	req, err := http.NewRequest("GET", metadataURL, nil)
-	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to create HTTP request: %s", err))
-		return
-	}
+	// Read before deploy.sh replaces the version file
+	previousVersion := helpers.GetUpdaterVersion()
 
-	req.SetBasicAuth(username, password)
+	username := cfg.UpdaterService.Username
+	password := cfg.UpdaterService.Password
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	metadata, validators, err := fetchMetadata(client)
 	if err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to fetch update metadata: %s", err))
+		logger.LogMessage("ERROR", err.Error())
 		return
 	}
-	defer resp.Body.Close()
-
-	var metadata struct {
-		Version           string `json:"version"`
-		BuildrootURL      string `json:"buildroot_url"`
-		BuildrootChecksum string `json:"buildroot_checksum"`
-		ReleaseNotes      string `json:"release_notes"`
+	if metadata == nil {
+		logger.LogMessage("INFO", "Update metadata unchanged since the last update, nothing to install.")
+		outcome = "up_to_date"
+		return
 	}
+	logMetadata(metadata, metadata.BuildrootURL)
 
-	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
-		logger.LogMessage("ERROR", fmt.Sprintf("Failed to parse update metadata: %s", err))
+	downloadURL, checksum, err := metadata.artifact(true)
+	if err != nil {
+		logger.LogMessage("ERROR", fmt.Sprintf("Update metadata unusable on this device: %s", err))
 		return
 	}
-
-	if metadata.Version == "" || metadata.BuildrootURL == "" || metadata.BuildrootChecksum == "" {
-		logger.LogMessage("ERROR", "Invalid update metadata received")
+	if !capabilities.Current().UpdateInstall {
+		outcome = "up_to_date"
+		if metadata.Version == previousVersion {
+			setAvailable("")
+		} else if notifyOnly(metadata.Version) {
+			outcome = "notify_only"
+		}
 		return
 	}
 
 	logger.LogMessage("INFO", fmt.Sprintf("New version %s found, downloading update...", metadata.Version))
 
-	updateReq, err := http.NewRequest("GET", metadata.BuildrootURL, nil)
+	updateReq, err := http.NewRequest("GET", downloadURL, nil)
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to create HTTP request for update: %s", err))
 		return
@@ -257,27 +284,32 @@ func UpdateBuildroot() {
 		return
 	}
 
-	_, err = io.Copy(f, updateResp.Body)
+	written, err := download(f, updateResp.Body, metadata.Version, expectedSize(metadata, updateResp.ContentLength))
 	if err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to save update: %s", err))
 		return
 	}
 	f.Close()
+	if metadata.Size > 0 && written != metadata.Size {
+		logger.LogMessage("ERROR", fmt.Sprintf("Downloaded update is %d bytes, metadata says %d", written, metadata.Size))
+		return
+	}
 
-	if !verifyChecksum(tmpFile, metadata.BuildrootChecksum) {
+	if !verifyChecksum(tmpFile, checksum) {
 		logger.LogMessage("ERROR", "Checksum verification failed")
 		return
 	}
 
 	// Extract the update to temp directory
-	cmd := exec.Command("tar", "-xJf", tmpFile, "-C", tmpDir)
-	if err := cmd.Run(); err != nil {
+	if _, _, err := cmdrunner.RunLong(installTimeout, "tar", "-xJf", tmpFile, "-C", tmpDir); err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to extract update: %s", err))
 		return
 	}
 
-	// Run deploy script
-	deployCmd := exec.Command("./deploy.sh")
+	// Run deploy script; needs its own working directory so it bypasses the runner
+	deployCtx, cancel := context.WithTimeout(context.Background(), installTimeout)
+	defer cancel()
+	deployCmd := exec.CommandContext(deployCtx, "./deploy.sh")
 	deployCmd.Dir = tmpDir
 	if err := deployCmd.Run(); err != nil {
 		logger.LogMessage("ERROR", fmt.Sprintf("Failed to run deploy script: %s", err))
@@ -285,5 +317,9 @@ func UpdateBuildroot() {
 	}
 
 	logger.LogMessage("INFO", "Update installed successfully. Restarting application...")
-	os.Exit(0) // Force restart via service manager
+	recordPending(previousVersion, metadata.Version)
+	saveValidators(validators)
+	// Recorded directly since the deferred outcome doesn't run on exit
+	metrics.IncCounter(metrics.UpdateChecksTotal, "result", "installed")
+	system.RequestRestart(ctx, "update") // Force restart via service manager
 }
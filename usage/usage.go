@@ -0,0 +1,224 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"status-updater/boot"
+	"status-updater/config"
+	"status-updater/logger"
+	"status-updater/state"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sampleInterval = time.Minute
+
+	// Counters survive a daemon restart, so saving less often only risks losing traffic across a reboot
+	saveInterval = 10 * time.Minute
+)
+
+// Received and transmitted byte counts
+type Bytes struct {
+	RX uint64 `json:"rx"`
+	TX uint64 `json:"tx"`
+}
+
+func (b *Bytes) add(rx, tx uint64) {
+	b.RX += rx
+	b.TX += tx
+}
+
+// Traffic of one cellular interface, reported as cellular_usage
+type Usage struct {
+	Today     Bytes `json:"today"`
+	ThisMonth Bytes `json:"this_month"`
+	Total     Bytes `json:"total"`
+}
+
+// Accumulators plus the last raw counter sample of an interface, persisted across restarts
+type interfaceState struct {
+	Usage
+	Day     string `json:"day"`
+	Month   string `json:"month"`
+	BootID  string `json:"boot_id"`
+	IfIndex int    `json:"ifindex"`
+	LastRX  uint64 `json:"last_rx"`
+	LastTX  uint64 `json:"last_tx"`
+}
+
+var (
+	mu       sync.Mutex
+	loaded   bool
+	dirty    bool
+	lastSave time.Time
+	counters = make(map[string]*interfaceState)
+)
+
+const sysfsRoot = "/sys/class/net"
+
+func filePath() string {
+	return filepath.Join(config.Current().StateDir, "cellular-usage.json")
+}
+
+// Samples the cellular interfaces every minute until ctx is cancelled, saving the accumulators on the way out
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	Sample(time.Now())
+	for {
+		select {
+		case <-ticker.C:
+			Sample(time.Now())
+		case <-ctx.Done():
+			Save()
+			return
+		}
+	}
+}
+
+// Returns the usage of every cellular interface seen so far, nil when there are none
+func Snapshot() map[string]Usage {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	if len(counters) == 0 {
+		return nil
+	}
+	snapshot := make(map[string]Usage, len(counters))
+	for name, state := range counters {
+		snapshot[name] = state.Usage
+	}
+	return snapshot
+}
+
+// Adds the traffic since the previous sample of every wwan*/ppp* interface
+func Sample(now time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	bootID := boot.ID()
+	for _, name := range cellularInterfaces() {
+		rx, errRX := readCounter(name, "statistics/rx_bytes")
+		tx, errTX := readCounter(name, "statistics/tx_bytes")
+		ifIndex, errIndex := readCounter(name, "ifindex")
+		if errRX != nil || errTX != nil || errIndex != nil {
+			// Interface disappeared between listing and reading; its last sample is kept for when it returns
+			continue
+		}
+
+		state, ok := counters[name]
+		if !ok {
+			// First sight: only traffic from now on is counted
+			state = &interfaceState{LastRX: rx, LastTX: tx}
+			counters[name] = state
+		}
+		state.rollOver(now)
+
+		deltaRX, deltaTX := rx-state.LastRX, tx-state.LastTX
+		// Counters restart from zero after a reboot, when the interface is re-created, or when they decrease
+		if state.BootID != bootID || state.IfIndex != int(ifIndex) || rx < state.LastRX || tx < state.LastTX {
+			if ok {
+				deltaRX, deltaTX = rx, tx
+			}
+		}
+		state.Today.add(deltaRX, deltaTX)
+		state.ThisMonth.add(deltaRX, deltaTX)
+		state.Total.add(deltaRX, deltaTX)
+		state.LastRX, state.LastTX = rx, tx
+		state.BootID, state.IfIndex = bootID, int(ifIndex)
+		dirty = true
+	}
+
+	if dirty && now.Sub(lastSave) >= saveInterval {
+		saveLocked()
+	}
+}
+
+// Starts new day and month buckets; a clock jumping backwards keeps the current ones
+func (s *interfaceState) rollOver(now time.Time) {
+	day := now.Local().Format("2006-01-02")
+	month := now.Local().Format("2006-01")
+	if day > s.Day {
+		s.Day = day
+		s.Today = Bytes{}
+	}
+	if month > s.Month {
+		s.Month = month
+		s.ThisMonth = Bytes{}
+	}
+}
+
+// Persists the accumulators
+func Save() {
+	mu.Lock()
+	defer mu.Unlock()
+	if dirty {
+		saveLocked()
+	}
+}
+
+func saveLocked() {
+	lastSave = time.Now()
+	if err := state.WriteJSON(filePath(), counters); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Failed to save cellular usage: %s", err))
+		return
+	}
+	dirty = false
+}
+
+// Reads the persisted accumulators once; a missing or corrupt file starts from zero
+func load() {
+	if loaded {
+		return
+	}
+	loaded = true
+
+	data, err := os.ReadFile(filePath())
+	if err != nil {
+		return
+	}
+	var saved map[string]*interfaceState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logger.LogMessage("WARN", fmt.Sprintf("Ignoring corrupt cellular usage file %s: %s", filePath(), err))
+		return
+	}
+	for name, state := range saved {
+		if state != nil {
+			counters[name] = state
+		}
+	}
+}
+
+func cellularInterfaces() []string {
+	entries, err := os.ReadDir(sysfsRoot)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "wwan") || strings.HasPrefix(name, "ppp") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readCounter(iface, name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(sysfsRoot, iface, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,166 @@
+package wifiwatch
+
+import (
+	"context"
+	"fmt"
+	"status-updater/cmdrunner"
+	"status-updater/config"
+	"status-updater/gatherer"
+	"status-updater/helpers"
+	"status-updater/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Association poll interval while a WLAN interface exists
+	pollInterval = 5 * time.Second
+
+	// Check for a WLAN interface appearing while there is none
+	interfaceInterval = 30 * time.Second
+
+	// Recent changes reported in the payload
+	maxTransitions = 10
+
+	// AP value while not associated
+	Disconnected = "disconnected"
+)
+
+// A change of the access point the device is associated with, or to/from Disconnected
+type Transition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+var (
+	stateMutex  sync.Mutex
+	active      bool
+	current     string
+	transitions []Transition
+	roams       []time.Time
+	lastEvent   time.Time
+)
+
+// Reports whether the wifi gatherer is enabled and iwgetid is installed
+func Enabled() bool {
+	if !config.Current().GathererEnabled("wifi") {
+		return false
+	}
+	_, err := cmdrunner.LookPath("iwgetid")
+	return err == nil
+}
+
+// Follows the associated access point until ctx is cancelled, calling onRoam when it moves from one AP to another,
+// at most once per events.cooldown. Polling pauses while no WLAN interface exists.
+func Run(ctx context.Context, onRoam func(Transition)) {
+	for {
+		if !hasWLANInterface() {
+			setActive(false)
+			if !sleep(ctx, interfaceInterval) {
+				return
+			}
+			continue
+		}
+
+		if setActive(true) {
+			logger.LogMessage("INFO", "WLAN interface present, watching the access point")
+		}
+		record(accessPoint(), onRoam)
+		if !sleep(ctx, pollInterval) {
+			return
+		}
+	}
+}
+
+// Returns the roams within the last 24 hours and the recent changes, or nil while no WLAN interface is watched
+func Snapshot() (*int, []Transition) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if !active {
+		return nil, nil
+	}
+	pruneRoams(time.Now())
+	count := len(roams)
+	return &count, append([]Transition{}, transitions...)
+}
+
+// Marks the watcher active or idle, reporting whether it just became active
+func setActive(present bool) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	started := present && !active
+	if active && !present {
+		logger.LogMessage("INFO", "WLAN interface gone, pausing the access point watch")
+		current = ""
+	}
+	active = present
+	return started
+}
+
+func hasWLANInterface() bool {
+	return len(helpers.WirelessInterfaces()) > 0
+}
+
+// MAC of the AP the first associated wireless interface is connected to, or Disconnected
+func accessPoint() string {
+	for _, iface := range helpers.WirelessInterfaces() {
+		if mac := gatherer.AssociatedAP(iface); mac != "" {
+			return strings.ToLower(mac)
+		}
+	}
+	return Disconnected
+}
+
+func record(ap string, onRoam func(Transition)) {
+	now := time.Now()
+
+	stateMutex.Lock()
+	previous := current
+	current = ap
+	if previous == "" || previous == ap {
+		stateMutex.Unlock()
+		return
+	}
+	transition := Transition{From: previous, To: ap, Time: now.UTC().Truncate(time.Second)}
+	transitions = append(transitions, transition)
+	if len(transitions) > maxTransitions {
+		transitions = transitions[len(transitions)-maxTransitions:]
+	}
+	roamed := previous != Disconnected && ap != Disconnected
+	cooledDown := false
+	if roamed {
+		roams = append(roams, now)
+		pruneRoams(now)
+		cooledDown = now.Sub(lastEvent) >= config.Current().Events.Cooldown.Duration()
+		if cooledDown {
+			lastEvent = now
+		}
+	}
+	stateMutex.Unlock()
+
+	logger.LogMessage("INFO", fmt.Sprintf("WiFi access point changed: %s -> %s", previous, ap))
+	if cooledDown {
+		onRoam(transition)
+	}
+}
+
+// Forgets roams older than a day; call with stateMutex held
+func pruneRoams(now time.Time) {
+	keep := 0
+	for keep < len(roams) && now.Sub(roams[keep]) > 24*time.Hour {
+		keep++
+	}
+	roams = roams[keep:]
+}
+
+// Waits for d, returning false when ctx was cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}